@@ -0,0 +1,182 @@
+package ahmp
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+///// AHMP codec registry
+// An AHMP message on a stream is its type (int), followed by the raw message.
+// A codec decodes the raw message and validates it into the parsed message.
+// Message types are registered once, usually in init().
+
+var (
+	// ErrUnknownMessageType: the body was skipped; the stream is still usable.
+	ErrUnknownMessageType = errors.New("unknown AHMP message type")
+	// ErrMalformedMessage: the body is not valid CBOR; the stream is broken.
+	ErrMalformedMessage = errors.New("malformed AHMP message")
+	// ErrInvalidMessage: the body was consumed, but failed validation.
+	ErrInvalidMessage = errors.New("invalid AHMP message")
+
+	ErrDuplicateMessageType = errors.New("duplicate AHMP message type")
+	ErrUnregisteredMessage  = errors.New("unregistered AHMP message")
+)
+
+// CodecError reports a message that could not be decoded or encoded.
+// It wraps one of the errors above, and the cause.
+type CodecError struct {
+	Type int
+	Name string // empty for unknown types
+	Err  error
+}
+
+func (e *CodecError) Error() string {
+	name := e.Name
+	if name == "" {
+		name = "type " + strconv.Itoa(e.Type)
+	}
+	return "AHMP " + name + ": " + e.Err.Error()
+}
+
+func (e *CodecError) Unwrap() error { return e.Err }
+
+type codec struct {
+	msg_type int
+	name     string
+	raw_type reflect.Type // pointer to raw message struct
+	parse    func(raw any) (any, error)
+}
+
+// Registry maps AHMP message types to their codecs. It is safe for concurrent use.
+type Registry struct {
+	mtx     sync.RWMutex
+	by_type map[int]*codec
+	by_raw  map[reflect.Type]*codec
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		by_type: make(map[int]*codec),
+		by_raw:  make(map[reflect.Type]*codec),
+	}
+}
+
+// Register adds a message type. parse validates the raw message R into the parsed message M.
+// Each type number and each raw message type can be registered only once.
+func Register[R any, M any](r *Registry, msg_type int, name string, parse func(*R) (*M, error)) error {
+	c := &codec{
+		msg_type: msg_type,
+		name:     name,
+		raw_type: reflect.TypeFor[*R](),
+		parse:    func(raw any) (any, error) { return parse(raw.(*R)) },
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.by_type[msg_type]; ok {
+		return &CodecError{Type: msg_type, Name: name, Err: ErrDuplicateMessageType}
+	}
+	if _, ok := r.by_raw[c.raw_type]; ok {
+		return &CodecError{Type: msg_type, Name: name, Err: ErrDuplicateMessageType}
+	}
+	r.by_type[msg_type] = c
+	r.by_raw[c.raw_type] = c
+	return nil
+}
+
+// MustRegister is Register for init().
+func MustRegister[R any, M any](r *Registry, msg_type int, name string, parse func(*R) (*M, error)) {
+	if err := Register(r, msg_type, name, parse); err != nil {
+		panic(err)
+	}
+}
+
+// Name returns the registered name of the message type.
+func (r *Registry) Name(msg_type int) (string, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	c, ok := r.by_type[msg_type]
+	if !ok {
+		return "", false
+	}
+	return c.name, true
+}
+
+// Decode reads a message, and returns its type and the parsed message.
+// Errors reading the type are returned as is (typically io.EOF);
+// the others are *CodecError.
+func (r *Registry) Decode(decoder *cbor.Decoder) (int, any, error) {
+	var msg_type int
+	if err := decoder.Decode(&msg_type); err != nil {
+		return 0, nil, err
+	}
+
+	r.mtx.RLock()
+	c, ok := r.by_type[msg_type]
+	r.mtx.RUnlock()
+	if !ok {
+		if err := SkipMessage(decoder); err != nil {
+			return msg_type, nil, &CodecError{Type: msg_type, Err: errors.Join(ErrMalformedMessage, err)}
+		}
+		return msg_type, nil, &CodecError{Type: msg_type, Err: ErrUnknownMessageType}
+	}
+
+	raw := reflect.New(c.raw_type.Elem()).Interface()
+	if err := decoder.Decode(raw); err != nil {
+		return msg_type, nil, &CodecError{Type: msg_type, Name: c.name, Err: errors.Join(ErrMalformedMessage, err)}
+	}
+	msg, err := c.parse(raw)
+	if err != nil {
+		return msg_type, nil, &CodecError{Type: msg_type, Name: c.name, Err: errors.Join(ErrInvalidMessage, err)}
+	}
+	return msg_type, msg, nil
+}
+
+// Encode writes the type of the raw message, and the raw message.
+// raw is a registered raw message, or a pointer to it.
+func (r *Registry) Encode(encoder *cbor.Encoder, raw any) error {
+	raw_type := reflect.TypeOf(raw)
+	if raw_type == nil {
+		return &CodecError{Type: -1, Err: ErrUnregisteredMessage}
+	}
+	if raw_type.Kind() != reflect.Pointer {
+		raw_type = reflect.PointerTo(raw_type)
+	}
+	r.mtx.RLock()
+	c, ok := r.by_raw[raw_type]
+	r.mtx.RUnlock()
+	if !ok {
+		return &CodecError{Type: -1, Name: raw_type.Elem().Name(), Err: ErrUnregisteredMessage}
+	}
+
+	if err := encoder.Encode(c.msg_type); err != nil {
+		return err
+	}
+	return encoder.Encode(raw)
+}
+
+// DefaultRegistry has the AND messages (JN ~ SOD), SAM, SOU, MOD and ROS.
+var DefaultRegistry = NewRegistry()
+
+func init() {
+	MustRegister(DefaultRegistry, JN_T, "JN", (*RawJN).TryParse)
+	MustRegister(DefaultRegistry, JOK_T, "JOK", (*RawJOK).TryParse)
+	MustRegister(DefaultRegistry, JDN_T, "JDN", (*RawJDN).TryParse)
+	MustRegister(DefaultRegistry, JNI_T, "JNI", (*RawJNI).TryParse)
+	MustRegister(DefaultRegistry, MEM_T, "MEM", (*RawMEM).TryParse)
+	MustRegister(DefaultRegistry, SJN_T, "SJN", (*RawSJN).TryParse)
+	MustRegister(DefaultRegistry, CRR_T, "CRR", (*RawCRR).TryParse)
+	MustRegister(DefaultRegistry, RST_T, "RST", (*RawRST).TryParse)
+	MustRegister(DefaultRegistry, SOA_T, "SOA", (*RawSOA).TryParse)
+	MustRegister(DefaultRegistry, SOD_T, "SOD", (*RawSOD).TryParse)
+	MustRegister(DefaultRegistry, SAM_T, "SAM", (*RawSAM).TryParse)
+	MustRegister(DefaultRegistry, SOU_T, "SOU", (*RawSOU).TryParse)
+	MustRegister(DefaultRegistry, MOD_T, "MOD", (*RawMOD).TryParse)
+	MustRegister(DefaultRegistry, ROS_T, "ROS", (*RawROS).TryParse)
+}
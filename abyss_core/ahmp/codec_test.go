@@ -0,0 +1,394 @@
+package ahmp_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/uuid"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+type rawPing struct {
+	Seq  int
+	Text string
+}
+
+type ping struct {
+	Seq int
+}
+
+func parsePing(raw *rawPing) (*ping, error) {
+	if raw.Seq < 0 {
+		return nil, errors.New("negative sequence")
+	}
+	return &ping{raw.Seq}, nil
+}
+
+// rawPingV2 is rawPing of a newer peer, with an optional field.
+type rawPingV2 struct {
+	Seq   int
+	Text  string
+	Extra []byte
+}
+
+func TestRegistry(t *testing.T) {
+	registry := ahmp.NewRegistry()
+	if err := ahmp.Register(registry, 1000, "PING", parsePing); err != nil {
+		t.Fatal(err)
+	}
+	if err := ahmp.Register(registry, 1000, "PONG", parsePing); !errors.Is(err, ahmp.ErrDuplicateMessageType) {
+		t.Fatal("duplicate type registered: ", err)
+	}
+
+	var buf bytes.Buffer
+	encoder := cbor.NewEncoder(&buf)
+	registry.Encode(encoder, &rawPing{Seq: 1})
+	encoder.Encode(1001) // unknown type
+	encoder.Encode(&rawPing{Seq: 2})
+	encoder.Encode(1000)
+	encoder.Encode(&rawPingV2{Seq: 3, Extra: []byte{1}})
+	registry.Encode(encoder, rawPing{Seq: -1})
+	if err := registry.Encode(encoder, &rawPingV2{}); !errors.Is(err, ahmp.ErrUnregisteredMessage) {
+		t.Fatal("unregistered message encoded: ", err)
+	}
+
+	decoder := ahmp.NewDecoder(&buf)
+	expect := func(seq int, expected_err error) {
+		t.Helper()
+		msg_type, msg, err := registry.Decode(decoder)
+		if expected_err != nil {
+			var codec_err *ahmp.CodecError
+			if !errors.Is(err, expected_err) || !errors.As(err, &codec_err) {
+				t.Fatal("unexpected error: ", err)
+			}
+			return
+		}
+		if err != nil || msg_type != 1000 || msg.(*ping).Seq != seq {
+			t.Fatal("unexpected message: ", msg, err)
+		}
+	}
+	expect(1, nil)
+	expect(0, ahmp.ErrUnknownMessageType)
+	expect(3, nil)
+	expect(0, ahmp.ErrInvalidMessage)
+	if _, _, err := registry.Decode(decoder); err != io.EOF {
+		t.Fatal("stream out of sync: ", err)
+	}
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	session_id := uuid.New()
+	var buf bytes.Buffer
+	if err := ahmp.DefaultRegistry.Encode(cbor.NewEncoder(&buf), &ahmp.RawJN{SenderSessionID: session_id.String(), Text: "/"}); err != nil {
+		t.Fatal(err)
+	}
+	msg_type, msg, err := ahmp.DefaultRegistry.Decode(ahmp.NewDecoder(&buf))
+	if err != nil || msg_type != ahmp.JN_T {
+		t.Fatal(err)
+	}
+	if jn, ok := msg.(*ahmp.JN); !ok || jn.SenderSessionID != session_id {
+		t.Fatal("unexpected message: ", msg)
+	}
+	if name, _ := ahmp.DefaultRegistry.Name(ahmp.SOD_T); name != "SOD" {
+		t.Fatal("unexpected name: ", name)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	dispatcher := ahmp.NewDispatcher[string]()
+	var received []string
+	ahmp.Handle(dispatcher, func(peer string, msg *ping) error {
+		received = append(received, peer)
+		if msg.Seq == 0 {
+			return errors.New("zero")
+		}
+		return nil
+	})
+
+	if err := dispatcher.Dispatch("A", &ping{Seq: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.Dispatch("B", &ping{Seq: 0}); err == nil {
+		t.Fatal("handler error not returned")
+	}
+	if err := dispatcher.Dispatch("C", &ahmp.JN{}); !errors.Is(err, ahmp.ErrUnhandledMessage) {
+		t.Fatal("unhandled message dispatched: ", err)
+	}
+	ahmp.Unhandle[string, ping](dispatcher)
+	if err := dispatcher.Dispatch("D", &ping{Seq: 1}); !errors.Is(err, ahmp.ErrUnhandledMessage) {
+		t.Fatal("handler not removed: ", err)
+	}
+	if len(received) != 2 || received[0] != "A" || received[1] != "B" {
+		t.Fatal("unexpected dispatch: ", received)
+	}
+}
+
+func TestSAM(t *testing.T) {
+	ssid, rsid := uuid.New(), uuid.New()
+	var buf bytes.Buffer
+	encoder := cbor.NewEncoder(&buf)
+	for _, raw := range []ahmp.RawSAM{
+		{SenderSessionID: ssid.String(), RecverSessionID: rsid.String(), Topic: "chat", Payload: []byte("hello")},
+		{SenderSessionID: ssid.String(), RecverSessionID: rsid.String(), Topic: ""},
+		{SenderSessionID: ssid.String(), RecverSessionID: rsid.String(), Topic: "voice", Payload: make([]byte, ahmp.MaxSAMPayloadSize+1)},
+	} {
+		if err := ahmp.DefaultRegistry.Encode(encoder, &raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decoder := ahmp.NewDecoder(&buf)
+	_, msg, err := ahmp.DefaultRegistry.Decode(decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sam, ok := msg.(*ahmp.SAM); !ok || sam.SenderSessionID != ssid || sam.RecverSessionID != rsid ||
+		sam.Topic != "chat" || string(sam.Payload) != "hello" {
+		t.Fatal("unexpected message: ", msg)
+	}
+	for range 2 {
+		if _, _, err := ahmp.DefaultRegistry.Decode(decoder); !errors.Is(err, ahmp.ErrInvalidMessage) {
+			t.Fatal("invalid SAM accepted: ", err)
+		}
+	}
+}
+
+func TestSOU(t *testing.T) {
+	ssid, rsid, oid := uuid.New(), uuid.New(), uuid.New()
+	transform := [7]float32{1, 2, 3, 0, 0, 0, 1}
+	var buf bytes.Buffer
+	if err := ahmp.DefaultRegistry.Encode(cbor.NewEncoder(&buf), &ahmp.RawSOU{
+		SenderSessionID: ssid.String(),
+		RecverSessionID: rsid.String(),
+		Objects: []ahmp.RawObjectUpdate{
+			{ID: oid.String(), Transform: &transform},
+			{ID: oid.String(), Properties: map[string]string{"color": "red"}, RemovedProperties: []string{"size"}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, msg, err := ahmp.DefaultRegistry.Decode(ahmp.NewDecoder(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sou, ok := msg.(*ahmp.SOU)
+	if !ok || sou.SenderSessionID != ssid || sou.RecverSessionID != rsid || len(sou.Objects) != 2 {
+		t.Fatal("unexpected message: ", msg)
+	}
+	moved, changed := sou.Objects[0], sou.Objects[1]
+	if moved.ID != oid || moved.Transform == nil || *moved.Transform != transform || moved.Properties != nil {
+		t.Fatal("unexpected transform update: ", moved)
+	}
+	if changed.Transform != nil || changed.Properties["color"] != "red" || len(changed.RemovedProperties) != 1 {
+		t.Fatal("unexpected property update: ", changed)
+	}
+}
+
+func TestSOULimits(t *testing.T) {
+	ssid, rsid, oid := uuid.New(), uuid.New(), uuid.New()
+	too_many_objects := make([]ahmp.RawObjectUpdate, ahmp.MaxSOUObjects+1)
+	for i := range too_many_objects {
+		too_many_objects[i].ID = oid.String()
+	}
+	too_many_properties := make(map[string]string, ahmp.MaxSOUProperties+1)
+	for i := range ahmp.MaxSOUProperties + 1 {
+		too_many_properties[strconv.Itoa(i)] = ""
+	}
+	long_key := strings.Repeat("k", ahmp.MaxSOUPropertyKeyLength+1)
+
+	var buf bytes.Buffer
+	encoder := cbor.NewEncoder(&buf)
+	for _, raw := range []ahmp.RawSOU{
+		{SenderSessionID: ssid.String(), RecverSessionID: rsid.String(), Objects: too_many_objects[:ahmp.MaxSOUObjects]},
+		{SenderSessionID: ssid.String(), RecverSessionID: rsid.String(), Objects: too_many_objects},
+		{SenderSessionID: ssid.String(), RecverSessionID: rsid.String(), Objects: []ahmp.RawObjectUpdate{
+			{ID: oid.String(), Properties: too_many_properties},
+		}},
+		{SenderSessionID: ssid.String(), RecverSessionID: rsid.String(), Objects: []ahmp.RawObjectUpdate{
+			{ID: oid.String(), Properties: map[string]string{long_key: ""}},
+		}},
+		{SenderSessionID: ssid.String(), RecverSessionID: rsid.String(), Objects: []ahmp.RawObjectUpdate{
+			{ID: oid.String(), RemovedProperties: []string{long_key}},
+		}},
+		{SenderSessionID: ssid.String(), RecverSessionID: rsid.String(), Objects: []ahmp.RawObjectUpdate{
+			{ID: oid.String(), Properties: map[string]string{"model": strings.Repeat("v", ahmp.MaxSOUPropertyValueSize+1)}},
+		}},
+	} {
+		if err := ahmp.DefaultRegistry.Encode(encoder, &raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decoder := ahmp.NewDecoder(&buf)
+	if _, msg, err := ahmp.DefaultRegistry.Decode(decoder); err != nil || len(msg.(*ahmp.SOU).Objects) != ahmp.MaxSOUObjects {
+		t.Fatal("SOU at the limit rejected: ", err)
+	}
+	for range 5 {
+		if _, _, err := ahmp.DefaultRegistry.Decode(decoder); !errors.Is(err, ahmp.ErrInvalidMessage) {
+			t.Fatal("SOU over the limit accepted: ", err)
+		}
+	}
+}
+
+func TestMOD(t *testing.T) {
+	ssid, rsid, wid := uuid.New(), uuid.New(), uuid.New()
+	moderation := &abyss.WorldModeration{
+		WorldID:   wid,
+		Action:    abyss.ModBan,
+		Target:    "Itarget",
+		Issuer:    "Iissuer",
+		Reason:    "griefing",
+		TimeStamp: time.UnixMilli(time.Now().UnixMilli()),
+		Signature: []byte{1, 2, 3},
+	}
+	var buf bytes.Buffer
+	if err := ahmp.DefaultRegistry.Encode(cbor.NewEncoder(&buf), ahmp.MakeRawMOD(ssid, rsid, moderation)); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := ahmp.DefaultRegistry.Decode(ahmp.NewDecoder(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, ok := msg.(*ahmp.MOD)
+	if !ok || mod.SenderSessionID != ssid || mod.RecverSessionID != rsid {
+		t.Fatal("unexpected message: ", msg)
+	}
+	if mod.Moderation.WorldID != wid || mod.Moderation.Action != abyss.ModBan ||
+		mod.Moderation.Target != "Itarget" || !mod.Moderation.TimeStamp.Equal(moderation.TimeStamp) {
+		t.Fatal("unexpected moderation: ", mod.Moderation)
+	}
+
+	raw := ahmp.MakeRawMOD(ssid, rsid, moderation)
+	raw.Action = 0
+	buf.Reset()
+	if err := ahmp.DefaultRegistry.Encode(cbor.NewEncoder(&buf), raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ahmp.DefaultRegistry.Decode(ahmp.NewDecoder(&buf)); err == nil {
+		t.Fatal("unknown action accepted")
+	}
+}
+
+func TestJOKAccess(t *testing.T) {
+	ssid, rsid, wid := uuid.New(), uuid.New(), uuid.New()
+	var buf bytes.Buffer
+	if err := ahmp.DefaultRegistry.Encode(cbor.NewEncoder(&buf), &ahmp.RawJOK{
+		SenderSessionID: ssid.String(),
+		RecverSessionID: rsid.String(),
+		Text:            "https://example.com/world",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ahmp.DefaultRegistry.Encode(cbor.NewEncoder(&buf), &ahmp.RawJOK{
+		SenderSessionID: ssid.String(),
+		RecverSessionID: rsid.String(),
+		Text:            "https://example.com/world",
+		Access: ahmp.MakeRawWorldAccess(&abyss.WorldAccess{
+			WorldID:   wid,
+			Owner:     "Iowner",
+			Signature: []byte{1, 2, 3},
+			Moderations: []*abyss.WorldModeration{{
+				WorldID:   wid,
+				Action:    abyss.ModBan,
+				Target:    "Ibanned",
+				Issuer:    "Iowner",
+				TimeStamp: time.UnixMilli(time.Now().UnixMilli()),
+				Signature: []byte{4, 5, 6},
+			}},
+		}),
+		MaxMembers:     8,
+		InterestRadius: 16,
+		InviteOnly:     true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := ahmp.NewDecoder(&buf)
+	_, msg, err := ahmp.DefaultRegistry.Decode(decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jok, ok := msg.(*ahmp.JOK); !ok || jok.Access != nil || jok.MaxMembers != 0 || jok.InterestRadius != 0 || jok.InviteOnly {
+		t.Fatal("unexpected legacy JOK: ", msg)
+	}
+	_, msg, err = ahmp.DefaultRegistry.Decode(decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jok, ok := msg.(*ahmp.JOK)
+	if !ok || jok.Access == nil || jok.Access.WorldID != wid || jok.Access.Owner != "Iowner" || len(jok.Access.Moderations) != 1 || jok.Access.Moderations[0].Target != "Ibanned" || jok.MaxMembers != 8 || jok.InterestRadius != 16 || !jok.InviteOnly {
+		t.Fatal("unexpected JOK: ", msg)
+	}
+}
+
+func TestROS(t *testing.T) {
+	ssid, rsid, msid := uuid.New(), uuid.New(), uuid.New()
+	member_url, err := aurl.TryParse("abyss:I5ZeR9ckNwP4rXtoMnJjB2gHmKqTf3vS8aWd:9.8.7.6:1605")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := abyss.ANDRosterEntry{Position: &[3]float32{1, 2, 3}, Heartbeat: 7}
+	roster := []abyss.ANDRosterEntry{{
+		ANDFullPeerSessionIdentity: abyss.ANDFullPeerSessionIdentity{
+			AURL:      member_url,
+			SessionID: msid,
+			TimeStamp: time.UnixMilli(time.Now().UnixMilli()),
+		},
+		Heartbeat: 3,
+		Left:      true,
+	}}
+	var buf bytes.Buffer
+	if err := ahmp.DefaultRegistry.Encode(cbor.NewEncoder(&buf), ahmp.MakeRawROS(ssid, rsid, true, local, roster)); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := ahmp.DefaultRegistry.Decode(ahmp.NewDecoder(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ros, ok := msg.(*ahmp.ROS)
+	if !ok || ros.SenderSessionID != ssid || ros.RecverSessionID != rsid || !ros.Keep {
+		t.Fatal("unexpected message: ", msg)
+	}
+	if ros.Local.Position == nil || *ros.Local.Position != *local.Position || ros.Local.Heartbeat != 7 || ros.Local.AURL != nil {
+		t.Fatal("unexpected local entry: ", ros.Local)
+	}
+	if len(ros.Roster) != 1 || ros.Roster[0].AURL.Hash != member_url.Hash || ros.Roster[0].SessionID != msid ||
+		ros.Roster[0].Position != nil || !ros.Roster[0].Left || !ros.Roster[0].TimeStamp.Equal(roster[0].TimeStamp) {
+		t.Fatal("unexpected roster: ", ros.Roster)
+	}
+
+	nan := float32(math.NaN())
+	local.Position = &[3]float32{0, nan, 0}
+	buf.Reset()
+	if err := ahmp.DefaultRegistry.Encode(cbor.NewEncoder(&buf), ahmp.MakeRawROS(ssid, rsid, false, local, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ahmp.DefaultRegistry.Decode(ahmp.NewDecoder(&buf)); err == nil {
+		t.Fatal("NaN position accepted")
+	}
+}
+
+func TestHPNCandidates(t *testing.T) {
+	candidates := make([]string, ahmp.MaxAddrCandidates+1)
+	for i := range candidates {
+		candidates[i] = "127.0.0.1:1000"
+	}
+	raw := ahmp.RawHPN{SourceID: "Isource", Candidates: candidates[:ahmp.MaxAddrCandidates]}
+	if hpn, err := raw.TryParse(); err != nil || len(hpn.Candidates) != ahmp.MaxAddrCandidates {
+		t.Fatal("HPN rejected: ", err)
+	}
+	raw.Candidates = candidates
+	if _, err := raw.TryParse(); err == nil {
+		t.Fatal("HPN with too many candidates accepted")
+	}
+}
@@ -0,0 +1,138 @@
+package ahmp
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/uuid"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+///// AHMP datagrams
+// Datagrams are unreliable and unordered; they carry state that is
+// superseded by the next update, like object transforms.
+// A datagram is a message type followed by the raw message, as on streams,
+// but has its own registry: datagram messages are never sent on streams.
+// They are kept small to fit a single QUIC packet; ids are raw bytes,
+// and fields are keyed by integers.
+
+const (
+	DTU_T int = iota + 128 // object transform update
+)
+
+// MaxDTUObjects keeps a DTU within the minimum QUIC datagram size.
+const MaxDTUObjects = 16
+
+type RawObjectTransform struct {
+	ID        []byte     `cbor:"1,keyasint"`
+	Transform [7]float32 `cbor:"2,keyasint"`
+}
+
+type RawDTU struct {
+	SenderSessionID []byte               `cbor:"1,keyasint"`
+	RecverSessionID []byte               `cbor:"2,keyasint"`
+	Sequence        uint64               `cbor:"3,keyasint"`
+	Objects         []RawObjectTransform `cbor:"4,keyasint"`
+}
+
+func MakeRawDTU(local_session_id uuid.UUID, peer_session_id uuid.UUID, sequence uint64, transforms []abyss.ObjectTransform) *RawDTU {
+	objects := make([]RawObjectTransform, len(transforms))
+	for i, t := range transforms {
+		objects[i] = RawObjectTransform{ID: t.ID[:], Transform: t.Transform}
+	}
+	return &RawDTU{
+		SenderSessionID: local_session_id[:],
+		RecverSessionID: peer_session_id[:],
+		Sequence:        sequence,
+		Objects:         objects,
+	}
+}
+
+func (r *RawDTU) TryParse() (*DTU, error) {
+	ssid, err := uuid.FromBytes(r.SenderSessionID)
+	if err != nil {
+		return nil, err
+	}
+	rsid, err := uuid.FromBytes(r.RecverSessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Objects) > MaxDTUObjects {
+		return nil, errors.New("too many objects")
+	}
+	transforms := make([]abyss.ObjectTransform, len(r.Objects))
+	for i, o := range r.Objects {
+		oid, err := uuid.FromBytes(o.ID)
+		if err != nil {
+			return nil, err
+		}
+		transforms[i] = abyss.ObjectTransform{ID: oid, Transform: o.Transform}
+	}
+	return &DTU{ssid, rsid, r.Sequence, transforms}, nil
+}
+
+// DatagramRegistry has the datagram messages.
+var DatagramRegistry = NewRegistry()
+
+func init() {
+	MustRegister(DatagramRegistry, DTU_T, "DTU", (*RawDTU).TryParse)
+}
+
+// EncodeDatagram returns the datagram of a raw message registered in r.
+func (r *Registry) EncodeDatagram(raw any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.Encode(cbor.NewEncoder(&buf), raw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeDatagram decodes a datagram. Trailing bytes are ignored.
+func (r *Registry) DecodeDatagram(datagram []byte) (int, any, error) {
+	return r.Decode(NewDecoder(bytes.NewReader(datagram)))
+}
+
+// SequenceFilter drops stale object updates.
+// Each object keeps the sequence of its latest update; an update is
+// accepted only if its sequence is newer. The sender increments the
+// sequence for every datagram, so a reordered datagram loses against
+// a later one for the objects they share.
+// It is safe for concurrent use.
+type SequenceFilter struct {
+	mtx    sync.Mutex
+	latest map[uuid.UUID]uint64
+}
+
+func NewSequenceFilter() *SequenceFilter {
+	return &SequenceFilter{
+		latest: make(map[uuid.UUID]uint64),
+	}
+}
+
+// Filter returns the transforms newer than previously accepted ones.
+func (f *SequenceFilter) Filter(sequence uint64, transforms []abyss.ObjectTransform) []abyss.ObjectTransform {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	result := make([]abyss.ObjectTransform, 0, len(transforms))
+	for _, t := range transforms {
+		if latest, ok := f.latest[t.ID]; ok && latest >= sequence {
+			continue
+		}
+		f.latest[t.ID] = sequence
+		result = append(result, t)
+	}
+	return result
+}
+
+// Forget removes the objects, e.g. when they are deleted.
+func (f *SequenceFilter) Forget(objectIDs []uuid.UUID) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	for _, oid := range objectIDs {
+		delete(f.latest, oid)
+	}
+}
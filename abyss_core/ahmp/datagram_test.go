@@ -0,0 +1,75 @@
+package ahmp_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+func TestDTU(t *testing.T) {
+	ssid, rsid := uuid.New(), uuid.New()
+	transforms := make([]abyss.ObjectTransform, ahmp.MaxDTUObjects)
+	for i := range transforms {
+		transforms[i] = abyss.ObjectTransform{ID: uuid.New(), Transform: [7]float32{float32(i), 0, 0, 0, 0, 0, 1}}
+	}
+
+	datagram, err := ahmp.DatagramRegistry.EncodeDatagram(ahmp.MakeRawDTU(ssid, rsid, 7, transforms))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the minimum QUIC datagram payload is about 1200 bytes.
+	if len(datagram) > 1100 {
+		t.Fatal("datagram too large: ", len(datagram))
+	}
+	msg_type, msg, err := ahmp.DatagramRegistry.DecodeDatagram(datagram)
+	if err != nil || msg_type != ahmp.DTU_T {
+		t.Fatal(err)
+	}
+	dtu := msg.(*ahmp.DTU)
+	if dtu.SenderSessionID != ssid || dtu.RecverSessionID != rsid || dtu.Sequence != 7 || len(dtu.Objects) != len(transforms) {
+		t.Fatal("unexpected message: ", dtu)
+	}
+	for i := range transforms {
+		if dtu.Objects[i] != transforms[i] {
+			t.Fatal("transform mismatch: ", dtu.Objects[i])
+		}
+	}
+
+	if _, _, err := ahmp.DefaultRegistry.DecodeDatagram(datagram); !errors.Is(err, ahmp.ErrUnknownMessageType) {
+		t.Fatal("datagram decoded as a stream message: ", err)
+	}
+	datagram, _ = ahmp.DatagramRegistry.EncodeDatagram(ahmp.MakeRawDTU(ssid, rsid, 8, append(transforms, transforms[0])))
+	if _, _, err := ahmp.DatagramRegistry.DecodeDatagram(datagram); !errors.Is(err, ahmp.ErrInvalidMessage) {
+		t.Fatal("oversized DTU accepted: ", err)
+	}
+}
+
+func TestSequenceFilter(t *testing.T) {
+	a := abyss.ObjectTransform{ID: uuid.New()}
+	b := abyss.ObjectTransform{ID: uuid.New()}
+	filter := ahmp.NewSequenceFilter()
+
+	expect := func(sequence uint64, transforms []abyss.ObjectTransform, expected ...abyss.ObjectTransform) {
+		t.Helper()
+		result := filter.Filter(sequence, transforms)
+		if len(result) != len(expected) {
+			t.Fatal("unexpected result: ", result)
+		}
+		for i := range result {
+			if result[i].ID != expected[i].ID {
+				t.Fatal("unexpected result: ", result)
+			}
+		}
+	}
+	expect(2, []abyss.ObjectTransform{a}, a)
+	expect(1, []abyss.ObjectTransform{a, b}, b) // reordered; a is stale
+	expect(2, []abyss.ObjectTransform{a, b}, b) // duplicate for a
+	expect(2, []abyss.ObjectTransform{b})
+	expect(3, []abyss.ObjectTransform{a, b}, a, b)
+
+	filter.Forget([]uuid.UUID{a.ID})
+	expect(1, []abyss.ObjectTransform{a, b}, a)
+}
@@ -0,0 +1,51 @@
+package ahmp
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+var ErrUnhandledMessage = errors.New("no handler for AHMP message")
+
+// Dispatcher routes parsed messages to handlers by their Go type.
+// P is what handlers receive along with the message, typically the sending peer.
+// It is safe for concurrent use; handlers can be replaced while dispatching.
+type Dispatcher[P any] struct {
+	mtx      sync.RWMutex
+	handlers map[reflect.Type]func(P, any) error
+}
+
+func NewDispatcher[P any]() *Dispatcher[P] {
+	return &Dispatcher[P]{
+		handlers: make(map[reflect.Type]func(P, any) error),
+	}
+}
+
+// Handle subscribes handler to messages of type *M, replacing the previous one.
+func Handle[P any, M any](d *Dispatcher[P], handler func(P, *M) error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.handlers[reflect.TypeFor[*M]()] = func(peer P, msg any) error { return handler(peer, msg.(*M)) }
+}
+
+// Unhandle removes the handler for messages of type *M.
+func Unhandle[P any, M any](d *Dispatcher[P]) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	delete(d.handlers, reflect.TypeFor[*M]())
+}
+
+// Dispatch calls the handler for msg, and returns its error.
+// It returns ErrUnhandledMessage if there is no handler.
+func (d *Dispatcher[P]) Dispatch(peer P, msg any) error {
+	d.mtx.RLock()
+	handler, ok := d.handlers[reflect.TypeOf(msg)]
+	d.mtx.RUnlock()
+	if !ok {
+		return ErrUnhandledMessage
+	}
+	return handler(peer, msg)
+}
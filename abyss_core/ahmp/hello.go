@@ -0,0 +1,105 @@
+package ahmp
+
+import (
+	"io"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+///// AHMP protocol hello
+// The hello is carried in handshake 1 and 2, so it costs no round trip.
+// A dialer sends RawHS1 with Hello; a server that understands it answers
+// with RawHS2 instead of the bare binding certificate.
+// The beta network service sends RawHS2 in both directions, if both sides offer it in ALPN.
+// Legacy peers never send a hello, and are treated as version 0 without capabilities.
+
+// ProtocolVersion is incremented when the meaning of an existing message changes.
+// Added messages and optional fields are announced by capabilities instead.
+const ProtocolVersion = 1
+
+// Capability is a bit set of optional protocol features.
+// It is an alias, so that interfaces without ahmp dependency (ani) can take it.
+type Capability = uint64
+
+const (
+	CapNATControl         Capability = 1 << iota // OBQ, HPR, HPN, HPA
+	CapRelay                                     // RLR, RLN, RLA
+	CapHandshakeKeyUpdate                        // HKU, HKR, HKA
+	CapMemberMessage                             // SAM
+	CapObjectDatagram                            // DTU
+	CapObjectUpdate                              // SOU
+	CapModeration                                // MOD, JOK access list
+	CapPartialMesh                               // ROS, JOK interest radius
+)
+
+// LocalCapabilities is what this build supports.
+const LocalCapabilities = CapNATControl | CapRelay | CapHandshakeKeyUpdate | CapMemberMessage | CapObjectDatagram | CapObjectUpdate | CapModeration | CapPartialMesh
+
+// Hello is the protocol version and capabilities of a peer.
+type Hello struct {
+	Version      int
+	Capabilities Capability
+}
+
+// LegacyHello is assumed for peers that do not send a hello.
+var LegacyHello = Hello{}
+
+func LocalHello() Hello {
+	return Hello{Version: ProtocolVersion, Capabilities: LocalCapabilities}
+}
+
+// Has reports whether every bit of c is supported.
+func (h Hello) Has(c Capability) bool {
+	return h.Capabilities&c == c
+}
+
+type RawHello struct {
+	Version      int
+	Capabilities uint64
+}
+
+func MakeRawHello(hello Hello) *RawHello {
+	return &RawHello{Version: hello.Version, Capabilities: hello.Capabilities}
+}
+
+// TryParse never fails; unknown capability bits are kept, as they are harmless.
+func (r *RawHello) TryParse() Hello {
+	return Hello{Version: r.Version, Capabilities: r.Capabilities}
+}
+
+// RawHS2 is handshake 2 for dialers that sent a hello.
+type RawHS2 struct {
+	BindingCertificate []byte
+	Hello              RawHello
+}
+
+// ParseHS2 accepts both the legacy handshake 2 (a bare binding certificate)
+// and RawHS2. The legacy form results in LegacyHello.
+func ParseHS2(raw cbor.RawMessage) ([]byte, Hello, error) {
+	var binding_cert []byte
+	if err := cbor.Unmarshal(raw, &binding_cert); err == nil {
+		return binding_cert, LegacyHello, nil
+	}
+	var hs2 RawHS2
+	if err := decMode.Unmarshal(raw, &hs2); err != nil {
+		return nil, LegacyHello, err
+	}
+	return hs2.BindingCertificate, hs2.Hello.TryParse(), nil
+}
+
+// decMode ignores unknown map keys, so that messages can gain optional fields
+// without breaking older peers. This is the cbor default; it is stated here as
+// a protocol requirement.
+var decMode, _ = cbor.DecOptions{ExtraReturnErrors: cbor.ExtraDecErrorNone}.DecMode()
+
+// NewDecoder returns a decoder for AHMP streams.
+func NewDecoder(r io.Reader) *cbor.Decoder {
+	return decMode.NewDecoder(r)
+}
+
+// SkipMessage discards the body of a message of unknown type.
+// Every AHMP message is a single CBOR item after its type, so the stream stays in sync.
+func SkipMessage(decoder *cbor.Decoder) error {
+	var body cbor.RawMessage
+	return decoder.Decode(&body)
+}
@@ -1,6 +1,7 @@
 package ahmp
 
 import (
+	"net/netip"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,6 +9,25 @@ import (
 	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
 )
 
+///// abyss node control (NAT traversal)
+
+type OBR struct {
+	ObservedAddr netip.AddrPort
+}
+type HPR struct {
+	TargetID   string
+	Candidates []netip.AddrPort
+}
+type HPN struct {
+	SourceID   string
+	Candidates []netip.AddrPort
+}
+type HPA struct {
+	Accepted   bool
+	Candidates []netip.AddrPort
+	Text       string //optional
+}
+
 ///// AND
 
 type JN struct {
@@ -21,6 +41,10 @@ type JOK struct {
 	TimeStamp       time.Time
 	Neighbors       []abyss.ANDFullPeerSessionIdentity
 	Text            string
+	Access          *abyss.WorldAccess //nil from nodes without moderation support
+	MaxMembers      int                //0 is unlimited
+	InterestRadius  float32            //0 is the full mesh
+	InviteOnly      bool
 }
 type JDN struct {
 	RecverSessionID uuid.UUID
@@ -63,6 +87,35 @@ type SOD struct {
 	RecverSessionID uuid.UUID
 	ObjectIDs       []uuid.UUID
 }
+type SAM struct {
+	SenderSessionID uuid.UUID
+	RecverSessionID uuid.UUID
+	Topic           string
+	Payload         []byte
+}
+type SOU struct {
+	SenderSessionID uuid.UUID
+	RecverSessionID uuid.UUID
+	Objects         []abyss.ObjectUpdate
+}
+type MOD struct {
+	SenderSessionID uuid.UUID
+	RecverSessionID uuid.UUID
+	Moderation      *abyss.WorldModeration
+}
+type ROS struct {
+	SenderSessionID uuid.UUID
+	RecverSessionID uuid.UUID
+	Keep            bool
+	Local           abyss.ANDRosterEntry //without identity
+	Roster          []abyss.ANDRosterEntry
+}
+type DTU struct {
+	SenderSessionID uuid.UUID
+	RecverSessionID uuid.UUID
+	Sequence        uint64
+	Objects         []abyss.ObjectTransform
+}
 
 type INVAL struct {
 	Err error
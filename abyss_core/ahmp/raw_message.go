@@ -2,10 +2,13 @@ package ahmp
 
 import (
 	"errors"
+	"math"
+	"net/netip"
 	"time"
 
 	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
 	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
 	"github.com/kadmila/Abyss-Browser/abyss_core/tools/functional"
 
 	"github.com/google/uuid"
@@ -13,9 +16,156 @@ import (
 
 ///// AHMP for abyss handshake
 
+// Suite is sec.HandshakeSuite. It is omitted for the legacy RSA-OAEP suite,
+// so that nodes without HPKE support read the message as before.
+// Hello is ignored by legacy nodes; see hello.go.
 type RawHS1 struct {
 	EncryptedCertificate []byte
 	EncryptedSecret      []byte
+	Suite                int       `cbor:",omitempty"`
+	Hello                *RawHello `cbor:",omitempty"`
+}
+
+///// AHMP for abyss node control streams (NAT traversal)
+// Each control stream carries one request and one reply.
+// Every message is preceded by its type.
+
+const (
+	OBQ_T int = iota + 64 // observed address query
+	OBR_T                 // observed address reply
+	HPR_T                 // hole punch request, to the rendezvous peer
+	HPN_T                 // hole punch notification, from the rendezvous peer
+	HPA_T                 // hole punch answer
+	RLR_T                 // relay request, to the relay peer
+	RLN_T                 // relay notification, from the relay peer
+	RLA_T                 // relay answer
+	HKU_T                 // handshake key update
+	HKR_T                 // handshake key revocation
+	HKA_T                 // handshake key update/revocation answer
+)
+
+// MaxAddrCandidates limits the address candidates of a hole punch message,
+// as the receiver dials every one of them.
+const MaxAddrCandidates = 8
+
+type RawOBQ struct{}
+
+type RawOBR struct {
+	ObservedAddr string
+}
+
+func (r *RawOBR) TryParse() (*OBR, error) {
+	addr, err := netip.ParseAddrPort(r.ObservedAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &OBR{addr}, nil
+}
+
+type RawHPR struct {
+	TargetID   string
+	Candidates []string
+}
+
+func (r *RawHPR) TryParse() (*HPR, error) {
+	candidates, err := parseAddrPorts(r.Candidates)
+	if err != nil {
+		return nil, err
+	}
+	return &HPR{r.TargetID, candidates}, nil
+}
+
+type RawHPN struct {
+	SourceID   string
+	Candidates []string
+}
+
+func (r *RawHPN) TryParse() (*HPN, error) {
+	candidates, err := parseAddrPorts(r.Candidates)
+	if err != nil {
+		return nil, err
+	}
+	return &HPN{r.SourceID, candidates}, nil
+}
+
+type RawHPA struct {
+	Accepted   bool
+	Candidates []string
+	Text       string
+}
+
+func (r *RawHPA) TryParse() (*HPA, error) {
+	candidates, err := parseAddrPorts(r.Candidates)
+	if err != nil {
+		return nil, err
+	}
+	return &HPA{r.Accepted, candidates, r.Text}, nil
+}
+
+// After an accepted RLA, the control stream carries an end-to-end TLS session.
+
+type RawRLR struct {
+	TargetID string
+}
+
+type RawRLN struct {
+	SourceID string
+}
+
+type RawRLA struct {
+	Accepted bool
+	Text     string
+}
+
+// HKU and HKR are sent by the owner of the handshake key, to every connected peer.
+
+type RawHKU struct {
+	HandshakeKeyCertificate []byte // der
+}
+
+type RawHKR struct {
+	ID              string
+	CertificateHash []byte
+	RevokeTime      int64 // unix seconds
+	Signature       []byte
+}
+
+func (r *RawHKR) TryParse() (*sec.HandshakeKeyRevocation, error) {
+	if len(r.CertificateHash) != 32 {
+		return nil, errors.New("invalid certificate hash")
+	}
+	return &sec.HandshakeKeyRevocation{
+		ID:              r.ID,
+		CertificateHash: [32]byte(r.CertificateHash),
+		RevokeTime:      time.Unix(r.RevokeTime, 0),
+		Signature:       r.Signature,
+	}, nil
+}
+
+func MakeRawHKR(revocation *sec.HandshakeKeyRevocation) *RawHKR {
+	return &RawHKR{
+		ID:              revocation.ID,
+		CertificateHash: revocation.CertificateHash[:],
+		RevokeTime:      revocation.RevokeTime.Unix(),
+		Signature:       revocation.Signature,
+	}
+}
+
+type RawHKA struct {
+	Accepted bool
+	Text     string
+}
+
+func parseAddrPorts(raw []string) ([]netip.AddrPort, error) {
+	if len(raw) > MaxAddrCandidates {
+		return nil, errors.New("too many address candidates")
+	}
+	result, _, err := functional.Filter_until_err(raw, netip.ParseAddrPort)
+	return result, err
+}
+
+func FormatAddrPorts(addrs []netip.AddrPort) []string {
+	return functional.Filter(addrs, netip.AddrPort.String)
 }
 
 ///// AHMP for AND
@@ -45,10 +195,15 @@ const (
 
 	SOA_T
 	SOD_T
+
+	SAM_T // application message between world members
+	SOU_T // object update
+	MOD_T // world moderation
+	ROS_T // roster gossip, in the partial mesh
 )
 
 // Msg_type_names for debug
-var Msg_type_names = [...]string{"JN", "JOK", "JDN", "JNI", "MEM", "SJN", "CRR", "RST", "SOA", "SOD"}
+var Msg_type_names = [...]string{"JN", "JOK", "JDN", "JNI", "MEM", "SJN", "CRR", "RST", "SOA", "SOD", "SAM", "SOU", "MOD", "ROS"}
 
 type RawJN struct {
 	SenderSessionID string
@@ -64,12 +219,19 @@ func (r *RawJN) TryParse() (*JN, error) {
 	return &JN{ssid, r.Text, time.UnixMilli(r.TimeStamp)}, nil
 }
 
+// Access is omitted by nodes without moderation support, MaxMembers by nodes
+// without capacity limits, InterestRadius by nodes without the partial mesh,
+// and InviteOnly by nodes without invites.
 type RawJOK struct {
 	SenderSessionID string
 	RecverSessionID string
 	TimeStamp       int64
 	Text            string
 	Neighbors       []RawSessionInfoForDiscovery
+	Access          *RawWorldAccess `cbor:",omitempty"`
+	MaxMembers      int             `cbor:",omitempty"`
+	InterestRadius  float32         `cbor:",omitempty"`
+	InviteOnly      bool            `cbor:",omitempty"`
 }
 
 func (r *RawJOK) TryParse() (*JOK, error) {
@@ -101,7 +263,72 @@ func (r *RawJOK) TryParse() (*JOK, error) {
 	if !ok {
 		return nil, errors.New("failed to parse session information")
 	}
-	return &JOK{ssid, rsid, time.UnixMilli(r.TimeStamp), neig, r.Text}, nil
+	var access *abyss.WorldAccess
+	if r.Access != nil {
+		access, err = r.Access.TryParse()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.MaxMembers < 0 {
+		return nil, errors.New("invalid member limit")
+	}
+	if !(r.InterestRadius >= 0) { // also NaN
+		return nil, errors.New("invalid interest radius")
+	}
+	return &JOK{ssid, rsid, time.UnixMilli(r.TimeStamp), neig, r.Text, access, r.MaxMembers, r.InterestRadius, r.InviteOnly}, nil
+}
+
+type RawWorldAccess struct {
+	WorldID          string
+	Owner            string
+	OwnerRootCertDer []byte
+	Signature        []byte
+	Moderations      []RawWorldModeration
+}
+
+func MakeRawWorldAccess(access *abyss.WorldAccess) *RawWorldAccess {
+	if access == nil {
+		return nil
+	}
+	moderations := make([]RawWorldModeration, len(access.Moderations))
+	for i, moderation := range access.Moderations {
+		moderations[i] = MakeRawWorldModeration(moderation)
+	}
+	return &RawWorldAccess{
+		WorldID:          access.WorldID.String(),
+		Owner:            access.Owner,
+		OwnerRootCertDer: access.OwnerRootCertDer,
+		Signature:        access.Signature,
+		Moderations:      moderations,
+	}
+}
+
+func (r *RawWorldAccess) TryParse() (*abyss.WorldAccess, error) {
+	wid, err := uuid.Parse(r.WorldID)
+	if err != nil {
+		return nil, err
+	}
+	if r.Owner == "" {
+		return nil, errors.New("world access without owner")
+	}
+	if len(r.Moderations) > abyss.MaxWorldAccessModerations {
+		return nil, errors.New("too many moderations")
+	}
+	moderations := make([]*abyss.WorldModeration, len(r.Moderations))
+	for i := range r.Moderations {
+		moderations[i], err = r.Moderations[i].TryParse()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &abyss.WorldAccess{
+		WorldID:          wid,
+		Owner:            r.Owner,
+		OwnerRootCertDer: r.OwnerRootCertDer,
+		Signature:        r.Signature,
+		Moderations:      moderations,
+	}, nil
 }
 
 type RawJDN struct {
@@ -305,3 +532,305 @@ func (r *RawSOD) TryParse() (*SOD, error) {
 	}
 	return &SOD{ssid, rsid, oids}, nil
 }
+
+// SAM limits. A SAM is sent on the AHMP stream, so a large payload
+// delays every other world message to the peer.
+const (
+	MaxSAMTopicLength = 256
+	MaxSAMPayloadSize = 64 * 1024
+)
+
+type RawSAM struct {
+	SenderSessionID string
+	RecverSessionID string
+	Topic           string
+	Payload         []byte
+}
+
+func (r *RawSAM) TryParse() (*SAM, error) {
+	ssid, err := uuid.Parse(r.SenderSessionID)
+	if err != nil {
+		return nil, err
+	}
+	rsid, err := uuid.Parse(r.RecverSessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Topic) == 0 || len(r.Topic) > MaxSAMTopicLength {
+		return nil, errors.New("invalid topic length")
+	}
+	if len(r.Payload) > MaxSAMPayloadSize {
+		return nil, errors.New("payload too large")
+	}
+	return &SAM{ssid, rsid, r.Topic, r.Payload}, nil
+}
+
+// RawObjectUpdate omits what is unchanged.
+type RawObjectUpdate struct {
+	ID                string
+	Transform         *[7]float32       `cbor:",omitempty"`
+	Properties        map[string]string `cbor:",omitempty"`
+	RemovedProperties []string          `cbor:",omitempty"`
+}
+
+// SOU limits. Like SAM, an SOU is sent on the AHMP stream.
+// A property counts toward MaxSOUProperties whether it is set or removed.
+const (
+	MaxSOUObjects           = 256
+	MaxSOUProperties        = 64
+	MaxSOUPropertyKeyLength = 256
+	MaxSOUPropertyValueSize = 16 * 1024
+)
+
+type RawSOU struct {
+	SenderSessionID string
+	RecverSessionID string
+	Objects         []RawObjectUpdate
+}
+
+func (r *RawSOU) TryParse() (*SOU, error) {
+	ssid, err := uuid.Parse(r.SenderSessionID)
+	if err != nil {
+		return nil, err
+	}
+	rsid, err := uuid.Parse(r.RecverSessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Objects) > MaxSOUObjects {
+		return nil, errors.New("too many objects")
+	}
+	updates, _, err := functional.Filter_until_err(r.Objects,
+		func(update_raw RawObjectUpdate) (abyss.ObjectUpdate, error) {
+			oid, err := uuid.Parse(update_raw.ID)
+			if err == nil {
+				err = update_raw.checkLimits()
+			}
+			return abyss.ObjectUpdate{
+				ID:                oid,
+				Transform:         update_raw.Transform,
+				Properties:        update_raw.Properties,
+				RemovedProperties: update_raw.RemovedProperties,
+			}, err
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &SOU{ssid, rsid, updates}, nil
+}
+
+func (u *RawObjectUpdate) checkLimits() error {
+	if len(u.Properties)+len(u.RemovedProperties) > MaxSOUProperties {
+		return errors.New("too many properties")
+	}
+	for key, value := range u.Properties {
+		if len(key) > MaxSOUPropertyKeyLength {
+			return errors.New("property key too long")
+		}
+		if len(value) > MaxSOUPropertyValueSize {
+			return errors.New("property value too large")
+		}
+	}
+	for _, key := range u.RemovedProperties {
+		if len(key) > MaxSOUPropertyKeyLength {
+			return errors.New("property key too long")
+		}
+	}
+	return nil
+}
+
+// MaxMODReasonLength bounds the reason of a moderation action.
+const MaxMODReasonLength = 1024
+
+type RawWorldModeration struct {
+	WorldID           string
+	Action            int
+	Target            string
+	Issuer            string
+	IssuerRootCertDer []byte
+	Reason            string
+	TimeStamp         int64
+	Signature         []byte
+}
+
+func MakeRawWorldModeration(moderation *abyss.WorldModeration) RawWorldModeration {
+	return RawWorldModeration{
+		WorldID:           moderation.WorldID.String(),
+		Action:            int(moderation.Action),
+		Target:            moderation.Target,
+		Issuer:            moderation.Issuer,
+		IssuerRootCertDer: moderation.IssuerRootCertDer,
+		Reason:            moderation.Reason,
+		TimeStamp:         moderation.TimeStamp.UnixMilli(),
+		Signature:         moderation.Signature,
+	}
+}
+
+func (r *RawWorldModeration) TryParse() (*abyss.WorldModeration, error) {
+	wid, err := uuid.Parse(r.WorldID)
+	if err != nil {
+		return nil, err
+	}
+	action := abyss.ModerationAction(r.Action)
+	if action < abyss.ModKick || action > abyss.ModRevokeModerator {
+		return nil, errors.New("unknown moderation action")
+	}
+	if r.Target == "" || r.Issuer == "" || len(r.Signature) == 0 {
+		return nil, errors.New("incomplete moderation")
+	}
+	if len(r.Reason) > MaxMODReasonLength {
+		return nil, errors.New("reason too long")
+	}
+	return &abyss.WorldModeration{
+		WorldID:           wid,
+		Action:            action,
+		Target:            r.Target,
+		Issuer:            r.Issuer,
+		IssuerRootCertDer: r.IssuerRootCertDer,
+		Reason:            r.Reason,
+		TimeStamp:         time.UnixMilli(r.TimeStamp),
+		Signature:         r.Signature,
+	}, nil
+}
+
+// RawMOD embeds the moderation, so its fields are flattened on the wire.
+type RawMOD struct {
+	SenderSessionID string
+	RecverSessionID string
+	RawWorldModeration
+}
+
+func MakeRawMOD(local_session_id uuid.UUID, peer_session_id uuid.UUID, moderation *abyss.WorldModeration) *RawMOD {
+	return &RawMOD{
+		SenderSessionID:    local_session_id.String(),
+		RecverSessionID:    peer_session_id.String(),
+		RawWorldModeration: MakeRawWorldModeration(moderation),
+	}
+}
+
+func (r *RawMOD) TryParse() (*MOD, error) {
+	ssid, err := uuid.Parse(r.SenderSessionID)
+	if err != nil {
+		return nil, err
+	}
+	rsid, err := uuid.Parse(r.RecverSessionID)
+	if err != nil {
+		return nil, err
+	}
+	moderation, err := r.RawWorldModeration.TryParse()
+	if err != nil {
+		return nil, err
+	}
+	return &MOD{ssid, rsid, moderation}, nil
+}
+
+// MaxROSEntries bounds the roster of a ROS.
+const MaxROSEntries = 4096
+
+// RawRosterEntry has no identity for the sender itself.
+type RawRosterEntry struct {
+	AURL                       string      `cbor:",omitempty"`
+	SessionID                  string      `cbor:",omitempty"`
+	TimeStamp                  int64       `cbor:",omitempty"`
+	RootCertificateDer         []byte      `cbor:",omitempty"`
+	HandshakeKeyCertificateDer []byte      `cbor:",omitempty"`
+	Position                   *[3]float32 `cbor:",omitempty"`
+	Heartbeat                  uint64
+	Left                       bool `cbor:",omitempty"`
+}
+type RawROS struct {
+	SenderSessionID string
+	RecverSessionID string
+	Keep            bool `cbor:",omitempty"`
+	Local           RawRosterEntry
+	Roster          []RawRosterEntry
+}
+
+func makeRawRosterEntry(entry abyss.ANDRosterEntry) RawRosterEntry {
+	result := RawRosterEntry{
+		Position:  entry.Position,
+		Heartbeat: entry.Heartbeat,
+		Left:      entry.Left,
+	}
+	if entry.AURL != nil {
+		result.AURL = entry.AURL.ToString()
+		result.SessionID = entry.SessionID.String()
+		result.TimeStamp = entry.TimeStamp.UnixMilli()
+		result.RootCertificateDer = entry.RootCertificateDer
+		result.HandshakeKeyCertificateDer = entry.HandshakeKeyCertificateDer
+	}
+	return result
+}
+
+func MakeRawROS(local_session_id uuid.UUID, peer_session_id uuid.UUID, keep bool, local abyss.ANDRosterEntry, roster []abyss.ANDRosterEntry) *RawROS {
+	return &RawROS{
+		SenderSessionID: local_session_id.String(),
+		RecverSessionID: peer_session_id.String(),
+		Keep:            keep,
+		Local:           makeRawRosterEntry(abyss.ANDRosterEntry{Position: local.Position, Heartbeat: local.Heartbeat, Left: local.Left}),
+		Roster:          functional.Filter(roster, makeRawRosterEntry),
+	}
+}
+
+func isFinitePosition(position *[3]float32) bool {
+	if position == nil {
+		return true
+	}
+	for _, v := range position {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *RawRosterEntry) tryParse() (abyss.ANDRosterEntry, error) {
+	if !isFinitePosition(r.Position) {
+		return abyss.ANDRosterEntry{}, errors.New("invalid position")
+	}
+	abyss_url, err := aurl.TryParse(r.AURL)
+	if err != nil {
+		return abyss.ANDRosterEntry{}, err
+	}
+	psid, err := uuid.Parse(r.SessionID)
+	if err != nil {
+		return abyss.ANDRosterEntry{}, err
+	}
+	return abyss.ANDRosterEntry{
+		ANDFullPeerSessionIdentity: abyss.ANDFullPeerSessionIdentity{
+			AURL:                       abyss_url,
+			SessionID:                  psid,
+			TimeStamp:                  time.UnixMilli(r.TimeStamp),
+			RootCertificateDer:         r.RootCertificateDer,
+			HandshakeKeyCertificateDer: r.HandshakeKeyCertificateDer,
+		},
+		Position:  r.Position,
+		Heartbeat: r.Heartbeat,
+		Left:      r.Left,
+	}, nil
+}
+
+func (r *RawROS) TryParse() (*ROS, error) {
+	ssid, err := uuid.Parse(r.SenderSessionID)
+	if err != nil {
+		return nil, err
+	}
+	rsid, err := uuid.Parse(r.RecverSessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !isFinitePosition(r.Local.Position) {
+		return nil, errors.New("invalid position")
+	}
+	if len(r.Roster) > MaxROSEntries {
+		return nil, errors.New("too many roster entries")
+	}
+	roster, _, err := functional.Filter_until_err(r.Roster, func(entry_raw RawRosterEntry) (abyss.ANDRosterEntry, error) {
+		return entry_raw.tryParse()
+	})
+	if err != nil {
+		return nil, err
+	}
+	local := abyss.ANDRosterEntry{Position: r.Local.Position, Heartbeat: r.Local.Heartbeat, Left: r.Local.Left}
+	return &ROS{ssid, rsid, r.Keep, local, roster}, nil
+}
@@ -1,6 +1,7 @@
 package and
 
 import (
+	"math"
 	"sync"
 	"time"
 
@@ -19,6 +20,8 @@ type AND struct {
 	peers  map[string]abyss.IANDPeer //id hash - peer
 	worlds map[uuid.UUID]*ANDWorld   //local session id - world
 
+	signer abyss.IRootSigner //nil disables moderation
+
 	stat ANDStatistics
 
 	api_mtx *sync.Mutex
@@ -34,6 +37,14 @@ func NewAND(local_hash string) *AND {
 	}
 }
 
+// SetRootSigner enables moderation in worlds opened or joined afterwards.
+func (a *AND) SetRootSigner(signer abyss.IRootSigner) {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	a.signer = signer
+}
+
 func (a *AND) EventChannel() chan abyss.NeighborEvent {
 	return a.eventCh
 }
@@ -156,6 +167,104 @@ func (a *AND) CloseWorld(local_session_id uuid.UUID) abyss.ANDERROR {
 	return 0
 }
 
+func (a *AND) SetWorldCapacity(local_session_id uuid.UUID, max_members int, queue_length int) abyss.ANDERROR {
+	//debug
+	watchdog.Info("appCall::SetWorldCapacity " + local_session_id.String())
+
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	if max_members < 0 || queue_length < 0 {
+		return abyss.EINVAL
+	}
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		a.stat.B(44)
+		return abyss.EINVAL
+	}
+	a.stat.B(45)
+
+	world.SetCapacity(max_members, queue_length)
+	return 0
+}
+
+func (a *AND) SetInviteOnly(local_session_id uuid.UUID, invite_only bool) abyss.ANDERROR {
+	//debug
+	watchdog.Info("appCall::SetInviteOnly " + local_session_id.String())
+
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		a.stat.B(52)
+		return abyss.EINVAL
+	}
+	a.stat.B(53)
+
+	world.invite_only = invite_only
+	return 0
+}
+
+func (a *AND) IsInviteOnly(local_session_id uuid.UUID) bool {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	world, ok := a.worlds[local_session_id]
+	return ok && world.invite_only
+}
+
+// SetInterest switches a world to the partial mesh; radius 0 keeps the full mesh.
+func (a *AND) SetInterest(local_session_id uuid.UUID, radius float32) abyss.ANDERROR {
+	//debug
+	watchdog.Info("appCall::SetInterest " + local_session_id.String())
+
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	if !(radius >= 0) || math.IsInf(float64(radius), 0) {
+		return abyss.EINVAL
+	}
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		a.stat.B(48)
+		return abyss.EINVAL
+	}
+	a.stat.B(49)
+
+	return world.SetInterest(radius)
+}
+
+func (a *AND) UpdatePosition(local_session_id uuid.UUID, position [3]float32) abyss.ANDERROR {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	for _, v := range position {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return abyss.EINVAL
+		}
+	}
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		a.stat.B(50)
+		return abyss.EINVAL
+	}
+	a.stat.B(51)
+
+	return world.UpdatePosition(position)
+}
+
+func (a *AND) Roster(local_session_id uuid.UUID) []abyss.ANDRosterEntry {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		return nil
+	}
+	return world.Roster()
+}
+
 func (a *AND) TimerExpire(local_session_id uuid.UUID) abyss.ANDERROR {
 	a.api_mtx.Lock()
 	defer a.api_mtx.Unlock()
@@ -185,7 +294,7 @@ func (a *AND) JN(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession,
 	world.JN(peer_session, timestamp)
 	return 0
 }
-func (a *AND) JOK(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession, timestamp time.Time, world_url string, member_infos []abyss.ANDFullPeerSessionIdentity) abyss.ANDERROR {
+func (a *AND) JOK(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession, timestamp time.Time, world_url string, member_infos []abyss.ANDFullPeerSessionIdentity, settings abyss.WorldSettings) abyss.ANDERROR {
 	a.api_mtx.Lock()
 	defer a.api_mtx.Unlock()
 
@@ -196,7 +305,7 @@ func (a *AND) JOK(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession,
 	}
 	a.stat.B(17)
 
-	world.JOK(peer_session, timestamp, world_url, member_infos)
+	world.JOK(peer_session, timestamp, world_url, member_infos, settings)
 	return 0
 }
 func (a *AND) JDN(local_session_id uuid.UUID, peer abyss.IANDPeer, code int, message string) abyss.ANDERROR {
@@ -210,7 +319,7 @@ func (a *AND) JDN(local_session_id uuid.UUID, peer abyss.IANDPeer, code int, mes
 	}
 	a.stat.B(19)
 
-	world.JDN(peer, code, message) // after, world should be manually closed from application-side.
+	world.JDN(peer, code, message) // closes the world
 	return 0
 }
 func (a *AND) JNI(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession, member_info abyss.ANDFullPeerSessionIdentity) abyss.ANDERROR {
@@ -234,6 +343,9 @@ func (a *AND) MEM(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession,
 	world, ok := a.worlds[local_session_id]
 	if !ok {
 		a.stat.B(22)
+
+		a.stat.RST_TX++
+		peer_session.Peer.TrySendRST(local_session_id, peer_session.PeerSessionID, "MEM::world not found") //the world may have closed after JNI
 		return 0
 	}
 	a.stat.B(23)
@@ -323,6 +435,92 @@ func (a *AND) SOD(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession,
 	world.SOD(peer_session, objectIDs)
 	return 0
 }
+func (a *AND) SOU(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession, updates []abyss.ObjectUpdate) abyss.ANDERROR {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		a.stat.B(38)
+		return 0
+	}
+	a.stat.B(39)
+
+	world.SOU(peer_session, updates)
+	return 0
+}
+func (a *AND) SAM(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession, topic string, payload []byte) abyss.ANDERROR {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		a.stat.B(36)
+		return 0
+	}
+	a.stat.B(37)
+
+	world.SAM(peer_session, topic, payload)
+	return 0
+}
+
+func (a *AND) MOD(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession, moderation *abyss.WorldModeration) abyss.ANDERROR {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		a.stat.B(40)
+		return 0
+	}
+	a.stat.B(41)
+
+	world.MOD(peer_session, moderation)
+	return 0
+}
+
+func (a *AND) ROS(local_session_id uuid.UUID, peer_session abyss.ANDPeerSession, keep bool, local abyss.ANDRosterEntry, roster []abyss.ANDRosterEntry) abyss.ANDERROR {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		a.stat.B(46)
+		return 0
+	}
+	a.stat.B(47)
+
+	world.ROS(peer_session, keep, local, roster)
+	return 0
+}
+
+func (a *AND) Moderate(local_session_id uuid.UUID, action abyss.ModerationAction, target_hash string, reason string) abyss.ANDERROR {
+	//debug
+	watchdog.Info("appCall::Moderate " + local_session_id.String() + " " + target_hash)
+
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		a.stat.B(42)
+		return abyss.EINVAL
+	}
+	a.stat.B(43)
+
+	return world.Moderate(action, target_hash, reason)
+}
+
+func (a *AND) Role(local_session_id uuid.UUID, peer_hash string) abyss.WorldRole {
+	a.api_mtx.Lock()
+	defer a.api_mtx.Unlock()
+
+	world, ok := a.worlds[local_session_id]
+	if !ok {
+		return abyss.RoleMember
+	}
+	return world.access.role(peer_hash)
+}
 
 func (a *AND) Statistics() string {
 	return a.stat.String()
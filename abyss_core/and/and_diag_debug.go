@@ -18,6 +18,10 @@ type ANDStatistics struct {
 	RST_TX int
 	SOA_TX int
 	SOD_TX int
+	SAM_TX int
+	SOU_TX int
+	MOD_TX int
+	ROS_TX int
 
 	JN_RX  int
 	JOK_RX int
@@ -29,9 +33,13 @@ type ANDStatistics struct {
 	RST_RX int
 	SOA_RX int
 	SOD_RX int
+	SAM_RX int
+	SOU_RX int
+	MOD_RX int
+	ROS_RX int
 
-	_b [36]int
-	_w [85]int
+	_b [54]int
+	_w [125]int
 }
 
 func (s *ANDStatistics) B(i int) {
@@ -42,6 +50,11 @@ func (s *ANDStatistics) W(i int) {
 	s._w[i]++
 }
 
+// Branches returns copies of the branch counters.
+func (s *ANDStatistics) Branches() ([]int, []int) {
+	return append([]int(nil), s._b[:]...), append([]int(nil), s._w[:]...)
+}
+
 // three-digit notation
 func __tdn(i int) string {
 	if i < 0 {
@@ -69,7 +82,7 @@ func __tdn(i int) string {
 
 func (s *ANDStatistics) String() string {
 	var sb strings.Builder
-	sb.WriteString(" JN JOK JDN JNI MEM SJN CRR RST SOA SOD\n")
+	sb.WriteString(" JN JOK JDN JNI MEM SJN CRR RST SOA SOD SAM SOU MOD ROS\n")
 	sb.WriteString(__tdn(s.JN_TX))
 	sb.WriteString(__tdn(s.JOK_TX))
 	sb.WriteString(__tdn(s.JDN_TX))
@@ -80,6 +93,10 @@ func (s *ANDStatistics) String() string {
 	sb.WriteString(__tdn(s.RST_TX))
 	sb.WriteString(__tdn(s.SOA_TX))
 	sb.WriteString(__tdn(s.SOD_TX))
+	sb.WriteString(__tdn(s.SAM_TX))
+	sb.WriteString(__tdn(s.SOU_TX))
+	sb.WriteString(__tdn(s.MOD_TX))
+	sb.WriteString(__tdn(s.ROS_TX))
 	sb.WriteString("\n")
 	sb.WriteString(__tdn(s.JN_RX))
 	sb.WriteString(__tdn(s.JOK_RX))
@@ -91,6 +108,10 @@ func (s *ANDStatistics) String() string {
 	sb.WriteString(__tdn(s.RST_RX))
 	sb.WriteString(__tdn(s.SOA_RX))
 	sb.WriteString(__tdn(s.SOD_RX))
+	sb.WriteString(__tdn(s.SAM_RX))
+	sb.WriteString(__tdn(s.SOU_RX))
+	sb.WriteString(__tdn(s.MOD_RX))
+	sb.WriteString(__tdn(s.ROS_RX))
 	sb.WriteString("\n")
 
 	for i, b := range s._b {
@@ -13,6 +13,10 @@ type ANDStatistics struct {
 	RST_TX int
 	SOA_TX int
 	SOD_TX int
+	SAM_TX int
+	SOU_TX int
+	MOD_TX int
+	ROS_TX int
 
 	JN_RX  int
 	JOK_RX int
@@ -24,12 +28,21 @@ type ANDStatistics struct {
 	RST_RX int
 	SOA_RX int
 	SOD_RX int
+	SAM_RX int
+	SOU_RX int
+	MOD_RX int
+	ROS_RX int
 }
 
 func (s *ANDStatistics) B(i int) {}
 
 func (s *ANDStatistics) W(i int) {}
 
+// Branches returns nil in release build.
+func (s *ANDStatistics) Branches() ([]int, []int) {
+	return nil, nil
+}
+
 func (s *ANDStatistics) String() string {
 	return "disabled in release build"
 }
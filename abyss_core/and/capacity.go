@@ -0,0 +1,137 @@
+package and
+
+import (
+	"time"
+
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+///// world capacity
+// A member counts the local host, members, and joiners being admitted, against max_members.
+// A JN beyond the limit waits in the queue, without ANDSessionRequest, until a room is freed;
+// if the queue is full, it is declined with JNC_WORLD_FULL, and after QueueTimeout with JNC_EXPIRED.
+// Only JN is limited; peers introduced with JNI were admitted by another member,
+// and are counted once connected. As each member counts its own view, joins through
+// different members at once may exceed the limit; it is approximate.
+// In the partial mesh, members are counted from the roster.
+
+// QueueTimeout is how long a joiner waits in the queue. Joiners usually give up earlier.
+const QueueTimeout = 10 * time.Second
+
+func (w *ANDWorld) memberCount() int {
+	result := 1 //local
+	if w.interest != nil {
+		for _, e := range w.interest.roster {
+			if !e.Left {
+				result++
+			}
+		}
+		for _, info := range w.peers {
+			if info.state == WS_JN && !info.queued {
+				result++
+			}
+		}
+		return result
+	}
+	for _, info := range w.peers {
+		switch info.state {
+		case WS_JN:
+			if !info.queued {
+				result++
+			}
+		case WS_RMEM_NJNI, WS_JNI, WS_RMEM, WS_TMEM, WS_MEM:
+			result++
+		}
+	}
+	return result
+}
+
+func (w *ANDWorld) hasRoom() bool {
+	return w.max_members == 0 || w.memberCount() < w.max_members
+}
+
+// pruneQueue removes the joiners that are no longer waiting, and declines those waited too long.
+func (w *ANDWorld) pruneQueue() {
+	result := w.queue[:0]
+	for _, peer_session := range w.queue {
+		info, ok := w.peers[peer_session.Peer.IDHash()]
+		if !ok || info.state != WS_JN || !info.queued || info.PeerSessionID != peer_session.PeerSessionID {
+			continue
+		}
+		if time.Since(info.enqueue) > QueueTimeout {
+			w.o.stat.W(124)
+
+			w.o.stat.JDN_TX++
+			info.Peer.TrySendJDN(info.PeerSessionID, JNC_EXPIRED, JNM_EXPIRED)
+			w.clearSession(info)
+			continue
+		}
+		result = append(result, peer_session)
+	}
+	w.queue = result
+}
+
+// RequestOrQueue handles a joiner in WS_JN.
+func (w *ANDWorld) RequestOrQueue(info *ANDPeerSessionState) {
+	info.queued = true
+	if w.hasRoom() {
+		w.o.stat.W(103)
+
+		info.queued = false
+		w.ech <- abyss.NeighborEvent{
+			Type:           abyss.ANDSessionRequest,
+			LocalSessionID: w.lsid,
+			ANDPeerSession: info.ANDPeerSession,
+		}
+		return
+	}
+	w.pruneQueue()
+	if len(w.queue) < w.queue_length {
+		w.o.stat.W(104)
+
+		info.enqueue = time.Now()
+		w.queue = append(w.queue, info.ANDPeerSession)
+		return
+	}
+	w.o.stat.W(105)
+
+	w.o.stat.JDN_TX++
+	info.Peer.TrySendJDN(info.PeerSessionID, JNC_WORLD_FULL, JNM_WORLD_FULL)
+	w.clearSession(info)
+}
+
+// AdmitQueued raises ANDSessionRequest for waiting joiners, while there is a room.
+func (w *ANDWorld) AdmitQueued() {
+	w.pruneQueue()
+	for len(w.queue) != 0 && w.hasRoom() {
+		w.o.stat.W(106)
+
+		info := w.peers[w.queue[0].Peer.IDHash()]
+		w.queue = w.queue[1:]
+		info.queued = false
+		w.ech <- abyss.NeighborEvent{
+			Type:           abyss.ANDSessionRequest,
+			LocalSessionID: w.lsid,
+			ANDPeerSession: info.ANDPeerSession,
+		}
+	}
+}
+
+// SetCapacity declines the joiners that no longer fit in the queue, newest first.
+func (w *ANDWorld) SetCapacity(max_members int, queue_length int) {
+	w.max_members = max_members
+	w.queue_length = queue_length
+
+	w.pruneQueue()
+	for len(w.queue) > w.queue_length {
+		w.o.stat.W(107)
+
+		last := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		info := w.peers[last.Peer.IDHash()]
+		w.o.stat.JDN_TX++
+		info.Peer.TrySendJDN(info.PeerSessionID, JNC_WORLD_FULL, JNM_WORLD_FULL)
+		w.clearSession(info)
+	}
+	w.AdmitQueued()
+}
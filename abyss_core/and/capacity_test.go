@@ -0,0 +1,94 @@
+package and
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+// openCapacityAND has a world opened by A, with the peers connected.
+func openCapacityAND(peers ...*recPeer) (*AND, uuid.UUID) {
+	a := NewAND("A")
+	lsid := uuid.New()
+	a.OpenWorld(lsid, "https://example.com/world")
+	for _, peer := range peers {
+		a.PeerConnected(peer)
+	}
+	drainEvents(a)
+	return a, lsid
+}
+
+func joinSession(a *AND, lsid uuid.UUID, peer *recPeer) abyss.ANDPeerSession {
+	peer_session := abyss.ANDPeerSession{Peer: peer, PeerSessionID: uuid.New()}
+	a.JN(lsid, peer_session, time.Now())
+	return peer_session
+}
+
+// TestCapacityQueue fills a world of two with a queue of one.
+func TestCapacityQueue(t *testing.T) {
+	c, d, e := &recPeer{hash: "C"}, &recPeer{hash: "D"}, &recPeer{hash: "E"}
+	a, lsid := openCapacityAND(c, d, e)
+	a.SetWorldCapacity(lsid, 2, 1)
+
+	c_session := joinSession(a, lsid, c)
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionRequest)
+	joinSession(a, lsid, d)
+	checkEventTypes(t, drainEvents(a))
+	joinSession(a, lsid, e)
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionDrop)
+	if strings.Join(e.sent, " ") != "JDN" {
+		t.Fatal("joiner beyond the queue not declined: ", e.sent)
+	}
+
+	// the room of a declined joiner goes to the queue.
+	a.DeclineSession(lsid, c_session, JNC_REJECTED, JNM_REJECTED)
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionDrop)
+	a.TimerExpire(lsid)
+	events := drainEvents(a)
+	checkEventTypes(t, events, abyss.ANDSessionRequest, abyss.ANDTimerRequest)
+	if events[0].Peer != d {
+		t.Fatal("another joiner admitted")
+	}
+}
+
+// TestCapacityCountsMembers checks that members take rooms.
+func TestCapacityCountsMembers(t *testing.T) {
+	b, c := &recPeer{hash: "B"}, &recPeer{hash: "C"}
+	a, lsid := openCapacityAND(b, c)
+	info := a.worlds[lsid].peers["B"]
+	info.PeerSessionID = uuid.New()
+	info.state = WS_MEM
+	a.SetWorldCapacity(lsid, 2, 0)
+
+	joinSession(a, lsid, c)
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionDrop)
+	if a.worlds[lsid].memberCount() != 2 {
+		t.Fatal("unexpected member count: ", a.worlds[lsid].memberCount())
+	}
+
+	a.SetWorldCapacity(lsid, 3, 0)
+	joinSession(a, lsid, c)
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionRequest)
+}
+
+// TestQueueTimeout checks that a joiner does not wait in the queue forever.
+func TestQueueTimeout(t *testing.T) {
+	c := &recPeer{hash: "C"}
+	a, lsid := openCapacityAND(c)
+	a.SetWorldCapacity(lsid, 1, 1)
+
+	joinSession(a, lsid, c)
+	a.TimerExpire(lsid)
+	checkEventTypes(t, drainEvents(a), abyss.ANDTimerRequest)
+
+	a.worlds[lsid].peers["C"].enqueue = time.Now().Add(-QueueTimeout - time.Second)
+	a.TimerExpire(lsid)
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionDrop, abyss.ANDTimerRequest)
+	if strings.Join(c.sent, " ") != "JDN" || len(a.worlds[lsid].queue) != 0 {
+		t.Fatal("expired joiner not declined: ", c.sent)
+	}
+}
@@ -10,11 +10,15 @@ const (
 	JNC_CLOSED    = 499
 
 	//Accepter-side response
-	JNC_COLLISION      = 520
-	JNC_INVALID_STATES = 521
-	JNC_EXPIRED        = 530
-	JNC_RESET          = 598
-	JNC_REJECTED       = 599
+	JNC_COLLISION       = 520
+	JNC_INVALID_STATES  = 521
+	JNC_EXPIRED         = 530
+	JNC_BANNED          = 540
+	JNC_INVITE_REQUIRED = 541
+	JNC_INVITE_INVALID  = 542
+	JNC_WORLD_FULL      = 550
+	JNC_RESET           = 598
+	JNC_REJECTED        = 599
 )
 
 const (
@@ -25,9 +29,13 @@ const (
 	JNM_CANCELED  = "Join Canceled"
 	JNM_CLOSED    = "Peer Disconnected"
 
-	JNM_COLLISION      = "Session ID Collided"
-	JNM_INVALID_STATES = "Invalid States"
-	JNM_EXPIRED        = "Join Expired"
-	JNM_RESET          = "Reset Requested"
-	JNM_REJECTED       = "Join Rejected"
+	JNM_COLLISION       = "Session ID Collided"
+	JNM_INVALID_STATES  = "Invalid States"
+	JNM_EXPIRED         = "Join Expired"
+	JNM_BANNED          = "Banned"
+	JNM_INVITE_REQUIRED = "Invite Required"
+	JNM_INVITE_INVALID  = "Invalid Invite"
+	JNM_WORLD_FULL      = "World Full"
+	JNM_RESET           = "Reset Requested"
+	JNM_REJECTED        = "Join Rejected"
 )
@@ -0,0 +1,387 @@
+package and
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+///// partial mesh
+// A world with an interest radius does not keep a full mesh.
+// A member keeps sessions with the members within the radius, its nearest members,
+// and a few random long links, which keep the mesh connected.
+// Instead, every member keeps a roster of the whole world, and gossips it to its sessions with ROS every round.
+// Only the member itself increases the heartbeat of its entry; an entry whose heartbeat stopped
+// for rosterExpiry is marked left, and a left entry is removed after another rosterExpiry.
+// A session is closed only if neither side wants to keep it, with hysteresis on the radius.
+// Sessions are made as with JNI, except that the MEM receiver takes the identity from its roster;
+// the roster only has peers that a member admitted, as JNI does.
+
+const (
+	interestNearest    = 4    //nearest members to keep, regardless of the radius
+	interestLinks      = 2    //random long links
+	interestHysteresis = 1.25 //radius factor to close a session
+	rosterExpiry       = 20 * time.Second
+	rosterFullPeriod   = 16 //rounds between sending every certificate
+)
+
+type rosterEntry struct {
+	abyss.ANDRosterEntry
+	introducer string    //who sent the certificates
+	updated    time.Time //last heartbeat increase
+	round      int       //when the session or its certificates were learned
+}
+
+type interestState struct {
+	radius         float32
+	local_position *[3]float32 //nil if unknown
+	heartbeat      uint64
+	round          int
+
+	roster    map[string]*rosterEntry //key: hash, excluding local
+	links     map[string]bool
+	full_sent map[string]uuid.UUID //key: hash, value: the session that received every certificate
+}
+
+func newInterestState(radius float32) *interestState {
+	return &interestState{
+		radius:    radius,
+		roster:    make(map[string]*rosterEntry),
+		links:     make(map[string]bool),
+		full_sent: make(map[string]uuid.UUID),
+	}
+}
+
+func (s *interestState) localEntry(left bool) abyss.ANDRosterEntry {
+	return abyss.ANDRosterEntry{
+		Position:  s.local_position,
+		Heartbeat: s.heartbeat,
+		Left:      left,
+	}
+}
+
+// merge takes a newer session, or a higher heartbeat of the same session.
+// A left entry of an unknown peer is ignored, so that removed entries are not revived.
+func (s *interestState) merge(introducer string, entry abyss.ANDRosterEntry) {
+	peer_id := entry.AURL.Hash
+	e, ok := s.roster[peer_id]
+	if !ok || e.TimeStamp.Before(entry.TimeStamp) {
+		if entry.Left {
+			return
+		}
+		s.roster[peer_id] = &rosterEntry{entry, introducer, time.Now(), s.round}
+		return
+	}
+	if e.SessionID != entry.SessionID {
+		return //old session
+	}
+	if e.RootCertificateDer == nil && entry.RootCertificateDer != nil {
+		e.RootCertificateDer = entry.RootCertificateDer
+		e.HandshakeKeyCertificateDer = entry.HandshakeKeyCertificateDer
+		e.introducer = introducer
+		e.round = s.round
+	}
+	if e.Heartbeat < entry.Heartbeat {
+		e.Heartbeat = entry.Heartbeat
+		e.Position = entry.Position
+		e.Left = entry.Left
+		e.updated = time.Now()
+	}
+}
+
+func (s *interestState) expire() {
+	now := time.Now()
+	for peer_id, e := range s.roster {
+		if now.Sub(e.updated) < rosterExpiry {
+			continue
+		}
+		if e.Left {
+			delete(s.roster, peer_id)
+			delete(s.links, peer_id)
+			delete(s.full_sent, peer_id)
+			continue
+		}
+		e.Left = true
+		e.updated = now
+	}
+}
+
+// updateLinks replaces the links that left.
+func (s *interestState) updateLinks() {
+	for peer_id := range s.links {
+		if e, ok := s.roster[peer_id]; !ok || e.Left {
+			delete(s.links, peer_id)
+		}
+	}
+	candidates := make([]string, 0)
+	for peer_id, e := range s.roster {
+		if !e.Left && !s.links[peer_id] {
+			candidates = append(candidates, peer_id)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	for _, peer_id := range candidates {
+		if len(s.links) >= interestLinks {
+			return
+		}
+		s.links[peer_id] = true
+	}
+}
+
+// distance is infinite if a position is unknown.
+func (s *interestState) distance(position *[3]float32) float32 {
+	if s.local_position == nil || position == nil {
+		return float32(math.Inf(1))
+	}
+	var sum float32
+	for i := range position {
+		d := position[i] - s.local_position[i]
+		sum += d * d
+	}
+	return float32(math.Sqrt(float64(sum)))
+}
+
+// wanted returns the members within the radius, the nearest members, and the links.
+func (s *interestState) wanted(radius float32) map[string]bool {
+	type candidate struct {
+		peer_id  string
+		distance float32
+	}
+	candidates := make([]candidate, 0, len(s.roster))
+	for peer_id, e := range s.roster {
+		if e.Left {
+			continue
+		}
+		candidates = append(candidates, candidate{peer_id, s.distance(e.Position)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance == candidates[j].distance {
+			return candidates[i].peer_id < candidates[j].peer_id
+		}
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	result := make(map[string]bool)
+	for i, c := range candidates {
+		if i < interestNearest || c.distance <= radius {
+			result[c.peer_id] = true
+		}
+	}
+	for peer_id := range s.links {
+		result[peer_id] = true
+	}
+	return result
+}
+
+// rosterFor omits the certificates that the receiver is likely to have.
+// Every certificate is sent on the first round of a session, and every rosterFullPeriod rounds.
+func (s *interestState) rosterFor(receiver_id string, receiver_session_id uuid.UUID) []abyss.ANDRosterEntry {
+	full := s.full_sent[receiver_id] != receiver_session_id || s.round%rosterFullPeriod == 0
+	s.full_sent[receiver_id] = receiver_session_id
+
+	result := make([]abyss.ANDRosterEntry, 0, len(s.roster))
+	for peer_id, e := range s.roster {
+		if peer_id == receiver_id {
+			continue
+		}
+		entry := e.ANDRosterEntry
+		if !full && s.round-e.round > 2 {
+			entry.RootCertificateDer = nil
+			entry.HandshakeKeyCertificateDer = nil
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func sessionIdentity(info *ANDPeerSessionState) abyss.ANDFullPeerSessionIdentity {
+	return abyss.ANDFullPeerSessionIdentity{
+		AURL:                       info.Peer.AURL(),
+		SessionID:                  info.PeerSessionID,
+		TimeStamp:                  info.TimeStamp,
+		RootCertificateDer:         info.Peer.RootCertificateDer(),
+		HandshakeKeyCertificateDer: info.Peer.HandshakeKeyCertificateDer(),
+	}
+}
+
+func (w *ANDWorld) Settings() abyss.WorldSettings {
+	result := abyss.WorldSettings{
+		Access:     w.access.snapshot(),
+		MaxMembers: w.max_members,
+		InviteOnly: w.invite_only,
+	}
+	if w.interest != nil {
+		result.InterestRadius = w.interest.radius
+	}
+	return result
+}
+
+// introduceFromRoster stands for the JNI, which members do not send in the partial mesh.
+func (w *ANDWorld) introduceFromRoster(peer_id string, info *ANDPeerSessionState) {
+	if w.interest == nil {
+		return
+	}
+	e, ok := w.interest.roster[peer_id]
+	if !ok || e.Left || e.SessionID != info.PeerSessionID {
+		w.o.stat.W(109)
+		return
+	}
+	w.o.stat.W(110)
+
+	w.JNI_MEMS(e.introducer, e.ANDFullPeerSessionIdentity)
+}
+
+func (w *ANDWorld) ROS(peer_session abyss.ANDPeerSession, keep bool, local abyss.ANDRosterEntry, roster []abyss.ANDRosterEntry) {
+	w.o.stat.ROS_RX++
+
+	sender_id := peer_session.Peer.IDHash()
+	info, ok := w.peers[sender_id]
+	if !ok || info.PeerSessionID != peer_session.PeerSessionID {
+		w.o.stat.W(111)
+
+		w.o.stat.RST_TX++
+		peer_session.Peer.TrySendRST(w.lsid, peer_session.PeerSessionID, "ROS::sessionID mismatch")
+		return
+	}
+	if info.state != WS_MEM || w.interest == nil {
+		w.o.stat.W(112)
+		return
+	}
+	w.o.stat.W(113)
+
+	info.keep = keep
+	local.ANDFullPeerSessionIdentity = sessionIdentity(info)
+	w.interest.merge(sender_id, local)
+	for _, entry := range roster {
+		peer_id := entry.AURL.Hash
+		if peer_id == w.local || w.access.isBanned(peer_id) {
+			w.o.stat.W(114)
+			continue
+		}
+		w.interest.merge(sender_id, entry)
+	}
+}
+
+// interestRound replaces SJN in the partial mesh.
+func (w *ANDWorld) interestRound() {
+	s := w.interest
+	s.round++
+	s.heartbeat++
+	s.expire()
+	s.updateLinks()
+
+	// members with sessions are always in the roster, so that a joiner is gossiped
+	// by the member who accepted it, even if the session is closed soon.
+	for peer_id, info := range w.peers {
+		if info.state != WS_MEM {
+			continue
+		}
+		if e, ok := s.roster[peer_id]; ok && !e.TimeStamp.Before(info.TimeStamp) {
+			continue
+		}
+		w.o.stat.W(115)
+
+		s.roster[peer_id] = &rosterEntry{
+			abyss.ANDRosterEntry{ANDFullPeerSessionIdentity: sessionIdentity(info)},
+			peer_id, time.Now(), s.round,
+		}
+	}
+
+	wanted := s.wanted(s.radius)
+	keep := s.wanted(s.radius * interestHysteresis)
+	for peer_id := range wanted {
+		e := s.roster[peer_id]
+		info, ok := w.peers[peer_id]
+		if ok && info.state != WS_CC {
+			continue
+		}
+		if w.access.isBanned(peer_id) {
+			w.o.stat.W(116)
+			continue
+		}
+		if !ok && e.RootCertificateDer == nil {
+			w.o.stat.W(117)
+			continue //certificates not received yet
+		}
+		w.o.stat.W(118)
+
+		w.JNI_MEMS(e.introducer, e.ANDFullPeerSessionIdentity)
+	}
+	for peer_id, info := range w.peers {
+		switch info.state {
+		case WS_RMEM_NJNI:
+			w.introduceFromRoster(peer_id, info)
+		case WS_MEM:
+			if keep[peer_id] || info.keep {
+				continue
+			}
+			w.o.stat.W(119)
+
+			w.ClearStates(peer_id, info, "out of interest")
+		}
+	}
+	for peer_id, info := range w.peers {
+		if info.state != WS_MEM {
+			continue
+		}
+		w.o.stat.W(120)
+
+		w.o.stat.ROS_TX++
+		info.Peer.TrySendROS(w.lsid, info.PeerSessionID, keep[peer_id], s.localEntry(false), s.rosterFor(peer_id, info.PeerSessionID))
+	}
+}
+
+// SetInterest switches a world without sessions to the partial mesh, or changes the radius.
+// The radius of a member only changes its own sessions; joiners take the radius of the member who accepted them.
+func (w *ANDWorld) SetInterest(radius float32) abyss.ANDERROR {
+	if w.interest != nil {
+		if radius == 0 {
+			w.o.stat.W(121)
+			return abyss.EINVAL
+		}
+		w.interest.radius = radius
+		return 0
+	}
+	if radius == 0 {
+		return 0
+	}
+	for _, info := range w.peers {
+		if info.state > WS_CC {
+			w.o.stat.W(122)
+			return abyss.EINVAL
+		}
+	}
+	w.o.stat.W(123)
+
+	w.interest = newInterestState(radius)
+	return 0
+}
+
+func (w *ANDWorld) UpdatePosition(position [3]float32) abyss.ANDERROR {
+	if w.interest == nil {
+		return abyss.EINVAL
+	}
+	w.interest.local_position = &position
+	return 0
+}
+
+// Roster returns the members in the roster, excluding local; nil in the full mesh.
+func (w *ANDWorld) Roster() []abyss.ANDRosterEntry {
+	if w.interest == nil {
+		return nil
+	}
+	result := make([]abyss.ANDRosterEntry, 0, len(w.interest.roster))
+	for _, e := range w.interest.roster {
+		if !e.Left {
+			result = append(result, e.ANDRosterEntry)
+		}
+	}
+	return result
+}
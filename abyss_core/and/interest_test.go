@@ -0,0 +1,267 @@
+package and
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+	"github.com/kadmila/Abyss-Browser/abyss_core/tools/functional"
+)
+
+///// mesh network
+// AND instances, each with a single world, exchanging messages through one FIFO queue.
+// Every connection and session request is accepted when the event is handled.
+
+type meshNode struct {
+	hash     string
+	and      *AND
+	lsid     uuid.UUID
+	peers    map[string]*meshPeer //key: remote hash
+	members  map[string]bool
+	joined   bool
+	timer    bool
+	position *[3]float32 //sent after joining, if not nil
+}
+
+// meshPeer is the remote node, as seen by the local node.
+// It implements only what AND sends in a partial mesh; anything else panics.
+type meshPeer struct {
+	abyss.IANDPeer
+	net    *meshNet
+	local  *meshNode
+	remote *meshNode
+}
+
+func (p *meshPeer) IDHash() string                     { return p.remote.hash }
+func (p *meshPeer) RootCertificateDer() []byte         { return []byte("root " + p.remote.hash) }
+func (p *meshPeer) HandshakeKeyCertificateDer() []byte { return []byte("handshake " + p.remote.hash) }
+func (p *meshPeer) AURL() *aurl.AURL                   { return &aurl.AURL{Scheme: "abyss", Hash: p.remote.hash} }
+
+// send queues a delivery to the remote node, which takes its peer object of the local node.
+func (p *meshPeer) send(deliver func(receiver *meshNode, sender abyss.IANDPeer)) bool {
+	receiver, sender := p.remote, p.remote.peers[p.local.hash]
+	p.net.queue = append(p.net.queue, func() { deliver(receiver, sender) })
+	return true
+}
+
+// meshTime is a local world timestamp, as sent.
+func meshTime(timestamp time.Time) time.Time {
+	return time.UnixMilli(timestamp.UnixMilli()).Add(-time.Minute)
+}
+
+func meshIdentity(session abyss.ANDPeerSessionWithTimeStamp) abyss.ANDFullPeerSessionIdentity {
+	return abyss.ANDFullPeerSessionIdentity{
+		AURL:                       session.Peer.AURL(),
+		SessionID:                  session.PeerSessionID,
+		TimeStamp:                  session.TimeStamp,
+		RootCertificateDer:         session.Peer.RootCertificateDer(),
+		HandshakeKeyCertificateDer: session.Peer.HandshakeKeyCertificateDer(),
+	}
+}
+
+func (p *meshPeer) TrySendJN(local_session_id uuid.UUID, path string, timestamp time.Time) bool {
+	timestamp = meshTime(timestamp)
+	return p.send(func(r *meshNode, s abyss.IANDPeer) {
+		r.and.JN(r.lsid, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, timestamp)
+	})
+}
+func (p *meshPeer) TrySendJOK(local_session_id uuid.UUID, peer_session_id uuid.UUID, timestamp time.Time, world_url string, member_sessions []abyss.ANDPeerSessionWithTimeStamp, settings abyss.WorldSettings) bool {
+	timestamp = meshTime(timestamp)
+	members := functional.Filter(member_sessions, meshIdentity)
+	return p.send(func(r *meshNode, s abyss.IANDPeer) {
+		r.and.JOK(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, timestamp, world_url, members, settings)
+	})
+}
+func (p *meshPeer) TrySendJNI(local_session_id uuid.UUID, peer_session_id uuid.UUID, member_session abyss.ANDPeerSessionWithTimeStamp) bool {
+	member := meshIdentity(member_session)
+	return p.send(func(r *meshNode, s abyss.IANDPeer) {
+		r.and.JNI(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, member)
+	})
+}
+func (p *meshPeer) TrySendMEM(local_session_id uuid.UUID, peer_session_id uuid.UUID, timestamp time.Time) bool {
+	timestamp = meshTime(timestamp)
+	return p.send(func(r *meshNode, s abyss.IANDPeer) {
+		r.and.MEM(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, timestamp)
+	})
+}
+func (p *meshPeer) TrySendSJN(local_session_id uuid.UUID, peer_session_id uuid.UUID, member_sessions []abyss.ANDPeerSessionIdentity) bool {
+	return p.send(func(r *meshNode, s abyss.IANDPeer) {
+		r.and.SJN(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, member_sessions)
+	})
+}
+func (p *meshPeer) TrySendCRR(local_session_id uuid.UUID, peer_session_id uuid.UUID, member_sessions []abyss.ANDPeerSessionIdentity) bool {
+	return p.send(func(r *meshNode, s abyss.IANDPeer) {
+		r.and.CRR(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, member_sessions)
+	})
+}
+func (p *meshPeer) TrySendRST(local_session_id uuid.UUID, peer_session_id uuid.UUID, message string) bool {
+	return p.send(func(r *meshNode, s abyss.IANDPeer) {
+		r.and.RST(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, message)
+	})
+}
+func (p *meshPeer) TrySendROS(local_session_id uuid.UUID, peer_session_id uuid.UUID, keep bool, local abyss.ANDRosterEntry, roster []abyss.ANDRosterEntry) bool {
+	return p.send(func(r *meshNode, s abyss.IANDPeer) {
+		r.and.ROS(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, keep, local, roster)
+	})
+}
+
+type meshNet struct {
+	nodes []*meshNode
+	queue []func()
+}
+
+// newMeshNet opens a world of the given radius at node 0, and joins the others to it.
+// Node i is placed at (10*i, 0, 0).
+func newMeshNet(node_count int, radius float32) *meshNet {
+	result := &meshNet{}
+	for i := range node_count {
+		hash := fmt.Sprintf("Imesh%02d", i)
+		result.nodes = append(result.nodes, &meshNode{
+			hash:     hash,
+			and:      NewAND(hash),
+			lsid:     uuid.New(),
+			peers:    make(map[string]*meshPeer),
+			members:  make(map[string]bool),
+			position: &[3]float32{float32(10 * i), 0, 0},
+		})
+	}
+	opener := result.nodes[0]
+	opener.and.OpenWorld(opener.lsid, "https://example.com/world")
+	opener.and.SetInterest(opener.lsid, radius)
+	opener.and.UpdatePosition(opener.lsid, *opener.position)
+	opener.joined = true
+	result.drain(opener)
+	for _, node := range result.nodes[1:] {
+		node.and.JoinWorld(node.lsid, &aurl.AURL{Scheme: "abyss", Hash: opener.hash, Path: "world"})
+		result.drain(node)
+	}
+	return result
+}
+
+func (n *meshNet) node(hash string) *meshNode {
+	for _, node := range n.nodes {
+		if node.hash == hash {
+			return node
+		}
+	}
+	panic("unknown node " + hash)
+}
+
+func (n *meshNet) connect(a *meshNode, b *meshNode) {
+	if _, ok := a.peers[b.hash]; ok {
+		return
+	}
+	a.peers[b.hash] = &meshPeer{net: n, local: a, remote: b}
+	b.peers[a.hash] = &meshPeer{net: n, local: b, remote: a}
+	a.and.PeerConnected(a.peers[b.hash])
+	b.and.PeerConnected(b.peers[a.hash])
+}
+
+// drain handles the events of a node, as the host does.
+func (n *meshNet) drain(node *meshNode) {
+	for {
+		select {
+		case event := <-node.and.EventChannel():
+			switch event.Type {
+			case abyss.ANDSessionRequest:
+				node.and.AcceptSession(event.LocalSessionID, event.ANDPeerSession)
+			case abyss.ANDSessionReady:
+				node.members[event.Peer.IDHash()] = true
+			case abyss.ANDSessionClose:
+				delete(node.members, event.Peer.IDHash())
+			case abyss.ANDJoinSuccess:
+				node.joined = true
+				node.and.UpdatePosition(node.lsid, *node.position)
+			case abyss.ANDConnectRequest:
+				n.connect(node, n.node(event.Object.(*aurl.AURL).Hash))
+			case abyss.ANDTimerRequest:
+				node.timer = true
+			}
+		default:
+			return
+		}
+	}
+}
+
+// settle delivers every message, then fires the requested timers, for the given rounds.
+func (n *meshNet) settle(rounds int) {
+	for range rounds {
+		for len(n.queue) != 0 {
+			deliver := n.queue[0]
+			n.queue = n.queue[1:]
+			deliver()
+			for _, node := range n.nodes {
+				n.drain(node)
+			}
+		}
+		for _, node := range n.nodes {
+			if node.timer {
+				node.timer = false
+				node.and.TimerExpire(node.lsid)
+				n.drain(node)
+			}
+		}
+	}
+}
+
+// TestInterestMesh places members on a line, where each sees its two neighbors.
+func TestInterestMesh(t *testing.T) {
+	const node_count = 16
+	n := newMeshNet(node_count, 15)
+	n.settle(40)
+
+	for _, node := range n.nodes {
+		if !node.joined {
+			t.Fatalf("%s did not join", node.hash)
+		}
+		roster := node.and.Roster(node.lsid)
+		if len(roster) != node_count-1 {
+			t.Fatalf("%s has %d in roster", node.hash, len(roster))
+		}
+		world := node.and.worlds[node.lsid]
+		world.CheckSanity()
+		for peer_id := range world.interest.wanted(world.interest.radius) {
+			if !node.members[peer_id] {
+				t.Fatalf("%s does not have %s as a member", node.hash, peer_id)
+			}
+		}
+		if len(node.members) >= node_count-1 {
+			t.Fatalf("%s has every member", node.hash)
+		}
+	}
+}
+
+// TestInterestMeshLeave closes the world of a member in the middle of the line.
+// Most members have no session with it, and learn that it left from the gossip.
+func TestInterestMeshLeave(t *testing.T) {
+	const node_count = 16
+	n := newMeshNet(node_count, 15)
+	n.settle(40)
+
+	leaver := n.nodes[node_count/2]
+	leaver.and.CloseWorld(leaver.lsid)
+	n.drain(leaver)
+	n.settle(10)
+
+	for _, node := range n.nodes {
+		if node == leaver {
+			continue
+		}
+		roster := node.and.Roster(node.lsid)
+		if len(roster) != node_count-2 {
+			t.Fatalf("%s has %d in roster", node.hash, len(roster))
+		}
+		for _, entry := range roster {
+			if entry.AURL.Hash == leaver.hash {
+				t.Fatalf("%s has %s in roster", node.hash, leaver.hash)
+			}
+		}
+		if node.members[leaver.hash] {
+			t.Fatalf("%s has %s as a member", node.hash, leaver.hash)
+		}
+	}
+}
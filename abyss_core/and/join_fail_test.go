@@ -0,0 +1,175 @@
+package and
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+// recPeer records the messages AND sends to it.
+// It implements only what a world sends to its join target and joiners; anything else panics.
+type recPeer struct {
+	abyss.IANDPeer
+	hash string
+	sent []string
+}
+
+func (p *recPeer) IDHash() string { return p.hash }
+func (p *recPeer) TrySendJN(local_session_id uuid.UUID, path string, timestamp time.Time) bool {
+	p.sent = append(p.sent, "JN")
+	return true
+}
+func (p *recPeer) TrySendRST(local_session_id uuid.UUID, peer_session_id uuid.UUID, message string) bool {
+	p.sent = append(p.sent, "RST")
+	return true
+}
+func (p *recPeer) TrySendJDN(peer_session_id uuid.UUID, code int, message string) bool {
+	p.sent = append(p.sent, "JDN")
+	return true
+}
+func (p *recPeer) TrySendJOK(local_session_id uuid.UUID, peer_session_id uuid.UUID, timestamp time.Time, world_url string, member_sessions []abyss.ANDPeerSessionWithTimeStamp, settings abyss.WorldSettings) bool {
+	p.sent = append(p.sent, "JOK")
+	return true
+}
+
+func drainEvents(a *AND) []abyss.NeighborEvent {
+	result := make([]abyss.NeighborEvent, 0)
+	for {
+		select {
+		case e := <-a.eventCh:
+			result = append(result, e)
+		default:
+			return result
+		}
+	}
+}
+
+func checkEventTypes(t *testing.T, events []abyss.NeighborEvent, types ...abyss.NeighborEventType) {
+	t.Helper()
+	if len(events) != len(types) {
+		t.Fatalf("%d events, expected %d: %v", len(events), len(types), events)
+	}
+	for i, e := range events {
+		if e.Type != types[i] {
+			t.Fatalf("event %d is %d, expected %d", i, e.Type, types[i])
+		}
+	}
+}
+
+// joiningAND has a world joining B, which is connected.
+func joiningAND(t *testing.T) (*AND, uuid.UUID, *recPeer) {
+	a := NewAND("A")
+	target := &recPeer{hash: "B"}
+	a.PeerConnected(target)
+	lsid := uuid.New()
+	a.JoinWorld(lsid, &aurl.AURL{Scheme: "abyss", Hash: "B", Path: "/home"})
+	if len(target.sent) != 1 || target.sent[0] != "JN" {
+		t.Fatal("JN not sent")
+	}
+	drainEvents(a)
+	return a, lsid, target
+}
+
+// TestJDNClosesWorld checks that a denied join removes the world,
+// and that a MEM to it afterwards is answered with RST.
+func TestJDNClosesWorld(t *testing.T) {
+	a, lsid, target := joiningAND(t)
+
+	a.JDN(lsid, target, JNC_NOT_FOUND, JNM_NOT_FOUND)
+	events := drainEvents(a)
+	checkEventTypes(t, events, abyss.ANDJoinFail, abyss.ANDWorldLeave)
+	if events[0].Value != JNC_NOT_FOUND || events[0].Text != JNM_NOT_FOUND {
+		t.Fatalf("join fail %d %s", events[0].Value, events[0].Text)
+	}
+	if _, ok := a.worlds[lsid]; ok {
+		t.Fatal("world of a failed join is kept")
+	}
+
+	// a member that got a JNI before the JDN still sends MEM; it must not wait in WS_TMEM.
+	member := &recPeer{hash: "C"}
+	a.PeerConnected(member)
+	a.MEM(lsid, abyss.ANDPeerSession{Peer: member, PeerSessionID: uuid.New()}, time.Now())
+	if len(member.sent) != 1 || member.sent[0] != "RST" {
+		t.Fatal("MEM to a closed world is not reset")
+	}
+	checkEventTypes(t, drainEvents(a))
+}
+
+// TestJoinTargetCloseFailsJoin closes the join target, while a joiner waits in the queue.
+// Nothing may follow ANDWorldLeave, as the host forgets the world on it.
+func TestJoinTargetCloseFailsJoin(t *testing.T) {
+	a, lsid, target := joiningAND(t)
+	joiner := &recPeer{hash: "C"}
+	a.PeerConnected(joiner)
+	a.SetWorldCapacity(lsid, 1, 1)
+	a.JN(lsid, abyss.ANDPeerSession{Peer: joiner, PeerSessionID: uuid.New()}, time.Now())
+	checkEventTypes(t, drainEvents(a))
+	world := a.worlds[lsid]
+
+	a.PeerClose(target)
+	events := drainEvents(a)
+	checkEventTypes(t, events, abyss.ANDJoinFail, abyss.ANDSessionDrop, abyss.ANDWorldLeave)
+	if events[0].Value != JNC_INVALID_STATES {
+		t.Fatalf("join fail %d %s", events[0].Value, events[0].Text)
+	}
+	if _, ok := a.worlds[lsid]; ok {
+		t.Fatal("world of a failed join is kept")
+	}
+	if len(world.queue) != 0 {
+		t.Fatal("queue of a closed world is kept")
+	}
+	if len(joiner.sent) != 1 || joiner.sent[0] != "RST" {
+		t.Fatal("queued joiner is not reset")
+	}
+
+	a.PeerClose(joiner)
+	checkEventTypes(t, drainEvents(a))
+}
+
+// TestJoinDrop checks that every JN which will not be ready is reported with ANDSessionDrop,
+// so that the host can release its invite.
+func TestJoinDrop(t *testing.T) {
+	a := NewAND("A")
+	lsid := uuid.New()
+	a.OpenWorld(lsid, "https://example.com/world")
+	joiner := &recPeer{hash: "C"}
+	a.PeerConnected(joiner)
+	drainEvents(a)
+	join := func() abyss.ANDPeerSession {
+		peer_session := abyss.ANDPeerSession{Peer: joiner, PeerSessionID: uuid.New()}
+		a.JN(lsid, peer_session, time.Now())
+		return peer_session
+	}
+
+	// no room, and no queue.
+	a.SetWorldCapacity(lsid, 1, 0)
+	peer_session := join()
+	events := drainEvents(a)
+	checkEventTypes(t, events, abyss.ANDSessionDrop)
+	if events[0].PeerSessionID != peer_session.PeerSessionID {
+		t.Fatal("dropped another session")
+	}
+
+	// declined by the host.
+	a.SetWorldCapacity(lsid, 0, 0)
+	peer_session = join()
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionRequest)
+	a.DeclineSession(lsid, peer_session, JNC_REJECTED, JNM_REJECTED)
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionDrop)
+
+	// reset by the joiner after being accepted.
+	peer_session = join()
+	a.AcceptSession(lsid, peer_session)
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionRequest)
+	a.RST(lsid, peer_session, "gone")
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionDrop)
+
+	if strings.Join(joiner.sent, " ") != "JDN JDN JOK RST" {
+		t.Fatal("unexpected messages: ", joiner.sent)
+	}
+}
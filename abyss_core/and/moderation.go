@@ -0,0 +1,298 @@
+package and
+
+import (
+	"encoding/binary"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+///// world moderation
+// A world opened with a root signer has an access list, which its members pass to joiners with JOK.
+// The owner is the peer who opened the world; the world is identified by the owner's session id.
+// Moderation actions are signed by the issuer's root key, and flooded to every member.
+// Each member checks the issuer's role, applies the action and forwards it once;
+// per target, an action older than the latest applied one is ignored, which also drops duplicates.
+//
+// The access list is passed as the owner's signature over the world id, and the applied actions.
+// A joiner replays them in order, checking each as a MOD, so no member can forge the list.
+// Role changes are kept in full, as later actions depend on the issuer's role at their time;
+// of bans and unbans, only the latest per target is kept, and kicks are not kept.
+// Once the log is full, only kicks are issued.
+
+type worldAccess struct {
+	world_id   uuid.UUID
+	owner      string
+	owner_cert []byte
+	signature  []byte
+	moderators map[string]bool
+	banned     map[string]bool
+	latest     map[string]time.Time //key: target hash
+	log        []*abyss.WorldModeration
+}
+
+func newWorldAccess(world_id uuid.UUID, owner string, owner_cert []byte, signature []byte) *worldAccess {
+	return &worldAccess{
+		world_id:   world_id,
+		owner:      owner,
+		owner_cert: owner_cert,
+		signature:  signature,
+		moderators: make(map[string]bool),
+		banned:     make(map[string]bool),
+		latest:     make(map[string]time.Time),
+	}
+}
+
+// ownerMessage is what the owner signs when opening a world.
+func ownerMessage(world_id uuid.UUID, owner string) []byte {
+	result := []byte("abyss world owner\x00")
+	result = append(result, world_id[:]...)
+	return append(result, owner...)
+}
+
+// openWorldAccess returns nil if signing fails; the world is then without moderation.
+func openWorldAccess(signer abyss.IRootSigner, world_id uuid.UUID, owner string) *worldAccess {
+	signature, err := signer.Sign(ownerMessage(world_id, owner))
+	if err != nil {
+		return nil
+	}
+	return newWorldAccess(world_id, owner, signer.RootCertificateDer(), signature)
+}
+
+// verifyWorldAccess rebuilds an access list received with JOK.
+// It returns nil if the owner's signature is invalid; moderations that fail their checks are dropped.
+func verifyWorldAccess(signer abyss.IRootSigner, access *abyss.WorldAccess) *worldAccess {
+	owner, err := signer.Verify(access.OwnerRootCertDer, ownerMessage(access.WorldID, access.Owner), access.Signature)
+	if err != nil || owner != access.Owner {
+		return nil
+	}
+	result := newWorldAccess(access.WorldID, access.Owner, access.OwnerRootCertDer, access.Signature)
+	for _, m := range access.Moderations {
+		if result.isStale(m) || !result.verify(signer, m) {
+			continue
+		}
+		result.apply(m)
+	}
+	return result
+}
+
+// snapshot returns nil for a world without access list.
+func (a *worldAccess) snapshot() *abyss.WorldAccess {
+	if a == nil {
+		return nil
+	}
+	return &abyss.WorldAccess{
+		WorldID:          a.world_id,
+		Owner:            a.owner,
+		OwnerRootCertDer: a.owner_cert,
+		Signature:        a.signature,
+		Moderations:      append([]*abyss.WorldModeration(nil), a.log...),
+	}
+}
+
+func (a *worldAccess) role(peer_hash string) abyss.WorldRole {
+	switch {
+	case a == nil:
+		return abyss.RoleMember
+	case peer_hash == a.owner:
+		return abyss.RoleOwner
+	case a.moderators[peer_hash]:
+		return abyss.RoleModerator
+	default:
+		return abyss.RoleMember
+	}
+}
+
+func (a *worldAccess) isBanned(peer_hash string) bool {
+	return a != nil && a.banned[peer_hash]
+}
+
+// permits checks the issuer's role. Moderators only act on members, and the owner on anyone else.
+func (a *worldAccess) permits(m *abyss.WorldModeration) bool {
+	issuer_role := a.role(m.Issuer)
+	switch m.Action {
+	case abyss.ModKick, abyss.ModBan, abyss.ModUnban:
+		return issuer_role >= abyss.RoleModerator && a.role(m.Target) < issuer_role
+	case abyss.ModGrantModerator, abyss.ModRevokeModerator:
+		return issuer_role == abyss.RoleOwner && m.Target != a.owner
+	default:
+		return false
+	}
+}
+
+func (a *worldAccess) isStale(m *abyss.WorldModeration) bool {
+	latest, ok := a.latest[m.Target]
+	return ok && !m.TimeStamp.After(latest)
+}
+
+// moderationMessage is what the issuer signs.
+func moderationMessage(m *abyss.WorldModeration) []byte {
+	result := []byte("abyss world moderation\x00")
+	result = append(result, m.WorldID[:]...)
+	result = binary.BigEndian.AppendUint32(result, uint32(m.Action))
+	result = binary.BigEndian.AppendUint64(result, uint64(m.TimeStamp.UnixMilli()))
+	for _, s := range []string{m.Target, m.Issuer, m.Reason} {
+		result = binary.BigEndian.AppendUint32(result, uint32(len(s)))
+		result = append(result, s...)
+	}
+	return result
+}
+
+func (a *worldAccess) verify(signer abyss.IRootSigner, m *abyss.WorldModeration) bool {
+	if m.WorldID != a.world_id || !a.permits(m) {
+		return false
+	}
+	issuer, err := signer.Verify(m.IssuerRootCertDer, moderationMessage(m), m.Signature)
+	return err == nil && issuer == m.Issuer
+}
+
+// apply updates the roles and the log.
+func (a *worldAccess) apply(m *abyss.WorldModeration) {
+	a.latest[m.Target] = m.TimeStamp
+	switch m.Action {
+	case abyss.ModBan:
+		a.banned[m.Target] = true
+		delete(a.moderators, m.Target)
+	case abyss.ModUnban:
+		delete(a.banned, m.Target)
+	case abyss.ModGrantModerator:
+		a.moderators[m.Target] = true
+	case abyss.ModRevokeModerator:
+		delete(a.moderators, m.Target)
+	}
+	switch m.Action {
+	case abyss.ModKick:
+		return
+	case abyss.ModBan, abyss.ModUnban:
+		a.log = slices.DeleteFunc(a.log, func(e *abyss.WorldModeration) bool {
+			return e.Target == m.Target && (e.Action == abyss.ModBan || e.Action == abyss.ModUnban)
+		})
+	}
+	if len(a.log) < abyss.MaxWorldAccessModerations {
+		a.log = append(a.log, m)
+	}
+}
+
+func (a *worldAccess) isFull() bool {
+	return len(a.log) >= abyss.MaxWorldAccessModerations
+}
+
+func (w *ANDWorld) verifyModeration(m *abyss.WorldModeration) bool {
+	return w.access != nil && w.o.signer != nil && w.access.verify(w.o.signer, m)
+}
+
+// applyModeration removes a kicked or banned target from the world.
+// The target is sent the action before its session is reset.
+// If the local host is the target, every member session is reset.
+func (w *ANDWorld) applyModeration(m *abyss.WorldModeration) {
+	w.access.apply(m)
+	w.ech <- abyss.NeighborEvent{
+		Type:           abyss.ANDWorldModeration,
+		LocalSessionID: w.lsid,
+		Object:         m,
+	}
+
+	if m.Action != abyss.ModKick && m.Action != abyss.ModBan {
+		return
+	}
+	if m.Target == w.local {
+		w.o.stat.W(93)
+
+		for peer_id, info := range w.peers {
+			w.ClearStates(peer_id, info, "removed from world")
+		}
+		return
+	}
+	info, ok := w.peers[m.Target]
+	if !ok {
+		w.o.stat.W(94)
+		return
+	}
+	w.o.stat.W(95)
+
+	if info.state == WS_MEM {
+		w.o.stat.MOD_TX++
+		info.Peer.TrySendMOD(w.lsid, info.PeerSessionID, m)
+	}
+	w.ClearStates(m.Target, info, "removed from world")
+}
+
+// forwardModeration sends the action to every member except the one it came from.
+func (w *ANDWorld) forwardModeration(m *abyss.WorldModeration, sender_id string) {
+	for peer_id, info := range w.peers {
+		if info.state != WS_MEM || peer_id == sender_id {
+			continue
+		}
+		w.o.stat.MOD_TX++
+		info.Peer.TrySendMOD(w.lsid, info.PeerSessionID, m)
+	}
+}
+
+func (w *ANDWorld) MOD(peer_session abyss.ANDPeerSession, m *abyss.WorldModeration) {
+	w.o.stat.MOD_RX++
+
+	sender_id := peer_session.Peer.IDHash()
+	info, ok := w.peers[sender_id]
+	if !ok || info.PeerSessionID != peer_session.PeerSessionID {
+		w.o.stat.W(88)
+
+		w.o.stat.RST_TX++
+		peer_session.Peer.TrySendRST(w.lsid, peer_session.PeerSessionID, "MOD::sessionID mismatch")
+		return
+	}
+	if info.state != WS_MEM {
+		w.o.stat.W(89)
+		return
+	}
+	if w.access == nil || w.access.isStale(m) {
+		w.o.stat.W(90)
+		return
+	}
+	if !w.verifyModeration(m) {
+		w.o.stat.W(91)
+		return
+	}
+	w.o.stat.W(92)
+
+	w.applyModeration(m)
+	w.forwardModeration(m, sender_id)
+}
+
+// Moderate issues a signed moderation action.
+func (w *ANDWorld) Moderate(action abyss.ModerationAction, target_hash string, reason string) abyss.ANDERROR {
+	if w.access == nil || w.o.signer == nil {
+		w.o.stat.W(96)
+		return abyss.EINVAL
+	}
+	timestamp := time.UnixMilli(time.Now().UnixMilli())
+	if latest, ok := w.access.latest[target_hash]; ok && !timestamp.After(latest) {
+		timestamp = latest.Add(time.Millisecond)
+	}
+	m := &abyss.WorldModeration{
+		WorldID:           w.access.world_id,
+		Action:            action,
+		Target:            target_hash,
+		Issuer:            w.local,
+		IssuerRootCertDer: w.o.signer.RootCertificateDer(),
+		Reason:            reason,
+		TimeStamp:         timestamp,
+	}
+	if !w.access.permits(m) || (action != abyss.ModKick && w.access.isFull()) {
+		w.o.stat.W(97)
+		return abyss.EINVAL
+	}
+	signature, err := w.o.signer.Sign(moderationMessage(m))
+	if err != nil {
+		w.o.stat.W(98)
+		return abyss.EINVAL
+	}
+	m.Signature = signature
+	w.o.stat.W(99)
+
+	w.applyModeration(m)
+	w.forwardModeration(m, "")
+	return 0
+}
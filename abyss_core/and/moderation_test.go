@@ -0,0 +1,233 @@
+package and
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+// testSigner stands for a root key; its certificate is the peer hash.
+type testSigner struct {
+	hash string
+}
+
+func testSignature(hash string, message []byte) []byte {
+	sum := sha256.Sum256(append([]byte(hash+"\x00"), message...))
+	return sum[:]
+}
+
+func (s testSigner) RootCertificateDer() []byte { return []byte(s.hash) }
+func (s testSigner) Sign(message []byte) ([]byte, error) {
+	return testSignature(s.hash, message), nil
+}
+func (s testSigner) Verify(root_cert_der []byte, message []byte, signature []byte) (string, error) {
+	if !bytes.Equal(signature, testSignature(string(root_cert_der), message)) {
+		return "", errors.New("invalid signature")
+	}
+	return string(root_cert_der), nil
+}
+
+func signedModeration(issuer string, world_id uuid.UUID, action abyss.ModerationAction, target string, timestamp time.Time) *abyss.WorldModeration {
+	m := &abyss.WorldModeration{
+		WorldID:           world_id,
+		Action:            action,
+		Target:            target,
+		Issuer:            issuer,
+		IssuerRootCertDer: []byte(issuer),
+		TimeStamp:         timestamp,
+	}
+	m.Signature = testSignature(issuer, moderationMessage(m))
+	return m
+}
+
+// modPeer is a recPeer that also takes moderation actions.
+type modPeer struct {
+	recPeer
+}
+
+func (p *modPeer) TrySendMOD(local_session_id uuid.UUID, peer_session_id uuid.UUID, moderation *abyss.WorldModeration) bool {
+	p.sent = append(p.sent, "MOD")
+	return true
+}
+
+// openModeratedAND has a world opened by O, and B as its member.
+func openModeratedAND(t *testing.T) (*AND, uuid.UUID, *modPeer, uuid.UUID) {
+	a := NewAND("O")
+	a.SetRootSigner(testSigner{"O"})
+	lsid := uuid.New()
+	a.OpenWorld(lsid, "https://example.com/world")
+	member := &modPeer{recPeer{hash: "B"}}
+	a.PeerConnected(member)
+	member_sid := uuid.New()
+	info := a.worlds[lsid].peers["B"]
+	info.PeerSessionID = member_sid
+	info.state = WS_MEM
+	drainEvents(a)
+	return a, lsid, member, member_sid
+}
+
+func TestModerationPermits(t *testing.T) {
+	world_id := uuid.New()
+	access := newWorldAccess(world_id, "O", nil, nil)
+	access.moderators["M"] = true
+	access.moderators["N"] = true
+	for _, c := range []struct {
+		issuer string
+		action abyss.ModerationAction
+		target string
+		permit bool
+	}{
+		{"M", abyss.ModKick, "X", true},
+		{"M", abyss.ModBan, "X", true},
+		{"M", abyss.ModBan, "N", false},
+		{"M", abyss.ModBan, "O", false},
+		{"M", abyss.ModGrantModerator, "X", false},
+		{"X", abyss.ModKick, "Y", false},
+		{"O", abyss.ModBan, "M", true},
+		{"O", abyss.ModGrantModerator, "X", true},
+		{"O", abyss.ModRevokeModerator, "O", false},
+		{"O", abyss.ModKick, "O", false},
+	} {
+		m := &abyss.WorldModeration{WorldID: world_id, Action: c.action, Target: c.target, Issuer: c.issuer}
+		if access.permits(m) != c.permit {
+			t.Errorf("%s %d %s: expected %v", c.issuer, c.action, c.target, c.permit)
+		}
+	}
+}
+
+// TestWorldAccessReplay rebuilds an access list as a joiner does.
+func TestWorldAccessReplay(t *testing.T) {
+	world_id := uuid.New()
+	base := time.UnixMilli(time.Now().UnixMilli())
+	grant := signedModeration("O", world_id, abyss.ModGrantModerator, "M", base)
+	ban := signedModeration("M", world_id, abyss.ModBan, "X", base.Add(time.Second))
+	revoke := signedModeration("O", world_id, abyss.ModRevokeModerator, "M", base.Add(2*time.Second))
+	access := &abyss.WorldAccess{
+		WorldID:          world_id,
+		Owner:            "O",
+		OwnerRootCertDer: []byte("O"),
+		Signature:        testSignature("O", ownerMessage(world_id, "O")),
+		Moderations: []*abyss.WorldModeration{
+			grant, ban, revoke,
+			ban, // replayed
+			signedModeration("M", world_id, abyss.ModBan, "Y", base.Add(3*time.Second)),   // after the revoke
+			signedModeration("X", world_id, abyss.ModUnban, "X", base.Add(4*time.Second)), // by a member
+		},
+	}
+	forged := signedModeration("X", world_id, abyss.ModBan, "O", base.Add(5*time.Second))
+	forged.Issuer = "O"
+	access.Moderations = append(access.Moderations, forged)
+
+	result := verifyWorldAccess(testSigner{"J"}, access)
+	if result == nil {
+		t.Fatal("valid access list refused")
+	}
+	if !result.isBanned("X") || result.isBanned("Y") || result.isBanned("O") || result.role("M") != abyss.RoleMember {
+		t.Fatal("unexpected access list: ", result.banned, result.moderators)
+	}
+	if len(result.log) != 3 {
+		t.Fatal("unexpected log length: ", len(result.log))
+	}
+	if again := verifyWorldAccess(testSigner{"J"}, result.snapshot()); again == nil || !again.isBanned("X") || len(again.log) != 3 {
+		t.Fatal("snapshot does not replay")
+	}
+
+	// a member claiming the world as its own.
+	access.Owner = "X"
+	access.OwnerRootCertDer = []byte("X")
+	if verifyWorldAccess(testSigner{"J"}, access) != nil {
+		t.Fatal("owner signature not checked")
+	}
+}
+
+// TestJOKAccess checks that a joiner takes only an access list signed by the owner,
+// and the invite-only flag.
+func TestJOKAccess(t *testing.T) {
+	for _, owner_key := range []string{"B", "C"} {
+		a, lsid, target := joiningAND(t)
+		a.SetRootSigner(testSigner{"A"})
+		world_id := uuid.New()
+		access := &abyss.WorldAccess{
+			WorldID:          world_id,
+			Owner:            "B",
+			OwnerRootCertDer: []byte(owner_key),
+			Signature:        testSignature(owner_key, ownerMessage(world_id, "B")),
+		}
+		a.JOK(lsid, abyss.ANDPeerSession{Peer: target, PeerSessionID: world_id}, time.Now(), "https://example.com/world", nil, abyss.WorldSettings{Access: access, InviteOnly: true})
+		if !a.IsInviteOnly(lsid) || !a.worlds[lsid].Settings().InviteOnly {
+			t.Fatal("invite-only flag not taken")
+		}
+		signed := a.worlds[lsid].access != nil
+		if signed != (owner_key == "B") {
+			t.Fatal("access list signed by ", owner_key, " taken: ", signed)
+		}
+	}
+}
+
+// TestModerationStaleReplay replays a ban after the unban that followed it.
+func TestModerationStaleReplay(t *testing.T) {
+	a, lsid, member, member_sid := openModeratedAND(t)
+	session := abyss.ANDPeerSession{Peer: member, PeerSessionID: member_sid}
+	base := time.UnixMilli(time.Now().UnixMilli())
+	ban := signedModeration("O", lsid, abyss.ModBan, "X", base)
+	unban := signedModeration("O", lsid, abyss.ModUnban, "X", base.Add(time.Second))
+
+	a.MOD(lsid, session, ban)
+	a.MOD(lsid, session, unban)
+	checkEventTypes(t, drainEvents(a), abyss.ANDWorldModeration, abyss.ANDWorldModeration)
+	a.MOD(lsid, session, ban)
+	checkEventTypes(t, drainEvents(a))
+	if a.worlds[lsid].access.isBanned("X") {
+		t.Fatal("stale ban applied")
+	}
+
+	// a valid action signed by someone else.
+	forged := signedModeration("B", lsid, abyss.ModBan, "X", base.Add(2*time.Second))
+	forged.Issuer = "O"
+	a.MOD(lsid, session, forged)
+	checkEventTypes(t, drainEvents(a))
+	if len(member.sent) != 0 {
+		t.Fatal("MOD sent back to its sender: ", member.sent)
+	}
+}
+
+// TestBannedPeer checks that a banned peer cannot join, become a member, or be introduced.
+func TestBannedPeer(t *testing.T) {
+	a, lsid, member, member_sid := openModeratedAND(t)
+	world := a.worlds[lsid]
+	if a.Moderate(lsid, abyss.ModBan, "X", "") != 0 || a.Moderate(lsid, abyss.ModBan, "Y", "") != 0 {
+		t.Fatal("ban failed")
+	}
+	checkEventTypes(t, drainEvents(a), abyss.ANDWorldModeration, abyss.ANDWorldModeration)
+	member.sent = nil
+
+	banned := &modPeer{recPeer{hash: "X"}}
+	a.PeerConnected(banned)
+	a.JN(lsid, abyss.ANDPeerSession{Peer: banned, PeerSessionID: uuid.New()}, time.Now())
+	if len(banned.sent) != 1 || banned.sent[0] != "JDN" {
+		t.Fatal("JN of a banned peer not denied: ", banned.sent)
+	}
+	checkEventTypes(t, drainEvents(a), abyss.ANDSessionDrop)
+	a.MEM(lsid, abyss.ANDPeerSession{Peer: banned, PeerSessionID: uuid.New()}, time.Now())
+	if world.peers["X"].state != WS_CC {
+		t.Fatal("MEM of a banned peer taken")
+	}
+	checkEventTypes(t, drainEvents(a))
+
+	a.JNI(lsid, abyss.ANDPeerSession{Peer: member, PeerSessionID: member_sid}, abyss.ANDFullPeerSessionIdentity{
+		AURL:      &aurl.AURL{Scheme: "abyss", Hash: "Y"},
+		SessionID: uuid.New(),
+		TimeStamp: time.Now(),
+	})
+	checkEventTypes(t, drainEvents(a))
+	if _, ok := world.peers["Y"]; ok {
+		t.Fatal("banned peer introduced")
+	}
+}
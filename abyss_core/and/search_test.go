@@ -0,0 +1,150 @@
+package and
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/tools/sear"
+)
+
+///// scenario search
+// simMachine enumerates the orders of the simulation steps with sear.ScenarioSearcher.
+// Timers are not steps, so that the choices are the same on every replay;
+// each scenario is cut at a depth, settled with timers, and then checked.
+// A link drop may partition the world for good, as nothing reconnects members;
+// then only the members are checked, as a half-made session may wait for a JNI that is lost.
+
+type simMachine struct {
+	node_count  int
+	depth       int
+	disconnects int
+
+	sim  *simulation
+	path []int
+
+	scenarios   int
+	err         error
+	failed_path []int
+	b_counts    []int
+	w_counts    []int
+}
+
+func (m *simMachine) Initialize() {
+	m.sim = newSimulation(m.node_count)
+	m.sim.disconnects = m.disconnects
+	m.path = m.path[:0]
+}
+
+func (m *simMachine) GetInitPaths() int {
+	return len(m.sim.steps())
+}
+
+func (m *simMachine) Forward(path int) (next int) {
+	m.path = append(m.path, path)
+	defer func() {
+		if r := recover(); r != nil {
+			m.fail(fmt.Errorf("panic: %v", r))
+			next = 0
+		}
+	}()
+
+	m.sim.run(m.sim.steps()[path])
+	next = len(m.sim.steps())
+	if next != 0 && len(m.path) < m.depth {
+		return next
+	}
+	m.finish()
+	return 0
+}
+
+func (m *simMachine) finish() {
+	m.scenarios++
+	m.sim.settle(4)
+	var err error
+	if m.sim.disconnects != m.disconnects {
+		err = m.sim.checkMembers()
+	} else if err = m.sim.checkSettled(); err == nil {
+		err = m.sim.checkFullMesh()
+	}
+	if err != nil {
+		m.fail(err)
+	}
+	for _, node := range m.sim.nodes {
+		b, w := node.and.stat.Branches()
+		m.b_counts = addCounts(m.b_counts, b)
+		m.w_counts = addCounts(m.w_counts, w)
+	}
+}
+
+// fail keeps the first failure.
+func (m *simMachine) fail(err error) {
+	if m.err == nil {
+		m.err = err
+		m.failed_path = slices.Clone(m.path)
+	}
+}
+
+func addCounts(sum []int, counts []int) []int {
+	if counts == nil { //release build
+		return nil
+	}
+	if sum == nil {
+		sum = make([]int, len(counts))
+	}
+	for i, c := range counts {
+		sum[i] += c
+	}
+	return sum
+}
+
+func untested(counts []int) []int {
+	result := make([]int, 0)
+	for i, c := range counts {
+		if c == 0 {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+func runSearch(t *testing.T, m *simMachine) {
+	searcher := sear.MakeScenarioSearcher(m)
+	searcher.Run()
+
+	if m.err != nil {
+		t.Fatalf("%v, after %d scenarios; path %v", m.err, m.scenarios, m.failed_path)
+	}
+	t.Logf("%d scenarios", m.scenarios)
+	if m.b_counts != nil { //debug build
+		t.Logf("untested B: %v", untested(m.b_counts))
+		t.Logf("untested W: %v", untested(m.w_counts))
+	}
+}
+
+// TestSearchJoin checks every order of concurrent joins.
+func TestSearchJoin(t *testing.T) {
+	depth := 100 // deeper than any scenario
+	if testing.Short() {
+		depth = 10
+	}
+	runSearch(t, &simMachine{node_count: 3, depth: depth})
+}
+
+// TestSearchJoinWide checks every order of the first steps of more joins.
+func TestSearchJoinWide(t *testing.T) {
+	depth := 8
+	if testing.Short() {
+		depth = 6
+	}
+	runSearch(t, &simMachine{node_count: 4, depth: depth})
+}
+
+// TestSearchDisconnect drops one link at any point of the joins.
+func TestSearchDisconnect(t *testing.T) {
+	depth := 12
+	if testing.Short() {
+		depth = 8
+	}
+	runSearch(t, &simMachine{node_count: 3, depth: depth, disconnects: 1})
+}
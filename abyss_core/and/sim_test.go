@@ -0,0 +1,446 @@
+package and
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/phuslu/log"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+	"github.com/kadmila/Abyss-Browser/abyss_core/tools/functional"
+)
+
+func TestMain(m *testing.M) {
+	log.DefaultLogger.Level = log.WarnLevel //AND logs every call
+	os.Exit(m.Run())
+}
+
+///// simulation
+// A network of AND instances, each with a single world, connected through links.
+// A link is a pair of message queues; AHMP is ordered per connection, so each queue is FIFO.
+// A step is a choice among the pending deliveries, connections, timers and link drops;
+// everything else, including accepting every session request, happens in the step.
+// Timestamps of the local world are sent a minute in the past, so that SJN is not held back
+// by the age of the members; the simulation does not wait.
+
+type simNode struct {
+	index   int
+	hash    string
+	and     *AND
+	lsid    uuid.UUID
+	links   map[string]*simLink  //key: remote hash
+	members map[string]uuid.UUID //ready sessions. key: hash
+
+	joined bool
+	failed bool
+	timer  bool //timer requested
+}
+
+type simLink struct {
+	ends   [2]*simNode
+	peers  [2]*simPeer //peers[i] is at ends[i]
+	queues [2][]func() //queues[i] is delivered to ends[i]
+	closed bool
+}
+
+type simPeer struct {
+	link   *simLink
+	local  *simNode
+	remote *simNode
+	to     int //index of the remote end
+}
+
+func (p *simPeer) IDHash() string                     { return p.remote.hash }
+func (p *simPeer) RootCertificateDer() []byte         { return []byte("root " + p.remote.hash) }
+func (p *simPeer) HandshakeKeyCertificateDer() []byte { return []byte("handshake " + p.remote.hash) }
+func (p *simPeer) IsConnected() bool                  { return !p.link.closed }
+func (p *simPeer) AURL() *aurl.AURL                   { return &aurl.AURL{Scheme: "abyss", Hash: p.remote.hash} }
+func (p *simPeer) Context() context.Context           { return context.Background() }
+func (p *simPeer) Activate()                          {}
+func (p *simPeer) Renew()                             {}
+func (p *simPeer) Deactivate()                        {}
+func (p *simPeer) Error() error                       { return nil }
+func (p *simPeer) AhmpCh() chan any                   { return nil }
+func (p *simPeer) DatagramCh() chan any               { return nil }
+
+// send queues a delivery to the remote end, which takes the remote's peer object of the local end.
+func (p *simPeer) send(deliver func(receiver *simNode, sender abyss.IANDPeer)) bool {
+	if p.link.closed {
+		return false
+	}
+	link, to := p.link, p.to
+	link.queues[to] = append(link.queues[to], func() {
+		deliver(link.ends[to], link.peers[to])
+	})
+	return true
+}
+
+// simTime is a local world timestamp, as sent.
+func simTime(timestamp time.Time) time.Time {
+	return time.UnixMilli(timestamp.UnixMilli()).Add(-time.Minute)
+}
+
+// simIdentity is what the receiver parses from RawSessionInfoForDiscovery.
+func simIdentity(session abyss.ANDPeerSessionWithTimeStamp) abyss.ANDFullPeerSessionIdentity {
+	return abyss.ANDFullPeerSessionIdentity{
+		AURL:                       session.Peer.AURL(),
+		SessionID:                  session.PeerSessionID,
+		TimeStamp:                  session.TimeStamp,
+		RootCertificateDer:         session.Peer.RootCertificateDer(),
+		HandshakeKeyCertificateDer: session.Peer.HandshakeKeyCertificateDer(),
+	}
+}
+
+func (p *simPeer) TrySendJN(local_session_id uuid.UUID, path string, timestamp time.Time) bool {
+	timestamp = simTime(timestamp)
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.JN(r.lsid, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, timestamp)
+	})
+}
+func (p *simPeer) TrySendJOK(local_session_id uuid.UUID, peer_session_id uuid.UUID, timestamp time.Time, world_url string, member_sessions []abyss.ANDPeerSessionWithTimeStamp, settings abyss.WorldSettings) bool {
+	timestamp = simTime(timestamp)
+	members := functional.Filter(member_sessions, simIdentity)
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.JOK(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, timestamp, world_url, members, settings)
+	})
+}
+func (p *simPeer) TrySendJDN(peer_session_id uuid.UUID, code int, message string) bool {
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.JDN(peer_session_id, s, code, message)
+	})
+}
+func (p *simPeer) TrySendJNI(local_session_id uuid.UUID, peer_session_id uuid.UUID, member_session abyss.ANDPeerSessionWithTimeStamp) bool {
+	member := simIdentity(member_session)
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.JNI(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, member)
+	})
+}
+func (p *simPeer) TrySendMEM(local_session_id uuid.UUID, peer_session_id uuid.UUID, timestamp time.Time) bool {
+	timestamp = simTime(timestamp)
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.MEM(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, timestamp)
+	})
+}
+func (p *simPeer) TrySendSJN(local_session_id uuid.UUID, peer_session_id uuid.UUID, member_sessions []abyss.ANDPeerSessionIdentity) bool {
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.SJN(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, member_sessions)
+	})
+}
+func (p *simPeer) TrySendCRR(local_session_id uuid.UUID, peer_session_id uuid.UUID, member_sessions []abyss.ANDPeerSessionIdentity) bool {
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.CRR(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, member_sessions)
+	})
+}
+func (p *simPeer) TrySendRST(local_session_id uuid.UUID, peer_session_id uuid.UUID, message string) bool {
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.RST(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, message)
+	})
+}
+func (p *simPeer) TrySendSOA(local_session_id uuid.UUID, peer_session_id uuid.UUID, objects []abyss.ObjectInfo) bool {
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.SOA(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, objects)
+	})
+}
+func (p *simPeer) TrySendSOD(local_session_id uuid.UUID, peer_session_id uuid.UUID, objectIDs []uuid.UUID) bool {
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.SOD(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, objectIDs)
+	})
+}
+func (p *simPeer) TrySendSOU(local_session_id uuid.UUID, peer_session_id uuid.UUID, updates []abyss.ObjectUpdate) bool {
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.SOU(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, updates)
+	})
+}
+func (p *simPeer) TrySendSAM(local_session_id uuid.UUID, peer_session_id uuid.UUID, topic string, payload []byte) bool {
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.SAM(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, topic, payload)
+	})
+}
+func (p *simPeer) TrySendMOD(local_session_id uuid.UUID, peer_session_id uuid.UUID, moderation *abyss.WorldModeration) bool {
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.MOD(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, moderation)
+	})
+}
+func (p *simPeer) TrySendROS(local_session_id uuid.UUID, peer_session_id uuid.UUID, keep bool, local abyss.ANDRosterEntry, roster []abyss.ANDRosterEntry) bool {
+	return p.send(func(r *simNode, s abyss.IANDPeer) {
+		r.and.ROS(peer_session_id, abyss.ANDPeerSession{Peer: s, PeerSessionID: local_session_id}, keep, local, roster)
+	})
+}
+func (p *simPeer) TrySendDTU(local_session_id uuid.UUID, peer_session_id uuid.UUID, sequence uint64, transforms []abyss.ObjectTransform) bool {
+	return !p.link.closed //not handled by AND
+}
+
+type simStepKind int
+
+const (
+	simDeliver simStepKind = iota
+	simConnect
+	simTimer
+	simDisconnect
+)
+
+type simStep struct {
+	kind simStepKind
+	a, b int //node indices; for simDeliver, a is the receiver
+}
+
+type simulation struct {
+	nodes       []*simNode
+	connects    map[[2]int]bool //requested, not yet connected. key: sorted node indices
+	timers      bool            //whether timers are steps
+	disconnects int             //link drops left
+}
+
+// newSimulation opens the world at node 0, and joins the others to it.
+func newSimulation(node_count int) *simulation {
+	result := &simulation{
+		connects: make(map[[2]int]bool),
+	}
+	for i := range node_count {
+		hash := fmt.Sprintf("Isim%02d", i)
+		result.nodes = append(result.nodes, &simNode{
+			index:   i,
+			hash:    hash,
+			and:     NewAND(hash),
+			lsid:    uuid.New(),
+			links:   make(map[string]*simLink),
+			members: make(map[string]uuid.UUID),
+		})
+	}
+	opener := result.nodes[0]
+	opener.and.OpenWorld(opener.lsid, "https://example.com/world")
+	result.drain(opener)
+	for _, node := range result.nodes[1:] {
+		node.and.JoinWorld(node.lsid, &aurl.AURL{Scheme: "abyss", Hash: opener.hash, Path: "world"})
+		result.drain(node)
+	}
+	return result
+}
+
+func (s *simulation) node(hash string) *simNode {
+	for _, node := range s.nodes {
+		if node.hash == hash {
+			return node
+		}
+	}
+	panic("unknown node " + hash)
+}
+
+func (s *simulation) world(node *simNode) *ANDWorld {
+	return node.and.worlds[node.lsid]
+}
+
+// drain handles the events of a node, as the host does.
+func (s *simulation) drain(node *simNode) {
+	for {
+		select {
+		case event := <-node.and.EventChannel():
+			s.handle(node, event)
+		default:
+			return
+		}
+	}
+}
+
+func (s *simulation) handle(node *simNode, event abyss.NeighborEvent) {
+	switch event.Type {
+	case abyss.ANDSessionRequest:
+		node.and.AcceptSession(event.LocalSessionID, event.ANDPeerSession)
+	case abyss.ANDSessionReady:
+		node.members[event.Peer.IDHash()] = event.PeerSessionID
+	case abyss.ANDSessionClose:
+		if node.members[event.Peer.IDHash()] == event.PeerSessionID {
+			delete(node.members, event.Peer.IDHash())
+		}
+	case abyss.ANDJoinSuccess:
+		node.joined = true
+	case abyss.ANDJoinFail:
+		node.failed = true
+	case abyss.ANDConnectRequest:
+		target := s.node(event.Object.(*aurl.AURL).Hash)
+		if _, ok := node.links[target.hash]; !ok {
+			s.connects[[2]int{min(node.index, target.index), max(node.index, target.index)}] = true
+		}
+	case abyss.ANDTimerRequest:
+		node.timer = true
+	}
+}
+
+// links returns the open links in a fixed order.
+func (s *simulation) links() []*simLink {
+	result := make([]*simLink, 0)
+	for _, node := range s.nodes {
+		for _, link := range node.links {
+			if link.ends[0] == node {
+				result = append(result, link)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ends[0].index != result[j].ends[0].index {
+			return result[i].ends[0].index < result[j].ends[0].index
+		}
+		return result[i].ends[1].index < result[j].ends[1].index
+	})
+	return result
+}
+
+// steps lists the possible steps in a fixed order.
+func (s *simulation) steps() []simStep {
+	result := make([]simStep, 0)
+	links := s.links()
+	for _, link := range links {
+		for i := range 2 {
+			if len(link.queues[i]) != 0 {
+				result = append(result, simStep{simDeliver, link.ends[i].index, link.ends[1-i].index})
+			}
+		}
+	}
+	connects := make([]simStep, 0, len(s.connects))
+	for pair := range s.connects {
+		connects = append(connects, simStep{simConnect, pair[0], pair[1]})
+	}
+	sort.Slice(connects, func(i, j int) bool {
+		if connects[i].a != connects[j].a {
+			return connects[i].a < connects[j].a
+		}
+		return connects[i].b < connects[j].b
+	})
+	result = append(result, connects...)
+	if s.timers {
+		for _, node := range s.nodes {
+			if node.timer {
+				result = append(result, simStep{simTimer, node.index, node.index})
+			}
+		}
+	}
+	if s.disconnects > 0 {
+		for _, link := range links {
+			result = append(result, simStep{simDisconnect, link.ends[0].index, link.ends[1].index})
+		}
+	}
+	return result
+}
+
+func (s *simulation) run(step simStep) {
+	a, b := s.nodes[step.a], s.nodes[step.b]
+	switch step.kind {
+	case simDeliver:
+		link := a.links[b.hash]
+		i := 0
+		if link.ends[1] == a {
+			i = 1
+		}
+		deliver := link.queues[i][0]
+		link.queues[i] = link.queues[i][1:]
+		deliver()
+		s.drain(a)
+	case simConnect:
+		delete(s.connects, [2]int{step.a, step.b})
+		link := &simLink{ends: [2]*simNode{a, b}}
+		link.peers[0] = &simPeer{link: link, local: a, remote: b, to: 1}
+		link.peers[1] = &simPeer{link: link, local: b, remote: a, to: 0}
+		a.links[b.hash] = link
+		b.links[a.hash] = link
+		a.and.PeerConnected(link.peers[0])
+		b.and.PeerConnected(link.peers[1])
+	case simTimer:
+		a.timer = false
+		a.and.TimerExpire(a.lsid)
+	case simDisconnect:
+		s.disconnects--
+		link := a.links[b.hash]
+		link.closed = true
+		link.queues = [2][]func(){}
+		delete(a.links, b.hash)
+		delete(b.links, a.hash)
+		a.and.PeerClose(link.peers[0])
+		b.and.PeerClose(link.peers[1])
+	}
+	for _, node := range s.nodes {
+		s.drain(node)
+	}
+}
+
+// settle runs the first step until only timers are left, then fires every timer, for the given rounds.
+func (s *simulation) settle(rounds int) {
+	timers, disconnects := s.timers, s.disconnects
+	s.timers, s.disconnects = false, 0
+	defer func() { s.timers, s.disconnects = timers, disconnects }()
+
+	for range rounds {
+		for steps := s.steps(); len(steps) != 0; steps = s.steps() {
+			s.run(steps[0])
+		}
+		for _, node := range s.nodes {
+			if node.timer {
+				s.run(simStep{simTimer, node.index, node.index})
+			}
+		}
+	}
+	for steps := s.steps(); len(steps) != 0; steps = s.steps() {
+		s.run(steps[0])
+	}
+}
+
+// checkMembers checks the sanity of every world, and that members are mutual.
+func (s *simulation) checkMembers() error {
+	for _, node := range s.nodes {
+		world := s.world(node)
+		if world == nil {
+			if len(node.members) != 0 {
+				return fmt.Errorf("%s: closed with members", node.hash)
+			}
+			continue
+		}
+		world.CheckSanity()
+		for peer_id, session_id := range node.members {
+			peer := s.node(peer_id)
+			if peer.lsid != session_id || peer.members[node.hash] != node.lsid {
+				return fmt.Errorf("%s: %s is not a mutual member", node.hash, peer_id)
+			}
+			if info, ok := world.peers[peer_id]; !ok || info.state != WS_MEM {
+				return fmt.Errorf("%s: %s is ready, but not WS_MEM", node.hash, peer_id)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSettled also checks that no session is half-made: every peer is either a member or only connected.
+func (s *simulation) checkSettled() error {
+	if err := s.checkMembers(); err != nil {
+		return err
+	}
+	for _, node := range s.nodes {
+		world := s.world(node)
+		if world == nil {
+			continue
+		}
+		for peer_id, info := range world.peers {
+			if info.state != WS_CC && info.state != WS_MEM {
+				return fmt.Errorf("%s: %s is stuck in state %d", node.hash, peer_id, info.state)
+			}
+		}
+	}
+	return nil
+}
+
+// checkFullMesh checks that every node joined, and has every other node as a member.
+func (s *simulation) checkFullMesh() error {
+	for _, node := range s.nodes {
+		if !node.joined || node.failed {
+			return fmt.Errorf("%s did not join", node.hash)
+		}
+		if len(node.members) != len(s.nodes)-1 {
+			return fmt.Errorf("%s has %d members of %d", node.hash, len(node.members), len(s.nodes)-1)
+		}
+	}
+	return nil
+}
@@ -28,9 +28,13 @@ const (
 type ANDPeerSessionState struct {
 	//latest
 	abyss.ANDPeerSessionWithTimeStamp
-	state int
-	sjnp  bool //is sjn suppressed
-	sjnc  int  //sjn receive count
+	state   int
+	sjnp    bool      //is sjn suppressed
+	sjnc    int       //sjn receive count
+	queued  bool      //WS_JN, waiting for a room
+	enqueue time.Time //when queued
+	keep    bool      //partial mesh: the peer wants to keep the session
+	joining bool      //came with JN, and not ready yet; see dropJoin
 }
 
 func NewANDPeerSessionState(peer abyss.IANDPeer, session_id uuid.UUID, timestamp time.Time, state int) *ANDPeerSessionState {
@@ -45,6 +49,10 @@ func NewANDPeerSessionState(peer abyss.IANDPeer, session_id uuid.UUID, timestamp
 		state,
 		false,
 		0,
+		false,
+		time.Time{},
+		true,
+		false,
 	}
 }
 
@@ -58,6 +66,9 @@ func (s *ANDPeerSessionState) Clear() {
 	}
 	s.sjnp = false
 	s.sjnc = 0
+	s.queued = false
+	s.keep = true
+	s.joining = false
 }
 
 type ANDWorld struct {
@@ -70,6 +81,14 @@ type ANDWorld struct {
 	join_path string                          //const
 	wurl      string                          //const
 	peers     map[string]*ANDPeerSessionState //key: hash
+	access    *worldAccess                    //nil if moderation is disabled
+
+	max_members  int                    //including local; 0 is unlimited
+	queue_length int                    //joiners waiting for a room
+	queue        []abyss.ANDPeerSession //JN sessions, oldest first
+	invite_only  bool                   //checked by the host; sent with JOK
+
+	interest *interestState //nil in the full mesh
 
 	ech chan abyss.NeighborEvent
 }
@@ -116,6 +135,9 @@ func NewWorldOpen(origin *AND, local_hash string, local_session_id uuid.UUID, wo
 		peers:     make(map[string]*ANDPeerSessionState),
 		ech:       event_ch,
 	}
+	if origin.signer != nil {
+		result.access = openWorldAccess(origin.signer, local_session_id, local_hash)
+	}
 	for peer_id, peer := range connected_members {
 		origin.stat.W(0)
 
@@ -174,21 +196,16 @@ func (w *ANDWorld) ClearStates(peer_id string, info *ANDPeerSessionState, messag
 	case WS_DC_JT, WS_DC_JNI:
 		delete(w.peers, peer_id)
 	case WS_CC:
-		info.Clear()
+		w.clearSession(info)
 	case WS_JT:
 		w.o.stat.RST_TX++
 		info.Peer.TrySendRST(w.lsid, info.PeerSessionID, "ClearStates::WS_JT "+message)
-		w.ech <- abyss.NeighborEvent{
-			Type:           abyss.ANDJoinFail,
-			LocalSessionID: w.lsid,
-			Text:           JNM_INVALID_STATES,
-			Value:          JNC_INVALID_STATES,
-		}
-		info.Clear()
+		w.clearSession(info)
+		w.FailJoin(JNC_INVALID_STATES, JNM_INVALID_STATES)
 	case WS_JN:
 		w.o.stat.JDN_TX++
 		info.Peer.TrySendJDN(info.PeerSessionID, JNC_INVALID_STATES, JNM_INVALID_STATES)
-		info.Clear()
+		w.clearSession(info)
 	case WS_MEM:
 		w.ech <- abyss.NeighborEvent{
 			Type:           abyss.ANDSessionClose,
@@ -199,8 +216,38 @@ func (w *ANDWorld) ClearStates(peer_id string, info *ANDPeerSessionState, messag
 	case WS_RMEM_NJNI, WS_JNI, WS_RMEM, WS_TMEM:
 		w.o.stat.RST_TX++
 		info.Peer.TrySendRST(w.lsid, info.PeerSessionID, "ClearStates::else "+message)
-		info.Clear()
+		w.clearSession(info)
+	}
+}
+
+// clearSession clears the state of a peer, reporting a joiner with dropJoin.
+func (w *ANDWorld) clearSession(info *ANDPeerSessionState) {
+	if info.joining {
+		w.dropJoin(info.ANDPeerSession)
+	}
+	info.Clear()
+}
+
+// dropJoin tells the host that a JN will not be followed by ANDSessionReady.
+func (w *ANDWorld) dropJoin(peer_session abyss.ANDPeerSession) {
+	w.ech <- abyss.NeighborEvent{
+		Type:           abyss.ANDSessionDrop,
+		LocalSessionID: w.lsid,
+		ANDPeerSession: peer_session,
+	}
+}
+
+// FailJoin closes the world after its join failed, as CloseWorld does for a canceled join.
+// The join target must be cleared; the others, introduced before JOK, are reset.
+func (w *ANDWorld) FailJoin(code int, message string) {
+	w.ech <- abyss.NeighborEvent{
+		Type:           abyss.ANDJoinFail,
+		LocalSessionID: w.lsid,
+		Text:           message,
+		Value:          code,
 	}
+	w.Close()
+	delete(w.o.worlds, w.lsid)
 }
 
 // TryUpdateSessionID returns (old session ID, success). old session ID is nil if not updated
@@ -266,6 +313,14 @@ func (w *ANDWorld) JN(peer_session abyss.ANDPeerSession, timestamp time.Time) {
 	w.o.stat.JN_RX++
 
 	info := w.peers[peer_session.Peer.IDHash()]
+	if w.access.isBanned(peer_session.Peer.IDHash()) {
+		w.o.stat.W(100)
+
+		w.o.stat.JDN_TX++
+		peer_session.Peer.TrySendJDN(peer_session.PeerSessionID, JNC_BANNED, JNM_BANNED)
+		w.dropJoin(peer_session)
+		return
+	}
 	switch info.state {
 	case WS_CC:
 		w.o.stat.W(7)
@@ -273,16 +328,14 @@ func (w *ANDWorld) JN(peer_session abyss.ANDPeerSession, timestamp time.Time) {
 		info.ANDPeerSession = peer_session
 		info.TimeStamp = timestamp
 		info.state = WS_JN
-		w.ech <- abyss.NeighborEvent{
-			Type:           abyss.ANDSessionRequest,
-			LocalSessionID: w.lsid,
-			ANDPeerSession: peer_session,
-		}
+		info.joining = true
+		w.RequestOrQueue(info)
 	case WS_JT: //should not happen. during joining, the world must be hidden, not accepting JN.
 		w.o.stat.W(8)
 
 		w.o.stat.JDN_TX++
 		peer_session.Peer.TrySendJDN(peer_session.PeerSessionID, JNC_INVALID_STATES, JNM_INVALID_STATES)
+		w.dropJoin(peer_session)
 	case WS_JN, WS_RMEM_NJNI, WS_JNI, WS_RMEM, WS_TMEM, WS_MEM:
 		w.o.stat.W(9)
 
@@ -290,22 +343,20 @@ func (w *ANDWorld) JN(peer_session abyss.ANDPeerSession, timestamp time.Time) {
 			w.o.stat.W(10)
 
 			info.state = WS_JN
-			w.ech <- abyss.NeighborEvent{
-				Type:           abyss.ANDSessionRequest,
-				LocalSessionID: w.lsid,
-				ANDPeerSession: peer_session,
-			}
+			info.joining = true
+			w.RequestOrQueue(info)
 		} else {
 			w.o.stat.W(11)
 
 			w.o.stat.JDN_TX++
 			peer_session.Peer.TrySendJDN(peer_session.PeerSessionID, JNC_DUPLICATE, JNM_DUPLICATE) //must not happen
+			w.dropJoin(peer_session)
 		}
 	default:
 		panic("and invalid state: JN")
 	}
 }
-func (w *ANDWorld) JOK(peer_session abyss.ANDPeerSession, timestamp time.Time, world_url string, member_infos []abyss.ANDFullPeerSessionIdentity) {
+func (w *ANDWorld) JOK(peer_session abyss.ANDPeerSession, timestamp time.Time, world_url string, member_infos []abyss.ANDFullPeerSessionIdentity, settings abyss.WorldSettings) {
 	w.o.stat.JOK_RX++
 
 	sender_id := peer_session.Peer.IDHash()
@@ -323,6 +374,16 @@ func (w *ANDWorld) JOK(peer_session abyss.ANDPeerSession, timestamp time.Time, w
 
 	info.ANDPeerSession = peer_session
 	info.TimeStamp = timestamp
+	if settings.Access != nil && w.o.signer != nil {
+		w.access = verifyWorldAccess(w.o.signer, settings.Access)
+	}
+	if settings.MaxMembers != 0 {
+		w.max_members = settings.MaxMembers
+	}
+	w.invite_only = settings.InviteOnly
+	if settings.InterestRadius != 0 {
+		w.interest = newInterestState(settings.InterestRadius)
+	}
 	w.ech <- abyss.NeighborEvent{
 		Type:           abyss.ANDJoinSuccess,
 		LocalSessionID: w.lsid,
@@ -335,6 +396,13 @@ func (w *ANDWorld) JOK(peer_session abyss.ANDPeerSession, timestamp time.Time, w
 	}
 	info.state = WS_RMEM
 	info.sjnp = true
+	if w.interest != nil { //joiners gossip as well
+		w.ech <- abyss.NeighborEvent{
+			Type:           abyss.ANDTimerRequest,
+			LocalSessionID: w.lsid,
+			Value:          500,
+		}
+	}
 
 	for _, mem_info := range member_infos {
 		w.o.stat.W(14)
@@ -355,13 +423,8 @@ func (w *ANDWorld) JDN(peer abyss.IANDPeer, code int, message string) { //no bra
 
 	w.o.stat.W(16)
 
-	w.ech <- abyss.NeighborEvent{
-		Type:           abyss.ANDJoinFail,
-		LocalSessionID: w.lsid,
-		Text:           message,
-		Value:          code,
-	}
 	info.Clear()
+	w.FailJoin(code, message)
 }
 
 func (w *ANDWorld) JNI(peer_session abyss.ANDPeerSession, member_info abyss.ANDFullPeerSessionIdentity) {
@@ -386,6 +449,10 @@ func (w *ANDWorld) JNI_MEMS(sender_id string, mem_info abyss.ANDFullPeerSessionI
 		w.o.stat.W(19)
 		return
 	}
+	if w.access.isBanned(peer_id) {
+		w.o.stat.W(101)
+		return
+	}
 
 	info, ok := w.peers[peer_id]
 	if !ok {
@@ -397,6 +464,7 @@ func (w *ANDWorld) JNI_MEMS(sender_id string, mem_info abyss.ANDFullPeerSessionI
 			Object: &abyss.PeerCertificates{
 				RootCertDer:         mem_info.RootCertificateDer,
 				HandshakeKeyCertDer: mem_info.HandshakeKeyCertificateDer,
+				Introducer:          sender_id,
 			},
 		}
 		w.ech <- abyss.NeighborEvent{
@@ -488,6 +556,12 @@ func (w *ANDWorld) MEM(peer_session abyss.ANDPeerSession, timestamp time.Time) {
 	w.o.stat.MEM_RX++
 
 	info := w.peers[peer_session.Peer.IDHash()]
+	if w.access.isBanned(peer_session.Peer.IDHash()) {
+		w.o.stat.W(102)
+
+		w.ClearStates(peer_session.Peer.IDHash(), info, "received MEM from banned peer")
+		return
+	}
 	switch info.state {
 	case WS_CC:
 		w.o.stat.W(29)
@@ -495,6 +569,7 @@ func (w *ANDWorld) MEM(peer_session abyss.ANDPeerSession, timestamp time.Time) {
 		info.ANDPeerSession = peer_session
 		info.TimeStamp = timestamp
 		info.state = WS_RMEM_NJNI
+		w.introduceFromRoster(peer_session.Peer.IDHash(), info)
 	case WS_JT:
 		w.o.stat.W(30)
 
@@ -535,6 +610,7 @@ func (w *ANDWorld) MEM(peer_session abyss.ANDPeerSession, timestamp time.Time) {
 			w.o.stat.W(38)
 
 			info.state = WS_MEM
+			info.joining = false
 			w.ech <- abyss.NeighborEvent{
 				Type:           abyss.ANDSessionReady,
 				LocalSessionID: w.lsid,
@@ -659,11 +735,63 @@ func (w *ANDWorld) SOD(peer_session abyss.ANDPeerSession, objectIDs []uuid.UUID)
 		w.o.stat.W(53)
 	}
 }
+func (w *ANDWorld) SOU(peer_session abyss.ANDPeerSession, updates []abyss.ObjectUpdate) {
+	w.o.stat.SOU_RX++
+
+	info, ok := w.peers[peer_session.Peer.IDHash()]
+	if !ok || info.PeerSessionID != peer_session.PeerSessionID {
+		w.o.stat.W(85)
+
+		w.o.stat.RST_TX++
+		peer_session.Peer.TrySendRST(w.lsid, peer_session.PeerSessionID, "SOU::sessionID mismatch")
+		return
+	}
+	switch info.state {
+	case WS_MEM:
+		w.o.stat.W(86)
+
+		w.ech <- abyss.NeighborEvent{
+			Type:           abyss.ANDObjectUpdate,
+			LocalSessionID: w.lsid,
+			ANDPeerSession: peer_session,
+			Object:         updates,
+		}
+	default:
+		w.o.stat.W(87)
+	}
+}
+func (w *ANDWorld) SAM(peer_session abyss.ANDPeerSession, topic string, payload []byte) {
+	w.o.stat.SAM_RX++
+
+	info, ok := w.peers[peer_session.Peer.IDHash()]
+	if !ok || info.PeerSessionID != peer_session.PeerSessionID {
+		w.o.stat.W(82)
+
+		w.o.stat.RST_TX++
+		peer_session.Peer.TrySendRST(w.lsid, peer_session.PeerSessionID, "SAM::sessionID mismatch")
+		return
+	}
+	switch info.state {
+	case WS_MEM:
+		w.o.stat.W(83)
+
+		w.ech <- abyss.NeighborEvent{
+			Type:           abyss.ANDMemberMessage,
+			LocalSessionID: w.lsid,
+			ANDPeerSession: peer_session,
+			Text:           topic,
+			Object:         payload,
+		}
+	default:
+		w.o.stat.W(84)
+	}
+}
 func (w *ANDWorld) RST(peer_session abyss.ANDPeerSession) {
 	w.o.stat.RST_RX++
 
 	info := w.peers[peer_session.Peer.IDHash()]
 	w.ClearStates(info.Peer.IDHash(), info, "RST received")
+	w.AdmitQueued()
 }
 
 func (w *ANDWorld) AcceptSession(peer_session abyss.ANDPeerSession) {
@@ -692,10 +820,16 @@ func (w *ANDWorld) AcceptSession(peer_session abyss.ANDPeerSession) {
 
 			return
 		}
+		if info.queued {
+			w.o.stat.W(108)
 
+			return
+		}
+
+		// in the partial mesh, the joiner learns the members from the roster.
 		member_infos := make([]abyss.ANDPeerSessionWithTimeStamp, 0)
 		for _, p := range w.peers {
-			if p.state != WS_MEM {
+			if p.state != WS_MEM || w.interest != nil {
 				w.o.stat.W(59)
 
 				continue
@@ -710,7 +844,7 @@ func (w *ANDWorld) AcceptSession(peer_session abyss.ANDPeerSession) {
 			p.Peer.TrySendJNI(w.lsid, p.PeerSessionID, info.ANDPeerSessionWithTimeStamp)
 		}
 		w.o.stat.JOK_TX++
-		info.Peer.TrySendJOK(w.lsid, info.PeerSessionID, w.timestamp, w.wurl, member_infos)
+		info.Peer.TrySendJOK(w.lsid, info.PeerSessionID, w.timestamp, w.wurl, member_infos, w.Settings())
 		info.state = WS_TMEM
 	case WS_RMEM_NJNI:
 		w.o.stat.W(61)
@@ -747,6 +881,7 @@ func (w *ANDWorld) AcceptSession(peer_session abyss.ANDPeerSession) {
 			ANDPeerSession: info.ANDPeerSession,
 		}
 		info.state = WS_MEM
+		info.joining = false
 	case WS_TMEM:
 		w.o.stat.W(68)
 
@@ -775,6 +910,18 @@ func (w *ANDWorld) DeclineSession(peer_session abyss.ANDPeerSession, code int, m
 
 }
 func (w *ANDWorld) TimerExpire() {
+	w.AdmitQueued()
+
+	if w.interest != nil {
+		w.interestRound()
+		w.ech <- abyss.NeighborEvent{
+			Type:           abyss.ANDTimerRequest,
+			LocalSessionID: w.lsid,
+			Value:          500 + rand.Intn(500),
+		}
+		return
+	}
+
 	sjn_mem := make([]abyss.ANDPeerSessionIdentity, 0)
 	for _, info := range w.peers {
 		if info.state != WS_MEM ||
@@ -819,8 +966,12 @@ func (w *ANDWorld) TimerExpire() {
 func (w *ANDWorld) RemovePeer(peer abyss.IANDPeer) {
 	w.ClearStates(peer.IDHash(), w.peers[peer.IDHash()], "")
 	delete(w.peers, peer.IDHash())
+	w.AdmitQueued()
 }
 func (w *ANDWorld) Close() {
+	if w.interest != nil {
+		w.interest.heartbeat++ //to be taken over the last round
+	}
 	for _, info := range w.peers {
 		switch info.state {
 		case WS_CC:
@@ -842,10 +993,16 @@ func (w *ANDWorld) Close() {
 
 			w.o.stat.RST_TX++
 			info.Peer.TrySendRST(w.lsid, info.PeerSessionID, "Close")
-
+			if info.joining {
+				w.dropJoin(info.ANDPeerSession)
+			}
 		case WS_MEM:
 			w.o.stat.W(80)
 
+			if w.interest != nil {
+				w.o.stat.ROS_TX++
+				info.Peer.TrySendROS(w.lsid, info.PeerSessionID, false, w.interest.localEntry(true), nil)
+			}
 			w.o.stat.RST_TX++
 			info.Peer.TrySendRST(w.lsid, info.PeerSessionID, "Close")
 
@@ -858,6 +1015,7 @@ func (w *ANDWorld) Close() {
 	}
 	w.o.stat.W(81)
 
+	w.queue = nil //the joiners are reset. AdmitQueued may still run, as in RemovePeer after FailJoin.
 	w.ech <- abyss.NeighborEvent{
 		Type:           abyss.ANDWorldLeave,
 		LocalSessionID: w.lsid,
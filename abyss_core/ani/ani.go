@@ -58,6 +58,10 @@ type IAbyssNode interface {
 	AppendKnownPeer(root_cert string, handshake_key_cert string) error
 	AppendKnownPeerDer(root_cert []byte, handshake_key_cert []byte) error
 
+	// IntroducePeerDer adds peer information relayed by another peer.
+	// Unlike AppendKnownPeer, the node may hold it until the application confirms.
+	IntroducePeerDer(introducer_id string, root_cert []byte, handshake_key_cert []byte) error
+
 	// EraseKnownPeer removes peer information.
 	// The peer cannot be dialed until the peer information is re-provided.
 	EraseKnownPeer(id string)
@@ -66,6 +70,11 @@ type IAbyssNode interface {
 	// When connected, the connection can be retrieved from Accept().
 	Dial(hash string, addr netip.AddrPort) error
 
+	// DialRelayed dials a peer through a connected peer (relay), which must
+	// consent to relaying. The session is end-to-end encrypted.
+	// When connected, the connection can be retrieved from Accept().
+	DialRelayed(relay_hash string, hash string) error
+
 	// Accept returns a newly established peer.
 	Accept(ctx context.Context) (IAbyssPeer, error)
 
@@ -99,13 +108,27 @@ type IAbyssPeer interface {
 	IAbyssPeerIdentity
 
 	// RemoteAddr is the actual connection endpoint, among RemoteAddrCandidates.
+	// For a relayed peer, it is the address of the relay.
 	RemoteAddr() netip.AddrPort
 
+	// ProtocolVersion and HasCapability tell what the peer announced in the handshake
+	// (see ahmp.Hello). Check them before sending messages of an optional capability.
+	ProtocolVersion() int
+	HasCapability(capability uint64) bool
+
 	// Send and Recv exchange ahmp messages. Encoding details are defined in ahmp package.
 	// Warning: Nither of them are thread safe, but they are mutually thread-safe (isolated).
 	Send(any) error
 	Recv(any) error
 
+	// SendDatagram and ReceiveDatagram exchange unreliable QUIC datagrams,
+	// which may be lost, duplicated or reordered. A datagram must fit in a
+	// single packet; see ahmp.DatagramRegistry for the message format.
+	// They are not available for relayed peers.
+	// They are thread-safe.
+	SendDatagram([]byte) error
+	ReceiveDatagram(ctx context.Context) ([]byte, error)
+
 	// Context returns a context that is cancelled when the connection dies.
 	// By calling Err(), you can retrieve the reason why the connection is closed.
 	// Context() context.Context
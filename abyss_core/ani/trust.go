@@ -0,0 +1,83 @@
+package ani
+
+import "errors"
+
+// The trust model is shared by the network services (ann.AbyssNode, net_service.BetaNetService),
+// so that applications handle identity changes of peers the same way on either.
+
+// IdentitySource tells how a known peer identity was obtained.
+type IdentitySource int
+
+const (
+	// IdentityDirect is given by the application (AppendKnownPeer),
+	// or announced by the peer itself over an authenticated connection.
+	IdentityDirect IdentitySource = iota
+	// IdentityIntroduced is relayed by another peer, e.g. a world member in JOK/JNI.
+	IdentityIntroduced
+	// IdentityStored is loaded from an identity store; it was trusted in a previous run.
+	IdentityStored
+)
+
+// IdentityOrigin is where a known peer identity came from.
+type IdentityOrigin struct {
+	Source     IdentitySource
+	Introducer string // peer id, only for IdentityIntroduced
+}
+
+var (
+	ErrIdentityHeld   = errors.New("peer identity held for confirmation")
+	ErrIdentityPinned = errors.New("peer identity does not match the pinned root certificate")
+	ErrNoHeldIdentity = errors.New("no peer identity held for confirmation")
+)
+
+// TrustPolicy decides which peer identities are trusted without confirmation.
+// Trust is on first use by default: the first identity of an id is accepted,
+// and a known id that presents a different root certificate through
+// an introduction is held until the application confirms it (ConfirmPeer).
+// A newer handshake key under the known root certificate is accepted, as it is
+// signed by the root key; this happens on every key rotation.
+// A held identity is not dialable or acceptable.
+//
+// A pinned peer (PinPeer) never changes its root certificate.
+// Pins are kept in memory only.
+type TrustPolicy struct {
+	// ConfirmIntroduced holds introduced identities of unknown peers.
+	ConfirmIntroduced bool
+
+	// ConfirmDirect holds identities of unknown peers given by AppendKnownPeer.
+	ConfirmDirect bool
+
+	// OnEvent, if not nil, is called for every TrustEvent, without locks held.
+	// It is called from handshake, control stream and event loop workers, so it must not block long.
+	OnEvent func(TrustEvent)
+}
+
+type TrustEventType int
+
+const (
+	// TrustNewPeer is the first identity of an id.
+	TrustNewPeer TrustEventType = iota + 1
+	// TrustRootCertificateChanged is a known id presenting a different root certificate.
+	TrustRootCertificateChanged
+	// TrustHandshakeKeyChanged is a known id presenting a different handshake key,
+	// under the same root certificate. This happens on every key rotation.
+	TrustHandshakeKeyChanged
+)
+
+type TrustOutcome int
+
+const (
+	TrustAccepted TrustOutcome = iota + 1
+	TrustHeld                  // waiting for ConfirmPeer or RejectPeer
+	TrustRejected              // conflicts with the pinned root certificate
+)
+
+// TrustEvent reports an identity update of a peer, and what the service did with it.
+type TrustEvent struct {
+	Type    TrustEventType
+	Outcome TrustOutcome
+	ID      string
+	Origin  IdentityOrigin
+	Old     IAbyssPeerIdentity // nil for TrustNewPeer
+	New     IAbyssPeerIdentity
+}
@@ -0,0 +1,203 @@
+package ann
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	abystCacheMaxEntries   = 1024
+	abystCacheMaxEntrySize = 8 << 20
+)
+
+// abystCacheEntry is a cached GET response.
+// Entries without freshness information are kept only if they can be revalidated.
+type abystCacheEntry struct {
+	status_code int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+	no_cache    bool // always revalidate
+}
+
+func (e *abystCacheEntry) isFresh() bool {
+	return !e.no_cache && time.Now().Before(e.expires)
+}
+
+func (e *abystCacheEntry) addValidators(request *http.Request) {
+	if etag := e.header.Get("ETag"); etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	if last_modified := e.header.Get("Last-Modified"); last_modified != "" {
+		request.Header.Set("If-Modified-Since", last_modified)
+	}
+}
+
+func (e *abystCacheEntry) canRevalidate() bool {
+	return e.header.Get("ETag") != "" || e.header.Get("Last-Modified") != ""
+}
+
+func (e *abystCacheEntry) response(target *url.URL) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(e.status_code) + " " + http.StatusText(e.status_code),
+		StatusCode:    e.status_code,
+		Proto:         "HTTP/3.0",
+		ProtoMajor:    3,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       &http.Request{Method: http.MethodGet, URL: target},
+	}
+}
+
+// abystCache is a private (single user) HTTP cache, keyed by peer id + request URI.
+type abystCache struct {
+	mtx     sync.Mutex
+	entries map[string]*abystCacheEntry
+}
+
+func newAbystCache() *abystCache {
+	return &abystCache{
+		entries: make(map[string]*abystCacheEntry),
+	}
+}
+
+func (c *abystCache) get(key string) *abystCacheEntry {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.entries[key]
+}
+
+// store caches the response if allowed by its headers.
+// The returned response must be used instead of the original one, as its body may be consumed.
+func (c *abystCache) store(key string, target *url.URL, response *http.Response) (*http.Response, error) {
+	if response.StatusCode != http.StatusOK {
+		c.remove(key)
+		return response, nil
+	}
+	entry, ok := newAbystCacheEntry(response)
+	if !ok || response.ContentLength > abystCacheMaxEntrySize {
+		c.remove(key)
+		return response, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, abystCacheMaxEntrySize+1))
+	if err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	if len(body) > abystCacheMaxEntrySize {
+		// too large; hand over the remaining stream without caching.
+		c.remove(key)
+		response.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), response.Body), response.Body}
+		return response, nil
+	}
+	response.Body.Close()
+	entry.body = body
+
+	c.mtx.Lock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= abystCacheMaxEntries {
+		for k := range c.entries { // evict arbitrary entry
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = entry
+	c.mtx.Unlock()
+
+	return entry.response(target), nil
+}
+
+// refresh replaces the entry with updated headers and freshness after 304 Not Modified.
+// Entries are immutable once stored, as their responses are read without lock.
+func (c *abystCache) refresh(key string, entry *abystCacheEntry, header http.Header) *abystCacheEntry {
+	refreshed := &abystCacheEntry{
+		status_code: entry.status_code,
+		header:      entry.header.Clone(),
+		body:        entry.body,
+	}
+	for k, v := range header {
+		refreshed.header[k] = v
+	}
+	refreshed.expires, refreshed.no_cache = cacheFreshness(refreshed.header)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.entries[key] = refreshed
+	return refreshed
+}
+
+func (c *abystCache) remove(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.entries, key)
+}
+
+// newAbystCacheEntry returns false if the response must not be stored.
+func newAbystCacheEntry(response *http.Response) (*abystCacheEntry, bool) {
+	directives := parseCacheControl(response.Header)
+	if _, ok := directives["no-store"]; ok {
+		return nil, false
+	}
+	if response.Header.Get("Vary") == "*" {
+		return nil, false
+	}
+	entry := &abystCacheEntry{
+		status_code: response.StatusCode,
+		header:      response.Header.Clone(),
+	}
+	entry.expires, entry.no_cache = cacheFreshness(response.Header)
+	if !entry.isFresh() && !entry.canRevalidate() {
+		return nil, false
+	}
+	return entry, true
+}
+
+// cacheFreshness computes expiration time from Cache-Control max-age or Expires.
+func cacheFreshness(header http.Header) (time.Time, bool) {
+	now := time.Now()
+	directives := parseCacheControl(header)
+	_, no_cache := directives["no-cache"]
+	if max_age, ok := directives["max-age"]; ok {
+		seconds, err := strconv.Atoi(max_age)
+		if err != nil || seconds < 0 {
+			return now, no_cache
+		}
+		return now.Add(time.Duration(seconds) * time.Second), no_cache
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		expires_time, err := http.ParseTime(expires)
+		if err != nil {
+			return now, no_cache
+		}
+		return expires_time, no_cache
+	}
+	return now, no_cache
+}
+
+func parseCacheControl(header http.Header) map[string]string {
+	result := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(line, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(directive, "=")
+			result[strings.ToLower(name)] = strings.Trim(value, "\"")
+		}
+	}
+	return result
+}
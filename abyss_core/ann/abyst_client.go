@@ -0,0 +1,322 @@
+package ann
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+const abystMaxRedirects = 10
+
+var (
+	ErrAbystPeerNotConnected = errors.New("abyst: peer not connected")
+	ErrAbystTooManyRedirects = errors.New("abyst: too many redirects")
+	ErrAbystRelayedPeer      = errors.New("abyst: not available through relay")
+)
+
+// abystConn is a TLS client auth QUIC connection to a connected peer.
+// ready is closed when dialing ends; err is set if the dial failed.
+type abystConn struct {
+	ready       chan struct{}
+	err         error
+	connection  quic.Connection
+	client_conn *http3.ClientConn
+}
+
+// AbystClient implements ani.IAbystClient.
+// It only processes abyst: URLs, and only reaches peers that are
+// currently connected with AbyssNode (abyss connection).
+// Redirects may cross peers. Each peer has its own cookie jar.
+type AbystClient struct {
+	origin       *AbyssNode
+	h3_transport *http3.Transport // only for NewClientConn
+
+	mtx   sync.Mutex
+	conns map[string]*abystConn
+	jars  map[string]http.CookieJar
+
+	cache *abystCache
+}
+
+func (n *AbyssNode) newAbystClient() *AbystClient {
+	return &AbystClient{
+		origin:       n,
+		h3_transport: &http3.Transport{},
+		conns:        make(map[string]*abystConn),
+		jars:         make(map[string]http.CookieJar),
+		cache:        newAbystCache(),
+	}
+}
+
+func (c *AbystClient) Get(url string) (*http.Response, error) {
+	return c.do(http.MethodGet, url, "", nil)
+}
+
+func (c *AbystClient) Head(url string) (*http.Response, error) {
+	return c.do(http.MethodHead, url, "", nil)
+}
+
+func (c *AbystClient) Post(url, contentType string, body io.Reader) (*http.Response, error) {
+	// body is buffered, to be replayed on 307/308 redirects.
+	var body_bytes []byte
+	if body != nil {
+		var err error
+		body_bytes, err = io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.do(http.MethodPost, url, contentType, body_bytes)
+}
+
+func (c *AbystClient) do(method string, raw_url string, content_type string, body []byte) (*http.Response, error) {
+	peer_id, target, err := parseAbystURL(raw_url)
+	if err != nil {
+		return nil, err
+	}
+
+	for range abystMaxRedirects + 1 {
+		response, err := c.doSingle(method, peer_id, target, content_type, body)
+		if err != nil {
+			return nil, err
+		}
+
+		switch response.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+			if method != http.MethodHead {
+				method = http.MethodGet
+			}
+			body = nil
+			content_type = ""
+		case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return response, nil
+		}
+
+		location := response.Header.Get("Location")
+		if location == "" {
+			return response, nil
+		}
+		response.Body.Close()
+
+		peer_id, target, err = resolveAbystRedirect(peer_id, target, location)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrAbystTooManyRedirects
+}
+
+// doSingle performs one request without following redirects.
+// GET requests go through the cache.
+func (c *AbystClient) doSingle(method string, peer_id string, target *url.URL, content_type string, body []byte) (*http.Response, error) {
+	cache_key := peer_id + target.RequestURI()
+	var cached *abystCacheEntry
+	if method == http.MethodGet {
+		cached = c.cache.get(cache_key)
+		if cached != nil && cached.isFresh() {
+			return cached.response(target), nil
+		}
+	}
+
+	client_conn, err := c.getClientConn(peer_id)
+	if err != nil {
+		return nil, err
+	}
+
+	var body_reader io.Reader
+	if body != nil {
+		body_reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(c.origin.service_ctx, method, target.String(), body_reader)
+	if err != nil {
+		return nil, err
+	}
+	if content_type != "" {
+		request.Header.Set("Content-Type", content_type)
+	}
+	jar := c.getCookieJar(peer_id)
+	for _, cookie := range jar.Cookies(target) {
+		request.AddCookie(cookie)
+	}
+	if cached != nil {
+		cached.addValidators(request)
+	}
+
+	response, err := client_conn.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	if cookies := response.Cookies(); len(cookies) > 0 {
+		jar.SetCookies(target, cookies)
+	}
+
+	if method != http.MethodGet {
+		return response, nil
+	}
+	if cached != nil && response.StatusCode == http.StatusNotModified {
+		response.Body.Close()
+		return c.cache.refresh(cache_key, cached, response.Header).response(target), nil
+	}
+	return c.cache.store(cache_key, target, response)
+}
+
+func (c *AbystClient) getCookieJar(peer_id string) http.CookieJar {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	jar, ok := c.jars[peer_id]
+	if !ok {
+		jar, _ = cookiejar.New(nil) // never fails with nil options.
+		c.jars[peer_id] = jar
+	}
+	return jar
+}
+
+// getClientConn reuses or establishes the abyst connection to a connected peer.
+// Concurrent calls for the same peer share a single dial.
+func (c *AbystClient) getClientConn(peer_id string) (*http3.ClientConn, error) {
+	peer, ok := c.origin.registry.GetConnectedPeer(peer_id)
+	if !ok {
+		c.dropConn(peer_id, nil)
+		return nil, ErrAbystPeerNotConnected
+	}
+	if peer.relay != nil {
+		return nil, ErrAbystRelayedPeer
+	}
+
+	c.mtx.Lock()
+	conn, ok := c.conns[peer_id]
+	if ok {
+		c.mtx.Unlock()
+		<-conn.ready
+		if conn.err == nil && conn.connection.Context().Err() == nil {
+			return conn.client_conn, nil
+		}
+		c.dropConn(peer_id, conn)
+		c.mtx.Lock()
+		if _, ok := c.conns[peer_id]; ok { // someone else is dialing.
+			c.mtx.Unlock()
+			return c.getClientConn(peer_id)
+		}
+	}
+	conn = &abystConn{ready: make(chan struct{})}
+	c.conns[peer_id] = conn
+	c.mtx.Unlock()
+
+	conn.connection, conn.err = c.dial(peer)
+	if conn.err == nil {
+		conn.client_conn = c.h3_transport.NewClientConn(conn.connection)
+	}
+	close(conn.ready)
+	if conn.err != nil {
+		c.dropConn(peer_id, conn)
+		return nil, conn.err
+	}
+	return conn.client_conn, nil
+}
+
+func (c *AbystClient) dial(peer *AbyssPeer) (quic.Connection, error) {
+	n := c.origin
+	dial_ctx, dial_ctx_cancel := context.WithTimeout(n.service_ctx, n.config.HandshakeTimeout)
+	defer dial_ctx_cancel()
+
+	connection, err := n.transport.Dial(
+		dial_ctx,
+		net.UDPAddrFromAddrPort(peer.remote_addr),
+		n.NewAbystClientTlsConf(n.registry),
+		n.newQuicConfig(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// the server must be the very peer we asked for, not just any connected peer.
+	server_id, ok := n.registry.GetPeerIdFromTlsCertificate(connection.ConnectionState().TLS.PeerCertificates[0])
+	if !ok || server_id != peer.ID() {
+		connection.CloseWithError(AbyssQuicAuthenticationFail, "peer mismatch")
+		return nil, errors.New("abyst: peer mismatch")
+	}
+	return connection, nil
+}
+
+// dropConn removes the connection entry. If expected is not nil,
+// it is removed only when it is still the current entry.
+func (c *AbystClient) dropConn(peer_id string, expected *abystConn) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	conn, ok := c.conns[peer_id]
+	if !ok || (expected != nil && conn != expected) {
+		return
+	}
+	delete(c.conns, peer_id)
+	select {
+	case <-conn.ready:
+		if conn.connection != nil {
+			conn.connection.CloseWithError(0, "")
+		}
+	default: // still dialing; the dialer owns it.
+	}
+}
+
+// parseAbystURL converts abyst:<peer-id>/path into peer id and an https URL for http3.
+func parseAbystURL(raw_url string) (string, *url.URL, error) {
+	abyst_url, err := aurl.TryParse(raw_url)
+	if err != nil {
+		return "", nil, err
+	}
+	if abyst_url.Scheme != "abyst" {
+		return "", nil, errors.New("abyst: unsupported scheme " + abyst_url.Scheme)
+	}
+	if abyst_url.Hash == "" {
+		return "", nil, errors.New("abyst: empty peer id")
+	}
+	target, err := url.Parse("https://" + abyst_url.Hash + "/" + abyst_url.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	return abyst_url.Hash, target, nil
+}
+
+// resolveAbystRedirect handles both abyst: locations (possibly another peer)
+// and relative locations on the current peer.
+func resolveAbystRedirect(peer_id string, current *url.URL, location string) (string, *url.URL, error) {
+	if strings.HasPrefix(location, "abyst:") {
+		return parseAbystURL(location)
+	}
+	next, err := current.Parse(location)
+	if err != nil {
+		return "", nil, err
+	}
+	if next.Scheme != "https" || next.Host != peer_id {
+		return "", nil, errors.New("abyst: redirect out of abyst scheme: " + location)
+	}
+	return peer_id, next, nil
+}
+
+// Close closes all abyst connections of the client.
+func (c *AbystClient) Close() {
+	c.mtx.Lock()
+	conns := c.conns
+	c.conns = make(map[string]*abystConn)
+	c.mtx.Unlock()
+
+	for _, conn := range conns {
+		<-conn.ready
+		if conn.connection != nil {
+			conn.connection.CloseWithError(0, "")
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package ann_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+)
+
+func TestAbystClient(t *testing.T) {
+	var cached_hits, etag_hits, not_modified atomic.Int32
+	backend := http.NewServeMux()
+	backend.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.Header.Get("X-Abyss-ID"))
+	})
+	backend.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/static/hello", http.StatusFound)
+	})
+	backend.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/static/loop", http.StatusFound)
+	})
+	backend.HandleFunc("/echo-post", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/static/echo", http.StatusTemporaryRedirect)
+	})
+	backend.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, r.Method+" "+string(body))
+	})
+	backend.HandleFunc("/cookie/set", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+	})
+	backend.HandleFunc("/cookie/get", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			io.WriteString(w, "none")
+			return
+		}
+		io.WriteString(w, cookie.Value)
+	})
+	backend.HandleFunc("/cached", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, strconv.Itoa(int(cached_hits.Add(1))))
+	})
+	backend.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		etag_hits.Add(1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			not_modified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "etag body")
+	})
+	backend_server := httptest.NewServer(backend)
+	defer backend_server.Close()
+
+	root_key_A, _ := sec.NewRootPrivateKey()
+	node_A, _ := ann.NewAbyssNode(root_key_A)
+	node_A.Listen()
+	go node_A.Serve()
+	defer node_A.Close()
+
+	root_key_B, _ := sec.NewRootPrivateKey()
+	node_B, _ := ann.NewAbyssNode(root_key_B)
+	node_B.Listen()
+	go node_B.Serve()
+	defer node_B.Close()
+
+	if err := node_B.ConfigAbystGateway(`{"static": "` + backend_server.URL + `"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	client, _ := node_A.NewAbystClient()
+	base := "abyst:" + node_B.ID() + "/static"
+
+	// not connected yet.
+	if _, err := client.Get(base + "/hello"); !errors.Is(err, ann.ErrAbystPeerNotConnected) {
+		t.Fatal("should fail for unconnected peer: ", err)
+	}
+
+	node_A.AppendKnownPeer(node_B.RootCertificate(), node_B.HandshakeKeyCertificate())
+	node_B.AppendKnownPeer(node_A.RootCertificate(), node_A.HandshakeKeyCertificate())
+	node_A.Dial(node_B.ID(), node_B.LocalAddrCandidates()[0])
+
+	ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer ctxcancel()
+	for {
+		_, err := node_A.Accept(ctx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				t.Fatal("accept timeout")
+			}
+			continue
+		}
+		break
+	}
+	// node_B must register node_A before serving abyst.
+	for {
+		_, err := node_B.Accept(ctx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				t.Fatal("accept timeout")
+			}
+			continue
+		}
+		break
+	}
+
+	readBody := func(response *http.Response, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	if body := readBody(client.Get(base + "/hello")); body != "hello "+node_A.ID() {
+		t.Fatal("unexpected body: ", body)
+	}
+	if body := readBody(client.Get(base + "/redirect")); body != "hello "+node_A.ID() {
+		t.Fatal("redirect not followed: ", body)
+	}
+	if body := readBody(client.Post(base+"/echo-post", "text/plain", strings.NewReader("payload"))); body != "POST payload" {
+		t.Fatal("307 should preserve method and body: ", body)
+	}
+	if _, err := client.Get(base + "/loop"); !errors.Is(err, ann.ErrAbystTooManyRedirects) {
+		t.Fatal("redirect loop should fail: ", err)
+	}
+
+	// cookies
+	if body := readBody(client.Get(base + "/cookie/get")); body != "none" {
+		t.Fatal("unexpected cookie: ", body)
+	}
+	readBody(client.Get(base + "/cookie/set"))
+	if body := readBody(client.Get(base + "/cookie/get")); body != "abc" {
+		t.Fatal("cookie not kept: ", body)
+	}
+	other_client, _ := node_A.NewAbystClient()
+	if body := readBody(other_client.Get(base + "/cookie/get")); body != "none" {
+		t.Fatal("cookie jar should not be shared across clients: ", body)
+	}
+
+	// cache
+	first := readBody(client.Get(base + "/cached"))
+	second := readBody(client.Get(base + "/cached"))
+	if first != second || cached_hits.Load() != 1 {
+		t.Fatal("max-age response should be cached")
+	}
+	readBody(client.Get(base + "/etag"))
+	if body := readBody(client.Get(base + "/etag")); body != "etag body" {
+		t.Fatal("revalidated body mismatch: ", body)
+	}
+	if etag_hits.Load() != 2 || not_modified.Load() != 1 {
+		t.Fatal("no-cache response should be revalidated with ETag")
+	}
+}
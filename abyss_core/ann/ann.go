@@ -1,22 +1,28 @@
 // Package ann (abyss net node) provides QUIC node that can establish
 // abyss P2P connections and TLS client auth HTTPS connections.
 // This implements ani (abyss new interface) for alpha release.
-// TODO: AbyssNodeConfig for construction (backlog, firewall, logger, etc)
+// AbyssNode is configured with AbyssNodeConfig on construction.
 // Handshake failures result in errors returned from Accept().
 package ann
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net"
 	"net/http"
 	"net/netip"
-	"time"
+	"slices"
+	"sync"
+	"sync/atomic"
 
 	"github.com/kadmila/Abyss-Browser/abyss_core/abyst"
 	"github.com/kadmila/Abyss-Browser/abyss_core/ani"
+	"github.com/kadmila/Abyss-Browser/abyss_core/fw"
 	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+	"github.com/phuslu/log"
 	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 )
 
 type backLogEntry struct {
@@ -25,33 +31,64 @@ type backLogEntry struct {
 }
 
 // AbyssNode handles abyss/abyst handshakes, listening inbound connections.
-// TODO: Close() should wait for ongoing handshake goroutines to terminate.
-// This requires the goroutines to 1) check before executing, 2) check when terminate.
-// TODO: abyss handshake does not timeout. Make it timeout, let the timeout duration adjustable.
+// Every connection handling goroutine (worker) is started with tryStartWorker,
+// so that Close() can wait for all of them to terminate.
 // Issue: a node's identity is unvailed by dialing and checking if it decrypts the handshake.
 // Do we assume that a peer with handshake encryption key cert already locates the peer? or not?
 type AbyssNode struct {
 	*sec.AbyssRootSecret
 	*sec.TLSIdentity
 
-	udpConn               *net.UDPConn
-	testConn              *DelayConn // debug
-	transport             *quic.Transport
-	listener              *quic.Listener
+	config AbyssNodeConfig
+	logger *log.Logger
+
+	udpConn   *net.UDPConn
+	testConn  net.PacketConn // debug; DelayConn and/or NatConn over udpConn
+	transport *quic.Transport
+	listener  *quic.Listener
+
+	// local_addr_candidates is replaced, never modified in place.
+	// It ends with reflexive_count reflexive addresses, confirmed from observed_addrs.
+	addr_mtx              sync.Mutex
 	local_addr_candidates []netip.AddrPort
+	reflexive_count       int
+	observed_addrs        map[string]netip.AddrPort // observer id -> reported address
 
 	service_ctx        context.Context
 	service_cancelfunc context.CancelFunc
 
+	// worker_mtx protects is_closing and is_serving, and orders them with worker_wg.Add.
+	worker_mtx sync.Mutex
+	worker_wg  sync.WaitGroup
+	is_closing bool
+	is_serving bool
+	serve_done chan struct{}
+	close_once sync.Once
+
 	registry *AbyssPeerRegistry
+	firewall *fw.Firewall
+
+	relay_policy   atomic.Pointer[RelayPolicy]
+	relay_sessions atomic.Int32
+	relay_limiter  *tokenBucket
+
+	rendezvous_policy   atomic.Pointer[RendezvousPolicy]
+	rendezvous_sessions atomic.Int32
 
 	backlog chan backLogEntry
 
 	abyst_hub *abyst.AbystGateway
 }
 
+// NewAbyssNode creates AbyssNode with DefaultAbyssNodeConfig.
 func NewAbyssNode(root_private_key sec.PrivateKey) (*AbyssNode, error) {
-	root_secret, err := sec.NewAbyssRootSecrets(root_private_key)
+	return NewAbyssNodeWithConfig(root_private_key, DefaultAbyssNodeConfig())
+}
+
+func NewAbyssNodeWithConfig(root_private_key sec.PrivateKey, config AbyssNodeConfig) (*AbyssNode, error) {
+	config = config.withDefaults()
+
+	root_secret, err := sec.NewAbyssRootSecretsWithKeyType(root_private_key, config.HandshakeKeyType)
 	if err != nil {
 		return nil, err
 	}
@@ -63,50 +100,86 @@ func NewAbyssNode(root_private_key sec.PrivateKey) (*AbyssNode, error) {
 
 	service_ctx, service_cancelfunc := context.WithCancel(context.Background())
 
-	return &AbyssNode{
+	result := &AbyssNode{
 		AbyssRootSecret: root_secret,
 		TLSIdentity:     tls_identity,
 
+		config: config,
+		logger: config.Logger,
+
 		udpConn:               nil,
 		testConn:              nil,
 		transport:             nil,
 		listener:              nil,
 		local_addr_candidates: make([]netip.AddrPort, 0),
+		observed_addrs:        make(map[string]netip.AddrPort),
 
 		service_ctx:        service_ctx,
 		service_cancelfunc: service_cancelfunc,
 
-		registry: NewAbyssPeerRegistry(),
+		serve_done: make(chan struct{}),
+
+		registry: NewAbyssPeerRegistryWithStore(config.IdentityStore, config.IdentityMaxAge),
+		firewall: config.Firewall,
 
-		backlog: make(chan backLogEntry, 128),
+		backlog: make(chan backLogEntry, config.BacklogSize),
+
+		relay_limiter: newTokenBucket(config.Relay.BytesPerSecond),
 
 		abyst_hub: abyst.NewAbystGateway(),
-	}, nil
+	}
+	result.relay_policy.Store(&config.Relay)
+	result.rendezvous_policy.Store(&config.Rendezvous)
+	result.registry.SetTrustPolicy(config.Trust)
+
+	// broken entries are skipped; the store is a cache of AppendKnownPeer calls.
+	if err := result.registry.LoadStore(); err != nil {
+		result.logger.Warn().Err(err).Msg("failed to load some known peers")
+	}
+	return result, nil
 }
 
-func newQuicConfig() *quic.Config {
+func (n *AbyssNode) newQuicConfig() *quic.Config {
 	return &quic.Config{
-		MaxIdleTimeout:  time.Second * 20,
-		KeepAlivePeriod: time.Second * 5,
+		MaxIdleTimeout:  n.config.QuicIdleTimeout,
+		KeepAlivePeriod: n.config.QuicKeepAlivePeriod,
 		EnableDatagrams: true,
 	}
 }
 
 func (n *AbyssNode) Listen() error {
 	var err error
-	n.udpConn, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	var bind_ip net.IP
+	if n.config.BindAddr.Addr().IsValid() {
+		bind_ip = n.config.BindAddr.Addr().AsSlice()
+	}
+	n.udpConn, err = net.ListenUDP(n.config.IPMode.network(), &net.UDPAddr{IP: bind_ip, Port: int(n.config.BindAddr.Port())})
 	if err != nil {
 		return err
 	}
 
-	// debug tool
-	n.testConn = NewDelayConn(n.udpConn, time.Millisecond*10, time.Millisecond*20)
-	n.transport = &quic.Transport{Conn: n.testConn}
-	// or
-	// n.transport = &quic.Transport{Conn: n.udpConn}
-	// normal
+	// debug tools
+	if n.config.SimulatedNAT != nil {
+		nat_conn, err := NewNatConn(n.udpConn, n.config.SimulatedNAT.MappingTimeout)
+		if err != nil {
+			return err
+		}
+		n.testConn = nat_conn
+	}
+	if n.config.SimulatedDelay != nil {
+		lower_conn := n.testConn
+		if lower_conn == nil {
+			lower_conn = n.udpConn
+		}
+		n.testConn = NewDelayConn(lower_conn, n.config.SimulatedDelay.Delay, n.config.SimulatedDelay.Jitter)
+	}
+	if n.testConn != nil {
+		n.transport = &quic.Transport{Conn: n.testConn}
+	} else {
+		n.transport = &quic.Transport{Conn: n.udpConn}
+	}
 
-	n.listener, err = n.transport.Listen(n.NewServerTlsConf(n.registry), newQuicConfig())
+	n.listener, err = n.transport.Listen(n.NewServerTlsConf(n.registry), n.newQuicConfig())
 	if err != nil {
 		return err
 	}
@@ -116,6 +189,19 @@ func (n *AbyssNode) Listen() error {
 		return errors.New("failed to get listener bind address")
 	}
 	port := uint16(bind_addr.Port)
+	n.logger.Info().Str("local_addr", bind_addr.String()).Str("id", n.ID()).Msg("abyss node listening")
+
+	// explicit bind address is the only candidate.
+	ip_mode := n.config.IPMode
+	if bind := n.config.BindAddr.Addr(); bind.IsValid() {
+		if !bind.IsUnspecified() {
+			n.appendLocalAddrCandidate(netip.AddrPortFrom(bind.Unmap(), port))
+			return nil
+		}
+		if bind.Is4() {
+			ip_mode = IPModeIPv4 // 0.0.0.0 is not dual-stack.
+		}
+	}
 
 	// query all network interfaces to fill local_addr_candidates
 	ifaces, err := net.Interfaces()
@@ -139,18 +225,11 @@ func (n *AbyssNode) Listen() error {
 				ip = v.IP
 			}
 
-			if ip == nil || ip.To4() == nil {
-				continue
-			}
-
-			netip_ip, ok := netip.AddrFromSlice(ip.To4())
+			netip_ip, ok := candidateAddr(ip, ip_mode)
 			if !ok {
 				continue
 			}
-			n.local_addr_candidates = append(
-				n.local_addr_candidates,
-				netip.AddrPortFrom(netip_ip, port),
-			)
+			n.appendLocalAddrCandidate(netip.AddrPortFrom(netip_ip, port))
 		}
 	}
 	return nil
@@ -159,6 +238,18 @@ func (n *AbyssNode) Listen() error {
 // Serve is the main server loop of AbyssNode.
 // It waits for incoming connections on quic.Listener in a loop.
 func (n *AbyssNode) Serve() error {
+	n.worker_mtx.Lock()
+	if n.is_closing || n.is_serving || n.listener == nil {
+		n.worker_mtx.Unlock()
+		return net.ErrClosed
+	}
+	n.is_serving = true
+	n.worker_mtx.Unlock()
+	defer close(n.serve_done)
+
+	n.tryStartWorker(n.maintenanceRoutine)
+	n.tryStartWorker(n.firewallRoutine)
+
 	var err error
 	for {
 		var connection quic.Connection
@@ -166,8 +257,7 @@ func (n *AbyssNode) Serve() error {
 		if err != nil {
 			var remote_addr netip.AddrPort
 			if connection != nil {
-				a := connection.RemoteAddr().(*net.UDPAddr)
-				remote_addr = netip.AddrPortFrom(netip.AddrFrom4([4]byte(a.IP.To4())), uint16(a.Port))
+				remote_addr = remoteAddrPort(connection)
 			}
 			switch v := err.(type) {
 			case net.Error:
@@ -182,25 +272,150 @@ func (n *AbyssNode) Serve() error {
 			break
 		}
 
+		// address-based firewall check, before any handshake work.
+		remote_addr := remoteAddrPort(connection)
+		if err := n.firewall.CheckAddress(fw.Inbound, remote_addr.Addr()); err != nil {
+			connection.CloseWithError(AbyssQuicFirewallReject, "rejected")
+			n.backlogAppendError(remote_addr, false, err)
+			continue
+		}
+
+		var started bool
 		switch connection.ConnectionState().TLS.NegotiatedProtocol {
 		case sec.NextProtoAbyss:
-			go n.serveRoutine(connection)
+			started = n.tryStartWorker(func() { n.serveRoutine(connection) })
+		case http3.NextProtoH3:
+			started = n.tryStartWorker(func() { n.serveAbystRoutine(connection) })
 		default:
 			connection.CloseWithError(0, "unsupported application layer protocol")
+			continue
+		}
+		if !started {
+			connection.CloseWithError(AbyssQuicClose, "closing")
 		}
 	}
-	return n.cleanUp(err)
+	return err
 }
 
-func (n *AbyssNode) cleanUp(serve_err error) error {
-	// TODO: wait for worker goroutine to terminate.
-	l_err := n.listener.Close()
-	t_err := n.transport.Close()
-	u_err := n.udpConn.Close()
-	return errors.Join(serve_err, l_err, t_err, u_err)
+// tryStartWorker starts a goroutine that Close() waits for.
+// It returns false if the node is closing.
+func (n *AbyssNode) tryStartWorker(worker func()) bool {
+	n.worker_mtx.Lock()
+	defer n.worker_mtx.Unlock()
+
+	if n.is_closing {
+		return false
+	}
+	n.worker_wg.Add(1)
+	go func() {
+		defer n.worker_wg.Done()
+		worker()
+	}()
+	return true
+}
+
+// candidateAddr filters interface addresses by IP mode.
+// IPv6 link-local addresses are skipped, as they require a zone.
+func candidateAddr(ip net.IP, mode IPMode) (netip.Addr, bool) {
+	if ip == nil {
+		return netip.Addr{}, false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		if mode == IPModeIPv6 {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFromSlice(ip4)
+	}
+	if mode == IPModeIPv4 || ip.IsLinkLocalUnicast() {
+		return netip.Addr{}, false
+	}
+	return netip.AddrFromSlice(ip.To16())
+}
+
+func remoteAddrPort(connection quic.Connection) netip.AddrPort {
+	a, ok := connection.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return netip.AddrPort{}
+	}
+	addr_port := a.AddrPort()
+	return netip.AddrPortFrom(addr_port.Addr().Unmap(), addr_port.Port())
+}
+
+// LocalAddrCandidates returns interface addresses, followed by reflexive addresses
+// that reflexiveConfirmations connected peers reported (see QueryObservedAddr).
+// The returned slice must not be modified.
+func (n *AbyssNode) LocalAddrCandidates() []netip.AddrPort {
+	n.addr_mtx.Lock()
+	defer n.addr_mtx.Unlock()
+
+	return n.local_addr_candidates
 }
 
-func (n *AbyssNode) LocalAddrCandidates() []netip.AddrPort { return n.local_addr_candidates }
+// appendLocalAddrCandidate appends an interface address.
+// It returns false if the address is already a candidate.
+func (n *AbyssNode) appendLocalAddrCandidate(addr netip.AddrPort) bool {
+	n.addr_mtx.Lock()
+	defer n.addr_mtx.Unlock()
+
+	if slices.Contains(n.local_addr_candidates, addr) {
+		return false
+	}
+	n.local_addr_candidates = append(slices.Clip(n.local_addr_candidates), addr)
+	return true
+}
+
+// Firewall returns the firewall consulted on every inbound and outbound connection.
+// Its rules can be modified at any time; connected peers that the new rules deny are closed.
+func (n *AbyssNode) Firewall() *fw.Firewall { return n.firewall }
+
+// checkConnectedPeer checks the peer in the direction it was connected.
+// Peers are checked once more after they are registered, so that a rule change
+// during the handshake is not missed by firewallRoutine.
+func (n *AbyssNode) checkConnectedPeer(peer *AbyssPeer) error {
+	direction := fw.Inbound
+	if peer.is_dialing {
+		direction = fw.Outbound
+	}
+	if peer.relay != nil {
+		return n.firewall.CheckPeer(direction, peer.ID()) // remote_addr is the relay's.
+	}
+	return n.firewall.Check(direction, peer.ID(), peer.remote_addr.Addr())
+}
+
+// firewallRoutine re-checks connected peers on every rule change. It runs while serving.
+func (n *AbyssNode) firewallRoutine() {
+	for {
+		select {
+		case <-n.service_ctx.Done():
+			return
+		case <-n.firewall.Changed():
+		}
+
+		for _, peer := range n.registry.GetConnectedPeers() {
+			if err := n.checkConnectedPeer(peer); err != nil {
+				n.logger.Info().Str("id", peer.ID()).Err(err).Msg("closing peer denied by firewall")
+				// the peer is removed from the registry when its owner calls Close().
+				peer.connection.CloseWithError(AbyssQuicFirewallReject, "rejected")
+			}
+		}
+	}
+}
+
+func (n *AbyssNode) RelayPolicy() RelayPolicy { return *n.relay_policy.Load() }
+
+// SetRelayPolicy applies to new relay sessions, except for the bandwidth limit,
+// which applies to ongoing sessions immediately.
+func (n *AbyssNode) SetRelayPolicy(policy RelayPolicy) {
+	n.relay_policy.Store(&policy)
+	n.relay_limiter.setRate(policy.BytesPerSecond)
+}
+
+func (n *AbyssNode) RendezvousPolicy() RendezvousPolicy { return *n.rendezvous_policy.Load() }
+
+// SetRendezvousPolicy applies to new hole punch requests.
+func (n *AbyssNode) SetRendezvousPolicy(policy RendezvousPolicy) {
+	n.rendezvous_policy.Store(&policy)
+}
 
 func (n *AbyssNode) AppendKnownPeer(root_cert string, handshake_key_cert string) error {
 	identity, err := sec.NewAbyssPeerIdentityFromPEM(root_cert, handshake_key_cert)
@@ -208,8 +423,7 @@ func (n *AbyssNode) AppendKnownPeer(root_cert string, handshake_key_cert string)
 		return err
 	}
 
-	n.registry.UpdatePeerIdentity(identity)
-	return nil
+	return n.registry.UpdatePeerIdentity(identity)
 }
 func (n *AbyssNode) AppendKnownPeerDer(root_cert []byte, handshake_key_cert []byte) error {
 	identity, err := sec.NewAbyssPeerIdentityFromDER(root_cert, handshake_key_cert)
@@ -217,17 +431,25 @@ func (n *AbyssNode) AppendKnownPeerDer(root_cert []byte, handshake_key_cert []by
 		return err
 	}
 
-	n.registry.UpdatePeerIdentity(identity)
-	return nil
+	return n.registry.UpdatePeerIdentity(identity)
 }
 
+// EraseKnownPeer also removes the peer from the identity store.
+// Store failures are only logged.
 func (n *AbyssNode) EraseKnownPeer(id string) {
-	n.registry.RemovePeerIdentity(id)
+	if err := n.registry.RemovePeerIdentity(id); err != nil {
+		n.logger.Warn().Str("id", id).Err(err).Msg("failed to erase stored peer identity")
+	}
 }
 
 // Dial synchronously check for dialing plausibility, and
 // start a goroutine for handshake procedure.
 func (n *AbyssNode) Dial(id string, addr netip.AddrPort) error {
+	addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+	if err := n.firewall.Check(fw.Outbound, id, addr.Addr()); err != nil {
+		return err
+	}
+
 	// query identity and dialing permission
 	// TODO: this should be separated.
 	peer_identity, err := n.registry.GetPeerIdentityIfDialable(id, addr.Addr())
@@ -235,16 +457,35 @@ func (n *AbyssNode) Dial(id string, addr netip.AddrPort) error {
 		return err
 	}
 
-	go n.dialRoutine(addr, peer_identity)
+	if n.transport == nil || !n.tryStartWorker(func() { n.dialRoutine(addr, peer_identity) }) {
+		n.registry.ReportDialTermination(peer_identity, addr.Addr())
+		return net.ErrClosed
+	}
 	return nil
 }
 
+// Accept returns net.ErrClosed after Close() is called.
 func (n *AbyssNode) Accept(ctx context.Context) (ani.IAbyssPeer, error) {
+	if n.service_ctx.Err() != nil {
+		return nil, net.ErrClosed
+	}
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
+	case <-n.service_ctx.Done():
+		return nil, net.ErrClosed
 	case backlog_entry := <-n.backlog:
-		return backlog_entry.peer, backlog_entry.err
+		if n.service_ctx.Err() != nil {
+			// raced with Close(); the peer is already dead.
+			if backlog_entry.peer != nil {
+				backlog_entry.peer.Close()
+			}
+			return nil, net.ErrClosed
+		}
+		if backlog_entry.peer == nil {
+			return nil, backlog_entry.err
+		}
+		return backlog_entry.peer, nil
 	}
 }
 
@@ -252,23 +493,97 @@ func (n *AbyssNode) ConfigAbystGateway(config string) error {
 	return n.abyst_hub.SetInternalMuxFromJson(config)
 }
 
+// NewAbystClient creates an abyst client, which reaches the peers connected with this node.
+// Each client has its own connections, cookie jars and cache.
 func (n *AbyssNode) NewAbystClient() (ani.IAbystClient, error) {
-	return nil, nil
+	return n.newAbystClient(), nil
 }
 
+// NewCollocatedHttp3Client creates an ordinary https client (http/3 only),
+// which dials through the node's QUIC transport, so that the requests
+// come from the same UDP port as abyss connections.
+// The node's TLS certificate is presented as a client certificate.
+// This must be called after Listen().
 func (n *AbyssNode) NewCollocatedHttp3Client() (*http.Client, error) {
-	return nil, nil
+	if n.transport == nil {
+		return nil, errors.New("abyss node is not listening")
+	}
+	return &http.Client{
+		Transport: &http3.Transport{
+			TLSClientConfig: n.NewCollocatedClientTlsConf(n.config.CollocatedRootCAs),
+			QUICConfig:      n.newQuicConfig(),
+			Dial: func(ctx context.Context, addr string, tls_config *tls.Config, quic_config *quic.Config) (quic.EarlyConnection, error) {
+				udp_addr, err := net.ResolveUDPAddr(n.config.IPMode.network(), addr)
+				if err != nil {
+					return nil, err
+				}
+				if err := n.firewall.CheckAddress(fw.Outbound, udp_addr.AddrPort().Addr().Unmap()); err != nil {
+					return nil, err
+				}
+				return n.transport.DialEarly(ctx, udp_addr, tls_config, quic_config)
+			},
+		},
+	}, nil
 }
 
-// Close gracefully closes AbyssNode.
-// * Issue: Close() may not return when backlog is full.
-// This is because when backlog is full, backlog appending call blocks,
-// and the connection handling goroutines cannot terminate.
-// 1. cancel context
-// 2. wait for accepter to terminate
-// 3. consume backlog until all goroutines terminate
-// 4. signal Serve loop
+// Close gracefully closes AbyssNode. It returns after all sockets are released.
+// 1. forbid new workers, and cancel context; this unblocks backlog appends.
+// 2. wait for Serve loop to terminate.
+// 3. close transport; this kills every connection, including peers.
+// 4. wait for workers to terminate.
+// 5. close peers remaining in the backlog.
+// 6. close UDP socket.
+// Calling Close() more than once is a no-op.
 func (n *AbyssNode) Close() error {
-	n.service_cancelfunc()
-	return nil
+	var err error
+	n.close_once.Do(func() {
+		n.worker_mtx.Lock()
+		n.is_closing = true
+		is_serving := n.is_serving
+		n.worker_mtx.Unlock()
+		n.service_cancelfunc()
+
+		if is_serving {
+			<-n.serve_done
+		}
+
+		var l_err, t_err, u_err error
+		if n.listener != nil {
+			l_err = n.listener.Close()
+		}
+		if n.transport != nil {
+			t_err = n.transport.Close()
+		}
+
+		n.worker_wg.Wait()
+
+	DRAIN:
+		for {
+			select {
+			case backlog_entry := <-n.backlog:
+				if backlog_entry.peer != nil {
+					backlog_entry.peer.Close()
+				}
+			default:
+				break DRAIN
+			}
+		}
+
+		if n.testConn != nil {
+			u_err = n.testConn.Close()
+		} else if n.udpConn != nil {
+			u_err = n.udpConn.Close()
+		}
+		n.addr_mtx.Lock()
+		n.local_addr_candidates = make([]netip.AddrPort, 0)
+		n.reflexive_count = 0
+		clear(n.observed_addrs)
+		n.addr_mtx.Unlock()
+
+		if errors.Is(l_err, quic.ErrServerClosed) {
+			l_err = nil
+		}
+		err = errors.Join(l_err, t_err, u_err)
+	})
+	return err
 }
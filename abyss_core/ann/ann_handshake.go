@@ -10,6 +10,7 @@ import (
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	"github.com/kadmila/Abyss-Browser/abyss_core/fw"
 	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
 	"github.com/quic-go/quic-go"
 )
@@ -25,7 +26,7 @@ type handshakeResult struct {
 
 func (n *AbyssNode) dialRoutine(addr netip.AddrPort, peer_identity *sec.AbyssPeerIdentity) {
 	// prepare handshake context - sets timeout for abyss handshake
-	handshake_ctx, handshake_ctx_cancel := context.WithTimeout(n.service_ctx, time.Second*5)
+	handshake_ctx, handshake_ctx_cancel := context.WithTimeout(n.service_ctx, n.config.HandshakeTimeout)
 	defer func() {
 		handshake_ctx_cancel()
 		n.registry.ReportDialTermination(peer_identity, addr.Addr())
@@ -34,12 +35,9 @@ func (n *AbyssNode) dialRoutine(addr netip.AddrPort, peer_identity *sec.AbyssPee
 	// dial
 	connection, err := n.transport.Dial(
 		handshake_ctx,
-		&net.UDPAddr{
-			IP:   addr.Addr().AsSlice(),
-			Port: int(addr.Port()),
-		},
+		net.UDPAddrFromAddrPort(addr),
 		n.TLSIdentity.NewAbyssClientTlsConf(),
-		newQuicConfig(),
+		n.newQuicConfig(),
 	)
 	if err != nil {
 		if connection != nil {
@@ -60,94 +58,25 @@ func (n *AbyssNode) dialRoutine(addr netip.AddrPort, peer_identity *sec.AbyssPee
 		n.backlogAppendError(addr, true, err)
 		return
 	}
-	ahmp_encoder := cbor.NewEncoder(ahmp_stream)
-	ahmp_decoder := cbor.NewDecoder(ahmp_stream)
 
-	// handle handshake timeout for non-contexted calls. This is somewhat lame, but is forced by the quic interface.
-	handshake_result := make(chan handshakeResult, 1)
-	go func() {
-		result := handshakeResult{}
-		defer func() {
-			handshake_result <- result
-		}()
-		// (handshake 1)
-		// send local tls-abyss binding cert encrypted with remote handshake key.
-		encrypted_cert, aes_secret, err := peer_identity.EncryptHandshake(n.TLSIdentity.AbyssBindingCertificate())
-		if err != nil {
-			result.err = err
-			result.close_code = AbyssQuicCryptoFail
-			result.close_msg = "abyss cryptograhic failure"
-			return
-		}
-		handshake_1_message := &ahmp.RawHS1{
-			EncryptedCertificate: encrypted_cert,
-			EncryptedSecret:      aes_secret,
-		}
-		if err := ahmp_encoder.Encode(handshake_1_message); err != nil {
-			result.err = err
-			result.close_code = AbyssQuicAhmpStreamFail
-			result.close_msg = "failed to transmit AHMP"
-			return
-		}
-
-		// (handshake 2)
-		// receive server-side tls-abyss binding and verify
-		var handshake_2_message []byte
-		if err := ahmp_decoder.Decode(&handshake_2_message); err != nil {
-			result.err = err
-			result.close_code = AbyssQuicAhmpStreamFail
-			result.close_msg = "failed to receive AHMP"
-			return
-		}
-		handshake_2_payload_x509, err := x509.ParseCertificate(handshake_2_message)
-		if err != nil {
-			result.err = err
-			result.close_code = AbyssQuicAuthenticationFail
-			result.close_msg = "failed to parse certificate"
-			return
-		}
-		if err := peer_identity.VerifyTLSBinding(handshake_2_payload_x509, client_tls_cert); err != nil {
-			result.err = err
-			result.close_code = AbyssQuicAuthenticationFail
-			result.close_msg = "invalid certificate"
-			return
-		}
-	}()
-	select {
-	case result := <-handshake_result:
-		if result.err == nil {
-			n.backlogAppend(true,
-				&AbyssPeer{
-					AbyssPeerIdentity: peer_identity,
-					origin:            n,
-					client_tls_cert:   client_tls_cert,
-					connection:        connection,
-					remote_addr:       addr,
-					ahmp_encoder:      ahmp_encoder,
-					ahmp_decoder:      ahmp_decoder,
-				})
-		} else if result.do_timeout {
-			<-handshake_ctx.Done()
-			connection.CloseWithError(AbyssQuicHandshakeTimeout, "handshake timeout")
-			n.backlogAppendError(addr, true, result.err)
-		} else {
-			connection.CloseWithError(result.close_code, result.close_msg)
-			n.backlogAppendError(addr, true, result.err)
-		}
-	case <-handshake_ctx.Done():
-		connection.CloseWithError(AbyssQuicHandshakeTimeout, "handshake timeout")
-		n.backlogAppendError(addr, true, handshake_ctx.Err())
-	}
+	n.finishHandshake(handshake_ctx, true, &AbyssPeer{
+		AbyssPeerIdentity: peer_identity,
+		origin:            n,
+		client_tls_cert:   client_tls_cert,
+		connection:        connection,
+		remote_addr:       addr,
+		ahmp_encoder:      cbor.NewEncoder(ahmp_stream),
+		ahmp_decoder:      ahmp.NewDecoder(ahmp_stream),
+	})
 }
 
 func (n *AbyssNode) serveRoutine(connection quic.Connection) {
 	// prepare handshake context - sets timeout for abyss handshake
-	handshake_ctx, handshake_ctx_cancel := context.WithTimeout(n.service_ctx, time.Second*5)
+	handshake_ctx, handshake_ctx_cancel := context.WithTimeout(n.service_ctx, n.config.HandshakeTimeout)
 	defer handshake_ctx_cancel()
 
 	// get address (for logging)
-	a := connection.RemoteAddr().(*net.UDPAddr)
-	addr := netip.AddrPortFrom(netip.AddrFrom4([4]byte(a.IP.To4())), uint16(a.Port))
+	addr := remoteAddrPort(connection)
 
 	// get self-signed TLS certificate that the peer presented.
 	tls_info := connection.ConnectionState().TLS
@@ -160,125 +89,230 @@ func (n *AbyssNode) serveRoutine(connection quic.Connection) {
 		n.backlogAppendError(addr, false, err)
 		return
 	}
-	ahmp_encoder := cbor.NewEncoder(ahmp_stream)
-	ahmp_decoder := cbor.NewDecoder(ahmp_stream)
 
+	n.finishHandshake(handshake_ctx, false, &AbyssPeer{
+		origin:          n,
+		client_tls_cert: client_tls_cert,
+		connection:      connection,
+		remote_addr:     addr,
+		ahmp_encoder:    cbor.NewEncoder(ahmp_stream),
+		ahmp_decoder:    ahmp.NewDecoder(ahmp_stream),
+	})
+}
+
+// finishHandshake runs the abyss handshake (1, 2) over the AHMP stream of pre_peer,
+// and appends the result to the backlog. The AHMP stream may be direct or relayed.
+// On the serving side, pre_peer has no identity yet; it is filled from the handshake.
+func (n *AbyssNode) finishHandshake(handshake_ctx context.Context, is_dialing bool, pre_peer *AbyssPeer) {
 	// handle handshake timeout for non-contexted calls. This is somewhat lame, but is forced by the quic interface.
 	handshake_result := make(chan handshakeResult, 1)
 	go func() {
-		result := handshakeResult{}
-		defer func() {
-			handshake_result <- result
-		}()
-		// (handshake 1)
-		// receive and decrypt peer's tls-binding certificate
-		var handshake_1_message ahmp.RawHS1
-		if err := ahmp_decoder.Decode(&handshake_1_message); err != nil {
-			result.err = err
-			result.close_code = AbyssQuicAhmpStreamFail
-			result.close_msg = "failed to receive AHMP"
-			return
-		}
-		tls_binding_cert_derBytes, err := n.DecryptHandshake(handshake_1_message.EncryptedCertificate, handshake_1_message.EncryptedSecret)
-		if err != nil {
-			result.err = err
-			result.do_timeout = true
-			return
-		}
-		tls_binding_cert, err := x509.ParseCertificate(tls_binding_cert_derBytes)
-		if err != nil {
-			result.err = err
-			result.do_timeout = true
-			return
-		}
-
-		// retrieve known identity
-		peer_id := tls_binding_cert.Issuer.CommonName
-		var peer_identity *sec.AbyssPeerIdentity
-		retry_time := time.Millisecond * 50
-		for { // exponential backoff (x1.5)
-			var err *DialError
-			peer_identity, err = n.registry.GetPeerIdentityIfAcceptable(peer_id)
-			if err == nil {
-				break
-			}
-			switch err.T {
-			case DE_Redundant:
-				// verify abyss-tls binding, to 1) quickly end connection,
-				// or 2) make it timeout if its malicious.
-				if err := peer_identity.VerifyTLSBinding(tls_binding_cert, client_tls_cert); err != nil {
-					result.err = err
-					result.do_timeout = true
-					return
-				} else {
-					result.err = &DialError{T: DE_Redundant}
-					result.close_code = AbyssQuicRedundantConnection
-					result.close_msg = "redundant connection"
-					return
-				}
-			case DE_UnknownPeer:
-				select {
-				case <-time.After(retry_time):
-					retry_time = retry_time * 3 / 2
-					continue
-				case <-handshake_ctx.Done():
-					result.err = handshake_ctx.Err()
-					result.close_code = AbyssQuicHandshakeTimeout
-					result.close_msg = "handshake timeout"
-					return
-				}
-			}
-		}
-
-		// verify abyss-tls binding
-		if err := peer_identity.VerifyTLSBinding(tls_binding_cert, client_tls_cert); err != nil {
-			result.err = err
-			result.do_timeout = true
-			return
-		}
-
-		// now, the opponent is valid, acceptable peer.
-
-		// (handshake 2)
-		// send local tls-abyss binding cert
-		if err = ahmp_encoder.Encode(n.TLSIdentity.AbyssBindingCertificate()); err != nil {
-			result.err = err
-			result.close_code = AbyssQuicAhmpStreamFail
-			result.close_msg = "failed to transmit AHMP"
-			return
+		if is_dialing {
+			handshake_result <- n.dialHandshake(pre_peer)
+		} else {
+			handshake_result <- n.serveHandshake(handshake_ctx, pre_peer)
 		}
-		result.received_identity = peer_identity
 	}()
 	select {
 	case result := <-handshake_result:
 		if result.err == nil {
-			n.backlogAppend(false,
-				&AbyssPeer{
-					AbyssPeerIdentity: result.received_identity,
-					origin:            n,
-					client_tls_cert:   client_tls_cert,
-					connection:        connection,
-					remote_addr:       addr,
-					ahmp_encoder:      ahmp_encoder,
-					ahmp_decoder:      ahmp_decoder,
-				})
+			if result.received_identity != nil {
+				pre_peer.AbyssPeerIdentity = result.received_identity
+			}
+			pre_peer.is_dialing = is_dialing
+			n.backlogAppend(is_dialing, pre_peer)
 		} else if result.do_timeout {
 			<-handshake_ctx.Done()
-			connection.CloseWithError(AbyssQuicHandshakeTimeout, "handshake timeout")
-			n.backlogAppendError(addr, false, result.err)
+			pre_peer.connection.CloseWithError(AbyssQuicHandshakeTimeout, "handshake timeout")
+			n.backlogAppendError(pre_peer.remote_addr, is_dialing, result.err)
 		} else {
-			connection.CloseWithError(result.close_code, result.close_msg)
-			n.backlogAppendError(addr, false, result.err)
+			pre_peer.connection.CloseWithError(result.close_code, result.close_msg)
+			n.backlogAppendError(pre_peer.remote_addr, is_dialing, result.err)
 		}
 	case <-handshake_ctx.Done():
-		connection.CloseWithError(AbyssQuicHandshakeTimeout, "handshake timeout")
-		n.backlogAppendError(addr, false, handshake_ctx.Err())
+		pre_peer.connection.CloseWithError(AbyssQuicHandshakeTimeout, "handshake timeout")
+		<-handshake_result // the connection is closed; it returns immediately.
+		n.backlogAppendError(pre_peer.remote_addr, is_dialing, handshake_ctx.Err())
+	}
+}
+
+// dialHandshake is the dialing side of handshake 1, 2.
+func (n *AbyssNode) dialHandshake(pre_peer *AbyssPeer) (result handshakeResult) {
+	peer_identity := pre_peer.AbyssPeerIdentity
+
+	// (handshake 1)
+	// send local tls-abyss binding cert encrypted with remote handshake key.
+	encrypted_cert, encrypted_secret, err := peer_identity.EncryptHandshake(n.TLSIdentity.AbyssBindingCertificate())
+	if err != nil {
+		result.err = err
+		result.close_code = AbyssQuicCryptoFail
+		result.close_msg = "abyss cryptograhic failure"
+		return
+	}
+	handshake_1_message := &ahmp.RawHS1{
+		EncryptedCertificate: encrypted_cert,
+		EncryptedSecret:      encrypted_secret,
+		Suite:                int(peer_identity.HandshakeSuite()),
+		Hello:                ahmp.MakeRawHello(ahmp.LocalHello()),
+	}
+	if err := pre_peer.ahmp_encoder.Encode(handshake_1_message); err != nil {
+		result.err = err
+		result.close_code = AbyssQuicAhmpStreamFail
+		result.close_msg = "failed to transmit AHMP"
+		return
+	}
+
+	// (handshake 2)
+	// receive server-side tls-abyss binding and verify
+	var handshake_2_message cbor.RawMessage
+	if err := pre_peer.ahmp_decoder.Decode(&handshake_2_message); err != nil {
+		result.err = err
+		result.close_code = AbyssQuicAhmpStreamFail
+		result.close_msg = "failed to receive AHMP"
+		return
+	}
+	handshake_2_payload, hello, err := ahmp.ParseHS2(handshake_2_message)
+	if err != nil {
+		result.err = err
+		result.close_code = AbyssQuicAhmpStreamFail
+		result.close_msg = "failed to parse AHMP"
+		return
+	}
+	handshake_2_payload_x509, err := x509.ParseCertificate(handshake_2_payload)
+	if err != nil {
+		result.err = err
+		result.close_code = AbyssQuicAuthenticationFail
+		result.close_msg = "failed to parse certificate"
+		return
+	}
+	if err := peer_identity.VerifyTLSBinding(handshake_2_payload_x509, pre_peer.client_tls_cert); err != nil {
+		result.err = err
+		result.close_code = AbyssQuicAuthenticationFail
+		result.close_msg = "invalid certificate"
+		return
+	}
+	pre_peer.hello = hello
+	return
+}
+
+// serveHandshake is the serving side of handshake 1, 2.
+func (n *AbyssNode) serveHandshake(handshake_ctx context.Context, pre_peer *AbyssPeer) (result handshakeResult) {
+	// (handshake 1)
+	// receive and decrypt peer's tls-binding certificate
+	var handshake_1_message ahmp.RawHS1
+	if err := pre_peer.ahmp_decoder.Decode(&handshake_1_message); err != nil {
+		result.err = err
+		result.close_code = AbyssQuicAhmpStreamFail
+		result.close_msg = "failed to receive AHMP"
+		return
+	}
+	tls_binding_cert_derBytes, err := n.DecryptHandshake(sec.HandshakeSuite(handshake_1_message.Suite), handshake_1_message.EncryptedCertificate, handshake_1_message.EncryptedSecret)
+	if err != nil {
+		result.err = err
+		result.do_timeout = true
+		return
+	}
+	tls_binding_cert, err := x509.ParseCertificate(tls_binding_cert_derBytes)
+	if err != nil {
+		result.err = err
+		result.do_timeout = true
+		return
+	}
+
+	// retrieve known identity
+	peer_id := tls_binding_cert.Issuer.CommonName
+	if err := n.firewall.CheckPeer(fw.Inbound, peer_id); err != nil {
+		result.err = err
+		result.close_code = AbyssQuicFirewallReject
+		result.close_msg = "rejected"
+		return
+	}
+	var peer_identity *sec.AbyssPeerIdentity
+	retry_time := time.Millisecond * 50
+	for { // exponential backoff (x1.5)
+		var err *DialError
+		peer_identity, err = n.registry.GetPeerIdentityIfAcceptable(peer_id)
+		if err == nil {
+			break
+		}
+		switch err.T {
+		case DE_Redundant:
+			// verify abyss-tls binding, to 1) quickly end connection,
+			// or 2) make it timeout if its malicious.
+			if err := peer_identity.VerifyTLSBinding(tls_binding_cert, pre_peer.client_tls_cert); err != nil {
+				result.err = err
+				result.do_timeout = true
+				return
+			} else {
+				result.err = &DialError{T: DE_Redundant}
+				result.close_code = AbyssQuicRedundantConnection
+				result.close_msg = "redundant connection"
+				return
+			}
+		case DE_UnknownPeer:
+			select {
+			case <-time.After(retry_time):
+				retry_time = retry_time * 3 / 2
+				continue
+			case <-handshake_ctx.Done():
+				result.err = handshake_ctx.Err()
+				result.close_code = AbyssQuicHandshakeTimeout
+				result.close_msg = "handshake timeout"
+				return
+			}
+		}
+	}
+
+	// verify abyss-tls binding
+	if err := peer_identity.VerifyTLSBinding(tls_binding_cert, pre_peer.client_tls_cert); err != nil {
+		result.err = err
+		result.do_timeout = true
+		return
+	}
+
+	// now, the opponent is valid, acceptable peer.
+
+	// (handshake 2)
+	// send local tls-abyss binding cert, with hello if the peer sent one.
+	var handshake_2_message any = n.TLSIdentity.AbyssBindingCertificate()
+	pre_peer.hello = ahmp.LegacyHello
+	if handshake_1_message.Hello != nil {
+		pre_peer.hello = handshake_1_message.Hello.TryParse()
+		handshake_2_message = &ahmp.RawHS2{
+			BindingCertificate: n.TLSIdentity.AbyssBindingCertificate(),
+			Hello:              *ahmp.MakeRawHello(ahmp.LocalHello()),
+		}
+	}
+	if err = pre_peer.ahmp_encoder.Encode(handshake_2_message); err != nil {
+		result.err = err
+		result.close_code = AbyssQuicAhmpStreamFail
+		result.close_msg = "failed to transmit AHMP"
+		return
+	}
+	result.received_identity = peer_identity
+	return
+}
+
+// serveAbystRoutine serves an abyst (http/3) connection from a connected peer.
+// The TLS client certificate is already checked in the TLS handshake,
+// but the peer may have disconnected since then.
+func (n *AbyssNode) serveAbystRoutine(connection quic.Connection) {
+	peer_id, ok := n.registry.GetPeerIdFromTlsCertificate(connection.ConnectionState().TLS.PeerCertificates[0])
+	if !ok {
+		connection.CloseWithError(AbyssQuicAuthenticationFail, "unknown peer")
+		return
+	}
+	peer, ok := n.registry.GetConnectedPeer(peer_id)
+	if !ok {
+		connection.CloseWithError(AbyssQuicAuthenticationFail, "unknown peer")
+		return
+	}
+	if err := n.abyst_hub.ServeConnection(connection, peer.AbyssPeerIdentity); err != nil {
+		n.logger.Debug().Str("id", peer_id).Err(err).Msg("abyst connection closed")
 	}
 }
 
 // Append blocks until 1) context cancels, or 2) abyss peer is constructed.
-// * Issue: it hard-blocks when BackLog is full.
-// should I let it accept context to prevent backlog blocking?
 func (n *AbyssNode) backlogAppend(is_dialing bool, pre_peer *AbyssPeer) {
 	// check who's in control.
 	controller_id, err := TieBreak(n.ID(), pre_peer.ID())
@@ -308,6 +342,13 @@ func (n *AbyssNode) backlogAppendMaster(is_dialing bool, pre_peer *AbyssPeer) {
 		n.backlogAppendError(pre_peer.remote_addr, is_dialing, dial_err)
 		return
 	}
+	if err := n.checkConnectedPeer(new_peer); err != nil {
+		// the rules changed during the handshake.
+		pre_peer.connection.CloseWithError(AbyssQuicFirewallReject, "rejected")
+		new_peer.Close()
+		n.backlogAppendError(pre_peer.remote_addr, is_dialing, err)
+		return
+	}
 
 	// connection confirmation (handshake 3)
 	code := 0
@@ -324,10 +365,11 @@ func (n *AbyssNode) backlogAppendMaster(is_dialing bool, pre_peer *AbyssPeer) {
 		return
 	}
 
-	n.backlog <- backLogEntry{
+	n.tryStartWorker(func() { n.controlRoutine(new_peer) })
+	n.backlogPush(backLogEntry{
 		peer: new_peer,
 		err:  nil,
-	}
+	})
 }
 
 func (n *AbyssNode) backlogAppendSlave(is_dialing bool, pre_peer *AbyssPeer) {
@@ -353,11 +395,19 @@ func (n *AbyssNode) backlogAppendSlave(is_dialing bool, pre_peer *AbyssPeer) {
 		n.backlogAppendError(pre_peer.remote_addr, is_dialing, dial_err)
 		return
 	}
+	if err := n.checkConnectedPeer(new_peer); err != nil {
+		// the rules changed during the handshake.
+		pre_peer.connection.CloseWithError(AbyssQuicFirewallReject, "rejected")
+		new_peer.Close()
+		n.backlogAppendError(pre_peer.remote_addr, is_dialing, err)
+		return
+	}
 
-	n.backlog <- backLogEntry{
+	n.tryStartWorker(func() { n.controlRoutine(new_peer) })
+	n.backlogPush(backLogEntry{
 		peer: new_peer,
 		err:  nil,
-	}
+	})
 }
 
 func (n *AbyssNode) backlogAppendError(addr netip.AddrPort, is_dialing bool, err error) {
@@ -367,8 +417,21 @@ func (n *AbyssNode) backlogAppendError(addr netip.AddrPort, is_dialing bool, err
 	} else {
 		direction = "(inbound)"
 	}
-	n.backlog <- backLogEntry{
+	n.logger.Debug().Str("remote_addr", addr.String()).Bool("dialing", is_dialing).Err(err).Msg("abyss handshake failed")
+	n.backlogPush(backLogEntry{
 		peer: nil,
 		err:  errors.New(addr.String() + direction + err.Error()),
+	})
+}
+
+// backlogPush blocks until the entry is fetched, or the node closes.
+// When the node closes, the peer in the entry is closed.
+func (n *AbyssNode) backlogPush(entry backLogEntry) {
+	select {
+	case n.backlog <- entry:
+	case <-n.service_ctx.Done():
+		if entry.peer != nil {
+			entry.peer.Close()
+		}
 	}
 }
@@ -10,6 +10,7 @@ import (
 
 	"github.com/kadmila/Abyss-Browser/abyss_core/ani"
 	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+	"github.com/kadmila/Abyss-Browser/abyss_core/fw"
 	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
 )
 
@@ -317,3 +318,184 @@ func TestDialTimeout2(t *testing.T) {
 		t.Fatal("should throw error other than Accept context timeout")
 	}
 }
+
+func TestFirewall(t *testing.T) {
+	root_key_A, _ := sec.NewRootPrivateKey()
+	node_A, _ := ann.NewAbyssNode(root_key_A)
+	node_A.Listen()
+	go node_A.Serve()
+	defer node_A.Close()
+
+	root_key_B, _ := sec.NewRootPrivateKey()
+	node_B, _ := ann.NewAbyssNode(root_key_B)
+	node_B.Listen()
+	go node_B.Serve()
+	defer node_B.Close()
+
+	node_A.AppendKnownPeer(node_B.RootCertificate(), node_B.HandshakeKeyCertificate())
+	node_B.AppendKnownPeer(node_A.RootCertificate(), node_A.HandshakeKeyCertificate())
+
+	// outbound block is synchronous.
+	node_A.Firewall().DenyPeer(fw.Outbound, node_B.ID())
+	var blocked_err *fw.BlockedError
+	if err := node_A.Dial(node_B.ID(), node_B.LocalAddrCandidates()[0]); !errors.As(err, &blocked_err) {
+		t.Fatal("outbound dial should be blocked")
+	}
+	node_A.Firewall().ClearPeer(fw.Outbound, node_B.ID())
+
+	// inbound block rejects the handshake on B, which fails the dial on A.
+	node_B.Firewall().DenyPeer(fw.Inbound, node_A.ID())
+	if err := node_A.Dial(node_B.ID(), node_B.LocalAddrCandidates()[0]); err != nil {
+		t.Fatal(err)
+	}
+	ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer ctxcancel()
+	if _, err := node_A.Accept(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("inbound firewall should reject the dial")
+	}
+	if _, err := node_B.Accept(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("inbound firewall should report rejection")
+	}
+
+	// hot-swap: after clearing the rule, the same peers connect.
+	node_B.Firewall().SetRuleSet(fw.NewRuleSet())
+	if err := node_A.Dial(node_B.ID(), node_B.LocalAddrCandidates()[0]); err != nil {
+		t.Fatal(err)
+	}
+	var peer ani.IAbyssPeer
+	for {
+		var err error
+		peer, err = node_A.Accept(ctx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				t.Fatal("accept timeout")
+			}
+			continue
+		}
+		if peer.ID() != node_B.ID() {
+			t.Fatal("peer id mismatch")
+		}
+		break
+	}
+
+	// a new deny rule closes the connected peer.
+	node_B.Firewall().DenyPeer(fw.Inbound, node_A.ID())
+	close_ctx, close_ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer close_ctxcancel()
+	select {
+	case <-peer.(*ann.AbyssPeer).Context().Done():
+	case <-close_ctx.Done():
+		t.Fatal("denied peer should be closed")
+	}
+}
+
+func TestNodeConfig(t *testing.T) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip.MustParseAddrPort("127.0.0.1:0")
+	config.SimulatedDelay = &ann.SimulatedDelay{Delay: time.Millisecond * 10, Jitter: time.Millisecond * 20}
+
+	root_key_A, _ := sec.NewRootPrivateKey()
+	node_A, err := ann.NewAbyssNodeWithConfig(root_key_A, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node_A.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go node_A.Serve()
+	defer node_A.Close()
+
+	candidates := node_A.LocalAddrCandidates()
+	if len(candidates) != 1 || candidates[0].Addr() != netip.MustParseAddr("127.0.0.1") || candidates[0].Port() == 0 {
+		t.Fatal("unexpected candidates: ", candidates)
+	}
+
+	// short handshake timeout; node_B listens but never serves.
+	config_B := ann.AbyssNodeConfig{HandshakeTimeout: time.Millisecond * 300}
+	root_key_B, _ := sec.NewRootPrivateKey()
+	node_B, _ := ann.NewAbyssNodeWithConfig(root_key_B, config_B)
+	node_B.Listen()
+	defer node_B.Close()
+
+	root_key_C, _ := sec.NewRootPrivateKey()
+	node_C, _ := ann.NewAbyssNodeWithConfig(root_key_C, config_B)
+	node_C.Listen()
+	go node_C.Serve()
+	defer node_C.Close()
+	node_C.AppendKnownPeer(node_B.RootCertificate(), node_B.HandshakeKeyCertificate())
+
+	start := time.Now()
+	if err := node_C.Dial(node_B.ID(), node_B.LocalAddrCandidates()[0]); err != nil {
+		t.Fatal(err)
+	}
+	ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer ctxcancel()
+	if _, err := node_C.Accept(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("should fail with handshake timeout")
+	}
+	if time.Since(start) > time.Second*2 {
+		t.Fatal("handshake timeout not applied")
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	root_key_A, _ := sec.NewRootPrivateKey()
+	node_A, _ := ann.NewAbyssNode(root_key_A)
+	if err := node_A.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go node_A.Serve()
+	defer node_A.Close()
+
+	var v6_candidate netip.AddrPort
+	for _, candidate := range node_A.LocalAddrCandidates() {
+		if candidate.Addr().Is6() {
+			v6_candidate = candidate
+			break
+		}
+	}
+	if !v6_candidate.IsValid() {
+		t.Skip("no IPv6 interface")
+	}
+
+	root_key_B, _ := sec.NewRootPrivateKey()
+	node_B, _ := ann.NewAbyssNode(root_key_B)
+	node_B.Listen()
+	go node_B.Serve()
+	defer node_B.Close()
+
+	node_A.AppendKnownPeer(node_B.RootCertificate(), node_B.HandshakeKeyCertificate())
+	node_B.AppendKnownPeer(node_A.RootCertificate(), node_A.HandshakeKeyCertificate())
+	if err := node_B.Dial(node_A.ID(), v6_candidate); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer ctxcancel()
+	for {
+		peer, err := node_A.Accept(ctx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				t.Fatal("accept timeout")
+			}
+			continue
+		}
+		if !peer.RemoteAddr().Addr().Is6() || peer.RemoteAddr().Addr().Is4In6() {
+			t.Fatal("expected IPv6 remote address: ", peer.RemoteAddr())
+		}
+		break
+	}
+
+	// IPv4-only node does not advertise IPv6 candidates.
+	config := ann.DefaultAbyssNodeConfig()
+	config.IPMode = ann.IPModeIPv4
+	root_key_C, _ := sec.NewRootPrivateKey()
+	node_C, _ := ann.NewAbyssNodeWithConfig(root_key_C, config)
+	node_C.Listen()
+	defer node_C.Close()
+	for _, candidate := range node_C.LocalAddrCandidates() {
+		if !candidate.Addr().Is4() {
+			t.Fatal("IPv4 mode advertised non-IPv4 candidate: ", candidate)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package ann_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+)
+
+var netip_loopback = netip.MustParseAddrPort("127.0.0.1:0")
+
+func newServingNode(t testing.TB, config ann.AbyssNodeConfig) (*ann.AbyssNode, chan error) {
+	root_key, err := sec.NewRootPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := ann.NewAbyssNodeWithConfig(root_key, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	serve_done := make(chan error, 1)
+	go func() { serve_done <- node.Serve() }()
+	return node, serve_done
+}
+
+func closeWithin(t testing.TB, node *ann.AbyssNode, duration time.Duration) {
+	t.Helper()
+	close_done := make(chan error, 1)
+	go func() { close_done <- node.Close() }()
+	select {
+	case err := <-close_done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(duration):
+		t.Fatal("Close() did not return")
+	}
+}
+
+func TestCloseWithFullBacklog(t *testing.T) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	config.BacklogSize = 1
+	node_A, serve_done := newServingNode(t, config)
+
+	dialers := make([]*ann.AbyssNode, 0)
+	for range 8 {
+		dialer, _ := newServingNode(t, config)
+		dialers = append(dialers, dialer)
+		node_A.AppendKnownPeer(dialer.RootCertificate(), dialer.HandshakeKeyCertificate())
+		dialer.AppendKnownPeer(node_A.RootCertificate(), node_A.HandshakeKeyCertificate())
+		if err := dialer.Dial(node_A.ID(), node_A.LocalAddrCandidates()[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// let handshakes complete and block on the full backlog.
+	time.Sleep(time.Second)
+
+	closeWithin(t, node_A, time.Second*5)
+	if err := <-serve_done; !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	if _, err := node_A.Accept(context.Background()); !errors.Is(err, net.ErrClosed) {
+		t.Fatal("Accept() after Close() should return net.ErrClosed: ", err)
+	}
+	if len(node_A.LocalAddrCandidates()) != 0 {
+		t.Fatal("LocalAddrCandidates should be emptied")
+	}
+	if err := node_A.Dial(dialers[0].ID(), dialers[0].LocalAddrCandidates()[0]); err == nil {
+		t.Fatal("Dial() after Close() should fail")
+	}
+	if err := node_A.Close(); err != nil {
+		t.Fatal("second Close() should be a no-op: ", err)
+	}
+
+	for _, dialer := range dialers {
+		closeWithin(t, dialer, time.Second*5)
+	}
+}
+
+func TestCloseNoGoroutineLeak(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	config.SimulatedDelay = &ann.SimulatedDelay{Delay: time.Millisecond * 5, Jitter: time.Millisecond * 5}
+
+	for range 10 {
+		node_A, _ := newServingNode(t, config)
+		node_B, _ := newServingNode(t, config)
+		node_A.AppendKnownPeer(node_B.RootCertificate(), node_B.HandshakeKeyCertificate())
+		node_B.AppendKnownPeer(node_A.RootCertificate(), node_A.HandshakeKeyCertificate())
+		node_A.Dial(node_B.ID(), node_B.LocalAddrCandidates()[0])
+		node_B.Dial(node_A.ID(), node_A.LocalAddrCandidates()[0])
+
+		// accept one side only; the other side is left in the backlog.
+		ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+		for {
+			peer, err := node_A.Accept(ctx)
+			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) {
+					t.Fatal("accept timeout")
+				}
+				continue
+			}
+			peer.Send(1)
+			break
+		}
+		ctxcancel()
+
+		closeWithin(t, node_A, time.Second*5)
+		closeWithin(t, node_B, time.Second*5)
+	}
+
+	// some goroutines (e.g. timers in quic-go) take a moment to exit.
+	deadline := time.Now().Add(time.Second * 5)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatal("goroutine leak: ", runtime.NumGoroutine(), " > ", baseline, "\n", string(buf[:runtime.Stack(buf, true)]))
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
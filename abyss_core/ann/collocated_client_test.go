@@ -0,0 +1,110 @@
+package ann_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func newLocalhostCertificate(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func TestCollocatedHttp3Client(t *testing.T) {
+	server_cert, server_x509 := newLocalhostCertificate(t)
+
+	udp_conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{server_cert},
+			ClientAuth:   tls.RequireAnyClientCert,
+		}),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.RemoteAddr)
+			if len(r.TLS.PeerCertificates) == 1 {
+				w.Write([]byte{0})
+				w.Write(r.TLS.PeerCertificates[0].Raw)
+			}
+		}),
+	}
+	go server.Serve(udp_conn)
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server_x509)
+	config := ann.DefaultAbyssNodeConfig()
+	config.CollocatedRootCAs = roots
+
+	root_key, _ := sec.NewRootPrivateKey()
+	node, _ := ann.NewAbyssNodeWithConfig(root_key, config)
+	if _, err := node.NewCollocatedHttp3Client(); err == nil {
+		t.Fatal("should fail before Listen")
+	}
+	node.Listen()
+	go node.Serve()
+	defer node.Close()
+
+	client, err := node.NewCollocatedHttp3Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.Get("https://" + udp_conn.LocalAddr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	remote_addr, client_cert, ok := bytes.Cut(body, []byte{0})
+	if !ok {
+		t.Fatal("client certificate not presented")
+	}
+	_, port, _ := net.SplitHostPort(string(remote_addr))
+	if port != strconv.Itoa(int(node.LocalAddrCandidates()[0].Port())) {
+		t.Fatal("request did not come from the node's port: ", string(remote_addr))
+	}
+	if !bytes.Equal(client_cert, node.TLSCertificate()) {
+		t.Fatal("client certificate mismatch")
+	}
+}
@@ -0,0 +1,172 @@
+package ann
+
+import (
+	"crypto/x509"
+	"io"
+	"net/netip"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/fw"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+	"github.com/phuslu/log"
+)
+
+// IPMode selects the IP version AbyssNode listens on and advertises.
+type IPMode int
+
+const (
+	IPModeDualStack IPMode = iota // default
+	IPModeIPv4
+	IPModeIPv6
+)
+
+func (m IPMode) network() string {
+	switch m {
+	case IPModeIPv4:
+		return "udp4"
+	case IPModeIPv6:
+		return "udp6"
+	default:
+		return "udp"
+	}
+}
+
+// SimulatedDelay configures the DelayConn debug layer.
+// Inbound packets are delayed by Delay + [0, Jitter).
+type SimulatedDelay struct {
+	Delay  time.Duration
+	Jitter time.Duration
+}
+
+// SimulatedNAT configures the NatConn debug layer.
+// Inbound packets are dropped unless the node sent a packet to the source
+// address within MappingTimeout (port-restricted cone NAT).
+type SimulatedNAT struct {
+	MappingTimeout time.Duration
+}
+
+// RelayPolicy decides whether the node forwards abyss sessions between its peers.
+// The zero value disables relaying.
+type RelayPolicy struct {
+	Enabled bool
+
+	// MaxSessions limits concurrent relay sessions. 0 means unlimited.
+	MaxSessions int
+
+	// BytesPerSecond limits the total relayed traffic of all sessions,
+	// in both directions. 0 means unlimited.
+	BytesPerSecond int
+}
+
+// RendezvousPolicy decides whether the node coordinates hole punching between its peers.
+// A rendezvous makes the target dial the addresses the source claims, so
+// the zero value disables it.
+type RendezvousPolicy struct {
+	Enabled bool
+
+	// MaxSessions limits concurrent coordinations. 0 means unlimited.
+	MaxSessions int
+}
+
+// AbyssNodeConfig is the construction option of AbyssNode.
+// Zero-valued fields fall back to DefaultAbyssNodeConfig.
+type AbyssNodeConfig struct {
+	// BindAddr is the local UDP address to listen on.
+	// If the address is invalid or unspecified, all interfaces are bound.
+	// Port 0 picks a random port.
+	BindAddr netip.AddrPort
+	IPMode   IPMode
+
+	// HandshakeTimeout bounds the whole abyss handshake, including QUIC dial.
+	HandshakeTimeout    time.Duration
+	QuicIdleTimeout     time.Duration
+	QuicKeepAlivePeriod time.Duration
+
+	// BacklogSize is the capacity of the Accept() queue.
+	BacklogSize int
+
+	// SimulatedDelay, if not nil, wraps the UDP socket with DelayConn.
+	// This is a debug tool; never set this in production.
+	SimulatedDelay *SimulatedDelay
+
+	// SimulatedNAT, if not nil, wraps the UDP socket with NatConn (below DelayConn).
+	// This is a debug tool; never set this in production.
+	SimulatedNAT *SimulatedNAT
+
+	// Logger, if nil, discards all logs.
+	Logger *log.Logger
+
+	// Firewall, if nil, a new open firewall is created.
+	Firewall *fw.Firewall
+
+	// IdentityStore, if not nil, persists known peer identities.
+	// Stored identities are loaded on construction.
+	IdentityStore IdentityStore
+
+	// IdentityMaxAge expires known peer identities whose handshake key
+	// is older than this. 0 means no limit. Certificate NotAfter is always honored.
+	IdentityMaxAge time.Duration
+
+	// HandshakeKeyType is the type of handshake key to issue, on construction and rotation.
+	// RSA (default) can be dialed by every peer. X25519 is much faster, but peers without
+	// HPKE support cannot dial the node; set it once the peers are upgraded.
+	// A node with an RSA key migrates by rotating to X25519 (see AbyssNode.RotateHandshakeKey).
+	HandshakeKeyType sec.HandshakeKeyType
+
+	// HandshakeKeyRotationPeriod, if positive, rotates the handshake key
+	// while serving, and announces the new key to connected peers.
+	// TLS certificates are renewed regardless of this.
+	HandshakeKeyRotationPeriod time.Duration
+
+	// Trust decides which peer identities need confirmation; see TrustPolicy.
+	Trust TrustPolicy
+
+	// Relay is the initial relay policy; see AbyssNode.SetRelayPolicy.
+	Relay RelayPolicy
+
+	// Rendezvous is the initial rendezvous policy; see AbyssNode.SetRendezvousPolicy.
+	Rendezvous RendezvousPolicy
+
+	// CollocatedRootCAs is the server certificate pool for NewCollocatedHttp3Client.
+	// If nil, the system roots are used.
+	CollocatedRootCAs *x509.CertPool
+}
+
+func DefaultAbyssNodeConfig() AbyssNodeConfig {
+	return AbyssNodeConfig{
+		IPMode:              IPModeDualStack,
+		HandshakeTimeout:    time.Second * 5,
+		QuicIdleTimeout:     time.Second * 20,
+		QuicKeepAlivePeriod: time.Second * 5,
+		BacklogSize:         128,
+	}
+}
+
+func (c AbyssNodeConfig) withDefaults() AbyssNodeConfig {
+	defaults := DefaultAbyssNodeConfig()
+	if c.HandshakeTimeout <= 0 {
+		c.HandshakeTimeout = defaults.HandshakeTimeout
+	}
+	if c.QuicIdleTimeout <= 0 {
+		c.QuicIdleTimeout = defaults.QuicIdleTimeout
+	}
+	if c.QuicKeepAlivePeriod <= 0 {
+		c.QuicKeepAlivePeriod = defaults.QuicKeepAlivePeriod
+	}
+	if c.BacklogSize <= 0 {
+		c.BacklogSize = defaults.BacklogSize
+	}
+	if c.SimulatedNAT != nil && c.SimulatedNAT.MappingTimeout <= 0 {
+		c.SimulatedNAT = &SimulatedNAT{MappingTimeout: time.Second * 30}
+	}
+	if c.Logger == nil {
+		c.Logger = &log.Logger{
+			Level:  log.PanicLevel,
+			Writer: &log.IOWriter{Writer: io.Discard},
+		}
+	}
+	if c.Firewall == nil {
+		c.Firewall = fw.NewFirewall()
+	}
+	return c
+}
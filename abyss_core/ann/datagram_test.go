@@ -0,0 +1,46 @@
+package ann_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+)
+
+func TestDatagram(t *testing.T) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	node_A, _ := newServingNode(t, config)
+	defer node_A.Close()
+	node_B, _ := newServingNode(t, config)
+	defer node_B.Close()
+
+	introduce(node_A, node_B)
+	node_A.Dial(node_B.ID(), node_B.LocalAddrCandidates()[0])
+	peer_A_B := acceptPeer(t, node_A, node_B.ID(), time.Second*3)
+	peer_B_A := acceptPeer(t, node_B, node_A.ID(), time.Second*3)
+
+	ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer ctxcancel()
+	payload := []byte("transform")
+	// datagrams may be lost, even on loopback; keep sending until one arrives.
+	go func() {
+		for ctx.Err() == nil {
+			peer_A_B.SendDatagram(payload)
+			time.Sleep(time.Millisecond * 50)
+		}
+	}()
+	received, err := peer_B_A.ReceiveDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Fatal("payload mismatch")
+	}
+
+	if err := peer_A_B.SendDatagram(make([]byte, 64*1024)); err == nil {
+		t.Fatal("oversized datagram sent")
+	}
+}
@@ -3,6 +3,7 @@ package ann
 import (
 	"math/rand/v2"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -13,7 +14,7 @@ type ReadResult struct {
 	time time.Time
 }
 
-// DelayConn is a net.PacketConn wrapper around *net.UDPConn that simulates
+// DelayConn is a net.PacketConn wrapper that simulates
 // network delay and unordered packet arrival.
 type DelayConn struct {
 	// The underlying connection
@@ -22,18 +23,24 @@ type DelayConn struct {
 	raw_read_ch chan *ReadResult
 	delayed_ch  chan *ReadResult
 
+	// done is closed on Close(), to release the internal goroutines.
+	done      chan struct{}
+	done_once sync.Once
+
 	// Configuration for simulation
 	delay  time.Duration // Base delay for all packets
 	jitter time.Duration // Amount of jitter
 }
 
-func NewDelayConn(original *net.UDPConn, delay time.Duration, jitter time.Duration) *DelayConn {
+func NewDelayConn(original net.PacketConn, delay time.Duration, jitter time.Duration) *DelayConn {
 	result := &DelayConn{
 		PacketConn: original,
 
 		raw_read_ch: make(chan *ReadResult, 32),
 		delayed_ch:  make(chan *ReadResult, 32),
 
+		done: make(chan struct{}),
+
 		delay:  delay,
 		jitter: jitter,
 	}
@@ -50,11 +57,16 @@ func (c *DelayConn) readRaw() {
 			close(c.raw_read_ch)
 			return
 		}
-		c.raw_read_ch <- &ReadResult{
+		select {
+		case c.raw_read_ch <- &ReadResult{
 			data: buf[:n],
 			addr: addr,
 			err:  err,
 			time: time.Now(),
+		}:
+		case <-c.done:
+			close(c.raw_read_ch)
+			return
 		}
 	}
 }
@@ -67,12 +79,22 @@ func (c *DelayConn) convey() {
 		}
 		// delay
 		time.Sleep(time.Until(read_res.time.Add(c.delay + c.jitter*time.Duration(rand.Float32()))))
-		c.delayed_ch <- read_res
+		select {
+		case c.delayed_ch <- read_res:
+		case <-c.done:
+			close(c.delayed_ch)
+			return
+		}
 	}
 }
 
 func (c *DelayConn) ReadFrom(p []byte) (int, net.Addr, error) {
-	res, ok := <-c.delayed_ch
+	var res *ReadResult
+	ok := false
+	select {
+	case res, ok = <-c.delayed_ch:
+	case <-c.done:
+	}
 	if !ok {
 		return 0, nil, &net.OpError{
 			Op:     "read",
@@ -84,3 +106,9 @@ func (c *DelayConn) ReadFrom(p []byte) (int, net.Addr, error) {
 	n := copy(p, res.data)
 	return n, res.addr, res.err
 }
+
+// Close closes the underlying connection and releases the internal goroutines.
+func (c *DelayConn) Close() error {
+	c.done_once.Do(func() { close(c.done) })
+	return c.PacketConn.Close()
+}
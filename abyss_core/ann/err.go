@@ -13,6 +13,7 @@ const (
 	AbyssQuicCryptoFail          quic.ApplicationErrorCode = 0x1002
 	AbyssQuicAuthenticationFail  quic.ApplicationErrorCode = 0x1003
 	AbyssQuicHandshakeTimeout    quic.ApplicationErrorCode = 0x1004
+	AbyssQuicFirewallReject      quic.ApplicationErrorCode = 0x1005
 
 	AbyssQuicClose    quic.ApplicationErrorCode = 0x1100
 	AbyssQuicOverride quic.ApplicationErrorCode = 0x1101
@@ -0,0 +1,116 @@
+package ann_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ani"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+)
+
+// Handshake benchmarks run over the loopback interface.
+// An op is dial-to-Accept on both sides; closing the peers is not timed.
+// Each benchmark runs once per handshake key type.
+
+var benchmarkKeyTypes = []struct {
+	name     string
+	key_type sec.HandshakeKeyType
+}{
+	{"RSA", sec.HandshakeKeyRSA},
+	{"X25519", sec.HandshakeKeyX25519},
+}
+
+func newBenchmarkNodes(b *testing.B, key_type sec.HandshakeKeyType, count int) (*ann.AbyssNode, []*ann.AbyssNode) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	config.BacklogSize = count
+	config.HandshakeKeyType = key_type
+	server, _ := newServingNode(b, config)
+	b.Cleanup(func() { server.Close() })
+
+	dialers := make([]*ann.AbyssNode, count)
+	for i := range dialers {
+		dialers[i], _ = newServingNode(b, config)
+		b.Cleanup(func() { dialers[i].Close() })
+		introduce(server, dialers[i])
+	}
+	return server, dialers
+}
+
+func BenchmarkHandshake(b *testing.B) {
+	for _, key := range benchmarkKeyTypes {
+		b.Run(key.name, func(b *testing.B) {
+			server, dialers := newBenchmarkNodes(b, key.key_type, 1)
+			dialer := dialers[0]
+			server_addr := server.LocalAddrCandidates()[0]
+
+			b.ResetTimer()
+			for range b.N {
+				if err := dialer.Dial(server.ID(), server_addr); err != nil {
+					b.Fatal(err)
+				}
+				peer_D := acceptPeer(b, dialer, server.ID(), time.Second*5)
+				peer_S := acceptPeer(b, server, dialer.ID(), time.Second*5)
+
+				b.StopTimer()
+				peer_D.Close()
+				peer_S.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkConcurrentHandshake(b *testing.B) {
+	for _, key := range benchmarkKeyTypes {
+		for _, count := range []int{8, 32} {
+			b.Run(key.name+"/"+strconv.Itoa(count), func(b *testing.B) {
+				server, dialers := newBenchmarkNodes(b, key.key_type, count)
+				server_addr := server.LocalAddrCandidates()[0]
+
+				b.ResetTimer()
+				for range b.N {
+					for _, dialer := range dialers {
+						if err := dialer.Dial(server.ID(), server_addr); err != nil {
+							b.Fatal(err)
+						}
+					}
+					peers := make([]ani.IAbyssPeer, 0, count*2)
+					for _, dialer := range dialers {
+						peers = append(peers, acceptPeer(b, dialer, server.ID(), time.Second*10))
+					}
+					for range dialers {
+						peers = append(peers, acceptAnyPeer(b, server, time.Second*10))
+					}
+
+					b.StopTimer()
+					for _, peer := range peers {
+						peer.Close()
+					}
+					b.StartTimer()
+				}
+				b.ReportMetric(float64(b.N*count)/b.Elapsed().Seconds(), "handshakes/s")
+			})
+		}
+	}
+}
+
+// acceptAnyPeer waits for a peer, skipping handshake errors.
+func acceptAnyPeer(t testing.TB, node *ann.AbyssNode, timeout time.Duration) ani.IAbyssPeer {
+	t.Helper()
+	ctx, ctxcancel := context.WithTimeout(context.Background(), timeout)
+	defer ctxcancel()
+	for {
+		peer, err := node.Accept(ctx)
+		if err == nil {
+			return peer
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("accept timeout")
+		}
+	}
+}
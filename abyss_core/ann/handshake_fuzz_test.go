@@ -0,0 +1,209 @@
+package ann_test
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+	"github.com/quic-go/quic-go"
+)
+
+// Handshake fuzzing drives a node with a hand-written abyss endpoint (rawPeer)
+// over the loopback interface. A panic in the node's handshake worker fails the run.
+// Background goroutines make coverage noisy, so disable minimization:
+//
+//	go test -run XXX -fuzz FuzzServeHandshake1 -fuzzminimizetime 0 ./ann
+
+type noAbystPeer struct{}
+
+func (noAbystPeer) GetPeerIdFromTlsCertificate(*x509.Certificate) (string, bool) { return "", false }
+
+// rawPeer has a valid identity, but speaks AHMP by hand.
+type rawPeer struct {
+	*sec.AbyssRootSecret
+	*sec.TLSIdentity
+	transport *quic.Transport
+}
+
+func newRawPeer(t testing.TB) *rawPeer {
+	root_key, _ := sec.NewRootPrivateKey()
+	secret, err := sec.NewAbyssRootSecrets(root_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tls_identity, err := secret.NewTLSIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp_conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip_loopback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &quic.Transport{Conn: udp_conn}
+	t.Cleanup(func() { transport.Close() })
+	return &rawPeer{secret, tls_identity, transport}
+}
+
+func (p *rawPeer) addr() netip.AddrPort {
+	return p.transport.Conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+// dial opens the AHMP stream to the node, as dialRoutine does.
+func (p *rawPeer) dial(t testing.TB, node *ann.AbyssNode) (quic.Connection, quic.Stream) {
+	ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer ctxcancel()
+	connection, err := p.transport.Dial(ctx, net.UDPAddrFromAddrPort(node.LocalAddrCandidates()[0]), p.NewAbyssClientTlsConf(), &quic.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := connection.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return connection, stream
+}
+
+// drainBacklog accepts and closes everything, so that handshake workers never block.
+func drainBacklog(node *ann.AbyssNode) {
+	for {
+		peer, err := node.Accept(context.Background())
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if peer != nil {
+			peer.Close()
+		}
+	}
+}
+
+func newFuzzTarget(f *testing.F) *ann.AbyssNode {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	config.HandshakeTimeout = time.Millisecond * 200
+	node, _ := newServingNode(f, config)
+	f.Cleanup(func() { node.Close() })
+	go drainBacklog(node)
+	return node
+}
+
+// sendHandshake1 writes data on the AHMP stream as handshake 1, and waits
+// shortly for the node to process it.
+func sendHandshake1(t *testing.T, raw_peer *rawPeer, node *ann.AbyssNode, data []byte) {
+	connection, stream := raw_peer.dial(t, node)
+	stream.Write(data)
+	stream.Close()
+	select {
+	case <-connection.Context().Done():
+	case <-time.After(time.Millisecond * 50):
+	}
+	connection.CloseWithError(0, "")
+}
+
+// FuzzServeHandshake1 feeds arbitrary bytes as the RawHS1 message.
+func FuzzServeHandshake1(f *testing.F) {
+	node := newFuzzTarget(f)
+	raw_peer := newRawPeer(f)
+	node.AppendKnownPeerDer(raw_peer.RootCertificateDer(), raw_peer.HandshakeKeyCertificateDer())
+
+	node_view, _ := sec.NewAbyssPeerIdentityFromDER(node.RootCertificateDer(), node.HandshakeKeyCertificateDer())
+	encrypted_cert, encrypted_secret, _ := node_view.EncryptHandshake(raw_peer.AbyssBindingCertificate())
+	valid, _ := cbor.Marshal(&ahmp.RawHS1{
+		EncryptedCertificate: encrypted_cert,
+		EncryptedSecret:      encrypted_secret,
+		Suite:                int(node_view.HandshakeSuite()),
+	})
+	legacy, _ := cbor.Marshal(&ahmp.RawHS1{EncryptedCertificate: encrypted_cert, EncryptedSecret: encrypted_secret})
+	f.Add(valid)
+	f.Add(legacy)
+	f.Add([]byte{})
+	f.Add([]byte{0xa0})
+	f.Add([]byte{0xbf, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sendHandshake1(t, raw_peer, node, data)
+	})
+}
+
+// FuzzServeTLSBinding feeds arbitrary bytes as the TLS binding certificate,
+// properly encrypted, so that certificate parsing and verification are reached.
+func FuzzServeTLSBinding(f *testing.F) {
+	node := newFuzzTarget(f)
+	raw_peer := newRawPeer(f)
+	node.AppendKnownPeerDer(raw_peer.RootCertificateDer(), raw_peer.HandshakeKeyCertificateDer())
+	node_view, _ := sec.NewAbyssPeerIdentityFromDER(node.RootCertificateDer(), node.HandshakeKeyCertificateDer())
+
+	other := newRawPeer(f)
+	f.Add(raw_peer.AbyssBindingCertificate())
+	f.Add(other.AbyssBindingCertificate())
+	f.Add(raw_peer.TLSCertificate())
+	f.Add(raw_peer.RootCertificateDer())
+	f.Add([]byte{0x30, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		encrypted_cert, encrypted_secret, err := node_view.EncryptHandshake(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message, _ := cbor.Marshal(&ahmp.RawHS1{
+			EncryptedCertificate: encrypted_cert,
+			EncryptedSecret:      encrypted_secret,
+			Suite:                int(node_view.HandshakeSuite()),
+		})
+		sendHandshake1(t, raw_peer, node, message)
+	})
+}
+
+// FuzzDialHandshake2 feeds arbitrary bytes as the handshake 2 response to a dialing node.
+func FuzzDialHandshake2(f *testing.F) {
+	node := newFuzzTarget(f)
+	raw_peer := newRawPeer(f)
+	node.AppendKnownPeerDer(raw_peer.RootCertificateDer(), raw_peer.HandshakeKeyCertificateDer())
+	listener, err := raw_peer.transport.Listen(raw_peer.NewServerTlsConf(noAbystPeer{}), &quic.Config{})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	valid, _ := cbor.Marshal(raw_peer.AbyssBindingCertificate())
+	f.Add(valid)
+	f.Add([]byte{0x40})
+	f.Add([]byte{0x41, 0x30})
+	f.Add([]byte{0xa0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// the previous input may have completed a connection, which is being closed.
+		for retry := 0; node.Dial(raw_peer.ID(), raw_peer.addr()) != nil; retry++ {
+			if retry == 100 {
+				t.Fatal("dial failed")
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer ctxcancel()
+		connection, err := listener.Accept(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer connection.CloseWithError(0, "")
+		stream, err := connection.AcceptStream(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var handshake_1 ahmp.RawHS1
+		if err := cbor.NewDecoder(stream).Decode(&handshake_1); err != nil {
+			t.Fatal(err)
+		}
+		stream.Write(data)
+		select {
+		case <-connection.Context().Done():
+		case <-time.After(time.Millisecond * 50):
+		}
+	})
+}
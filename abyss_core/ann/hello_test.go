@@ -0,0 +1,139 @@
+package ann_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ani"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+	"github.com/quic-go/quic-go"
+)
+
+func TestProtocolHello(t *testing.T) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	node_A, _ := newServingNode(t, config)
+	defer node_A.Close()
+	node_B, _ := newServingNode(t, config)
+	defer node_B.Close()
+
+	introduce(node_A, node_B)
+	node_B.Dial(node_A.ID(), node_A.LocalAddrCandidates()[0])
+	for _, peer := range []ani.IAbyssPeer{
+		acceptPeer(t, node_B, node_A.ID(), time.Second*3),
+		acceptPeer(t, node_A, node_B.ID(), time.Second*3),
+	} {
+		if peer.ProtocolVersion() != ahmp.ProtocolVersion || !peer.HasCapability(ahmp.LocalCapabilities) {
+			t.Fatal("hello not exchanged")
+		}
+	}
+}
+
+// rawHS1Future is RawHS1 of a newer peer, with a field this build does not know.
+type rawHS1Future struct {
+	EncryptedCertificate []byte
+	EncryptedSecret      []byte
+	Suite                int
+	Hello                *ahmp.RawHello
+	Future               string
+}
+
+func TestProtocolHelloServing(t *testing.T) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	node, _ := newServingNode(t, config)
+	defer node.Close()
+	node_view, _ := sec.NewAbyssPeerIdentityFromDER(node.RootCertificateDer(), node.HandshakeKeyCertificateDer())
+
+	// each case uses a new peer, as a completed connection makes the next one redundant.
+	for _, with_hello := range []bool{false, true} {
+		raw_peer := newRawPeer(t)
+		node.AppendKnownPeerDer(raw_peer.RootCertificateDer(), raw_peer.HandshakeKeyCertificateDer())
+		encrypted_cert, encrypted_secret, _ := node_view.EncryptHandshake(raw_peer.AbyssBindingCertificate())
+		message := rawHS1Future{
+			EncryptedCertificate: encrypted_cert,
+			EncryptedSecret:      encrypted_secret,
+			Suite:                int(node_view.HandshakeSuite()),
+			Future:               "ignored",
+		}
+		if with_hello {
+			message.Hello = &ahmp.RawHello{Version: ahmp.ProtocolVersion + 1, Capabilities: 1 << 63}
+		}
+
+		connection, stream := raw_peer.dial(t, node)
+		cbor.NewEncoder(stream).Encode(&message)
+		var handshake_2 cbor.RawMessage
+		if err := cbor.NewDecoder(stream).Decode(&handshake_2); err != nil {
+			t.Fatal(err)
+		}
+		var legacy []byte
+		is_legacy := cbor.Unmarshal(handshake_2, &legacy) == nil
+		if is_legacy == with_hello {
+			t.Fatal("handshake 2 form mismatch; hello: ", with_hello)
+		}
+		binding_cert, hello, err := ahmp.ParseHS2(handshake_2)
+		if err != nil || len(binding_cert) == 0 {
+			t.Fatal("failed to parse handshake 2: ", err)
+		}
+		if with_hello && hello != ahmp.LocalHello() {
+			t.Fatal("unexpected hello: ", hello)
+		}
+		connection.CloseWithError(0, "")
+	}
+}
+
+func TestProtocolHelloLegacyServer(t *testing.T) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	node, _ := newServingNode(t, config)
+	defer node.Close()
+	raw_peer := newRawPeer(t)
+	node.AppendKnownPeerDer(raw_peer.RootCertificateDer(), raw_peer.HandshakeKeyCertificateDer())
+	listener, err := raw_peer.transport.Listen(raw_peer.NewServerTlsConf(noAbystPeer{}), &quic.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node.Dial(raw_peer.ID(), raw_peer.addr()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer ctxcancel()
+	connection, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.CloseWithError(0, "")
+	stream, err := connection.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := cbor.NewDecoder(stream)
+	encoder := cbor.NewEncoder(stream)
+	var handshake_1 ahmp.RawHS1
+	if err := decoder.Decode(&handshake_1); err != nil {
+		t.Fatal(err)
+	}
+	if handshake_1.Hello == nil || handshake_1.Hello.TryParse() != ahmp.LocalHello() {
+		t.Fatal("dialer did not send hello")
+	}
+
+	// a legacy server answers with the bare binding certificate.
+	encoder.Encode(raw_peer.AbyssBindingCertificate())
+	controller_id, _ := ann.TieBreak(raw_peer.ID(), node.ID())
+	if controller_id == raw_peer.ID() {
+		encoder.Encode(0)
+	} else {
+		var code int
+		decoder.Decode(&code)
+	}
+
+	peer := acceptPeer(t, node, raw_peer.ID(), time.Second*3)
+	if peer.ProtocolVersion() != 0 || peer.HasCapability(ahmp.CapRelay) {
+		t.Fatal("legacy peer should have no capabilities")
+	}
+}
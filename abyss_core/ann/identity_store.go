@@ -0,0 +1,219 @@
+package ann
+
+import (
+	"crypto/sha3"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+)
+
+// IdentityStore persists known peer identities across restarts.
+// AbyssPeerRegistry keeps the store in sync with its in-memory identities;
+// the store itself does not need to care about IssueTime ordering or expiry.
+// Implementations must be safe for concurrent use.
+type IdentityStore interface {
+	// LoadAll returns every stored identity. Entries that fail to load are
+	// skipped and reported in the error, along with the loaded identities.
+	LoadAll() ([]*sec.AbyssPeerIdentity, error)
+
+	// Save stores the identity, replacing any stored identity with the same id.
+	Save(identity *sec.AbyssPeerIdentity) error
+
+	// Delete removes the identity. Deleting an absent identity is not an error.
+	Delete(id string) error
+
+	// LoadRevocations returns every stored handshake key revocation,
+	// in the same manner as LoadAll.
+	LoadRevocations() ([]*sec.HandshakeKeyRevocation, error)
+
+	// SaveRevocation stores a verified handshake key revocation.
+	// Revocations are kept after the identity is deleted, so that
+	// a revoked handshake key is never accepted again.
+	SaveRevocation(revocation *sec.HandshakeKeyRevocation) error
+}
+
+// FileIdentityStore is the default IdentityStore, a directory of PEM files.
+// Each file holds the root certificate and the handshake key certificate of a peer.
+// File names are hex-encoded SHA3-256 of peer ids, as peer ids (base58) are
+// case-sensitive and some file systems are not.
+// Revocations are kept in the "revoked" subdirectory, named by the revoked
+// certificate hash.
+type FileIdentityStore struct {
+	dir string
+}
+
+const (
+	identityFileExt   = ".pem"
+	revocationDir     = "revoked"
+	revocationPemType = "ABYSS HANDSHAKE KEY REVOCATION"
+)
+
+// NewFileIdentityStore creates the directory if it does not exist.
+func NewFileIdentityStore(dir string) (*FileIdentityStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, revocationDir), 0o700); err != nil {
+		return nil, err
+	}
+	return &FileIdentityStore{dir: dir}, nil
+}
+
+func (s *FileIdentityStore) path(id string) string {
+	hash := sha3.Sum256([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(hash[:])+identityFileExt)
+}
+
+func (s *FileIdentityStore) LoadAll() ([]*sec.AbyssPeerIdentity, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*sec.AbyssPeerIdentity, 0, len(entries))
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), identityFileExt) {
+			continue
+		}
+		identity, err := s.load(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			errs = append(errs, errors.New(entry.Name()+": "+err.Error()))
+			continue
+		}
+		result = append(result, identity)
+	}
+	return result, errors.Join(errs...)
+}
+
+func (s *FileIdentityStore) load(path string) (*sec.AbyssPeerIdentity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	root_cert_block, rest := pem.Decode(data)
+	if root_cert_block == nil {
+		return nil, errors.New("no root certificate")
+	}
+	handshake_key_cert_block, _ := pem.Decode(rest)
+	if handshake_key_cert_block == nil {
+		return nil, errors.New("no handshake key certificate")
+	}
+	identity, err := sec.NewAbyssPeerIdentityFromDER(root_cert_block.Bytes, handshake_key_cert_block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if s.path(identity.ID()) != path {
+		return nil, errors.New("file name mismatch")
+	}
+	return identity, nil
+}
+
+// Save writes to a temporary file and renames it, so that
+// a crash never leaves a half-written identity.
+func (s *FileIdentityStore) Save(identity *sec.AbyssPeerIdentity) error {
+	return writeFileAtomic(s.dir, s.path(identity.ID()), []byte(identity.RootCertificate()+identity.HandshakeKeyCertificate()))
+}
+
+func writeFileAtomic(dir string, path string, data []byte) error {
+	file, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if c_err := file.Close(); err == nil {
+		err = c_err
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (s *FileIdentityStore) Delete(id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileIdentityStore) revocationPath(certificate_hash [32]byte) string {
+	return filepath.Join(s.dir, revocationDir, hex.EncodeToString(certificate_hash[:])+identityFileExt)
+}
+
+func (s *FileIdentityStore) LoadRevocations() ([]*sec.HandshakeKeyRevocation, error) {
+	dir := filepath.Join(s.dir, revocationDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*sec.HandshakeKeyRevocation, 0, len(entries))
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), identityFileExt) {
+			continue
+		}
+		revocation, err := s.loadRevocation(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			errs = append(errs, errors.New(entry.Name()+": "+err.Error()))
+			continue
+		}
+		result = append(result, revocation)
+	}
+	return result, errors.Join(errs...)
+}
+
+func (s *FileIdentityStore) loadRevocation(path string) (*sec.HandshakeKeyRevocation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != revocationPemType {
+		return nil, errors.New("no handshake key revocation")
+	}
+	certificate_hash, err := hex.DecodeString(block.Headers["Certificate-Hash"])
+	if err != nil || len(certificate_hash) != 32 {
+		return nil, errors.New("invalid certificate hash")
+	}
+	revoke_time, err := strconv.ParseInt(block.Headers["Revoke-Time"], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid revoke time")
+	}
+	revocation := &sec.HandshakeKeyRevocation{
+		ID:              block.Headers["ID"],
+		CertificateHash: [32]byte(certificate_hash),
+		RevokeTime:      time.Unix(revoke_time, 0),
+		Signature:       block.Bytes,
+	}
+	if s.revocationPath(revocation.CertificateHash) != path {
+		return nil, errors.New("file name mismatch")
+	}
+	return revocation, nil
+}
+
+func (s *FileIdentityStore) SaveRevocation(revocation *sec.HandshakeKeyRevocation) error {
+	data := pem.EncodeToMemory(&pem.Block{
+		Type: revocationPemType,
+		Headers: map[string]string{
+			"ID":               revocation.ID,
+			"Certificate-Hash": hex.EncodeToString(revocation.CertificateHash[:]),
+			"Revoke-Time":      strconv.FormatInt(revocation.RevokeTime.Unix(), 10),
+		},
+		Bytes: revocation.Signature,
+	})
+	return writeFileAtomic(s.dir, s.revocationPath(revocation.CertificateHash), data)
+}
@@ -0,0 +1,172 @@
+package ann_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+)
+
+func TestFileIdentityStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := ann.NewFileIdentityStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root_key, _ := sec.NewRootPrivateKey()
+	old_secret, _ := sec.NewAbyssRootSecrets(root_key)
+	time.Sleep(time.Millisecond * 1100) // certificate time has second precision.
+	new_secret, _ := sec.NewAbyssRootSecrets(root_key)
+	other_key, _ := sec.NewRootPrivateKey()
+	other_secret, _ := sec.NewAbyssRootSecrets(other_key)
+
+	// the registry keeps the newer handshake key, in memory and in the store.
+	config := ann.DefaultAbyssNodeConfig()
+	config.IdentityStore = store
+	node, _ := ann.NewAbyssNodeWithConfig(other_key, config)
+	if err := node.AppendKnownPeer(new_secret.RootCertificate(), new_secret.HandshakeKeyCertificate()); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.AppendKnownPeer(old_secret.RootCertificate(), old_secret.HandshakeKeyCertificate()); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.AppendKnownPeer(other_secret.RootCertificate(), other_secret.HandshakeKeyCertificate()); err != nil {
+		t.Fatal(err)
+	}
+	node.Close()
+
+	// broken files are skipped.
+	os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a certificate"), 0o600)
+
+	identities, err := store.LoadAll()
+	if err == nil {
+		t.Fatal("broken entry should be reported")
+	}
+	if len(identities) != 2 {
+		t.Fatal("unexpected number of identities: ", len(identities))
+	}
+	for _, identity := range identities {
+		if identity.ID() == new_secret.ID() && identity.HandshakeKeyCertificate() != new_secret.HandshakeKeyCertificate() {
+			t.Fatal("older handshake key overwrote the newer one")
+		}
+	}
+
+	if err := store.Delete(other_secret.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(other_secret.ID()); err != nil {
+		t.Fatal("deleting an absent identity should not fail: ", err)
+	}
+	os.Remove(filepath.Join(dir, "broken.pem"))
+
+	// expired identities are rejected, and purged from the store on load.
+	config.IdentityMaxAge = time.Millisecond
+	node, _ = ann.NewAbyssNodeWithConfig(other_key, config)
+	defer node.Close()
+	if identities, _ := store.LoadAll(); len(identities) != 0 {
+		t.Fatal("expired identity should be purged")
+	}
+	if err := node.AppendKnownPeer(new_secret.RootCertificate(), new_secret.HandshakeKeyCertificate()); !errors.Is(err, ann.ErrIdentityExpired) {
+		t.Fatal("expired identity should be rejected: ", err)
+	}
+}
+
+func TestKnownPeerPersistence(t *testing.T) {
+	store, err := ann.NewFileIdentityStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	node_B, _ := newServingNode(t, config)
+	defer node_B.Close()
+
+	config.IdentityStore = store
+	root_key_A, _ := sec.NewRootPrivateKey()
+	node_A, _ := ann.NewAbyssNodeWithConfig(root_key_A, config)
+	node_A.AppendKnownPeer(node_B.RootCertificate(), node_B.HandshakeKeyCertificate())
+	node_A.Close()
+
+	// restarted node remembers node_B.
+	node_A, _ = ann.NewAbyssNodeWithConfig(root_key_A, config)
+	if err := node_A.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go node_A.Serve()
+	defer node_A.Close()
+
+	node_B.AppendKnownPeer(node_A.RootCertificate(), node_A.HandshakeKeyCertificate())
+	if err := node_A.Dial(node_B.ID(), node_B.LocalAddrCandidates()[0]); err != nil {
+		t.Fatal(err)
+	}
+	acceptPeer(t, node_A, node_B.ID(), time.Second*3)
+
+	node_A.EraseKnownPeer(node_B.ID())
+	if identities, _ := store.LoadAll(); len(identities) != 0 {
+		t.Fatal("erased peer should be removed from the store")
+	}
+}
+
+func TestRevocationPersistence(t *testing.T) {
+	store, err := ann.NewFileIdentityStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	node_A, _ := newServingNode(t, config)
+	defer node_A.Close()
+
+	config.IdentityStore = store
+	root_key_B, _ := sec.NewRootPrivateKey()
+	node_B, _ := ann.NewAbyssNodeWithConfig(root_key_B, config)
+	if err := node_B.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go node_B.Serve()
+
+	introduce(node_A, node_B)
+	node_B.Dial(node_A.ID(), node_A.LocalAddrCandidates()[0])
+	acceptPeer(t, node_B, node_A.ID(), time.Second*3)
+	acceptPeer(t, node_A, node_B.ID(), time.Second*3)
+
+	old_cert := node_A.HandshakeKeyCertificateDer()
+	if _, err := node_A.RotateHandshakeKey(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node_A.RevokeHandshakeKey(old_cert); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 3)
+	for !errors.Is(node_B.AppendKnownPeerDer(node_A.RootCertificateDer(), old_cert), ann.ErrIdentityRevoked) {
+		if time.Now().After(deadline) {
+			t.Fatal("revocation not received")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	node_B.Close()
+
+	// a stale entry with the revoked key, e.g. from a failed delete, is purged on load.
+	old_identity, err := sec.NewAbyssPeerIdentityFromDER(node_A.RootCertificateDer(), old_cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(old_identity); err != nil {
+		t.Fatal(err)
+	}
+
+	// restarted node_B still rejects the revoked key.
+	node_B, _ = ann.NewAbyssNodeWithConfig(root_key_B, config)
+	defer node_B.Close()
+	if identities, _ := store.LoadAll(); len(identities) != 0 {
+		t.Fatal("identity with a revoked key should be purged")
+	}
+	if err := node_B.AppendKnownPeerDer(node_A.RootCertificateDer(), old_cert); !errors.Is(err, ann.ErrIdentityRevoked) {
+		t.Fatal("revoked key should be rejected after restart: ", err)
+	}
+}
@@ -0,0 +1,137 @@
+package ann
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+)
+
+// Key maintenance.
+//  1. handshake key rotation: a new handshake key certificate is announced to
+//     every directly connected peer (HKU). Peers that missed it can still
+//     dial with the previous key, until it is revoked.
+//  2. revocation: a root-signed revocation (HKR) is announced likewise.
+//     Receivers forget the revoked key, and never accept it again.
+//  3. TLS renewal: TLS certificates are valid for 7 days. They are renewed
+//     with the same key, tlsRenewMargin before expiry, while serving.
+
+const tlsRenewMargin = time.Hour * 24
+
+var ErrHandshakeKeyRejected = errors.New("handshake key update rejected")
+
+// RotateHandshakeKey issues a new handshake key of the configured type, and announces its certificate
+// to connected peers. It returns the new handshake key certificate (der).
+// Announcement failures are logged, not returned; the rotation is already done.
+func (n *AbyssNode) RotateHandshakeKey() ([]byte, error) {
+	handshake_key_cert_der, err := n.AbyssRootSecret.RotateHandshakeKeyTo(n.config.HandshakeKeyType)
+	if err != nil {
+		return nil, err
+	}
+	n.logger.Info().Msg("handshake key rotated")
+	n.announce(ahmp.HKU_T, &ahmp.RawHKU{HandshakeKeyCertificate: handshake_key_cert_der})
+	return handshake_key_cert_der, nil
+}
+
+// RevokeHandshakeKey revokes a previous handshake key, and announces the revocation
+// to connected peers. The current key cannot be revoked; rotate first.
+func (n *AbyssNode) RevokeHandshakeKey(handshake_key_cert_der []byte) (*sec.HandshakeKeyRevocation, error) {
+	revocation, err := n.AbyssRootSecret.RevokeHandshakeKey(handshake_key_cert_der)
+	if err != nil {
+		return nil, err
+	}
+	n.logger.Info().Msg("handshake key revoked")
+	n.announce(ahmp.HKR_T, ahmp.MakeRawHKR(revocation))
+	return revocation, nil
+}
+
+// announce sends a key maintenance message to every directly connected peer
+// that supports it, and waits for the answers.
+func (n *AbyssNode) announce(msg_type int, msg any) {
+	ctx, ctx_cancel := context.WithTimeout(n.service_ctx, n.config.HandshakeTimeout)
+	defer ctx_cancel()
+
+	var wg sync.WaitGroup
+	for _, peer := range n.registry.GetConnectedPeers() {
+		if peer.relay != nil || !peer.HasCapability(ahmp.CapHandshakeKeyUpdate) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var answer ahmp.RawHKA
+			err := n.requestControl(ctx, peer, msg_type, msg, ahmp.HKA_T, &answer)
+			if err == nil && !answer.Accepted {
+				err = errors.Join(ErrHandshakeKeyRejected, errors.New(answer.Text))
+			}
+			if err != nil {
+				n.logger.Warn().Str("id", peer.ID()).Int("type", msg_type).Err(err).Msg("key announcement failed")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// answerHandshakeKeyUpdate accepts a new handshake key certificate of the peer.
+// The certificate must be signed by the peer's root; an older one is ignored.
+func (n *AbyssNode) answerHandshakeKeyUpdate(peer *AbyssPeer, raw_msg *ahmp.RawHKU) *ahmp.RawHKA {
+	identity, err := sec.NewAbyssPeerIdentityFromDER(peer.RootCertificateDer(), raw_msg.HandshakeKeyCertificate)
+	if err != nil {
+		return &ahmp.RawHKA{Text: "invalid certificate"}
+	}
+	if err := n.registry.UpdatePeerIdentity(identity); err != nil {
+		return &ahmp.RawHKA{Text: err.Error()}
+	}
+	return &ahmp.RawHKA{Accepted: true}
+}
+
+// answerHandshakeKeyRevocation honors a revocation. As revocations are signed,
+// they are accepted from any peer, not only from the owner.
+func (n *AbyssNode) answerHandshakeKeyRevocation(raw_msg *ahmp.RawHKR) *ahmp.RawHKA {
+	revocation, err := raw_msg.TryParse()
+	if err != nil {
+		return &ahmp.RawHKA{Text: err.Error()}
+	}
+	if err := n.registry.RevokeHandshakeKey(revocation); err != nil {
+		return &ahmp.RawHKA{Text: err.Error()}
+	}
+	return &ahmp.RawHKA{Accepted: true}
+}
+
+// maintenanceRoutine rotates the handshake key and renews TLS certificates on schedule.
+// It runs while serving.
+func (n *AbyssNode) maintenanceRoutine() {
+	for {
+		next := n.TLSNotAfter().Add(-tlsRenewMargin)
+		if n.config.HandshakeKeyRotationPeriod > 0 {
+			if rotate_at := n.IssueTime().Add(n.config.HandshakeKeyRotationPeriod); rotate_at.Before(next) {
+				next = rotate_at
+			}
+		}
+
+		// a failed renewal is retried, without spinning.
+		timer := time.NewTimer(max(time.Until(next), time.Second))
+		select {
+		case <-n.service_ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		if !now.Before(n.TLSNotAfter().Add(-tlsRenewMargin)) {
+			if err := n.RenewTLSIdentity(n.TLSIdentity); err != nil {
+				n.logger.Error().Err(err).Msg("failed to renew TLS certificate")
+			}
+		}
+		if n.config.HandshakeKeyRotationPeriod > 0 && !now.Before(n.IssueTime().Add(n.config.HandshakeKeyRotationPeriod)) {
+			if _, err := n.RotateHandshakeKey(); err != nil {
+				n.logger.Error().Err(err).Msg("failed to rotate handshake key")
+			}
+		}
+	}
+}
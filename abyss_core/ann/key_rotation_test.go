@@ -0,0 +1,202 @@
+package ann_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+	"github.com/quic-go/quic-go"
+)
+
+func TestHandshakeKeyRotation(t *testing.T) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	node_A, _ := newServingNode(t, config)
+	defer node_A.Close()
+	node_B, _ := newServingNode(t, config)
+	defer node_B.Close()
+
+	introduce(node_A, node_B)
+	node_B.Dial(node_A.ID(), node_A.LocalAddrCandidates()[0])
+	peer_A := acceptPeer(t, node_B, node_A.ID(), time.Second*3)
+	peer_B := acceptPeer(t, node_A, node_B.ID(), time.Second*3)
+
+	old_cert := node_A.HandshakeKeyCertificateDer()
+	if _, err := node_A.RotateHandshakeKey(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node_A.RevokeHandshakeKey(old_cert); err != nil {
+		t.Fatal(err)
+	}
+
+	// the revoked key is never accepted again.
+	if err := node_B.AppendKnownPeerDer(node_A.RootCertificateDer(), old_cert); !errors.Is(err, ann.ErrIdentityRevoked) {
+		t.Fatal("revoked key should be rejected: ", err)
+	}
+
+	// node_A no longer decrypts with the old key; redialing succeeds only with the announced one.
+	peer_A.Close()
+	peer_B.Close()
+	if err := node_B.Dial(node_A.ID(), node_A.LocalAddrCandidates()[0]); err != nil {
+		t.Fatal(err)
+	}
+	acceptPeer(t, node_B, node_A.ID(), time.Second*3)
+	acceptPeer(t, node_A, node_B.ID(), time.Second*3)
+}
+
+func TestScheduledHandshakeKeyRotation(t *testing.T) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	config.HandshakeKeyRotationPeriod = time.Second * 2
+	node_A, _ := newServingNode(t, config)
+	defer node_A.Close()
+
+	old_cert := node_A.HandshakeKeyCertificateDer()
+	deadline := time.Now().Add(time.Second * 5)
+	for bytes.Equal(old_cert, node_A.HandshakeKeyCertificateDer()) {
+		if time.Now().After(deadline) {
+			t.Fatal("handshake key not rotated")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+func TestMixedHandshakeKeyTypes(t *testing.T) {
+	legacy_config := ann.DefaultAbyssNodeConfig()
+	legacy_config.BindAddr = netip_loopback
+	node_A, _ := newServingNode(t, legacy_config)
+	defer node_A.Close()
+
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	config.HandshakeKeyType = sec.HandshakeKeyX25519
+	node_B, _ := newServingNode(t, config)
+	defer node_B.Close()
+	node_C, _ := newServingNode(t, config)
+	defer node_C.Close()
+
+	introduce(node_A, node_B)
+	introduce(node_A, node_C)
+
+	// RSA to X25519, and X25519 to RSA.
+	node_A.Dial(node_B.ID(), node_B.LocalAddrCandidates()[0])
+	acceptPeer(t, node_A, node_B.ID(), time.Second*3)
+	acceptPeer(t, node_B, node_A.ID(), time.Second*3)
+	node_C.Dial(node_A.ID(), node_A.LocalAddrCandidates()[0])
+	acceptPeer(t, node_A, node_C.ID(), time.Second*3)
+	acceptPeer(t, node_C, node_A.ID(), time.Second*3)
+}
+
+// rawHS1Legacy is RawHS1 of a peer without HPKE support.
+type rawHS1Legacy struct {
+	EncryptedCertificate []byte
+	EncryptedSecret      []byte
+}
+
+// TestLegacyDialer dials a node with the default config from a peer that only speaks RSA-OAEP.
+func TestLegacyDialer(t *testing.T) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	node, _ := newServingNode(t, config)
+	defer node.Close()
+
+	// a legacy peer can only encrypt to an RSA handshake key.
+	handshake_key_cert, err := x509.ParseCertificate(node.HandshakeKeyCertificateDer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := handshake_key_cert.PublicKey.(*rsa.PublicKey); !ok {
+		t.Fatal("default handshake key is not RSA")
+	}
+	node_view, _ := sec.NewAbyssPeerIdentityFromDER(node.RootCertificateDer(), node.HandshakeKeyCertificateDer())
+	if node_view.HandshakeSuite() != sec.HandshakeSuiteRSAOAEP {
+		t.Fatal("default handshake suite is not RSA-OAEP")
+	}
+
+	raw_peer, connection := connectLegacyPeer(t, node)
+	defer connection.CloseWithError(0, "")
+
+	peer := acceptPeer(t, node, raw_peer.ID(), time.Second*3)
+	if peer.ProtocolVersion() != 0 {
+		t.Fatal("legacy peer should have no hello")
+	}
+}
+
+// TestLegacyPeerControl checks that a peer without capabilities is never sent control streams,
+// which it would not accept.
+func TestLegacyPeerControl(t *testing.T) {
+	config := ann.DefaultAbyssNodeConfig()
+	config.BindAddr = netip_loopback
+	node, _ := newServingNode(t, config)
+	defer node.Close()
+
+	raw_peer, connection := connectLegacyPeer(t, node)
+	defer connection.CloseWithError(0, "")
+	peer := acceptPeer(t, node, raw_peer.ID(), time.Second*3)
+	if peer.HasCapability(ahmp.CapHandshakeKeyUpdate) || peer.HasCapability(ahmp.CapNATControl) {
+		t.Fatal("legacy peer should have no capabilities")
+	}
+
+	begin := time.Now()
+	if _, err := node.RotateHandshakeKey(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed > config.HandshakeTimeout/2 {
+		t.Fatal("key announcement waited for the legacy peer: ", elapsed)
+	}
+	if _, err := node.QueryObservedAddr(context.Background(), raw_peer.ID()); !errors.Is(err, ann.ErrNotSupportedByPeer) {
+		t.Fatal("observed address query to a legacy peer: ", err)
+	}
+	if err := node.DialRelayed(raw_peer.ID(), "unknown"); !errors.Is(err, ann.ErrNotSupportedByPeer) {
+		t.Fatal("relay through a legacy peer: ", err)
+	}
+
+	accept_ctx, accept_ctx_cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer accept_ctx_cancel()
+	if _, err := connection.AcceptStream(accept_ctx); err == nil {
+		t.Fatal("control stream opened to a legacy peer")
+	}
+}
+
+// connectLegacyPeer completes the handshake of a raw peer that sends neither a suite nor a hello.
+func connectLegacyPeer(t *testing.T, node *ann.AbyssNode) (*rawPeer, quic.Connection) {
+	node_view, err := sec.NewAbyssPeerIdentityFromDER(node.RootCertificateDer(), node.HandshakeKeyCertificateDer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw_peer := newRawPeer(t)
+	node.AppendKnownPeerDer(raw_peer.RootCertificateDer(), raw_peer.HandshakeKeyCertificateDer())
+	encrypted_cert, encrypted_secret, err := node_view.EncryptHandshake(raw_peer.AbyssBindingCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection, stream := raw_peer.dial(t, node)
+	encoder := cbor.NewEncoder(stream)
+	decoder := cbor.NewDecoder(stream)
+	encoder.Encode(&rawHS1Legacy{encrypted_cert, encrypted_secret})
+
+	// without hello, the node answers with the bare binding certificate.
+	var handshake_2 []byte
+	if err := decoder.Decode(&handshake_2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x509.ParseCertificate(handshake_2); err != nil {
+		t.Fatal(err)
+	}
+	controller_id, _ := ann.TieBreak(raw_peer.ID(), node.ID())
+	if controller_id == raw_peer.ID() {
+		encoder.Encode(0)
+	} else {
+		var code int
+		decoder.Decode(&code)
+	}
+	return raw_peer, connection
+}
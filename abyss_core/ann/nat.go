@@ -0,0 +1,432 @@
+package ann
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	"github.com/kadmila/Abyss-Browser/abyss_core/fw"
+	"github.com/quic-go/quic-go"
+)
+
+// NAT traversal.
+// Besides the AHMP stream, connected peers exchange control streams.
+// Each control stream carries one request and one reply (see ahmp.OBQ_T).
+// Key maintenance messages also use control streams (see key_rotation.go).
+//  1. observed address query: the peer replies the address it sees us at (reflexive address).
+//     A reflexive address is advertised only after reflexiveConfirmations peers report it,
+//     so that a single peer cannot make us advertise an arbitrary address.
+//  2. hole punching: a peer connected with both sides (rendezvous) relays
+//     address candidates of each side to the other, and both sides dial simultaneously.
+//     Outbound packets of each side open its own NAT for the other.
+//     Acting as a rendezvous is subject to RendezvousPolicy and the firewall.
+// The messages are AHMP-encoded, but they are not sent on the AHMP stream,
+// which carries application messages read by the owner of the peer.
+
+const (
+	reflexiveConfirmations = 2  // observers that must report the same address
+	maxReflexiveCandidates = 4  // the oldest is replaced, as NAT mappings change
+	maxObservedAddrs       = 16 // observers remembered
+)
+
+var (
+	ErrPeerNotConnected   = errors.New("peer not connected")
+	ErrHolePunchRejected  = errors.New("hole punch rejected")
+	ErrUnexpectedResponse = errors.New("unexpected control stream response")
+	ErrRelayedPeer        = errors.New("not available through relay")
+	ErrNotSupportedByPeer = errors.New("not supported by peer")
+)
+
+// controlCapabilities is the capability a peer must announce to be sent each control request.
+// A legacy peer never accepts control streams, so a request would only wait for the timeout.
+var controlCapabilities = map[int]ahmp.Capability{
+	ahmp.OBQ_T: ahmp.CapNATControl,
+	ahmp.HPR_T: ahmp.CapNATControl,
+	ahmp.HPN_T: ahmp.CapNATControl,
+	ahmp.RLR_T: ahmp.CapRelay,
+	ahmp.RLN_T: ahmp.CapRelay,
+	ahmp.HKU_T: ahmp.CapHandshakeKeyUpdate,
+	ahmp.HKR_T: ahmp.CapHandshakeKeyUpdate,
+}
+
+// controlRoutine accepts control streams of a connected peer until the connection ends.
+// Relayed peers have no control streams.
+func (n *AbyssNode) controlRoutine(peer *AbyssPeer) {
+	connection, ok := peer.quicConnection()
+	if !ok {
+		return
+	}
+	for {
+		stream, err := connection.AcceptStream(connection.Context())
+		if err != nil {
+			return
+		}
+		if !n.tryStartWorker(func() { n.handleControlStream(peer, stream) }) {
+			stream.CancelRead(0)
+			stream.CancelWrite(0)
+			return
+		}
+	}
+}
+
+func (n *AbyssNode) handleControlStream(peer *AbyssPeer, stream quic.Stream) {
+	handed_off := false // the stream now carries a relayed session.
+	defer func() {
+		if !handed_off {
+			stream.CancelRead(0)
+			stream.Close()
+		}
+	}()
+	// the rendezvous side waits for the target's answer within HandshakeTimeout.
+	stream.SetDeadline(time.Now().Add(n.config.HandshakeTimeout * 2))
+
+	decoder := ahmp.NewDecoder(stream)
+	var msg_type int
+	if err := decoder.Decode(&msg_type); err != nil {
+		return
+	}
+
+	var err error
+	switch msg_type {
+	case ahmp.OBQ_T:
+		var raw_msg ahmp.RawOBQ
+		if err = decoder.Decode(&raw_msg); err != nil {
+			break
+		}
+		err = writeControl(stream, ahmp.OBR_T, &ahmp.RawOBR{ObservedAddr: peer.remote_addr.String()})
+	case ahmp.HPR_T:
+		var raw_msg ahmp.RawHPR
+		if err = decoder.Decode(&raw_msg); err != nil {
+			break
+		}
+		var request *ahmp.HPR
+		if request, err = raw_msg.TryParse(); err != nil {
+			break
+		}
+		err = writeControl(stream, ahmp.HPA_T, n.rendezvous(peer, request))
+	case ahmp.HPN_T:
+		var raw_msg ahmp.RawHPN
+		if err = decoder.Decode(&raw_msg); err != nil {
+			break
+		}
+		var notify *ahmp.HPN
+		if notify, err = raw_msg.TryParse(); err != nil {
+			break
+		}
+		answer := n.answerHolePunch(notify)
+		if err = writeControl(stream, ahmp.HPA_T, answer); err != nil {
+			break
+		}
+		if answer.Accepted {
+			n.punch(notify.SourceID, notify.Candidates)
+		}
+	case ahmp.RLR_T:
+		var raw_msg ahmp.RawRLR
+		if err = decoder.Decode(&raw_msg); err != nil {
+			break
+		}
+		err = n.serveRelay(peer, stream, io.MultiReader(decoder.Buffered(), stream), raw_msg.TargetID)
+	case ahmp.RLN_T:
+		var raw_msg ahmp.RawRLN
+		if err = decoder.Decode(&raw_msg); err != nil {
+			break
+		}
+		answer := n.answerRelay(raw_msg.SourceID)
+		if err = writeControl(stream, ahmp.RLA_T, answer); err != nil {
+			break
+		}
+		if answer.Accepted {
+			handed_off = true
+			n.relayServeRoutine(peer, stream, io.MultiReader(decoder.Buffered(), stream))
+		}
+	case ahmp.HKU_T:
+		var raw_msg ahmp.RawHKU
+		if err = decoder.Decode(&raw_msg); err != nil {
+			break
+		}
+		err = writeControl(stream, ahmp.HKA_T, n.answerHandshakeKeyUpdate(peer, &raw_msg))
+	case ahmp.HKR_T:
+		var raw_msg ahmp.RawHKR
+		if err = decoder.Decode(&raw_msg); err != nil {
+			break
+		}
+		err = writeControl(stream, ahmp.HKA_T, n.answerHandshakeKeyRevocation(&raw_msg))
+	default:
+		// a newer peer may send requests we do not know; the stream is closed without reply.
+		if err = ahmp.SkipMessage(decoder); err == nil {
+			n.logger.Debug().Str("id", peer.ID()).Int("type", msg_type).Msg("unsupported control message")
+		}
+	}
+	if err != nil {
+		n.logger.Debug().Str("id", peer.ID()).Int("type", msg_type).Err(err).Msg("control stream failed")
+	}
+}
+
+func writeControl(stream quic.Stream, msg_type int, body any) error {
+	encoder := cbor.NewEncoder(stream)
+	if err := encoder.Encode(msg_type); err != nil {
+		return err
+	}
+	return encoder.Encode(body)
+}
+
+// requestControl opens a control stream, sends a request, and waits for the reply.
+func (n *AbyssNode) requestControl(ctx context.Context, peer *AbyssPeer, req_type int, req any, resp_type int, resp any) error {
+	stream, _, err := n.openControl(ctx, peer, req_type, req, resp_type, resp)
+	if err != nil {
+		return err
+	}
+	stream.CancelRead(0)
+	stream.Close()
+	return nil
+}
+
+// openControl opens a control stream, sends a request, and waits for the reply.
+// The stream is left open; the returned reader continues the stream after the reply.
+// It fails with ErrNotSupportedByPeer, without opening a stream, if the peer lacks the capability.
+func (n *AbyssNode) openControl(ctx context.Context, peer *AbyssPeer, req_type int, req any, resp_type int, resp any) (quic.Stream, io.Reader, error) {
+	if !peer.HasCapability(controlCapabilities[req_type]) {
+		return nil, nil, ErrNotSupportedByPeer
+	}
+	connection, ok := peer.quicConnection()
+	if !ok {
+		return nil, nil, ErrRelayedPeer
+	}
+	stream, err := connection.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	abort := func(err error) (quic.Stream, io.Reader, error) {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		return nil, nil, err
+	}
+
+	// the stream does not watch ctx after it is opened.
+	stop := context.AfterFunc(ctx, func() {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+	})
+	defer stop()
+
+	if err := writeControl(stream, req_type, req); err != nil {
+		return abort(err)
+	}
+	decoder := ahmp.NewDecoder(stream)
+	var msg_type int
+	if err := decoder.Decode(&msg_type); err != nil {
+		return abort(err)
+	}
+	if msg_type != resp_type {
+		return abort(ErrUnexpectedResponse)
+	}
+	if err := decoder.Decode(resp); err != nil {
+		return abort(err)
+	}
+	if !stop() {
+		return abort(ctx.Err())
+	}
+	return stream, io.MultiReader(decoder.Buffered(), stream), nil
+}
+
+// QueryObservedAddr asks a connected peer for the address it sees this node at.
+// The address is appended to LocalAddrCandidates as a reflexive address,
+// once reflexiveConfirmations peers have reported it.
+func (n *AbyssNode) QueryObservedAddr(ctx context.Context, peer_id string) (netip.AddrPort, error) {
+	peer, ok := n.registry.GetConnectedPeer(peer_id)
+	if !ok {
+		return netip.AddrPort{}, ErrPeerNotConnected
+	}
+
+	var raw_msg ahmp.RawOBR
+	if err := n.requestControl(ctx, peer, ahmp.OBQ_T, &ahmp.RawOBQ{}, ahmp.OBR_T, &raw_msg); err != nil {
+		return netip.AddrPort{}, err
+	}
+	reply, err := raw_msg.TryParse()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	addr := netip.AddrPortFrom(reply.ObservedAddr.Addr().Unmap(), reply.ObservedAddr.Port())
+	if n.reportObservedAddr(peer_id, addr) {
+		n.logger.Info().Str("reflexive_addr", addr.String()).Str("observer", peer_id).Msg("reflexive address discovered")
+	}
+	return addr, nil
+}
+
+// reportObservedAddr records the latest address each observer reported.
+// It returns true if the address became a reflexive candidate.
+func (n *AbyssNode) reportObservedAddr(observer_id string, addr netip.AddrPort) bool {
+	n.addr_mtx.Lock()
+	defer n.addr_mtx.Unlock()
+
+	if _, ok := n.observed_addrs[observer_id]; !ok && len(n.observed_addrs) >= maxObservedAddrs {
+		for id := range n.observed_addrs {
+			delete(n.observed_addrs, id)
+			break
+		}
+	}
+	n.observed_addrs[observer_id] = addr
+
+	if slices.Contains(n.local_addr_candidates, addr) {
+		return false
+	}
+	confirmations := 0
+	for _, observed := range n.observed_addrs {
+		if observed == addr {
+			confirmations++
+		}
+	}
+	if confirmations < reflexiveConfirmations {
+		return false
+	}
+
+	candidates := slices.Clone(n.local_addr_candidates)
+	if n.reflexive_count >= maxReflexiveCandidates {
+		oldest := len(candidates) - n.reflexive_count
+		candidates = slices.Delete(candidates, oldest, oldest+1)
+		n.reflexive_count--
+	}
+	n.local_addr_candidates = append(candidates, addr)
+	n.reflexive_count++
+	return true
+}
+
+// holePunchCandidates returns the local address candidates to send in a hole punch,
+// reflexive addresses first, within ahmp.MaxAddrCandidates.
+func (n *AbyssNode) holePunchCandidates() []netip.AddrPort {
+	n.addr_mtx.Lock()
+	defer n.addr_mtx.Unlock()
+
+	interfaces := len(n.local_addr_candidates) - n.reflexive_count
+	result := slices.Concat(n.local_addr_candidates[interfaces:], n.local_addr_candidates[:interfaces])
+	return result[:min(len(result), ahmp.MaxAddrCandidates)]
+}
+
+// RequestHolePunch asks the rendezvous peer, which must be connected with both
+// this node and the target, to coordinate simultaneous dialing with the target.
+// It returns after this node starts dialing; the result arrives at Accept().
+func (n *AbyssNode) RequestHolePunch(ctx context.Context, rendezvous_id string, target_id string) error {
+	if _, err := n.registry.GetPeerIdentityIfAcceptable(target_id); err != nil {
+		return err
+	}
+	rendezvous, ok := n.registry.GetConnectedPeer(rendezvous_id)
+	if !ok {
+		return ErrPeerNotConnected
+	}
+
+	var raw_msg ahmp.RawHPA
+	if err := n.requestControl(ctx, rendezvous, ahmp.HPR_T, &ahmp.RawHPR{
+		TargetID:   target_id,
+		Candidates: ahmp.FormatAddrPorts(n.holePunchCandidates()),
+	}, ahmp.HPA_T, &raw_msg); err != nil {
+		return err
+	}
+	answer, err := raw_msg.TryParse()
+	if err != nil {
+		return err
+	}
+	if !answer.Accepted {
+		return errors.Join(ErrHolePunchRejected, errors.New(answer.Text))
+	}
+	n.punch(target_id, answer.Candidates)
+	return nil
+}
+
+// rendezvous relays a hole punch request to the target, and the target's answer back.
+// The observed address of each side is put first, as it is the most likely to pass a NAT.
+func (n *AbyssNode) rendezvous(source *AbyssPeer, request *ahmp.HPR) *ahmp.RawHPA {
+	policy := n.rendezvous_policy.Load()
+	if !policy.Enabled {
+		return &ahmp.RawHPA{Text: "rendezvous disabled"}
+	}
+	if n.firewall.CheckPeer(fw.Inbound, source.ID()) != nil || n.firewall.CheckPeer(fw.Outbound, request.TargetID) != nil {
+		return &ahmp.RawHPA{Text: "rejected"}
+	}
+	target, ok := n.registry.GetConnectedPeer(request.TargetID)
+	if !ok {
+		return &ahmp.RawHPA{Text: "target not connected"}
+	}
+	if !n.acquireRendezvousSession(policy) {
+		return &ahmp.RawHPA{Text: "too many rendezvous sessions"}
+	}
+	defer n.rendezvous_sessions.Add(-1)
+
+	ctx, ctx_cancel := context.WithTimeout(n.service_ctx, n.config.HandshakeTimeout)
+	defer ctx_cancel()
+
+	var raw_msg ahmp.RawHPA
+	if err := n.requestControl(ctx, target, ahmp.HPN_T, &ahmp.RawHPN{
+		SourceID:   source.ID(),
+		Candidates: ahmp.FormatAddrPorts(withObservedAddr(source.remote_addr, request.Candidates)),
+	}, ahmp.HPA_T, &raw_msg); err != nil {
+		return &ahmp.RawHPA{Text: "target unreachable"}
+	}
+	answer, err := raw_msg.TryParse()
+	if err != nil {
+		return &ahmp.RawHPA{Text: "invalid answer from target"}
+	}
+	if !answer.Accepted {
+		return &raw_msg
+	}
+	return &ahmp.RawHPA{
+		Accepted:   true,
+		Candidates: ahmp.FormatAddrPorts(withObservedAddr(target.remote_addr, answer.Candidates)),
+		Text:       answer.Text,
+	}
+}
+
+// answerHolePunch decides whether to join a hole punch notified by a rendezvous peer.
+func (n *AbyssNode) answerHolePunch(notify *ahmp.HPN) *ahmp.RawHPA {
+	if err := n.firewall.CheckPeer(fw.Outbound, notify.SourceID); err != nil {
+		return &ahmp.RawHPA{Text: "rejected"}
+	}
+	if _, err := n.registry.GetPeerIdentityIfAcceptable(notify.SourceID); err != nil {
+		return &ahmp.RawHPA{Text: err.Error()}
+	}
+	return &ahmp.RawHPA{
+		Accepted:   true,
+		Candidates: ahmp.FormatAddrPorts(n.holePunchCandidates()),
+	}
+}
+
+// punch dials every candidate at once. Redundant connections are resolved
+// by the handshake, as in ordinary simultaneous dialing.
+func (n *AbyssNode) punch(id string, candidates []netip.AddrPort) {
+	for _, addr := range candidates {
+		if err := n.Dial(id, addr); err != nil {
+			n.logger.Debug().Str("id", id).Str("remote_addr", addr.String()).Err(err).Msg("hole punch dial failed")
+		}
+	}
+}
+
+func (n *AbyssNode) acquireRendezvousSession(policy *RendezvousPolicy) bool {
+	for {
+		count := n.rendezvous_sessions.Load()
+		if policy.MaxSessions > 0 && int(count) >= policy.MaxSessions {
+			return false
+		}
+		if n.rendezvous_sessions.CompareAndSwap(count, count+1) {
+			return true
+		}
+	}
+}
+
+// withObservedAddr puts observed first, within ahmp.MaxAddrCandidates.
+func withObservedAddr(observed netip.AddrPort, candidates []netip.AddrPort) []netip.AddrPort {
+	result := make([]netip.AddrPort, 0, ahmp.MaxAddrCandidates)
+	result = append(result, observed)
+	for _, candidate := range candidates {
+		if len(result) == ahmp.MaxAddrCandidates {
+			break
+		}
+		if candidate != observed {
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+package ann
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// NatConn is a net.PacketConn wrapper that simulates a port-restricted cone NAT.
+// Outbound packets leave from a separate external socket, so that peers observe
+// the external port, not the local one. Packets to the local port are never read.
+// An inbound packet to the external port is dropped, unless a packet was sent
+// to its source address within the mapping timeout. This is a debug tool for hole punching.
+type NatConn struct {
+	// The underlying connection, which is only closed.
+	net.PacketConn
+
+	external net.PacketConn
+
+	mtx      sync.Mutex
+	mappings map[netip.AddrPort]time.Time // remote address -> last outbound packet
+
+	timeout time.Duration
+}
+
+// NewNatConn opens the external socket on the IP address of original.
+func NewNatConn(original net.PacketConn, timeout time.Duration) (*NatConn, error) {
+	local_addr, ok := original.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, errors.New("NatConn requires a UDP connection")
+	}
+	external, err := net.ListenUDP(local_addr.Network(), &net.UDPAddr{IP: local_addr.IP, Zone: local_addr.Zone})
+	if err != nil {
+		return nil, err
+	}
+	return &NatConn{
+		PacketConn: original,
+		external:   external,
+		mappings:   make(map[netip.AddrPort]time.Time),
+		timeout:    timeout,
+	}, nil
+}
+
+// ExternalAddr is the address peers observe this connection at.
+func (c *NatConn) ExternalAddr() net.Addr {
+	return c.external.LocalAddr()
+}
+
+func natKey(addr net.Addr) (netip.AddrPort, bool) {
+	udp_addr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	addr_port := udp_addr.AddrPort()
+	return netip.AddrPortFrom(addr_port.Addr().Unmap(), addr_port.Port()), true
+}
+
+func (c *NatConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if key, ok := natKey(addr); ok {
+		c.mtx.Lock()
+		c.mappings[key] = time.Now()
+		c.mtx.Unlock()
+	}
+	return c.external.WriteTo(p, addr)
+}
+
+func (c *NatConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.external.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+		if c.isMapped(addr) {
+			return n, addr, nil
+		}
+		// filtered; silently dropped.
+	}
+}
+
+func (c *NatConn) SetDeadline(t time.Time) error {
+	return c.external.SetDeadline(t)
+}
+
+func (c *NatConn) SetReadDeadline(t time.Time) error {
+	return c.external.SetReadDeadline(t)
+}
+
+func (c *NatConn) SetWriteDeadline(t time.Time) error {
+	return c.external.SetWriteDeadline(t)
+}
+
+func (c *NatConn) Close() error {
+	return errors.Join(c.external.Close(), c.PacketConn.Close())
+}
+
+func (c *NatConn) isMapped(addr net.Addr) bool {
+	key, ok := natKey(addr)
+	if !ok {
+		return false
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	last_sent, ok := c.mappings[key]
+	if !ok {
+		return false
+	}
+	if time.Since(last_sent) > c.timeout {
+		delete(c.mappings, key)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package ann_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ani"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+)
+
+func introduce(a *ann.AbyssNode, b *ann.AbyssNode) {
+	a.AppendKnownPeer(b.RootCertificate(), b.HandshakeKeyCertificate())
+	b.AppendKnownPeer(a.RootCertificate(), a.HandshakeKeyCertificate())
+}
+
+// acceptPeer waits for the peer, skipping handshake errors.
+func acceptPeer(t testing.TB, node *ann.AbyssNode, id string, timeout time.Duration) ani.IAbyssPeer {
+	t.Helper()
+	ctx, ctxcancel := context.WithTimeout(context.Background(), timeout)
+	defer ctxcancel()
+	for {
+		peer, err := node.Accept(ctx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				t.Fatal("accept timeout")
+			}
+			continue
+		}
+		if peer.ID() == id {
+			return peer
+		}
+	}
+}
+
+func TestHolePunch(t *testing.T) {
+	rendezvous_config := ann.DefaultAbyssNodeConfig()
+	rendezvous_config.BindAddr = netip_loopback
+	node_C, _ := newServingNode(t, rendezvous_config)
+	defer node_C.Close()
+	node_E, _ := newServingNode(t, rendezvous_config) // second observer of A
+	defer node_E.Close()
+
+	nat_config := rendezvous_config
+	nat_config.HandshakeTimeout = time.Second * 2
+	nat_config.SimulatedNAT = &ann.SimulatedNAT{}
+	node_A, _ := newServingNode(t, nat_config)
+	defer node_A.Close()
+	node_B, _ := newServingNode(t, nat_config)
+	defer node_B.Close()
+	node_D, _ := newServingNode(t, nat_config)
+	defer node_D.Close()
+
+	introduce(node_A, node_B)
+	introduce(node_D, node_B)
+	introduce(node_A, node_D)
+	for _, node := range []*ann.AbyssNode{node_A, node_B} {
+		introduce(node, node_C)
+		node.Dial(node_C.ID(), node_C.LocalAddrCandidates()[0])
+		acceptPeer(t, node, node_C.ID(), time.Second*3)
+		acceptPeer(t, node_C, node.ID(), time.Second*3)
+	}
+	introduce(node_A, node_E)
+	node_A.Dial(node_E.ID(), node_E.LocalAddrCandidates()[0])
+	acceptPeer(t, node_A, node_E.ID(), time.Second*3)
+	acceptPeer(t, node_E, node_A.ID(), time.Second*3)
+
+	// direct dialing is filtered by the NAT.
+	node_D.Dial(node_B.ID(), node_B.LocalAddrCandidates()[0])
+	ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*5)
+	if peer, err := node_D.Accept(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("direct dial through NAT should fail: ", peer, err)
+	}
+	ctxcancel()
+
+	// reflexive address: the NAT maps another port, which is advertised once a second peer reports it.
+	ctx, ctxcancel = context.WithTimeout(context.Background(), time.Second*3)
+	defer ctxcancel()
+	observed, err := node_A.QueryObservedAddr(ctx, node_C.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if observed.Addr() != netip_loopback.Addr() || observed.Port() == node_A.LocalAddrCandidates()[0].Port() {
+		t.Fatal("observed address is not the NAT mapping: ", observed)
+	}
+	if slices.Contains(node_A.LocalAddrCandidates(), observed) {
+		t.Fatal("address reported by a single peer should not be advertised")
+	}
+	if observed_E, err := node_A.QueryObservedAddr(ctx, node_E.ID()); err != nil || observed_E != observed {
+		t.Fatal("observed address mismatch: ", observed_E, err)
+	}
+	if candidates := node_A.LocalAddrCandidates(); candidates[len(candidates)-1] != observed {
+		t.Fatal("confirmed reflexive address should be advertised: ", candidates)
+	}
+	if _, err := node_A.QueryObservedAddr(ctx, node_B.ID()); !errors.Is(err, ann.ErrPeerNotConnected) {
+		t.Fatal("query to unconnected peer should fail: ", err)
+	}
+
+	// hole punching through the rendezvous, which is disabled by default.
+	if err := node_A.RequestHolePunch(ctx, node_C.ID(), node_B.ID()); !errors.Is(err, ann.ErrHolePunchRejected) {
+		t.Fatal("hole punch through a disabled rendezvous should be rejected: ", err)
+	}
+	node_C.SetRendezvousPolicy(ann.RendezvousPolicy{Enabled: true, MaxSessions: 1})
+	if err := node_A.RequestHolePunch(ctx, node_C.ID(), node_D.ID()); !errors.Is(err, ann.ErrHolePunchRejected) {
+		t.Fatal("hole punch to a peer not connected with rendezvous should be rejected: ", err)
+	}
+	if err := node_A.RequestHolePunch(ctx, node_C.ID(), node_B.ID()); err != nil {
+		t.Fatal(err)
+	}
+	acceptPeer(t, node_A, node_B.ID(), time.Second*5)
+	acceptPeer(t, node_B, node_A.ID(), time.Second*5)
+}
@@ -7,20 +7,31 @@ import (
 	"sync/atomic"
 
 	"github.com/fxamacker/cbor/v2"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
 	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
 	"github.com/quic-go/quic-go"
 )
 
+// peerConn is the transport of an abyss peer.
+// It is quic.Connection for direct peers, and *relayConn for relayed peers.
+type peerConn interface {
+	Context() context.Context
+	CloseWithError(quic.ApplicationErrorCode, string) error
+}
+
 type AbyssPeer struct {
 	*sec.AbyssPeerIdentity
 	origin          *AbyssNode
 	internal_id     uint64
 	client_tls_cert *x509.Certificate // this is stupid
 
-	connection   quic.Connection
-	remote_addr  netip.AddrPort
+	connection   peerConn
+	remote_addr  netip.AddrPort // the relay's address for relayed peers.
+	relay        *AbyssPeer     // nil for direct peers.
 	ahmp_encoder *cbor.Encoder
 	ahmp_decoder *cbor.Decoder
+	hello        ahmp.Hello // set in handshake 2
+	is_dialing   bool       // the local node dialed; the firewall checks it as outbound.
 
 	// abyst connections
 
@@ -32,12 +43,52 @@ func (p *AbyssPeer) RemoteAddr() netip.AddrPort {
 	return p.remote_addr
 }
 
+// RelayID returns the id of the relay peer, or an empty string for direct peers.
+func (p *AbyssPeer) RelayID() string {
+	if p.relay == nil {
+		return ""
+	}
+	return p.relay.ID()
+}
+
+// quicConnection returns the QUIC connection of a direct peer.
+func (p *AbyssPeer) quicConnection() (quic.Connection, bool) {
+	connection, ok := p.connection.(quic.Connection)
+	return connection, ok
+}
+
+// Hello returns the protocol version and capabilities the peer announced.
+func (p *AbyssPeer) Hello() ahmp.Hello {
+	return p.hello
+}
+
+func (p *AbyssPeer) ProtocolVersion() int { return p.hello.Version }
+
+func (p *AbyssPeer) HasCapability(capability uint64) bool { return p.hello.Has(capability) }
+
 func (p *AbyssPeer) Send(v any) error {
 	return p.ahmp_encoder.Encode(v)
 }
 func (p *AbyssPeer) Recv(v any) error {
 	return p.ahmp_decoder.Decode(v)
 }
+
+// SendDatagram and ReceiveDatagram fail with ErrRelayedPeer for relayed peers,
+// as relays only forward streams.
+func (p *AbyssPeer) SendDatagram(datagram []byte) error {
+	connection, ok := p.quicConnection()
+	if !ok {
+		return ErrRelayedPeer
+	}
+	return connection.SendDatagram(datagram)
+}
+func (p *AbyssPeer) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	connection, ok := p.quicConnection()
+	if !ok {
+		return nil, ErrRelayedPeer
+	}
+	return connection.ReceiveDatagram(ctx)
+}
 func (p *AbyssPeer) Context() context.Context {
 	return p.connection.Context()
 }
@@ -1,7 +1,9 @@
 package ann
 
 import (
+	"bytes"
 	"crypto/x509"
+	"errors"
 	"net/netip"
 	"slices"
 	"sync"
@@ -15,40 +17,284 @@ type dialHistory struct {
 	addresses                []netip.Addr
 }
 
+var (
+	ErrIdentityExpired = errors.New("peer identity expired")
+	ErrIdentityRevoked = errors.New("peer handshake key revoked")
+)
+
 // AbyssPeerRegistry ensures only one connection exists with a peer.
 // tls_certs entry only exists while the corresponding peer is connected.
+// Known identities are written through to the store, if any.
+// An expired identity is treated as unknown, and removed on lookup.
+// Revoked handshake key certificates are remembered, and never accepted again.
+// Identity updates are subject to the TrustPolicy; see trust.go.
 type AbyssPeerRegistry struct {
 	mtx         sync.Mutex
+	store       IdentityStore // may be nil
+	max_age     time.Duration // 0 for no limit
+	policy      TrustPolicy
 	known       map[string]*sec.AbyssPeerIdentity
+	origins     map[string]IdentityOrigin // for known
+	held        map[string]heldIdentity
+	pinned      map[string][]byte // root certificate
 	dialed      map[string]dialHistory
 	peer_id_cnt uint64
 	connected   map[string]*AbyssPeer
 	tls_certs   map[[32]byte]string // for abyst
+	revoked     map[[32]byte]bool   // handshake key certificate hashes
 }
 
 func NewAbyssPeerRegistry() *AbyssPeerRegistry {
+	return NewAbyssPeerRegistryWithStore(nil, 0)
+}
+
+// NewAbyssPeerRegistryWithStore creates a registry backed by store.
+// Identities older than max_age (by IssueTime) expire; 0 means no limit.
+// Call LoadStore to fetch stored identities.
+func NewAbyssPeerRegistryWithStore(store IdentityStore, max_age time.Duration) *AbyssPeerRegistry {
 	return &AbyssPeerRegistry{
+		store:     store,
+		max_age:   max_age,
 		known:     make(map[string]*sec.AbyssPeerIdentity),
+		origins:   make(map[string]IdentityOrigin),
+		held:      make(map[string]heldIdentity),
+		pinned:    make(map[string][]byte),
 		dialed:    make(map[string]dialHistory),
 		connected: make(map[string]*AbyssPeer),
 		tls_certs: make(map[[32]byte]string),
+		revoked:   make(map[[32]byte]bool),
 	}
 }
 
-func (r *AbyssPeerRegistry) UpdatePeerIdentity(identity *sec.AbyssPeerIdentity) {
+// LoadStore fetches every stored revocation and identity, in IssueTime order.
+// Expired identities and identities with a revoked handshake key are deleted from the store.
+// The returned error reports entries that failed to load; the others are still loaded.
+func (r *AbyssPeerRegistry) LoadStore() error {
+	if r.store == nil {
+		return nil
+	}
+	revocations, revocations_err := r.store.LoadRevocations()
+	identities, load_err := r.store.LoadAll()
+
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
-	// when there is an old identity, replace it and return.
-	old_identity, ok := r.known[identity.ID()]
-	if ok && old_identity.IssueTime().After(identity.IssueTime()) {
-		return
+	errs := []error{revocations_err, load_err}
+	stored := make(map[string]*sec.AbyssPeerIdentity, len(identities))
+	for _, identity := range identities {
+		stored[identity.ID()] = identity
 	}
+	for _, revocation := range revocations {
+		// A revocation whose peer is no longer stored was verified when saved.
+		if identity, ok := stored[revocation.ID]; ok {
+			if err := identity.VerifyHandshakeKeyRevocation(revocation); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		r.revoked[revocation.CertificateHash] = true
+	}
+
+	now := time.Now()
+	for _, identity := range identities {
+		if r.isExpired(identity, now) || r.revoked[sec.HashHandshakeKeyCertificate(identity.HandshakeKeyCertificateDer())] {
+			errs = append(errs, r.store.Delete(identity.ID()))
+			continue
+		}
+		old_identity, ok := r.known[identity.ID()]
+		if ok && old_identity.IssueTime().After(identity.IssueTime()) {
+			continue
+		}
+		r.known[identity.ID()] = identity
+		r.origins[identity.ID()] = IdentityOrigin{Source: IdentityStored}
+	}
+	return errors.Join(errs...)
+}
+
+// SetTrustPolicy must be called before the registry is used.
+func (r *AbyssPeerRegistry) SetTrustPolicy(policy TrustPolicy) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.policy = policy
+}
+
+func (r *AbyssPeerRegistry) isExpired(identity *sec.AbyssPeerIdentity, now time.Time) bool {
+	if expire_time := identity.ExpireTime(); !expire_time.IsZero() && now.After(expire_time) {
+		return true
+	}
+	return r.max_age > 0 && now.Sub(identity.IssueTime()) > r.max_age
+}
+
+// getKnown must be called with r.mtx locked.
+func (r *AbyssPeerRegistry) getKnown(id string) (*sec.AbyssPeerIdentity, bool) {
+	identity, ok := r.known[id]
+	if !ok {
+		return nil, false
+	}
+	if r.isExpired(identity, time.Now()) {
+		delete(r.known, id)
+		delete(r.origins, id)
+		if r.store != nil {
+			r.store.Delete(id)
+		}
+		return nil, false
+	}
+	return identity, true
+}
 
+// UpdatePeerIdentity updates the peer identity from a direct source.
+// It returns an error if the identity is expired, revoked, rejected or held
+// by the trust policy, or the store fails.
+// A store failure does not prevent the in-memory update.
+func (r *AbyssPeerRegistry) UpdatePeerIdentity(identity *sec.AbyssPeerIdentity) error {
+	return r.updatePeerIdentity(identity, IdentityOrigin{Source: IdentityDirect})
+}
+
+// IntroducePeerIdentity updates the peer identity introduced by another peer.
+func (r *AbyssPeerRegistry) IntroducePeerIdentity(identity *sec.AbyssPeerIdentity, introducer_id string) error {
+	return r.updatePeerIdentity(identity, IdentityOrigin{Source: IdentityIntroduced, Introducer: introducer_id})
+}
+
+func (r *AbyssPeerRegistry) updatePeerIdentity(identity *sec.AbyssPeerIdentity, origin IdentityOrigin) error {
+	event, err := r.tryUpdatePeerIdentity(identity, origin)
+	if event != nil && r.policy.OnEvent != nil {
+		r.policy.OnEvent(*event)
+	}
+	return err
+}
+
+func (r *AbyssPeerRegistry) tryUpdatePeerIdentity(identity *sec.AbyssPeerIdentity, origin IdentityOrigin) (*TrustEvent, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.isExpired(identity, time.Now()) {
+		return nil, ErrIdentityExpired
+	}
+	if r.revoked[sec.HashHandshakeKeyCertificate(identity.HandshakeKeyCertificateDer())] {
+		return nil, ErrIdentityRevoked
+	}
+
+	old_identity, known := r.getKnown(identity.ID())
+	event := &TrustEvent{ID: identity.ID(), Origin: origin, New: identity}
+	if known {
+		event.Old = old_identity
+	}
+	switch {
+	case !known:
+		event.Type = TrustNewPeer
+	case !bytes.Equal(old_identity.RootCertificateDer(), identity.RootCertificateDer()):
+		event.Type = TrustRootCertificateChanged
+	case bytes.Equal(old_identity.HandshakeKeyCertificateDer(), identity.HandshakeKeyCertificateDer()):
+		return nil, nil // nothing new; introductions are repeated often.
+	default:
+		event.Type = TrustHandshakeKeyChanged
+	}
+
+	// an older handshake key is ignored.
+	if known && old_identity.IssueTime().After(identity.IssueTime()) {
+		return nil, nil
+	}
+
+	pinned_root, pinned := r.pinned[identity.ID()]
+	if pinned && !bytes.Equal(pinned_root, identity.RootCertificateDer()) {
+		event.Outcome = TrustRejected
+		return event, ErrIdentityPinned
+	}
+
+	var hold bool
+	if origin.Source == IdentityIntroduced {
+		hold = event.Type == TrustRootCertificateChanged || (!known && r.policy.ConfirmIntroduced)
+	} else {
+		hold = !known && !pinned && r.policy.ConfirmDirect
+	}
+	if hold {
+		if held, ok := r.held[identity.ID()]; ok && bytes.Equal(held.identity.HandshakeKeyCertificateDer(), identity.HandshakeKeyCertificateDer()) {
+			return nil, ErrIdentityHeld // already reported.
+		}
+		r.held[identity.ID()] = heldIdentity{identity: identity, origin: origin}
+		event.Outcome = TrustHeld
+		return event, ErrIdentityHeld
+	}
+
+	event.Outcome = TrustAccepted
+	return event, r.acceptPeerIdentity(identity, origin)
+}
+
+// acceptPeerIdentity must be called with r.mtx locked.
+func (r *AbyssPeerRegistry) acceptPeerIdentity(identity *sec.AbyssPeerIdentity, origin IdentityOrigin) error {
 	r.known[identity.ID()] = identity
+	r.origins[identity.ID()] = origin
+	delete(r.held, identity.ID())
 
 	// peer identity updated - new handshake key, all old ongoing dials will fail.
 	delete(r.dialed, identity.ID())
+
+	if r.store != nil {
+		return r.store.Save(identity)
+	}
+	return nil
+}
+
+// ConfirmPeerIdentity accepts the held identity, even if it is older than the known one.
+func (r *AbyssPeerRegistry) ConfirmPeerIdentity(id string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	held, ok := r.held[id]
+	if !ok {
+		return ErrNoHeldIdentity
+	}
+	delete(r.held, id)
+	if r.isExpired(held.identity, time.Now()) {
+		return ErrIdentityExpired
+	}
+	if r.revoked[sec.HashHandshakeKeyCertificate(held.identity.HandshakeKeyCertificateDer())] {
+		return ErrIdentityRevoked
+	}
+	if pinned_root, pinned := r.pinned[id]; pinned && !bytes.Equal(pinned_root, held.identity.RootCertificateDer()) {
+		return ErrIdentityPinned
+	}
+	return r.acceptPeerIdentity(held.identity, held.origin)
+}
+
+func (r *AbyssPeerRegistry) RejectPeerIdentity(id string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.held, id)
+}
+
+// PinPeer pins the root certificate of the known identity.
+// The pin outlives the identity, e.g. when its handshake key is revoked,
+// until RemovePeerIdentity or UnpinPeer.
+func (r *AbyssPeerRegistry) PinPeer(id string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	identity, ok := r.getKnown(id)
+	if !ok {
+		return errors.New("unknown peer")
+	}
+	r.pinned[id] = identity.RootCertificateDer()
+	return nil
+}
+
+func (r *AbyssPeerRegistry) UnpinPeer(id string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.pinned, id)
+}
+
+func (r *AbyssPeerRegistry) PeerOrigin(id string) (IdentityOrigin, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.getKnown(id); !ok {
+		return IdentityOrigin{}, false
+	}
+	return r.origins[id], true
 }
 
 // RemovePeerIdentity removes every information for the peer, and
@@ -56,15 +302,61 @@ func (r *AbyssPeerRegistry) UpdatePeerIdentity(identity *sec.AbyssPeerIdentity)
 // We don't delete the peer from dialed or connected,
 // as it should be removed by ReportDialTermination and ReportPeerClose.
 // However, we signal the connection silently.
-func (r *AbyssPeerRegistry) RemovePeerIdentity(id string) {
+func (r *AbyssPeerRegistry) RemovePeerIdentity(id string) error {
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
 	delete(r.known, id)
+	delete(r.origins, id)
+	delete(r.held, id)
+	delete(r.pinned, id)
+	var err error
+	if r.store != nil {
+		err = r.store.Delete(id)
+	}
 	if old_peer, ok := r.connected[id]; ok {
 		delete(r.tls_certs, sec.HashTlsCertificate(old_peer.client_tls_cert))
 		old_peer.connection.CloseWithError(AbyssQuicClose, "")
 	}
+	return err
+}
+
+// RevokeHandshakeKey verifies the revocation with the known identity of the peer.
+// If the known identity has the revoked handshake key, it is removed,
+// and the peer must be re-introduced with a newer one.
+// The revocation is saved to the store, so that it survives restarts.
+// Connections are kept, as they are already authenticated with TLS.
+func (r *AbyssPeerRegistry) RevokeHandshakeKey(revocation *sec.HandshakeKeyRevocation) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	identity, ok := r.known[revocation.ID]
+	if !ok {
+		if peer, connected := r.connected[revocation.ID]; connected {
+			identity = peer.AbyssPeerIdentity
+		} else {
+			return errors.New("unknown peer")
+		}
+	}
+	if err := identity.VerifyHandshakeKeyRevocation(revocation); err != nil {
+		return err
+	}
+	r.revoked[revocation.CertificateHash] = true
+	var err error
+	if r.store != nil {
+		err = r.store.SaveRevocation(revocation)
+	}
+
+	if !ok || sec.HashHandshakeKeyCertificate(identity.HandshakeKeyCertificateDer()) != revocation.CertificateHash {
+		return err
+	}
+	delete(r.known, revocation.ID)
+	delete(r.origins, revocation.ID)
+	delete(r.dialed, revocation.ID)
+	if r.store != nil {
+		err = errors.Join(err, r.store.Delete(revocation.ID))
+	}
+	return err
 }
 
 // GetPeerIdentityIfAcceptable returns error if the dialing is considered redundant,
@@ -74,7 +366,7 @@ func (r *AbyssPeerRegistry) GetPeerIdentityIfAcceptable(id string) (*sec.AbyssPe
 	defer r.mtx.Unlock()
 
 	// Cannot accept if the peer is unknown.
-	identity, ok := r.known[id]
+	identity, ok := r.getKnown(id)
 	if !ok {
 		return nil, &DialError{T: DE_UnknownPeer}
 	}
@@ -95,7 +387,7 @@ func (r *AbyssPeerRegistry) GetPeerIdentityIfDialable(id string, addr netip.Addr
 	defer r.mtx.Unlock()
 
 	// Cannot dial if the peer is unknown.
-	identity, ok := r.known[id]
+	identity, ok := r.getKnown(id)
 	if !ok {
 		return nil, &DialError{T: DE_UnknownPeer}
 	}
@@ -140,7 +432,7 @@ func (r *AbyssPeerRegistry) TryCompletingPeer(peer *AbyssPeer) (*AbyssPeer, *Dia
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
-	if _, ok := r.known[peer.ID()]; !ok {
+	if _, ok := r.getKnown(peer.ID()); !ok {
 		return nil, &DialError{T: DE_UnknownPeer}
 	}
 
@@ -174,6 +466,27 @@ func (r *AbyssPeerRegistry) ReportPeerClose(peer *AbyssPeer) error {
 	return err
 }
 
+// GetConnectedPeer returns the peer with an active abyss connection.
+func (r *AbyssPeerRegistry) GetConnectedPeer(id string) (*AbyssPeer, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	peer, ok := r.connected[id]
+	return peer, ok
+}
+
+// GetConnectedPeers returns every peer with an active abyss connection.
+func (r *AbyssPeerRegistry) GetConnectedPeers() []*AbyssPeer {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	result := make([]*AbyssPeer, 0, len(r.connected))
+	for _, peer := range r.connected {
+		result = append(result, peer)
+	}
+	return result
+}
+
 // GetPeerIdFromTlsCertificate implements ani.IAbystTlsCertChecker interface
 func (r *AbyssPeerRegistry) GetPeerIdFromTlsCertificate(abyst_tls_cert *x509.Certificate) (string, bool) {
 	r.mtx.Lock()
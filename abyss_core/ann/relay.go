@@ -0,0 +1,294 @@
+package ann
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	"github.com/kadmila/Abyss-Browser/abyss_core/fw"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+	"github.com/quic-go/quic-go"
+)
+
+// Relay.
+// When neither direct dialing nor hole punching works, two peers connected with
+// a common peer (relay) can tunnel an abyss session through it.
+//  1. the source sends RLR to the relay over a control stream.
+//  2. the relay checks its RelayPolicy, and sends RLN to the target over another control stream.
+//  3. the target answers RLA, and the relay forwards it to the source.
+//  4. if accepted, the relay splices the two streams. The source and the target run
+//     TLS and the abyss handshake end-to-end over them; the relay only sees ciphertext.
+
+const relayChunkSize = 16 * 1024
+
+// DialRelayed dials the target through a connected relay peer.
+// As with Dial, the result arrives at Accept().
+// The relayed peer reports the relay's address as RemoteAddr.
+func (n *AbyssNode) DialRelayed(relay_id string, id string) error {
+	if err := n.firewall.CheckPeer(fw.Outbound, id); err != nil {
+		return err
+	}
+	relay, ok := n.registry.GetConnectedPeer(relay_id)
+	if !ok {
+		return ErrPeerNotConnected
+	}
+	if relay.relay != nil {
+		return ErrRelayedPeer
+	}
+	if !relay.HasCapability(ahmp.CapRelay) {
+		return ErrNotSupportedByPeer
+	}
+	peer_identity, dial_err := n.registry.GetPeerIdentityIfAcceptable(id)
+	if dial_err != nil {
+		return dial_err
+	}
+
+	if !n.tryStartWorker(func() { n.relayDialRoutine(relay, peer_identity) }) {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+func (n *AbyssNode) relayDialRoutine(relay *AbyssPeer, peer_identity *sec.AbyssPeerIdentity) {
+	handshake_ctx, handshake_ctx_cancel := context.WithTimeout(n.service_ctx, n.config.HandshakeTimeout)
+	defer handshake_ctx_cancel()
+
+	addr := relay.remote_addr
+
+	var answer ahmp.RawRLA
+	stream, stream_rest, err := n.openControl(handshake_ctx, relay, ahmp.RLR_T, &ahmp.RawRLR{TargetID: peer_identity.ID()}, ahmp.RLA_T, &answer)
+	if err != nil {
+		n.backlogAppendError(addr, true, err)
+		return
+	}
+	if !answer.Accepted {
+		stream.CancelRead(0)
+		stream.Close()
+		n.backlogAppendError(addr, true, errors.New("relay rejected: "+answer.Text))
+		return
+	}
+
+	connection := newRelayConn(relay, stream, stream_rest, func(conn net.Conn) *tls.Conn {
+		return tls.Client(conn, n.TLSIdentity.NewAbyssClientTlsConf())
+	})
+	if err := connection.tls_conn.HandshakeContext(handshake_ctx); err != nil {
+		connection.CloseWithError(AbyssQuicCryptoFail, "")
+		n.backlogAppendError(addr, true, err)
+		return
+	}
+
+	n.finishHandshake(handshake_ctx, true, &AbyssPeer{
+		AbyssPeerIdentity: peer_identity,
+		origin:            n,
+		client_tls_cert:   connection.tls_conn.ConnectionState().PeerCertificates[0],
+		connection:        connection,
+		remote_addr:       addr,
+		relay:             relay,
+		ahmp_encoder:      cbor.NewEncoder(connection),
+		ahmp_decoder:      ahmp.NewDecoder(connection),
+	})
+}
+
+// answerRelay decides whether to accept a relayed session notified by a relay peer.
+func (n *AbyssNode) answerRelay(source_id string) *ahmp.RawRLA {
+	if err := n.firewall.CheckPeer(fw.Inbound, source_id); err != nil {
+		return &ahmp.RawRLA{Text: "rejected"}
+	}
+	if _, err := n.registry.GetPeerIdentityIfAcceptable(source_id); err != nil {
+		return &ahmp.RawRLA{Text: err.Error()}
+	}
+	return &ahmp.RawRLA{Accepted: true}
+}
+
+// relayServeRoutine is the serving side of a relayed session.
+func (n *AbyssNode) relayServeRoutine(relay *AbyssPeer, stream quic.Stream, stream_rest io.Reader) {
+	handshake_ctx, handshake_ctx_cancel := context.WithTimeout(n.service_ctx, n.config.HandshakeTimeout)
+	defer handshake_ctx_cancel()
+
+	addr := relay.remote_addr
+	stream.SetDeadline(time.Time{})
+
+	connection := newRelayConn(relay, stream, stream_rest, func(conn net.Conn) *tls.Conn {
+		return tls.Server(conn, n.NewServerTlsConf(n.registry))
+	})
+	if err := connection.tls_conn.HandshakeContext(handshake_ctx); err != nil {
+		connection.CloseWithError(AbyssQuicCryptoFail, "")
+		n.backlogAppendError(addr, false, err)
+		return
+	}
+	tls_info := connection.tls_conn.ConnectionState()
+	if tls_info.NegotiatedProtocol != sec.NextProtoAbyss {
+		connection.CloseWithError(0, "")
+		n.backlogAppendError(addr, false, errors.New("unsupported application layer protocol"))
+		return
+	}
+
+	n.finishHandshake(handshake_ctx, false, &AbyssPeer{
+		origin:          n,
+		client_tls_cert: tls_info.PeerCertificates[0],
+		connection:      connection,
+		remote_addr:     addr,
+		relay:           relay,
+		ahmp_encoder:    cbor.NewEncoder(connection),
+		ahmp_decoder:    ahmp.NewDecoder(connection),
+	})
+}
+
+// serveRelay handles RLR as the relay. If the target accepts,
+// it forwards the session until either side ends.
+func (n *AbyssNode) serveRelay(source *AbyssPeer, source_stream quic.Stream, source_rest io.Reader, target_id string) error {
+	reject := func(text string) error {
+		return writeControl(source_stream, ahmp.RLA_T, &ahmp.RawRLA{Text: text})
+	}
+
+	policy := n.relay_policy.Load()
+	if !policy.Enabled {
+		return reject("relay disabled")
+	}
+	if n.firewall.CheckPeer(fw.Inbound, source.ID()) != nil || n.firewall.CheckPeer(fw.Outbound, target_id) != nil {
+		return reject("rejected")
+	}
+	target, ok := n.registry.GetConnectedPeer(target_id)
+	if !ok || target.relay != nil {
+		return reject("target not connected")
+	}
+	if !n.acquireRelaySession(policy) {
+		return reject("too many relay sessions")
+	}
+	defer n.relay_sessions.Add(-1)
+
+	ctx, ctx_cancel := context.WithTimeout(n.service_ctx, n.config.HandshakeTimeout)
+	var answer ahmp.RawRLA
+	target_stream, target_rest, err := n.openControl(ctx, target, ahmp.RLN_T, &ahmp.RawRLN{SourceID: source.ID()}, ahmp.RLA_T, &answer)
+	ctx_cancel()
+	if err != nil {
+		return reject("target unreachable")
+	}
+	if err := writeControl(source_stream, ahmp.RLA_T, &answer); err != nil || !answer.Accepted {
+		target_stream.CancelRead(0)
+		target_stream.Close()
+		return err
+	}
+
+	source_stream.SetDeadline(time.Time{})
+	n.logger.Debug().Str("source", source.ID()).Str("target", target_id).Msg("relay session started")
+	n.splice(source_stream, source_rest, target_stream, target_rest)
+	return nil
+}
+
+func (n *AbyssNode) acquireRelaySession(policy *RelayPolicy) bool {
+	for {
+		count := n.relay_sessions.Load()
+		if policy.MaxSessions > 0 && int(count) >= policy.MaxSessions {
+			return false
+		}
+		if n.relay_sessions.CompareAndSwap(count, count+1) {
+			return true
+		}
+	}
+}
+
+// splice forwards bytes between two streams, within the relay bandwidth limit.
+// When either direction ends, both streams are closed.
+func (n *AbyssNode) splice(a quic.Stream, a_rest io.Reader, b quic.Stream, b_rest io.Reader) {
+	ctx, ctx_cancel := context.WithCancel(n.service_ctx)
+	done := make(chan struct{}, 2)
+	forward := func(dst io.Writer, src io.Reader) {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, relayChunkSize)
+		for {
+			nr, err := src.Read(buf)
+			if nr > 0 {
+				if n.relay_limiter.wait(ctx, nr) != nil {
+					return
+				}
+				if _, err := dst.Write(buf[:nr]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	go forward(b, a_rest)
+	go forward(a, b_rest)
+
+	<-done
+	ctx_cancel()
+	for _, stream := range []quic.Stream{a, b} {
+		stream.CancelRead(0)
+		stream.Close()
+	}
+	<-done
+}
+
+// relayStreamConn adapts a relayed stream to net.Conn, for crypto/tls.
+type relayStreamConn struct {
+	quic.Stream
+	reader      io.Reader // bytes buffered from the control messages, then the stream.
+	local_addr  net.Addr
+	remote_addr net.Addr
+}
+
+func (c *relayStreamConn) Read(p []byte) (int, error) { return c.reader.Read(p) }
+func (c *relayStreamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+func (c *relayStreamConn) LocalAddr() net.Addr  { return c.local_addr }
+func (c *relayStreamConn) RemoteAddr() net.Addr { return c.remote_addr }
+
+// relayConn is an end-to-end TLS connection over a relayed stream.
+// It implements peerConn for relayed peers. TLS does not carry
+// application error codes, so CloseWithError only closes the connection.
+// Its context is cancelled when a read or write fails, or the relay disconnects.
+type relayConn struct {
+	tls_conn  *tls.Conn
+	ctx       context.Context
+	ctx_close context.CancelCauseFunc
+}
+
+func newRelayConn(relay *AbyssPeer, stream quic.Stream, stream_rest io.Reader, wrap func(net.Conn) *tls.Conn) *relayConn {
+	relay_connection, _ := relay.quicConnection() // relays are always direct peers.
+	ctx, ctx_close := context.WithCancelCause(relay_connection.Context())
+	return &relayConn{
+		tls_conn: wrap(&relayStreamConn{
+			Stream:      stream,
+			reader:      stream_rest,
+			local_addr:  relay_connection.LocalAddr(),
+			remote_addr: relay_connection.RemoteAddr(),
+		}),
+		ctx:       ctx,
+		ctx_close: ctx_close,
+	}
+}
+
+func (c *relayConn) Read(p []byte) (int, error) {
+	n, err := c.tls_conn.Read(p)
+	if err != nil {
+		c.ctx_close(err)
+	}
+	return n, err
+}
+
+func (c *relayConn) Write(p []byte) (int, error) {
+	n, err := c.tls_conn.Write(p)
+	if err != nil {
+		c.ctx_close(err)
+	}
+	return n, err
+}
+
+func (c *relayConn) Context() context.Context { return c.ctx }
+
+func (c *relayConn) CloseWithError(quic.ApplicationErrorCode, string) error {
+	c.ctx_close(net.ErrClosed)
+	c.tls_conn.Close()
+	return nil
+}
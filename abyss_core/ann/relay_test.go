@@ -0,0 +1,103 @@
+package ann_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+)
+
+func TestRelay(t *testing.T) {
+	relay_config := ann.DefaultAbyssNodeConfig()
+	relay_config.BindAddr = netip_loopback
+	node_C, _ := newServingNode(t, relay_config)
+	defer node_C.Close()
+
+	nat_config := relay_config
+	nat_config.SimulatedNAT = &ann.SimulatedNAT{}
+	node_A, _ := newServingNode(t, nat_config)
+	defer node_A.Close()
+	node_B, _ := newServingNode(t, nat_config)
+	defer node_B.Close()
+
+	introduce(node_A, node_B)
+	for _, node := range []*ann.AbyssNode{node_A, node_B} {
+		introduce(node, node_C)
+		node.Dial(node_C.ID(), node_C.LocalAddrCandidates()[0])
+		acceptPeer(t, node, node_C.ID(), time.Second*3)
+		acceptPeer(t, node_C, node.ID(), time.Second*3)
+	}
+
+	// relaying is disabled by default.
+	if err := node_A.DialRelayed(node_C.ID(), node_B.ID()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, ctxcancel := context.WithTimeout(context.Background(), time.Second*3)
+	if _, err := node_A.Accept(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("relay should be rejected: ", err)
+	}
+	ctxcancel()
+
+	node_C.SetRelayPolicy(ann.RelayPolicy{Enabled: true, MaxSessions: 1, BytesPerSecond: 64 * 1024})
+	if err := node_A.DialRelayed(node_C.ID(), node_B.ID()); err != nil {
+		t.Fatal(err)
+	}
+	peer_A_B := acceptPeer(t, node_A, node_B.ID(), time.Second*5)
+	peer_B_A := acceptPeer(t, node_B, node_A.ID(), time.Second*5)
+
+	if peer_A_B.RemoteAddr() != node_C.LocalAddrCandidates()[0] {
+		t.Fatal("relayed peer should report the relay address: ", peer_A_B.RemoteAddr())
+	}
+	if relay_id := peer_B_A.(*ann.AbyssPeer).RelayID(); relay_id != node_C.ID() {
+		t.Fatal("relay id mismatch: ", relay_id)
+	}
+	if err := peer_A_B.SendDatagram([]byte{1}); !errors.Is(err, ann.ErrRelayedPeer) {
+		t.Fatal("datagram through relay: ", err)
+	}
+
+	// AHMP over the relay, within the bandwidth limit.
+	payload := bytes.Repeat([]byte{0xab}, 64*1024)
+	start := time.Now()
+	for range 3 {
+		if err := peer_A_B.Send(payload); err != nil {
+			t.Fatal(err)
+		}
+		var received []byte
+		if err := peer_B_A.Recv(&received); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received, payload) {
+			t.Fatal("payload mismatch")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatal("bandwidth limit not applied: ", elapsed)
+	}
+	if err := peer_B_A.Send("pong"); err != nil {
+		t.Fatal(err)
+	}
+	var pong string
+	if err := peer_A_B.Recv(&pong); err != nil || pong != "pong" {
+		t.Fatal("reverse direction failed: ", err)
+	}
+
+	client, _ := node_A.NewAbystClient()
+	if _, err := client.Get("abyst:" + node_B.ID() + "/"); !errors.Is(err, ann.ErrAbystRelayedPeer) {
+		t.Fatal("abyst should not be available through relay: ", err)
+	}
+
+	// closing one side ends the session on the other side.
+	peer_A_B.Close()
+	if err := peer_B_A.Recv(&pong); err == nil {
+		t.Fatal("recv should fail after the remote closes")
+	}
+	select {
+	case <-peer_B_A.(*ann.AbyssPeer).Context().Done():
+	case <-time.After(time.Second * 3):
+		t.Fatal("relayed peer context not cancelled")
+	}
+	peer_B_A.Close()
+}
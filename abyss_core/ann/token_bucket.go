@@ -0,0 +1,59 @@
+package ann
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a byte rate limiter, shared by all relay sessions.
+// Tokens may go negative; a waiter reserves its bytes and sleeps off the debt.
+// The bucket holds at most one second of tokens. A rate of 0 means unlimited.
+type tokenBucket struct {
+	mtx    sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytes_per_second int) *tokenBucket {
+	result := &tokenBucket{}
+	result.setRate(bytes_per_second)
+	return result
+}
+
+func (b *tokenBucket) setRate(bytes_per_second int) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.rate = float64(max(bytes_per_second, 0))
+	b.tokens = b.rate
+	b.last = time.Now()
+}
+
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mtx.Lock()
+	if b.rate == 0 {
+		b.mtx.Unlock()
+		return nil
+	}
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	debt := -b.tokens
+	rate := b.rate
+	b.mtx.Unlock()
+
+	if debt <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(debt / rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,69 @@
+package ann
+
+import (
+	"github.com/kadmila/Abyss-Browser/abyss_core/ani"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+)
+
+// The trust model is shared with the other network services; see ani.TrustPolicy.
+// A pinned peer accepts newer handshake keys under its pinned root certificate,
+// as every peer does under its known one.
+
+type (
+	IdentitySource = ani.IdentitySource
+	IdentityOrigin = ani.IdentityOrigin
+	TrustPolicy    = ani.TrustPolicy
+	TrustEventType = ani.TrustEventType
+	TrustOutcome   = ani.TrustOutcome
+	TrustEvent     = ani.TrustEvent
+)
+
+const (
+	IdentityDirect     = ani.IdentityDirect
+	IdentityIntroduced = ani.IdentityIntroduced
+	IdentityStored     = ani.IdentityStored
+
+	TrustNewPeer                = ani.TrustNewPeer
+	TrustRootCertificateChanged = ani.TrustRootCertificateChanged
+	TrustHandshakeKeyChanged    = ani.TrustHandshakeKeyChanged
+
+	TrustAccepted = ani.TrustAccepted
+	TrustHeld     = ani.TrustHeld
+	TrustRejected = ani.TrustRejected
+)
+
+var (
+	ErrIdentityHeld   = ani.ErrIdentityHeld
+	ErrIdentityPinned = ani.ErrIdentityPinned
+	ErrNoHeldIdentity = ani.ErrNoHeldIdentity
+)
+
+type heldIdentity struct {
+	identity *sec.AbyssPeerIdentity
+	origin   IdentityOrigin
+}
+
+// IntroducePeerDer is AppendKnownPeerDer for an identity relayed by introducer_id,
+// which is subject to the trust policy. A held identity returns ErrIdentityHeld.
+func (n *AbyssNode) IntroducePeerDer(introducer_id string, root_cert []byte, handshake_key_cert []byte) error {
+	identity, err := sec.NewAbyssPeerIdentityFromDER(root_cert, handshake_key_cert)
+	if err != nil {
+		return err
+	}
+
+	return n.registry.IntroducePeerIdentity(identity, introducer_id)
+}
+
+// ConfirmPeer accepts the held identity of the peer, replacing the known one.
+func (n *AbyssNode) ConfirmPeer(id string) error { return n.registry.ConfirmPeerIdentity(id) }
+
+// RejectPeer discards the held identity of the peer, if any.
+func (n *AbyssNode) RejectPeer(id string) { n.registry.RejectPeerIdentity(id) }
+
+// PinPeer fixes the root certificate of a known peer.
+func (n *AbyssNode) PinPeer(id string) error { return n.registry.PinPeer(id) }
+
+func (n *AbyssNode) UnpinPeer(id string) { n.registry.UnpinPeer(id) }
+
+// PeerOrigin returns where the known identity of the peer came from.
+func (n *AbyssNode) PeerOrigin(id string) (IdentityOrigin, bool) { return n.registry.PeerOrigin(id) }
@@ -0,0 +1,143 @@
+package ann_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ann"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+)
+
+func newTrustNode(t *testing.T, policy ann.TrustPolicy) (*ann.AbyssNode, *[]ann.TrustEvent) {
+	events := &[]ann.TrustEvent{}
+	policy.OnEvent = func(event ann.TrustEvent) { *events = append(*events, event) }
+	config := ann.DefaultAbyssNodeConfig()
+	config.Trust = policy
+	root_key, _ := sec.NewRootPrivateKey()
+	node, err := ann.NewAbyssNodeWithConfig(root_key, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	return node, events
+}
+
+func expectTrustEvent(t *testing.T, events *[]ann.TrustEvent, event_type ann.TrustEventType, outcome ann.TrustOutcome) {
+	t.Helper()
+	if len(*events) != 1 {
+		t.Fatal("expected one trust event, got ", len(*events))
+	}
+	event := (*events)[0]
+	*events = (*events)[:0]
+	if event.Type != event_type || event.Outcome != outcome {
+		t.Fatal("unexpected trust event: ", event.Type, event.Outcome)
+	}
+}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	node, events := newTrustNode(t, ann.TrustPolicy{})
+	root_key, _ := sec.NewRootPrivateKey()
+	peer, _ := sec.NewAbyssRootSecrets(root_key)
+
+	if err := node.IntroducePeerDer("introducer", peer.RootCertificateDer(), peer.HandshakeKeyCertificateDer()); err != nil {
+		t.Fatal(err)
+	}
+	expectTrustEvent(t, events, ann.TrustNewPeer, ann.TrustAccepted)
+	if origin, ok := node.PeerOrigin(peer.ID()); !ok || origin.Source != ann.IdentityIntroduced || origin.Introducer != "introducer" {
+		t.Fatal("unexpected origin: ", origin)
+	}
+
+	// repeated introductions are silent.
+	node.IntroducePeerDer("introducer", peer.RootCertificateDer(), peer.HandshakeKeyCertificateDer())
+	if len(*events) != 0 {
+		t.Fatal("repeated introduction raised an event")
+	}
+
+	// a different root certificate for the same id is held.
+	reissued, _ := sec.NewAbyssRootSecrets(root_key)
+	if err := node.IntroducePeerDer("introducer", reissued.RootCertificateDer(), reissued.HandshakeKeyCertificateDer()); !errors.Is(err, ann.ErrIdentityHeld) {
+		t.Fatal("changed root certificate should be held: ", err)
+	}
+	expectTrustEvent(t, events, ann.TrustRootCertificateChanged, ann.TrustHeld)
+	if err := node.ConfirmPeer(peer.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.ConfirmPeer(peer.ID()); !errors.Is(err, ann.ErrNoHeldIdentity) {
+		t.Fatal("confirmed twice: ", err)
+	}
+
+	// the same change from a direct source is accepted, but reported.
+	reissued, _ = sec.NewAbyssRootSecrets(root_key)
+	if err := node.AppendKnownPeerDer(reissued.RootCertificateDer(), reissued.HandshakeKeyCertificateDer()); err != nil {
+		t.Fatal(err)
+	}
+	expectTrustEvent(t, events, ann.TrustRootCertificateChanged, ann.TrustAccepted)
+	if origin, _ := node.PeerOrigin(peer.ID()); origin.Source != ann.IdentityDirect {
+		t.Fatal("unexpected origin: ", origin)
+	}
+}
+
+func TestTrustPinning(t *testing.T) {
+	node, events := newTrustNode(t, ann.TrustPolicy{})
+	root_key, _ := sec.NewRootPrivateKey()
+	peer, _ := sec.NewAbyssRootSecrets(root_key)
+	node.AppendKnownPeerDer(peer.RootCertificateDer(), peer.HandshakeKeyCertificateDer())
+	*events = (*events)[:0]
+	if err := node.PinPeer(peer.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	reissued, _ := sec.NewAbyssRootSecrets(root_key)
+	if err := node.AppendKnownPeerDer(reissued.RootCertificateDer(), reissued.HandshakeKeyCertificateDer()); !errors.Is(err, ann.ErrIdentityPinned) {
+		t.Fatal("pinned root certificate replaced: ", err)
+	}
+	expectTrustEvent(t, events, ann.TrustRootCertificateChanged, ann.TrustRejected)
+
+	// a newer handshake key signed by the pinned root is accepted, even when introduced.
+	new_cert, err := peer.RotateHandshakeKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.IntroducePeerDer("introducer", peer.RootCertificateDer(), new_cert); err != nil {
+		t.Fatal("rotated handshake key of a pinned peer should be accepted: ", err)
+	}
+	expectTrustEvent(t, events, ann.TrustHandshakeKeyChanged, ann.TrustAccepted)
+
+	node.UnpinPeer(peer.ID())
+	time.Sleep(time.Millisecond * 1100) // newer than the rotated key; certificate time has second precision.
+	reissued, _ = sec.NewAbyssRootSecrets(root_key)
+	if err := node.AppendKnownPeerDer(reissued.RootCertificateDer(), reissued.HandshakeKeyCertificateDer()); err != nil {
+		t.Fatal(err)
+	}
+	expectTrustEvent(t, events, ann.TrustRootCertificateChanged, ann.TrustAccepted)
+}
+
+func TestTrustConfirmation(t *testing.T) {
+	node, events := newTrustNode(t, ann.TrustPolicy{ConfirmIntroduced: true})
+	peer_key, _ := sec.NewRootPrivateKey()
+	peer, _ := sec.NewAbyssRootSecrets(peer_key)
+
+	if err := node.IntroducePeerDer("introducer", peer.RootCertificateDer(), peer.HandshakeKeyCertificateDer()); !errors.Is(err, ann.ErrIdentityHeld) {
+		t.Fatal("introduced peer should be held: ", err)
+	}
+	expectTrustEvent(t, events, ann.TrustNewPeer, ann.TrustHeld)
+	var dial_err *ann.DialError
+	if err := node.Dial(peer.ID(), netip_loopback); !errors.As(err, &dial_err) || dial_err.T != ann.DE_UnknownPeer {
+		t.Fatal("held peer should not be dialable: ", err)
+	}
+	if err := node.ConfirmPeer(peer.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := node.PeerOrigin(peer.ID()); !ok {
+		t.Fatal("confirmed peer is unknown")
+	}
+
+	// direct identities are trusted on first use.
+	other_key, _ := sec.NewRootPrivateKey()
+	other, _ := sec.NewAbyssRootSecrets(other_key)
+	if err := node.AppendKnownPeerDer(other.RootCertificateDer(), other.HandshakeKeyCertificateDer()); err != nil {
+		t.Fatal(err)
+	}
+	expectTrustEvent(t, events, ann.TrustNewPeer, ann.TrustAccepted)
+}
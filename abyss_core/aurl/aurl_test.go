@@ -2,6 +2,7 @@ package aurl
 
 import (
 	"fmt"
+	"net"
 	"testing"
 )
 
@@ -41,3 +42,24 @@ func TestAurl(t *testing.T) {
 	ParsePrintAURL("abyss:hhh:|/")
 	ParsePrintAURL("abyss:hhh::1605/")
 }
+
+func TestAurlIPv6(t *testing.T) {
+	const id = "Ha7kJ4dbmoZKZ9ok1Wy7Gn8GDiCL9Fmpeq5SFxqK1xRr"
+	raw := "abyss:" + id + ":[2001:db8::1]:1605|9.8.7.6:1605|[::1]:1605/somepath"
+	parsed, err := TryParse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Addresses) != 3 {
+		t.Fatal("expected 3 candidates, got ", len(parsed.Addresses))
+	}
+	if !parsed.Addresses[0].IP.Equal(net.ParseIP("2001:db8::1")) || parsed.Addresses[0].Port != 1605 {
+		t.Fatal("IPv6 candidate parse fail: ", parsed.Addresses[0])
+	}
+	if parsed.Path != "somepath" {
+		t.Fatal("path parse fail: ", parsed.Path)
+	}
+	if parsed.ToString() != raw {
+		t.Fatal("round trip fail: ", parsed.ToString())
+	}
+}
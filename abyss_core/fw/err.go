@@ -0,0 +1,30 @@
+package fw
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// BlockedError is returned when a connection is blocked by the firewall.
+// Either Addr or PeerID is set, depending on which rule blocked it.
+type BlockedError struct {
+	Direction Direction
+	Addr      netip.Addr
+	PeerID    string
+	Reason    string
+}
+
+func (e *BlockedError) Error() string {
+	var b strings.Builder
+	b.WriteString("fw: ")
+	b.WriteString(e.Direction.String())
+	b.WriteString(" ")
+	if e.PeerID != "" {
+		b.WriteString(e.PeerID)
+	} else {
+		b.WriteString(e.Addr.String())
+	}
+	b.WriteString(" blocked; ")
+	b.WriteString(e.Reason)
+	return b.String()
+}
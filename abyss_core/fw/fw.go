@@ -1,4 +1,168 @@
 // Package fw is a configurable firewall for abyss P2P network.
 // It supports IP/hash inbound/outbound rules,
 // where the entries can be dynamically set.
+//
+// A Firewall holds an immutable RuleSet, which is atomically swapped
+// on every modification. Deny entries always take precedence over allow entries.
+// An empty allow list means "allow all" for the category.
+// Address and peer ID rules are evaluated independently; both must pass.
 package fw
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// Firewall is safe for concurrent use.
+// Rules can be changed while the node is running; new rules apply to
+// connections checked after the change, and users of the firewall
+// wait on Changed to re-check the connections they hold.
+type Firewall struct {
+	rules atomic.Pointer[RuleSet]
+
+	changed_mtx sync.Mutex
+	changed     chan struct{}
+}
+
+// NewFirewall creates an open firewall, which allows everything.
+func NewFirewall() *Firewall {
+	result := &Firewall{
+		changed: make(chan struct{}),
+	}
+	result.rules.Store(NewRuleSet())
+	return result
+}
+
+// Changed returns a channel that is closed on the next rule change.
+func (f *Firewall) Changed() <-chan struct{} {
+	f.changed_mtx.Lock()
+	defer f.changed_mtx.Unlock()
+
+	return f.changed
+}
+
+func (f *Firewall) notifyChanged() {
+	f.changed_mtx.Lock()
+	defer f.changed_mtx.Unlock()
+
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// RuleSet returns the current rule set. The return value must not be mutated.
+func (f *Firewall) RuleSet() *RuleSet {
+	return f.rules.Load()
+}
+
+// SetRuleSet replaces the whole rule set (hot-swap).
+// The firewall keeps its own copy, so the caller may keep modifying rule_set.
+// nil is an empty rule set, which allows everything.
+func (f *Firewall) SetRuleSet(rule_set *RuleSet) {
+	if rule_set == nil {
+		rule_set = NewRuleSet()
+	}
+	f.rules.Store(rule_set.Clone())
+	f.notifyChanged()
+}
+
+// Update applies modify on a copy of the current rule set, and swaps it in.
+// modify may be called more than once on concurrent updates.
+func (f *Firewall) Update(modify func(rule_set *RuleSet)) {
+	for {
+		prev := f.rules.Load()
+		next := prev.Clone()
+		modify(next)
+		if f.rules.CompareAndSwap(prev, next) {
+			f.notifyChanged()
+			return
+		}
+	}
+}
+
+func (f *Firewall) AllowPeer(direction Direction, id string) {
+	f.Update(func(r *RuleSet) { r.forEach(direction, func(d *DirectionRules) { d.AllowPeers[id] = struct{}{} }) })
+}
+func (f *Firewall) DenyPeer(direction Direction, id string) {
+	f.Update(func(r *RuleSet) { r.forEach(direction, func(d *DirectionRules) { d.DenyPeers[id] = struct{}{} }) })
+}
+
+// ClearPeer removes the peer from both allow and deny lists.
+func (f *Firewall) ClearPeer(direction Direction, id string) {
+	f.Update(func(r *RuleSet) {
+		r.forEach(direction, func(d *DirectionRules) {
+			delete(d.AllowPeers, id)
+			delete(d.DenyPeers, id)
+		})
+	})
+}
+
+func (f *Firewall) AllowPrefix(direction Direction, prefix netip.Prefix) {
+	f.Update(func(r *RuleSet) {
+		r.forEach(direction, func(d *DirectionRules) { d.AllowPrefixes = appendPrefix(d.AllowPrefixes, prefix) })
+	})
+}
+func (f *Firewall) DenyPrefix(direction Direction, prefix netip.Prefix) {
+	f.Update(func(r *RuleSet) {
+		r.forEach(direction, func(d *DirectionRules) { d.DenyPrefixes = appendPrefix(d.DenyPrefixes, prefix) })
+	})
+}
+
+// ClearPrefix removes the prefix from both allow and deny lists.
+func (f *Firewall) ClearPrefix(direction Direction, prefix netip.Prefix) {
+	f.Update(func(r *RuleSet) {
+		r.forEach(direction, func(d *DirectionRules) {
+			d.AllowPrefixes = removePrefix(d.AllowPrefixes, prefix)
+			d.DenyPrefixes = removePrefix(d.DenyPrefixes, prefix)
+		})
+	})
+}
+
+// CheckAddress returns *BlockedError if the address is not allowed.
+// This is used before the peer is identified.
+func (f *Firewall) CheckAddress(direction Direction, addr netip.Addr) error {
+	return f.rules.Load().check(direction, func(d *DirectionRules, direction Direction) error {
+		return d.checkAddress(direction, addr)
+	})
+}
+
+// CheckPeer returns *BlockedError if the peer is not allowed.
+func (f *Firewall) CheckPeer(direction Direction, id string) error {
+	return f.rules.Load().check(direction, func(d *DirectionRules, direction Direction) error {
+		return d.checkPeer(direction, id)
+	})
+}
+
+// Check evaluates both address and peer rules over a single snapshot of the rule set.
+func (f *Firewall) Check(direction Direction, id string, addr netip.Addr) error {
+	return f.rules.Load().check(direction, func(d *DirectionRules, direction Direction) error {
+		if err := d.checkAddress(direction, addr); err != nil {
+			return err
+		}
+		return d.checkPeer(direction, id)
+	})
+}
+
+// IsInboundOpen implements interfaces.IIpFilter.
+func (f *Firewall) IsInboundOpen(addr *net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	var ip net.IP
+	switch v := (*addr).(type) {
+	case *net.UDPAddr:
+		ip = v.IP
+	case *net.TCPAddr:
+		ip = v.IP
+	case *net.IPAddr:
+		ip = v.IP
+	default:
+		return false
+	}
+	netip_addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	return f.CheckAddress(Inbound, netip_addr) == nil
+}
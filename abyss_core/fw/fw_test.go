@@ -0,0 +1,164 @@
+package fw_test
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/fw"
+	"github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+var _ interfaces.IIpFilter = (*fw.Firewall)(nil)
+
+func TestOpenFirewall(t *testing.T) {
+	f := fw.NewFirewall()
+	if err := f.Check(fw.Inbound, "H-any", netip.MustParseAddr("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Check(fw.Outbound, "H-any", netip.MustParseAddr("::1")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrefixRules(t *testing.T) {
+	f := fw.NewFirewall()
+	f.AllowPrefix(fw.Inbound, netip.MustParsePrefix("192.168.0.0/16"))
+	f.DenyPrefix(fw.Inbound, netip.MustParsePrefix("192.168.7.0/24"))
+
+	if err := f.CheckAddress(fw.Inbound, netip.MustParseAddr("192.168.1.1")); err != nil {
+		t.Fatal(err)
+	}
+	// deny wins over allow.
+	var blocked *fw.BlockedError
+	if err := f.CheckAddress(fw.Inbound, netip.MustParseAddr("192.168.7.1")); !errors.As(err, &blocked) {
+		t.Fatal("should be denied")
+	}
+	// not in allow list.
+	if err := f.CheckAddress(fw.Inbound, netip.MustParseAddr("10.0.0.1")); err == nil {
+		t.Fatal("should not be allowed")
+	}
+	// IPv4-mapped IPv6 addresses follow IPv4 rules.
+	if err := f.CheckAddress(fw.Inbound, netip.MustParseAddr("::ffff:192.168.7.1")); err == nil {
+		t.Fatal("mapped address should be denied")
+	}
+	// outbound is unaffected.
+	if err := f.CheckAddress(fw.Outbound, netip.MustParseAddr("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	f.ClearPrefix(fw.Inbound, netip.MustParsePrefix("192.168.7.0/24"))
+	if err := f.CheckAddress(fw.Inbound, netip.MustParseAddr("192.168.7.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	var addr net.Addr = &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1605}
+	if f.IsInboundOpen(&addr) {
+		t.Fatal("IsInboundOpen should follow inbound rules")
+	}
+}
+
+func TestPeerRules(t *testing.T) {
+	f := fw.NewFirewall()
+	f.DenyPeer(fw.Bidirectional, "H-bad")
+	if err := f.CheckPeer(fw.Inbound, "H-bad"); err == nil {
+		t.Fatal("should be denied")
+	}
+	if err := f.CheckPeer(fw.Outbound, "H-bad"); err == nil {
+		t.Fatal("should be denied")
+	}
+
+	f.AllowPeer(fw.Outbound, "H-good")
+	if err := f.CheckPeer(fw.Outbound, "H-good"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.CheckPeer(fw.Outbound, "H-other"); err == nil {
+		t.Fatal("should not be allowed")
+	}
+	if err := f.CheckPeer(fw.Inbound, "H-other"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.ClearPeer(fw.Bidirectional, "H-bad")
+	if err := f.CheckPeer(fw.Inbound, "H-bad"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBidirectionalCheck(t *testing.T) {
+	f := fw.NewFirewall()
+	if err := f.Check(fw.Bidirectional, "H-any", netip.MustParseAddr("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// denied in either direction is denied in both.
+	f.DenyPeer(fw.Outbound, "H-bad")
+	var blocked *fw.BlockedError
+	if err := f.CheckPeer(fw.Bidirectional, "H-bad"); !errors.As(err, &blocked) || blocked.Direction != fw.Outbound {
+		t.Fatal("should be denied outbound")
+	}
+	f.DenyPrefix(fw.Inbound, netip.MustParsePrefix("10.0.0.0/8"))
+	if err := f.CheckAddress(fw.Bidirectional, netip.MustParseAddr("10.0.0.1")); !errors.As(err, &blocked) || blocked.Direction != fw.Inbound {
+		t.Fatal("should be denied inbound")
+	}
+	if err := f.Check(fw.Bidirectional, "H-good", netip.MustParseAddr("192.168.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a direction without flags is denied, not a panic.
+	if err := f.Check(fw.Direction(0), "H-good", netip.MustParseAddr("192.168.0.1")); !errors.As(err, &blocked) {
+		t.Fatal("invalid direction should be denied")
+	}
+}
+
+func TestHotSwap(t *testing.T) {
+	f := fw.NewFirewall()
+	rule_set := fw.NewRuleSet()
+	rule_set.Inbound.DenyPeers["H-bad"] = struct{}{}
+	f.SetRuleSet(rule_set)
+
+	// the firewall keeps its own copy.
+	delete(rule_set.Inbound.DenyPeers, "H-bad")
+	if err := f.CheckPeer(fw.Inbound, "H-bad"); err == nil {
+		t.Fatal("rule set should be copied")
+	}
+
+	// concurrent updates must not be lost.
+	var wg sync.WaitGroup
+	for i := range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.DenyPeer(fw.Inbound, string(rune('a'+i)))
+		}()
+	}
+	wg.Wait()
+	if len(f.RuleSet().Inbound.DenyPeers) != 65 {
+		t.Fatal("lost update: ", len(f.RuleSet().Inbound.DenyPeers))
+	}
+}
+
+func TestChanged(t *testing.T) {
+	f := fw.NewFirewall()
+	changed := f.Changed()
+	f.DenyPeer(fw.Inbound, "H-bad")
+	select {
+	case <-changed:
+	default:
+		t.Fatal("update should close the changed channel")
+	}
+
+	// nil is an empty rule set.
+	changed = f.Changed()
+	f.SetRuleSet(nil)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("hot-swap should close the changed channel")
+	}
+	if err := f.CheckPeer(fw.Inbound, "H-bad"); err != nil {
+		t.Fatal(err)
+	}
+}
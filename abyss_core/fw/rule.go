@@ -0,0 +1,159 @@
+package fw
+
+import (
+	"maps"
+	"net/netip"
+	"slices"
+)
+
+// Direction is a bit flag; Bidirectional applies a rule to both directions.
+type Direction int
+
+const (
+	Inbound Direction = 1 << iota
+	Outbound
+
+	Bidirectional = Inbound | Outbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "inbound"
+	case Outbound:
+		return "outbound"
+	case Bidirectional:
+		return "bidirectional"
+	default:
+		return "unknown"
+	}
+}
+
+// DirectionRules is a set of rules for a single direction.
+// Deny lists take precedence. Empty allow lists allow everything.
+type DirectionRules struct {
+	AllowPrefixes []netip.Prefix
+	DenyPrefixes  []netip.Prefix
+	AllowPeers    map[string]struct{}
+	DenyPeers     map[string]struct{}
+}
+
+func newDirectionRules() DirectionRules {
+	return DirectionRules{
+		AllowPrefixes: make([]netip.Prefix, 0),
+		DenyPrefixes:  make([]netip.Prefix, 0),
+		AllowPeers:    make(map[string]struct{}),
+		DenyPeers:     make(map[string]struct{}),
+	}
+}
+
+func (d *DirectionRules) clone() DirectionRules {
+	result := DirectionRules{
+		AllowPrefixes: slices.Clone(d.AllowPrefixes),
+		DenyPrefixes:  slices.Clone(d.DenyPrefixes),
+		AllowPeers:    maps.Clone(d.AllowPeers),
+		DenyPeers:     maps.Clone(d.DenyPeers),
+	}
+	// maps.Clone keeps nil, which we do not want to write on.
+	if result.AllowPeers == nil {
+		result.AllowPeers = make(map[string]struct{})
+	}
+	if result.DenyPeers == nil {
+		result.DenyPeers = make(map[string]struct{})
+	}
+	return result
+}
+
+func (d *DirectionRules) checkAddress(direction Direction, addr netip.Addr) error {
+	addr = addr.Unmap()
+	for _, prefix := range d.DenyPrefixes {
+		if prefix.Contains(addr) {
+			return &BlockedError{Direction: direction, Addr: addr, Reason: "address denied"}
+		}
+	}
+	if len(d.AllowPrefixes) == 0 {
+		return nil
+	}
+	for _, prefix := range d.AllowPrefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	return &BlockedError{Direction: direction, Addr: addr, Reason: "address not allowed"}
+}
+
+func (d *DirectionRules) checkPeer(direction Direction, id string) error {
+	if _, ok := d.DenyPeers[id]; ok {
+		return &BlockedError{Direction: direction, PeerID: id, Reason: "peer denied"}
+	}
+	if len(d.AllowPeers) == 0 {
+		return nil
+	}
+	if _, ok := d.AllowPeers[id]; ok {
+		return nil
+	}
+	return &BlockedError{Direction: direction, PeerID: id, Reason: "peer not allowed"}
+}
+
+// RuleSet is the complete firewall configuration.
+// Once handed to a Firewall, it is treated as immutable.
+type RuleSet struct {
+	Inbound  DirectionRules
+	Outbound DirectionRules
+}
+
+// NewRuleSet creates an empty rule set, which allows everything.
+func NewRuleSet() *RuleSet {
+	return &RuleSet{
+		Inbound:  newDirectionRules(),
+		Outbound: newDirectionRules(),
+	}
+}
+
+func (r *RuleSet) Clone() *RuleSet {
+	return &RuleSet{
+		Inbound:  r.Inbound.clone(),
+		Outbound: r.Outbound.clone(),
+	}
+}
+
+// check evaluates f on the rules of each direction in the flag.
+// Bidirectional passes only if both directions pass; a direction with neither flag is denied.
+func (r *RuleSet) check(direction Direction, f func(d *DirectionRules, direction Direction) error) error {
+	if direction&Bidirectional == 0 {
+		return &BlockedError{Direction: direction, Reason: "invalid direction"}
+	}
+	if direction&Inbound != 0 {
+		if err := f(&r.Inbound, Inbound); err != nil {
+			return err
+		}
+	}
+	if direction&Outbound != 0 {
+		if err := f(&r.Outbound, Outbound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RuleSet) forEach(direction Direction, f func(d *DirectionRules)) {
+	if direction&Inbound != 0 {
+		f(&r.Inbound)
+	}
+	if direction&Outbound != 0 {
+		f(&r.Outbound)
+	}
+}
+
+func appendPrefix(prefixes []netip.Prefix, prefix netip.Prefix) []netip.Prefix {
+	prefix = prefix.Masked()
+	if slices.Contains(prefixes, prefix) {
+		return prefixes
+	}
+	return append(prefixes, prefix)
+}
+
+func removePrefix(prefixes []netip.Prefix, prefix netip.Prefix) []netip.Prefix {
+	prefix = prefix.Masked()
+	return slices.DeleteFunc(prefixes, func(p netip.Prefix) bool { return p == prefix })
+}
@@ -45,10 +45,13 @@ type AbyssHost struct {
 
 	join_queue map[uuid.UUID]chan *WorldCreationEvent //forwarding of AND join result event.
 	join_q_mtx *sync.Mutex
+
+	dispatcher *ahmp.Dispatcher[abyss.IANDPeer] // inbound AHMP messages
+	invites    *inviteTokens
 }
 
 func NewAbyssHost(netServ abyss.INetworkService, nda abyss.INeighborDiscovery, path_resolver abyss.IPathResolver) *AbyssHost {
-	return &AbyssHost{
+	result := &AbyssHost{
 		listen_done:                make(chan bool, 1),
 		event_done:                 make(chan bool, 1),
 		NetworkService:             netServ,
@@ -63,7 +66,20 @@ func NewAbyssHost(netServ abyss.INetworkService, nda abyss.INeighborDiscovery, p
 		worlds_mtx: new(sync.Mutex),
 		join_queue: make(map[uuid.UUID]chan *WorldCreationEvent),
 		join_q_mtx: new(sync.Mutex),
+		dispatcher: ahmp.NewDispatcher[abyss.IANDPeer](),
+		invites:    newInviteTokens(nil),
+	}
+	if provider, ok := netServ.(abyss.IRootSignerProvider); ok {
+		result.invites = newInviteTokens(provider.RootSigner())
 	}
+	result.handleAndMessages()
+	return result
+}
+
+// AhmpDispatcher routes inbound AHMP messages to the neighbor discovery algorithm.
+// Application messages, registered to ahmp.DefaultRegistry, are subscribed here with ahmp.Handle.
+func (h *AbyssHost) AhmpDispatcher() *ahmp.Dispatcher[abyss.IANDPeer] {
+	return h.dispatcher
 }
 
 func (h *AbyssHost) GetLocalAbyssURL() *aurl.AURL {
@@ -223,6 +239,7 @@ func (h *AbyssHost) serveLoop(peer abyss.IANDPeer) {
 	}
 
 	ahmp_channel := peer.AhmpCh()
+	datagram_channel := peer.DatagramCh()
 	for {
 		select {
 		case <-h.ctx.Done():
@@ -232,49 +249,137 @@ func (h *AbyssHost) serveLoop(peer abyss.IANDPeer) {
 			fmt.Println("peer expired: " + peer.Error().Error())
 			return
 		case message_any := <-ahmp_channel:
-			var and_result abyss.ANDERROR
-
-			switch message := message_any.(type) {
-			case *ahmp.JN:
-				local_session_id, ok := h.pathResolver.PathToSessionID(message.Text, peer.IDHash())
-				if !ok {
-					peer.TrySendJDN(message.SenderSessionID, and.JNC_NOT_FOUND, and.JNM_NOT_FOUND)
-					continue // TODO: respond with proper error code
-				}
-				and_result = h.neighborDiscoveryAlgorithm.JN(local_session_id, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.TimeStamp)
-			case *ahmp.JOK:
-				and_result = h.neighborDiscoveryAlgorithm.JOK(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.TimeStamp, message.Text, message.Neighbors)
-			case *ahmp.JDN:
-				and_result = h.neighborDiscoveryAlgorithm.JDN(message.RecverSessionID, peer, message.Code, message.Text)
-			case *ahmp.JNI:
-				and_result = h.neighborDiscoveryAlgorithm.JNI(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Neighbor)
-			case *ahmp.MEM:
-				and_result = h.neighborDiscoveryAlgorithm.MEM(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.TimeStamp)
-			case *ahmp.SJN:
-				and_result = h.neighborDiscoveryAlgorithm.SJN(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.MemberInfos)
-			case *ahmp.CRR:
-				and_result = h.neighborDiscoveryAlgorithm.CRR(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.MemberInfos)
-			case *ahmp.RST:
-				and_result = h.neighborDiscoveryAlgorithm.RST(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Message)
-			case *ahmp.SOA:
-				and_result = h.neighborDiscoveryAlgorithm.SOA(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Objects)
-			case *ahmp.SOD:
-				and_result = h.neighborDiscoveryAlgorithm.SOD(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.ObjectIDs)
-			case *ahmp.INVAL:
-				//parsing fail
-				watchdog.Error(message.Err)
+			err := h.dispatcher.Dispatch(peer, message_any)
+			switch {
+			case err == nil:
+			case errors.Is(err, errANDInvalid):
+				fmt.Println("AND: invalid arguments - " + reflect.TypeOf(message_any).String() + fmt.Sprintf("%+v", message_any))
+			case errors.Is(err, ahmp.ErrUnhandledMessage):
+				watchdog.Error(errors.New("unhandled ahmp message: " + reflect.TypeOf(message_any).String()))
 			default:
-				panic("unknown ahmp message type: This is internal implementation missing. MUST be resolved.")
+				watchdog.Error(err)
 			}
+		case datagram_any := <-datagram_channel:
+			h.handleDatagram(peer, datagram_any)
+		}
+	}
+}
 
-			switch and_result {
-			case abyss.EPANIC:
-				panic("AND panic!!!")
-			case abyss.EINVAL:
-				fmt.Println("AND: invalid arguments - " + reflect.TypeOf(message_any).String() + fmt.Sprintf("%+v", message_any))
-			}
+// handleDatagram raises datagram events to worlds. Invalid datagrams are silently dropped.
+func (h *AbyssHost) handleDatagram(peer abyss.IANDPeer, datagram_any any) {
+	switch datagram := datagram_any.(type) {
+	case *ahmp.DTU:
+		h.worlds_mtx.Lock()
+		world, ok := h.worlds[datagram.RecverSessionID]
+		h.worlds_mtx.Unlock()
+		if !ok {
+			return
+		}
+
+		peer.Renew()
+		world.RaiseObjectTransform(abyss.ANDPeerSession{Peer: peer, PeerSessionID: datagram.SenderSessionID}, datagram.Sequence, datagram.Objects)
+	}
+}
+
+var errANDInvalid = errors.New("AND: invalid arguments")
+
+// andResult converts the AND return value to a handler error.
+func andResult(and_result abyss.ANDERROR) error {
+	switch and_result {
+	case abyss.EPANIC:
+		panic("AND panic!!!")
+	case abyss.EINVAL:
+		return errANDInvalid
+	}
+	return nil
+}
+
+// admitJoin checks the invite of a joiner. A valid invite lets the joiner in without EWorldMemberRequest.
+func (h *AbyssHost) admitJoin(local_session_id uuid.UUID, peer abyss.IANDPeer, peer_session_id uuid.UUID, invite string) (int, string, bool) {
+	h.worlds_mtx.Lock()
+	world, ok := h.worlds[local_session_id]
+	h.worlds_mtx.Unlock()
+
+	if !ok {
+		return and.JNC_NOT_FOUND, and.JNM_NOT_FOUND, false
+	}
+	if invite == "" {
+		if world.IsInviteOnly() {
+			return and.JNC_INVITE_REQUIRED, and.JNM_INVITE_REQUIRED, false
 		}
+		return 0, "", true
 	}
+	use, err := h.invites.Verify(invite, local_session_id)
+	if err != nil {
+		watchdog.Info("invite from " + peer.IDHash() + ": " + err.Error())
+		return and.JNC_INVITE_INVALID, and.JNM_INVITE_INVALID, false
+	}
+	world.AdmitInvited(peer_session_id, use)
+	return 0, "", true
+}
+
+func (h *AbyssHost) handleAndMessages() {
+	nda := h.neighborDiscoveryAlgorithm
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.JN) error {
+		path, invite := splitInvite(message.Text)
+		local_session_id, ok := h.pathResolver.PathToSessionID(path, peer.IDHash())
+		if !ok {
+			peer.TrySendJDN(message.SenderSessionID, and.JNC_NOT_FOUND, and.JNM_NOT_FOUND)
+			return nil // TODO: respond with proper error code
+		}
+		if code, text, ok := h.admitJoin(local_session_id, peer, message.SenderSessionID, invite); !ok {
+			peer.TrySendJDN(message.SenderSessionID, code, text)
+			return nil
+		}
+		return andResult(nda.JN(local_session_id, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.TimeStamp))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.JOK) error {
+		return andResult(nda.JOK(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.TimeStamp, message.Text, message.Neighbors, abyss.WorldSettings{
+			Access:         message.Access,
+			MaxMembers:     message.MaxMembers,
+			InterestRadius: message.InterestRadius,
+			InviteOnly:     message.InviteOnly,
+		}))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.JDN) error {
+		return andResult(nda.JDN(message.RecverSessionID, peer, message.Code, message.Text))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.JNI) error {
+		return andResult(nda.JNI(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Neighbor))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.MEM) error {
+		return andResult(nda.MEM(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.TimeStamp))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.SJN) error {
+		return andResult(nda.SJN(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.MemberInfos))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.CRR) error {
+		return andResult(nda.CRR(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.MemberInfos))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.RST) error {
+		return andResult(nda.RST(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Message))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.SOA) error {
+		return andResult(nda.SOA(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Objects))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.SOD) error {
+		return andResult(nda.SOD(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.ObjectIDs))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.SOU) error {
+		return andResult(nda.SOU(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Objects))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.SAM) error {
+		return andResult(nda.SAM(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Topic, message.Payload))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.MOD) error {
+		return andResult(nda.MOD(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Moderation))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.ROS) error {
+		return andResult(nda.ROS(message.RecverSessionID, abyss.ANDPeerSession{Peer: peer, PeerSessionID: message.SenderSessionID}, message.Keep, message.Local, message.Roster))
+	})
+	ahmp.Handle(h.dispatcher, func(peer abyss.IANDPeer, message *ahmp.INVAL) error {
+		return message.Err // parsing fail
+	})
 }
 
 func (h *AbyssHost) eventLoop() {
@@ -315,11 +420,26 @@ func (h *AbyssHost) eventLoop() {
 					panic("world not found")
 				}
 
+				if use, ok := world.TakeInvite(e.PeerSessionID); ok {
+					h.invites.Use(use)
+				}
 				e.Peer.Activate()
 				world.RaisePeerReady(abyss.ANDPeerSession{
 					Peer:          e.Peer,
 					PeerSessionID: e.PeerSessionID,
 				})
+			case abyss.ANDSessionDrop:
+				h.worlds_mtx.Lock()
+				world, ok := h.worlds[e.LocalSessionID]
+				h.worlds_mtx.Unlock()
+
+				if !ok || world == nil { // not joined yet, or failed to join
+					break
+				}
+
+				if use, ok := world.TakeInvite(e.PeerSessionID); ok {
+					h.invites.Release(use)
+				}
 			case abyss.ANDSessionClose:
 				//fmt.Println(h.NetworkService.LocalIdentity().IDHash()[:6] + " event ::: abyss.ANDSessionClose")
 				h.worlds_mtx.Lock()
@@ -337,7 +457,7 @@ func (h *AbyssHost) eventLoop() {
 
 				var new_world *World
 				if e.Type == abyss.ANDJoinSuccess {
-					new_world = NewWorld(h.neighborDiscoveryAlgorithm, e.LocalSessionID, e.Text)
+					new_world = NewWorld(h.neighborDiscoveryAlgorithm, e.LocalSessionID, e.Text, h.NetworkService.LocalIdentity().IDHash())
 					h.worlds_mtx.Lock()
 					h.worlds[e.LocalSessionID] = new_world
 					h.worlds_mtx.Unlock()
@@ -405,7 +525,11 @@ func (h *AbyssHost) eventLoop() {
 			case abyss.ANDPeerRegister:
 				//fmt.Println(h.NetworkService.LocalIdentity().IDHash()[:6] + " event ::: abyss.ANDPeerRegister")
 				certificates := e.Object.(*abyss.PeerCertificates)
-				h.NetworkService.AppendKnownPeerDer(certificates.RootCertDer, certificates.HandshakeKeyCertDer)
+				if introducer, ok := h.NetworkService.(abyss.IPeerIntroducer); ok {
+					introducer.IntroducePeerDer(certificates.Introducer, certificates.RootCertDer, certificates.HandshakeKeyCertDer)
+				} else {
+					h.NetworkService.AppendKnownPeerDer(certificates.RootCertDer, certificates.HandshakeKeyCertDer)
+				}
 
 			case abyss.ANDObjectAppend:
 				//fmt.Println(h.NetworkService.LocalIdentity().IDHash()[:6] + " event ::: abyss.ANDObjectAppend")
@@ -433,6 +557,41 @@ func (h *AbyssHost) eventLoop() {
 				e.Peer.Renew()
 				world.RaiseObjectDelete(e.Peer.IDHash(), e.Object.([]uuid.UUID))
 
+			case abyss.ANDObjectUpdate:
+				h.worlds_mtx.Lock()
+				world, ok := h.worlds[e.LocalSessionID]
+				h.worlds_mtx.Unlock()
+
+				if !ok {
+					panic("world not found")
+				}
+
+				e.Peer.Renew()
+				world.RaiseObjectUpdate(e.Peer.IDHash(), e.Object.([]abyss.ObjectUpdate))
+
+			case abyss.ANDMemberMessage:
+				h.worlds_mtx.Lock()
+				world, ok := h.worlds[e.LocalSessionID]
+				h.worlds_mtx.Unlock()
+
+				if !ok {
+					panic("world not found")
+				}
+
+				e.Peer.Renew()
+				world.RaiseMemberMessage(e.Peer.IDHash(), e.Text, e.Object.([]byte))
+
+			case abyss.ANDWorldModeration:
+				h.worlds_mtx.Lock()
+				world, ok := h.worlds[e.LocalSessionID]
+				h.worlds_mtx.Unlock()
+
+				if !ok {
+					panic("world not found")
+				}
+
+				world.RaiseModeration(e.Object.(*abyss.WorldModeration))
+
 			case abyss.ANDNeighborEventDebug:
 				//fmt.Println(h.NetworkService.LocalIdentity().IDHash()[:6] + " event ::: abyss.ANDNeighborEventDebug")
 				fmt.Println(time.Now().Format("00:00:00.000") + " " + e.Text)
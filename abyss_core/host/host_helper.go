@@ -17,5 +17,8 @@ func NewBetaAbyssHost(ctx context.Context, root_private_key abyss_net.PrivateKey
 	path_resolver := NewSimplePathResolver()
 	netserv, _ := abyss_net.NewBetaNetService(ctx, root_private_key, address_selector, abyst_server)
 
-	return NewAbyssHost(netserv, abyss_and.NewAND(netserv.LocalAURL().Hash), path_resolver), path_resolver, nil
+	and := abyss_and.NewAND(netserv.LocalAURL().Hash)
+	and.SetRootSigner(netserv.RootSigner())
+
+	return NewAbyssHost(netserv, and, path_resolver), path_resolver, nil
 }
@@ -0,0 +1,217 @@
+package host
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/uuid"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+///// world invites
+// An invite is a token in the query of the world path, e.g. "world?invite=...".
+// It is signed with the root key of the host that verifies it, which is the host
+// named in the invite AURL, and is bound to the local session id of the world.
+// A joiner with a valid invite is accepted without EWorldMemberRequest.
+// A single-use invite is reserved by the JN that presents it, and used up when
+// the joiner is ready; if the join fails, it is released for another try.
+// Used invites are remembered until they expire.
+
+const InviteQueryKey = "invite"
+
+type rawInvite struct {
+	SessionID []byte `cbor:"1,keyasint"`
+	Expiry    int64  `cbor:"2,keyasint"` // unix milli
+	Nonce     []byte `cbor:"3,keyasint"`
+	SingleUse bool   `cbor:"4,keyasint,omitempty"`
+}
+
+type rawSignedInvite struct {
+	Body      []byte `cbor:"1,keyasint"`
+	Signature []byte `cbor:"2,keyasint"`
+}
+
+var (
+	errInviteUnavailable = errors.New("invites require a root signer")
+	errInviteInvalid     = errors.New("invalid invite")
+	errInviteExpired     = errors.New("invite expired")
+	errInviteUsed        = errors.New("invite already used")
+)
+
+// inviteUse is a verified invite, held by a joiner.
+type inviteUse struct {
+	nonce  string // hex; empty for invites that are not single-use
+	expiry time.Time
+}
+
+func inviteMessage(body []byte) []byte {
+	return append([]byte("abyss world invite\x00"), body...)
+}
+
+type inviteTokens struct {
+	signer abyss.IRootSigner //nil disables invites
+
+	used     map[string]time.Time //key: nonce (hex), value: expiry
+	reserved map[string]time.Time //key: nonce (hex), value: expiry
+	mtx      sync.Mutex
+}
+
+func newInviteTokens(signer abyss.IRootSigner) *inviteTokens {
+	return &inviteTokens{
+		signer:   signer,
+		used:     make(map[string]time.Time),
+		reserved: make(map[string]time.Time),
+	}
+}
+
+func (t *inviteTokens) Issue(session_id uuid.UUID, expiry time.Time, single_use bool) (string, error) {
+	if t.signer == nil {
+		return "", errInviteUnavailable
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	body, err := cbor.Marshal(rawInvite{
+		SessionID: session_id[:],
+		Expiry:    expiry.UnixMilli(),
+		Nonce:     nonce,
+		SingleUse: single_use,
+	})
+	if err != nil {
+		return "", err
+	}
+	signature, err := t.signer.Sign(inviteMessage(body))
+	if err != nil {
+		return "", err
+	}
+	token, err := cbor.Marshal(rawSignedInvite{Body: body, Signature: signature})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// Verify checks the token for the world, and reserves it if it is single-use.
+// The result must be passed to Use or Release.
+func (t *inviteTokens) Verify(token string, session_id uuid.UUID) (inviteUse, error) {
+	if t.signer == nil {
+		return inviteUse{}, errInviteUnavailable
+	}
+	token_bytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return inviteUse{}, errInviteInvalid
+	}
+	var signed rawSignedInvite
+	if err := cbor.Unmarshal(token_bytes, &signed); err != nil {
+		return inviteUse{}, errInviteInvalid
+	}
+	if _, err := t.signer.Verify(t.signer.RootCertificateDer(), inviteMessage(signed.Body), signed.Signature); err != nil {
+		return inviteUse{}, errInviteInvalid
+	}
+	var invite rawInvite
+	if err := cbor.Unmarshal(signed.Body, &invite); err != nil {
+		return inviteUse{}, errInviteInvalid
+	}
+	invite_session_id, err := uuid.FromBytes(invite.SessionID)
+	if err != nil || invite_session_id != session_id {
+		return inviteUse{}, errInviteInvalid
+	}
+	result := inviteUse{expiry: time.UnixMilli(invite.Expiry)}
+	now := time.Now()
+	if now.After(result.expiry) {
+		return inviteUse{}, errInviteExpired
+	}
+	if !invite.SingleUse {
+		return result, nil
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, nonces := range []map[string]time.Time{t.used, t.reserved} {
+		for nonce, nonce_expiry := range nonces {
+			if now.After(nonce_expiry) {
+				delete(nonces, nonce)
+			}
+		}
+	}
+	result.nonce = hex.EncodeToString(invite.Nonce)
+	if _, ok := t.used[result.nonce]; ok {
+		return inviteUse{}, errInviteUsed
+	}
+	if _, ok := t.reserved[result.nonce]; ok {
+		return inviteUse{}, errInviteUsed
+	}
+	t.reserved[result.nonce] = result.expiry
+	return result, nil
+}
+
+// Use uses up a single-use invite, once its joiner is ready.
+func (t *inviteTokens) Use(use inviteUse) {
+	if use.nonce == "" {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	delete(t.reserved, use.nonce)
+	t.used[use.nonce] = use.expiry
+}
+
+// Release makes a single-use invite available again, after its join failed.
+func (t *inviteTokens) Release(use inviteUse) {
+	if use.nonce == "" {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	delete(t.reserved, use.nonce)
+}
+
+// splitInvite removes the invite from a world path. Other query parameters are kept.
+func splitInvite(path string) (string, string) {
+	base, query, ok := strings.Cut(path, "?")
+	if !ok {
+		return path, ""
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil || !values.Has(InviteQueryKey) {
+		return path, ""
+	}
+	token := values.Get(InviteQueryKey)
+	values.Del(InviteQueryKey)
+	if len(values) != 0 {
+		base += "?" + values.Encode()
+	}
+	return base, token
+}
+
+// CreateInvite returns an AURL to join the world through the local host.
+// path must be mapped to the world by the path resolver.
+func (h *AbyssHost) CreateInvite(world abyss.IAbyssWorld, path string, ttl time.Duration, single_use bool) (*aurl.AURL, error) {
+	if session_id, ok := h.pathResolver.PathToSessionID(path, h.NetworkService.LocalIdentity().IDHash()); !ok || session_id != world.SessionID() {
+		return nil, errors.New("path is not mapped to the world")
+	}
+	token, err := h.invites.Issue(world.SessionID(), time.Now().Add(ttl), single_use)
+	if err != nil {
+		return nil, err
+	}
+	result := h.GetLocalAbyssURL()
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	result.Path = path + separator + InviteQueryKey + "=" + token
+	return result, nil
+}
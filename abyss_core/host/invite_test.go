@@ -0,0 +1,100 @@
+package host
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/net_service"
+)
+
+func newTestInviteTokens(t *testing.T) *inviteTokens {
+	root_key, err := net_service.NewRootPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root_secrets, err := net_service.NewRootIdentity(root_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newInviteTokens(root_secrets)
+}
+
+func TestInviteSignature(t *testing.T) {
+	tokens, other := newTestInviteTokens(t), newTestInviteTokens(t)
+	session_id := uuid.New()
+	token, err := tokens.Issue(session_id, time.Now().Add(time.Minute), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Verify(token, session_id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Verify(token, uuid.New()); !errors.Is(err, errInviteInvalid) {
+		t.Fatal("invite for another world accepted: ", err)
+	}
+	if _, err := other.Verify(token, session_id); !errors.Is(err, errInviteInvalid) {
+		t.Fatal("invite of another host accepted: ", err)
+	}
+	token_bytes, _ := base64.RawURLEncoding.DecodeString(token)
+	token_bytes[len(token_bytes)-1] ^= 1
+	if _, err := tokens.Verify(base64.RawURLEncoding.EncodeToString(token_bytes), session_id); !errors.Is(err, errInviteInvalid) {
+		t.Fatal("tampered invite accepted: ", err)
+	}
+	if _, err := newInviteTokens(nil).Verify(token, session_id); !errors.Is(err, errInviteUnavailable) {
+		t.Fatal("invite accepted without signer: ", err)
+	}
+}
+
+func TestInviteExpiry(t *testing.T) {
+	tokens := newTestInviteTokens(t)
+	session_id := uuid.New()
+	token, err := tokens.Issue(session_id, time.Now().Add(-time.Second), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Verify(token, session_id); !errors.Is(err, errInviteExpired) {
+		t.Fatal("expired invite accepted: ", err)
+	}
+}
+
+// TestInviteSingleUse checks that a single-use invite is held by one join,
+// released if the join fails, and used up once the joiner is ready.
+func TestInviteSingleUse(t *testing.T) {
+	tokens := newTestInviteTokens(t)
+	session_id := uuid.New()
+	token, err := tokens.Issue(session_id, time.Now().Add(time.Minute), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	use, err := tokens.Verify(token, session_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Verify(token, session_id); !errors.Is(err, errInviteUsed) {
+		t.Fatal("reserved invite accepted: ", err)
+	}
+	tokens.Release(use)
+	if use, err = tokens.Verify(token, session_id); err != nil {
+		t.Fatal("released invite refused: ", err)
+	}
+	tokens.Use(use)
+	if _, err := tokens.Verify(token, session_id); !errors.Is(err, errInviteUsed) {
+		t.Fatal("used invite accepted: ", err)
+	}
+
+	reusable, err := tokens.Issue(session_id, time.Now().Add(time.Minute), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		use, err := tokens.Verify(reusable, session_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens.Use(use)
+	}
+}
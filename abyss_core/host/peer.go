@@ -1,6 +1,9 @@
 package host
 
 import (
+	"sync/atomic"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
 	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
 
 	"github.com/google/uuid"
@@ -10,6 +13,9 @@ type WorldMember struct {
 	world       *World
 	hash        string
 	peerSession abyss.ANDPeerSession
+
+	sequence atomic.Uint64        // of sent datagrams
+	filter   *ahmp.SequenceFilter // of received datagrams
 }
 
 func (p *WorldMember) Hash() string {
@@ -24,3 +30,38 @@ func (p *WorldMember) AppendObjects(objects []abyss.ObjectInfo) bool {
 func (p *WorldMember) DeleteObjects(objectIDs []uuid.UUID) bool {
 	return p.peerSession.Peer.TrySendSOD(p.world.session_id, p.peerSession.PeerSessionID, objectIDs)
 }
+
+// UpdateObjects splits the updates into SOUs of ahmp.MaxSOUObjects.
+func (p *WorldMember) UpdateObjects(updates []abyss.ObjectUpdate) bool {
+	for len(updates) > 0 {
+		n := min(len(updates), ahmp.MaxSOUObjects)
+		if !p.peerSession.Peer.TrySendSOU(p.world.session_id, p.peerSession.PeerSessionID, updates[:n]) {
+			return false
+		}
+		updates = updates[n:]
+	}
+	return true
+}
+
+// SendMessage fails without sending if the topic or payload exceeds the AHMP limits,
+// as the member would reject the message.
+func (p *WorldMember) SendMessage(topic string, payload []byte) bool {
+	if len(topic) == 0 || len(topic) > ahmp.MaxSAMTopicLength || len(payload) > ahmp.MaxSAMPayloadSize {
+		return false
+	}
+	return p.peerSession.Peer.TrySendSAM(p.world.session_id, p.peerSession.PeerSessionID, topic, payload)
+}
+
+// UpdateTransforms splits the transforms into datagrams of ahmp.MaxDTUObjects,
+// each with a new sequence number. The anchor of the world is moved as well.
+func (p *WorldMember) UpdateTransforms(transforms []abyss.ObjectTransform) bool {
+	p.world.trackTransforms(transforms)
+	for len(transforms) > 0 {
+		n := min(len(transforms), ahmp.MaxDTUObjects)
+		if !p.peerSession.Peer.TrySendDTU(p.world.session_id, p.peerSession.PeerSessionID, p.sequence.Add(1), transforms[:n]) {
+			return false
+		}
+		transforms = transforms[n:]
+	}
+	return true
+}
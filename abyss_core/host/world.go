@@ -1,6 +1,10 @@
 package host
 
 import (
+	"errors"
+	"sync"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
 	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
 
 	"github.com/google/uuid"
@@ -10,15 +14,31 @@ type World struct {
 	origin       abyss.INeighborDiscovery
 	session_id   uuid.UUID
 	url          string
+	local_hash   string
 	eventChannel chan any
+
+	members  map[string]*WorldMember               // ready members. key: hash
+	objects  map[string]map[uuid.UUID]*worldObject // object table. key: owner hash
+	mtx      sync.Mutex                            // for members, objects and the anchor
+	send_mtx sync.Mutex                            // orders object messages; see lockSend
+
+	anchor   uuid.UUID   // local object at the local position
+	position *[3]float32 // last sent to origin
+
+	invited    map[uuid.UUID]inviteUse // key: peer session id; accepted without EWorldMemberRequest
+	invite_mtx sync.Mutex
 }
 
-func NewWorld(origin abyss.INeighborDiscovery, session_id uuid.UUID, url string) *World {
+func NewWorld(origin abyss.INeighborDiscovery, session_id uuid.UUID, url string, local_hash string) *World {
 	return &World{
 		origin:       origin,
 		session_id:   session_id,
 		url:          url,
+		local_hash:   local_hash,
 		eventChannel: make(chan any, 4096),
+		members:      make(map[string]*WorldMember),
+		objects:      make(map[string]map[uuid.UUID]*worldObject),
+		invited:      make(map[uuid.UUID]inviteUse),
 	}
 }
 
@@ -28,7 +48,43 @@ func (w *World) GetEventChannel() chan any {
 	return w.eventChannel
 }
 
+// SetInviteOnly makes joins through the local host require an invite.
+// The flag is sent to joiners, so that joins through them require their invites.
+func (w *World) SetInviteOnly(invite_only bool) {
+	w.origin.SetInviteOnly(w.session_id, invite_only)
+}
+func (w *World) IsInviteOnly() bool {
+	return w.origin.IsInviteOnly(w.session_id)
+}
+func (w *World) SetCapacity(max_members int, queue_length int) error {
+	return andResult(w.origin.SetWorldCapacity(w.session_id, max_members, queue_length))
+}
+func (w *World) AdmitInvited(peer_session_id uuid.UUID, use inviteUse) {
+	w.invite_mtx.Lock()
+	defer w.invite_mtx.Unlock()
+
+	w.invited[peer_session_id] = use
+}
+
+// TakeInvite removes the invite of a joiner, when it is ready or dropped.
+func (w *World) TakeInvite(peer_session_id uuid.UUID) (inviteUse, bool) {
+	w.invite_mtx.Lock()
+	defer w.invite_mtx.Unlock()
+
+	use, ok := w.invited[peer_session_id]
+	delete(w.invited, peer_session_id)
+	return use, ok
+}
+
 func (w *World) RaisePeerRequest(peer_session abyss.ANDPeerSession) {
+	w.invite_mtx.Lock()
+	_, invited := w.invited[peer_session.PeerSessionID]
+	w.invite_mtx.Unlock()
+
+	if invited {
+		w.origin.AcceptSession(w.session_id, peer_session)
+		return
+	}
 	w.eventChannel <- abyss.EWorldMemberRequest{
 		MemberHash: peer_session.Peer.IDHash(),
 		Accept: func() {
@@ -40,27 +96,121 @@ func (w *World) RaisePeerRequest(peer_session abyss.ANDPeerSession) {
 	}
 }
 func (w *World) RaisePeerReady(peer_session abyss.ANDPeerSession) {
+	member := &WorldMember{
+		world:       w,
+		hash:        peer_session.Peer.IDHash(),
+		peerSession: peer_session,
+		filter:      ahmp.NewSequenceFilter(),
+	}
+	w.mtx.Lock()
+	w.members[member.hash] = member
+	infos, updates := w.snapshot()
+	w.send_mtx.Lock()
+	w.mtx.Unlock()
+
+	if len(infos) != 0 {
+		member.AppendObjects(infos)
+	}
+	if len(updates) != 0 {
+		member.UpdateObjects(updates)
+	}
+	w.send_mtx.Unlock()
+
 	w.eventChannel <- abyss.EWorldMemberReady{
-		Member: &WorldMember{
-			world:       w,
-			hash:        peer_session.Peer.IDHash(),
-			peerSession: peer_session,
-		},
+		Member: member,
 	}
 }
 func (w *World) RaiseObjectAppend(peer_hash string, objects []abyss.ObjectInfo) {
+	w.mtx.Lock()
+	objects = w.applyAppend(peer_hash, objects)
+	w.mtx.Unlock()
+
+	if len(objects) == 0 {
+		return
+	}
 	w.eventChannel <- abyss.EMemberObjectAppend{
 		PeerHash: peer_hash,
 		Objects:  objects,
 	}
 }
 func (w *World) RaiseObjectDelete(peer_hash string, objectIDs []uuid.UUID) {
+	w.mtx.Lock()
+	w.applyDelete(peer_hash, objectIDs)
+	if member, ok := w.members[peer_hash]; ok {
+		member.filter.Forget(objectIDs)
+	}
+	w.mtx.Unlock()
+
 	w.eventChannel <- abyss.EMemberObjectDelete{
 		PeerHash:  peer_hash,
 		ObjectIDs: objectIDs,
 	}
 }
+func (w *World) RaiseObjectUpdate(peer_hash string, updates []abyss.ObjectUpdate) {
+	w.mtx.Lock()
+	w.applyUpdate(peer_hash, updates)
+	w.mtx.Unlock()
+
+	w.eventChannel <- abyss.EMemberObjectUpdate{
+		PeerHash: peer_hash,
+		Updates:  updates,
+	}
+}
+func (w *World) RaiseMemberMessage(peer_hash string, topic string, payload []byte) {
+	w.eventChannel <- abyss.EMemberMessage{
+		PeerHash: peer_hash,
+		Topic:    topic,
+		Payload:  payload,
+	}
+}
+func (w *World) RaiseModeration(moderation *abyss.WorldModeration) {
+	w.eventChannel <- abyss.EWorldModeration{
+		Action: moderation.Action,
+		Issuer: moderation.Issuer,
+		Target: moderation.Target,
+		Reason: moderation.Reason,
+	}
+}
+
+// RaiseObjectTransform drops stale updates, and updates from a session that is not a ready member.
+// Unlike other events, it is dropped if the event channel is full.
+func (w *World) RaiseObjectTransform(peer_session abyss.ANDPeerSession, sequence uint64, transforms []abyss.ObjectTransform) {
+	w.mtx.Lock()
+	member, ok := w.members[peer_session.Peer.IDHash()]
+	if !ok || member.peerSession.PeerSessionID != peer_session.PeerSessionID {
+		w.mtx.Unlock()
+		return
+	}
+	transforms = member.filter.Filter(sequence, transforms)
+	w.applyTransform(member.hash, transforms)
+	w.mtx.Unlock()
+
+	if len(transforms) == 0 {
+		return
+	}
+	select {
+	case w.eventChannel <- abyss.EMemberObjectTransform{
+		PeerHash:   member.hash,
+		Transforms: transforms,
+	}:
+	default:
+	}
+}
+
+// RaisePeerLeave raises EMemberObjectDelete for the objects the member left behind.
 func (w *World) RaisePeerLeave(peer_hash string) {
+	w.mtx.Lock()
+	delete(w.members, peer_hash)
+	objectIDs := w.removeOwner(peer_hash)
+	w.mtx.Unlock()
+
+	if len(objectIDs) != 0 {
+		w.eventChannel <- abyss.EMemberObjectDelete{
+			PeerHash:  peer_hash,
+			ObjectIDs: objectIDs,
+		}
+	}
+
 	w.eventChannel <- abyss.EWorldMemberLeave{
 		PeerHash: peer_hash,
 	}
@@ -68,3 +218,18 @@ func (w *World) RaisePeerLeave(peer_hash string) {
 func (w *World) RaiseWorldTerminate() {
 	w.eventChannel <- abyss.EWorldTerminate{}
 }
+
+var errModerationRejected = errors.New("moderation rejected: no access list, or insufficient role")
+
+func (w *World) Role(peer_hash string) abyss.WorldRole {
+	return w.origin.Role(w.session_id, peer_hash)
+}
+func (w *World) Moderate(action abyss.ModerationAction, target_hash string, reason string) error {
+	if len(reason) > ahmp.MaxMODReasonLength {
+		return errors.New("reason too long")
+	}
+	if w.origin.Moderate(w.session_id, action, target_hash, reason) != 0 {
+		return errModerationRejected
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package host
+
+import (
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+
+	"github.com/google/uuid"
+)
+
+///// partial mesh
+// The local position is that of the anchor, taken from the local object table and the transforms
+// sent to members. It is sent to origin when it moves more than positionThreshold.
+
+const positionThreshold = 0.5
+
+func (w *World) SetInterest(radius float32, anchor uuid.UUID) error {
+	if radius != 0 {
+		if err := andResult(w.origin.SetInterest(w.session_id, radius)); err != nil {
+			return err
+		}
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.anchor = anchor
+	w.position = nil
+	if object, ok := w.objects[w.local_hash][anchor]; ok {
+		w.moveAnchor(anchor, object.info.Transform)
+	}
+	return nil
+}
+
+// moveAnchor is called with mtx held.
+func (w *World) moveAnchor(object_id uuid.UUID, transform [7]float32) {
+	if object_id != w.anchor || w.anchor == uuid.Nil {
+		return
+	}
+	position := [3]float32(transform[:3])
+	if w.position != nil {
+		var sum float32
+		for i := range position {
+			d := position[i] - w.position[i]
+			sum += d * d
+		}
+		if sum < positionThreshold*positionThreshold {
+			return
+		}
+	}
+	if w.origin.UpdatePosition(w.session_id, position) == 0 {
+		w.position = &position
+	}
+}
+
+func (w *World) trackTransforms(transforms []abyss.ObjectTransform) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, transform := range transforms {
+		w.moveAnchor(transform.ID, transform.Transform)
+	}
+}
+
+func (w *World) Roster() []abyss.WorldRosterMember {
+	roster := w.origin.Roster(w.session_id)
+	if roster == nil {
+		return nil
+	}
+	result := make([]abyss.WorldRosterMember, 0, len(roster))
+	for _, entry := range roster {
+		result = append(result, abyss.WorldRosterMember{
+			PeerHash: entry.AURL.Hash,
+			Position: entry.Position,
+		})
+	}
+	return result
+}
@@ -0,0 +1,197 @@
+package host
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+
+	"github.com/google/uuid"
+)
+
+///// world object table
+// Each peer is authoritative for the objects it owns.
+// Local objects are sent to every ready member, and to members that become ready later.
+// Objects of a member are recorded from its SOA, SOD, SOU and DTU, and removed when it leaves.
+// The table is guarded by World.mtx. Messages are sent after releasing it, in the order
+// of the table changes, under World.send_mtx.
+
+// MaxObjectsPerOwner bounds the objects of each owner, local or remote.
+// Appends beyond it are ignored, and not raised as events.
+const MaxObjectsPerOwner = 4096
+
+type worldObject struct {
+	info       abyss.ObjectInfo
+	properties map[string]string
+}
+
+func (w *World) ownedObjects(owner string) map[uuid.UUID]*worldObject {
+	objects, ok := w.objects[owner]
+	if !ok {
+		objects = make(map[uuid.UUID]*worldObject)
+		w.objects[owner] = objects
+	}
+	return objects
+}
+
+// applyAppend returns the appended objects, without those beyond MaxObjectsPerOwner.
+func (w *World) applyAppend(owner string, infos []abyss.ObjectInfo) []abyss.ObjectInfo {
+	objects := w.ownedObjects(owner)
+	result := infos[:0:0]
+	for _, info := range infos {
+		if _, ok := objects[info.ID]; !ok && len(objects) >= MaxObjectsPerOwner {
+			continue
+		}
+		objects[info.ID] = &worldObject{info: info}
+		result = append(result, info)
+	}
+	return result
+}
+
+func (w *World) applyDelete(owner string, objectIDs []uuid.UUID) {
+	objects := w.ownedObjects(owner)
+	for _, oid := range objectIDs {
+		delete(objects, oid)
+	}
+}
+
+// applyUpdate ignores updates of unknown objects.
+func (w *World) applyUpdate(owner string, updates []abyss.ObjectUpdate) {
+	objects := w.ownedObjects(owner)
+	for _, update := range updates {
+		object, ok := objects[update.ID]
+		if !ok {
+			continue
+		}
+		if update.Transform != nil {
+			object.info.Transform = *update.Transform
+		}
+		if len(update.Properties) != 0 && object.properties == nil {
+			object.properties = make(map[string]string)
+		}
+		maps.Copy(object.properties, update.Properties)
+		for _, key := range update.RemovedProperties {
+			delete(object.properties, key)
+		}
+	}
+}
+
+func (w *World) applyTransform(owner string, transforms []abyss.ObjectTransform) {
+	objects := w.ownedObjects(owner)
+	for _, transform := range transforms {
+		if object, ok := objects[transform.ID]; ok {
+			object.info.Transform = transform.Transform
+		}
+	}
+}
+
+// removeOwner returns the ids of the removed objects.
+func (w *World) removeOwner(owner string) []uuid.UUID {
+	objects := w.objects[owner]
+	delete(w.objects, owner)
+	result := make([]uuid.UUID, 0, len(objects))
+	for oid := range objects {
+		result = append(result, oid)
+	}
+	return result
+}
+
+// snapshot returns the local objects for a new member; SOA, then SOU for the properties.
+func (w *World) snapshot() ([]abyss.ObjectInfo, []abyss.ObjectUpdate) {
+	objects := w.objects[w.local_hash]
+	infos := make([]abyss.ObjectInfo, 0, len(objects))
+	updates := make([]abyss.ObjectUpdate, 0)
+	for _, object := range objects {
+		infos = append(infos, object.info)
+		// an update carries at most ahmp.MaxSOUProperties.
+		var properties map[string]string
+		for key, value := range object.properties {
+			if len(properties) == ahmp.MaxSOUProperties {
+				updates = append(updates, abyss.ObjectUpdate{ID: object.info.ID, Properties: properties})
+				properties = nil
+			}
+			if properties == nil {
+				properties = make(map[string]string)
+			}
+			properties[key] = value
+		}
+		if properties != nil {
+			updates = append(updates, abyss.ObjectUpdate{ID: object.info.ID, Properties: properties})
+		}
+	}
+	return infos, updates
+}
+
+// lockSend is called with mtx held. It returns the ready members, to send to
+// after mtx is released; the caller must unlock send_mtx when done.
+func (w *World) lockSend() []*WorldMember {
+	w.send_mtx.Lock()
+	return slices.Collect(maps.Values(w.members))
+}
+
+// AppendObjects shares local objects with every member.
+func (w *World) AppendObjects(objects []abyss.ObjectInfo) {
+	w.mtx.Lock()
+	objects = w.applyAppend(w.local_hash, objects)
+	for _, object := range objects {
+		w.moveAnchor(object.ID, object.Transform)
+	}
+	members := w.lockSend()
+	w.mtx.Unlock()
+	defer w.send_mtx.Unlock()
+
+	if len(objects) == 0 {
+		return
+	}
+	for _, member := range members {
+		member.AppendObjects(objects)
+	}
+}
+
+func (w *World) DeleteObjects(objectIDs []uuid.UUID) {
+	w.mtx.Lock()
+	w.applyDelete(w.local_hash, objectIDs)
+	members := w.lockSend()
+	w.mtx.Unlock()
+	defer w.send_mtx.Unlock()
+
+	for _, member := range members {
+		member.DeleteObjects(objectIDs)
+	}
+}
+
+func (w *World) UpdateObjects(updates []abyss.ObjectUpdate) {
+	w.mtx.Lock()
+	w.applyUpdate(w.local_hash, updates)
+	for _, update := range updates {
+		if update.Transform != nil {
+			w.moveAnchor(update.ID, *update.Transform)
+		}
+	}
+	members := w.lockSend()
+	w.mtx.Unlock()
+	defer w.send_mtx.Unlock()
+
+	for _, member := range members {
+		member.UpdateObjects(updates)
+	}
+}
+
+// Objects returns a copy of the table.
+func (w *World) Objects() []abyss.WorldObject {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	result := make([]abyss.WorldObject, 0)
+	for owner, objects := range w.objects {
+		for _, object := range objects {
+			result = append(result, abyss.WorldObject{
+				Owner:      owner,
+				ObjectInfo: object.info,
+				Properties: maps.Clone(object.properties),
+			})
+		}
+	}
+	return result
+}
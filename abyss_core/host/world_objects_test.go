@@ -0,0 +1,132 @@
+package host
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+// objectPeer records the object messages a world sends to it.
+// It checks that the world is not locked while sending.
+type objectPeer struct {
+	abyss.IANDPeer
+	world *World
+	hash  string
+
+	appended      []abyss.ObjectInfo
+	updates       [][]abyss.ObjectUpdate
+	sent_unlocked bool
+}
+
+func (p *objectPeer) IDHash() string { return p.hash }
+func (p *objectPeer) checkUnlocked() {
+	if p.world.mtx.TryLock() {
+		p.world.mtx.Unlock()
+		return
+	}
+	p.sent_unlocked = false
+}
+func (p *objectPeer) TrySendSOA(local_session_id uuid.UUID, peer_session_id uuid.UUID, objects []abyss.ObjectInfo) bool {
+	p.checkUnlocked()
+	p.appended = append(p.appended, objects...)
+	return true
+}
+func (p *objectPeer) TrySendSOD(local_session_id uuid.UUID, peer_session_id uuid.UUID, objectIDs []uuid.UUID) bool {
+	p.checkUnlocked()
+	return true
+}
+func (p *objectPeer) TrySendSOU(local_session_id uuid.UUID, peer_session_id uuid.UUID, updates []abyss.ObjectUpdate) bool {
+	p.checkUnlocked()
+	p.updates = append(p.updates, updates)
+	return true
+}
+
+func readyObjectPeer(w *World, hash string) *objectPeer {
+	peer := &objectPeer{world: w, hash: hash, sent_unlocked: true}
+	w.RaisePeerReady(abyss.ANDPeerSession{Peer: peer, PeerSessionID: uuid.New()})
+	<-w.eventChannel // EWorldMemberReady
+	return peer
+}
+
+func TestObjectSnapshot(t *testing.T) {
+	w := NewWorld(nil, uuid.New(), "/", "A")
+	oid := uuid.New()
+	properties := make(map[string]string)
+	for i := range ahmp.MaxSOUProperties*2 + 1 {
+		properties[strconv.Itoa(i)] = "v"
+	}
+	w.AppendObjects([]abyss.ObjectInfo{{ID: oid}})
+	w.UpdateObjects([]abyss.ObjectUpdate{{ID: oid, Properties: properties}})
+
+	// a new member receives the objects and every property, in SOUs it accepts.
+	peer_B := readyObjectPeer(w, "B")
+	if len(peer_B.appended) != 1 || peer_B.appended[0].ID != oid {
+		t.Fatal("object not sent: ", peer_B.appended)
+	}
+	received := make(map[string]string)
+	for _, updates := range peer_B.updates {
+		for _, update := range updates {
+			if len(update.Properties) > ahmp.MaxSOUProperties {
+				t.Fatal("too many properties in an update: ", len(update.Properties))
+			}
+			for key, value := range update.Properties {
+				received[key] = value
+			}
+		}
+	}
+	if len(received) != len(properties) {
+		t.Fatal("properties not sent: ", len(received))
+	}
+
+	// later changes are sent to the member too.
+	w.AppendObjects([]abyss.ObjectInfo{{ID: uuid.New()}})
+	w.DeleteObjects([]uuid.UUID{oid})
+	if len(peer_B.appended) != 2 {
+		t.Fatal("append not sent")
+	}
+	if !peer_B.sent_unlocked {
+		t.Fatal("sent while holding the world lock")
+	}
+}
+
+func TestObjectsPerOwner(t *testing.T) {
+	w := NewWorld(nil, uuid.New(), "/", "A")
+	readyObjectPeer(w, "B")
+
+	objects := make([]abyss.ObjectInfo, MaxObjectsPerOwner+1)
+	for i := range objects {
+		objects[i].ID = uuid.New()
+	}
+
+	// remote objects beyond the limit are ignored, and not raised.
+	w.RaiseObjectAppend("B", objects[:MaxObjectsPerOwner-1])
+	<-w.eventChannel
+	w.RaiseObjectAppend("B", objects[MaxObjectsPerOwner-1:])
+	event := (<-w.eventChannel).(abyss.EMemberObjectAppend)
+	if len(event.Objects) != 1 || event.Objects[0].ID != objects[MaxObjectsPerOwner-1].ID {
+		t.Fatal("unexpected append event: ", len(event.Objects))
+	}
+	w.RaiseObjectAppend("B", objects[MaxObjectsPerOwner:])
+	select {
+	case e := <-w.eventChannel:
+		t.Fatal("ignored append raised: ", e)
+	default:
+	}
+
+	// an existing object can still be replaced.
+	w.RaiseObjectAppend("B", objects[:1])
+	<-w.eventChannel
+	if n := len(w.Objects()); n != MaxObjectsPerOwner {
+		t.Fatal("unexpected number of objects: ", n)
+	}
+
+	// the limit applies to local objects as well.
+	w.AppendObjects(objects)
+	if n := len(w.objects["A"]); n != MaxObjectsPerOwner {
+		t.Fatal("unexpected number of local objects: ", n)
+	}
+}
@@ -23,6 +23,10 @@ const (
 
 	ANDObjectAppend
 	ANDObjectDelete
+	ANDMemberMessage // Text: topic, Object: payload ([]byte)
+	ANDObjectUpdate
+	ANDWorldModeration // Object: *WorldModeration, applied
+	ANDSessionDrop     // a JN passed to AND that will not get ANDSessionReady
 	ANDNeighborEventDebug
 )
 
@@ -35,9 +39,19 @@ type NeighborEvent struct {
 	Object any
 }
 
+// WorldSettings are sent to joiners with JOK.
+// Zero values are what nodes without the features assume.
+type WorldSettings struct {
+	Access         *WorldAccess // nil if moderation is disabled
+	MaxMembers     int          // including local; 0 is unlimited
+	InterestRadius float32      // 0 is the full mesh
+	InviteOnly     bool         // joins through any member require an invite, checked by the host
+}
+
 type PeerCertificates struct {
 	RootCertDer         []byte
 	HandshakeKeyCertDer []byte
+	Introducer          string // the member who sent the certificates
 }
 
 type ANDERROR int
@@ -60,10 +74,27 @@ type INeighborDiscovery interface { // all calls must be thread-safe
 	DeclineSession(local_session_id uuid.UUID, peer_session ANDPeerSession, code int, message string) ANDERROR
 	CloseWorld(local_session_id uuid.UUID) ANDERROR
 	TimerExpire(local_session_id uuid.UUID) ANDERROR
+	// SetWorldCapacity limits the members, including local; 0 is unlimited.
+	// Joiners beyond the limit wait in a queue of queue_length, or are declined with JDN;
+	// a queued joiner is declined after a timeout. The limit counts the local view, so it is approximate.
+	// The limit is sent to joiners with JOK; the queue is local.
+	SetWorldCapacity(local_session_id uuid.UUID, max_members int, queue_length int) ANDERROR
+	// SetInterest switches a world without sessions to the partial mesh, where members keep
+	// sessions only with the members within the radius, and gossip a roster of the whole world.
+	// The radius is sent to joiners with JOK. 0 is the full mesh, which can not be restored.
+	SetInterest(local_session_id uuid.UUID, radius float32) ANDERROR
+	// SetInviteOnly marks a world invite-only; the host checks the invites.
+	// The flag is sent to joiners with JOK, so that every member requires an invite.
+	SetInviteOnly(local_session_id uuid.UUID, invite_only bool) ANDERROR
+	IsInviteOnly(local_session_id uuid.UUID) bool
+	// UpdatePosition sets the local position in the partial mesh.
+	UpdatePosition(local_session_id uuid.UUID, position [3]float32) ANDERROR
+	// Roster returns the members of a world in the partial mesh, excluding local; nil in the full mesh.
+	Roster(local_session_id uuid.UUID) []ANDRosterEntry
 
 	//ahmp messages
 	JN(local_session_id uuid.UUID, peer_session ANDPeerSession, timestamp time.Time) ANDERROR
-	JOK(local_session_id uuid.UUID, peer_session ANDPeerSession, timestamp time.Time, world_url string, member_sessions []ANDFullPeerSessionIdentity) ANDERROR
+	JOK(local_session_id uuid.UUID, peer_session ANDPeerSession, timestamp time.Time, world_url string, member_sessions []ANDFullPeerSessionIdentity, settings WorldSettings) ANDERROR
 	JDN(local_session_id uuid.UUID, peer IANDPeer, code int, message string) ANDERROR
 	JNI(local_session_id uuid.UUID, peer_session ANDPeerSession, member_session ANDFullPeerSessionIdentity) ANDERROR
 	MEM(local_session_id uuid.UUID, peer_session ANDPeerSession, timestamp time.Time) ANDERROR
@@ -73,6 +104,18 @@ type INeighborDiscovery interface { // all calls must be thread-safe
 
 	SOA(local_session_id uuid.UUID, peer_session ANDPeerSession, objects []ObjectInfo) ANDERROR
 	SOD(local_session_id uuid.UUID, peer_session ANDPeerSession, objectIDs []uuid.UUID) ANDERROR
+	SOU(local_session_id uuid.UUID, peer_session ANDPeerSession, updates []ObjectUpdate) ANDERROR
+
+	SAM(local_session_id uuid.UUID, peer_session ANDPeerSession, topic string, payload []byte) ANDERROR
+
+	// moderation; disabled without a root signer, and in worlds joined through peers that sent
+	// no access list, or one without a valid owner signature.
+	MOD(local_session_id uuid.UUID, peer_session ANDPeerSession, moderation *WorldModeration) ANDERROR
+	Moderate(local_session_id uuid.UUID, action ModerationAction, target_hash string, reason string) ANDERROR
+	Role(local_session_id uuid.UUID, peer_hash string) WorldRole
+
+	// partial mesh
+	ROS(local_session_id uuid.UUID, peer_session ANDPeerSession, keep bool, local ANDRosterEntry, roster []ANDRosterEntry) ANDERROR
 
 	Statistics() string
 }
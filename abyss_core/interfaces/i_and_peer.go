@@ -32,6 +32,15 @@ type ANDFullPeerSessionIdentity struct {
 	HandshakeKeyCertificateDer []byte
 }
 
+// ANDRosterEntry is a world member in the partial mesh, as gossiped between members.
+// Certificates are omitted if the receiver is likely to have them.
+type ANDRosterEntry struct {
+	ANDFullPeerSessionIdentity
+	Position  *[3]float32 // nil if unknown
+	Heartbeat uint64      // increased by the member itself
+	Left      bool
+}
+
 type IANDPeer interface {
 	IDHash() string
 	RootCertificateDer() []byte
@@ -48,9 +57,10 @@ type IANDPeer interface {
 	Error() error
 
 	AhmpCh() chan any
+	DatagramCh() chan any // parsed AHMP datagrams; dropped when full
 
 	TrySendJN(local_session_id uuid.UUID, path string, timestamp time.Time) bool
-	TrySendJOK(local_session_id uuid.UUID, peer_session_id uuid.UUID, timestamp time.Time, world_url string, member_sessions []ANDPeerSessionWithTimeStamp) bool
+	TrySendJOK(local_session_id uuid.UUID, peer_session_id uuid.UUID, timestamp time.Time, world_url string, member_sessions []ANDPeerSessionWithTimeStamp, settings WorldSettings) bool
 	TrySendJDN(peer_session_id uuid.UUID, code int, message string) bool
 	TrySendJNI(local_session_id uuid.UUID, peer_session_id uuid.UUID, member_session ANDPeerSessionWithTimeStamp) bool
 	TrySendMEM(local_session_id uuid.UUID, peer_session_id uuid.UUID, timestamp time.Time) bool
@@ -60,4 +70,13 @@ type IANDPeer interface {
 
 	TrySendSOA(local_session_id uuid.UUID, peer_session_id uuid.UUID, objects []ObjectInfo) bool
 	TrySendSOD(local_session_id uuid.UUID, peer_session_id uuid.UUID, objectIDs []uuid.UUID) bool
+	TrySendSOU(local_session_id uuid.UUID, peer_session_id uuid.UUID, updates []ObjectUpdate) bool
+
+	TrySendSAM(local_session_id uuid.UUID, peer_session_id uuid.UUID, topic string, payload []byte) bool
+	TrySendMOD(local_session_id uuid.UUID, peer_session_id uuid.UUID, moderation *WorldModeration) bool
+	// TrySendROS sends the local entry, without identity, and the roster.
+	// keep tells the receiver whether the sender wants to keep the session.
+	TrySendROS(local_session_id uuid.UUID, peer_session_id uuid.UUID, keep bool, local ANDRosterEntry, roster []ANDRosterEntry) bool
+
+	TrySendDTU(local_session_id uuid.UUID, peer_session_id uuid.UUID, sequence uint64, transforms []ObjectTransform) bool
 }
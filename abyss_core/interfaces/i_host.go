@@ -2,6 +2,7 @@ package interfaces
 
 import (
 	"context"
+	"time"
 
 	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
 
@@ -19,11 +20,33 @@ type ObjectInfo struct {
 	Transform [7]float32
 }
 
+// ObjectUpdate changes an appended object. Nil or empty fields are unchanged.
+// Properties are application-defined; they are set or removed by key.
+type ObjectUpdate struct {
+	ID                uuid.UUID
+	Transform         *[7]float32
+	Properties        map[string]string
+	RemovedProperties []string
+}
+
+// ObjectTransform is a transform update, sent unreliably.
+type ObjectTransform struct {
+	ID        uuid.UUID
+	Transform [7]float32
+}
+
 type IWorldMember interface {
 	Hash() string
 	SessionID() uuid.UUID
 	AppendObjects(objects []ObjectInfo) bool
 	DeleteObjects(objectIDs []uuid.UUID) bool
+	// SendMessage sends an application-defined message to the member.
+	// The topic is for the application to tell messages apart; it must not be empty.
+	SendMessage(topic string, payload []byte) bool
+	UpdateObjects(updates []ObjectUpdate) bool
+	// UpdateTransforms sends the transforms in datagrams, which may be lost or reordered.
+	// The member drops updates older than the latest it received for each object.
+	UpdateTransforms(transforms []ObjectTransform) bool
 }
 
 type EWorldMemberRequest struct {
@@ -42,15 +65,88 @@ type EMemberObjectDelete struct {
 	PeerHash  string
 	ObjectIDs []uuid.UUID
 }
+type EMemberObjectUpdate struct {
+	PeerHash string
+	Updates  []ObjectUpdate
+}
+type EMemberMessage struct {
+	PeerHash string
+	Topic    string
+	Payload  []byte
+}
+type EMemberObjectTransform struct {
+	PeerHash   string
+	Transforms []ObjectTransform
+}
+
+// EWorldModeration is raised for every applied moderation action.
+// If the local host is kicked or banned, the members leave right after.
+type EWorldModeration struct {
+	Action ModerationAction
+	Issuer string
+	Target string
+	Reason string
+}
 type EWorldMemberLeave struct { //now, the peer must be closed as soon as possible.
 	PeerHash string
 }
 type EWorldTerminate struct{}
 
+// WorldObject is an entry of the world object table.
+type WorldObject struct {
+	Owner string // peer hash
+	ObjectInfo
+	Properties map[string]string
+}
+
+// WorldRosterMember is a member of a world in the partial mesh.
+type WorldRosterMember struct {
+	PeerHash string
+	Position *[3]float32 // nil if unknown
+}
+
 type IAbyssWorld interface {
 	SessionID() uuid.UUID
 	URL() string
 	GetEventChannel() chan any
+
+	// AppendObjects, DeleteObjects and UpdateObjects change the local objects.
+	// Local objects are sent to every member, including those who become ready later.
+	// Each owner has at most host.MaxObjectsPerOwner objects; further appends are ignored.
+	AppendObjects(objects []ObjectInfo)
+	DeleteObjects(objectIDs []uuid.UUID)
+	UpdateObjects(updates []ObjectUpdate)
+
+	// Objects returns the local objects and those of ready members.
+	// Objects of a member are removed when it leaves; EMemberObjectDelete is raised
+	// for them, before EWorldMemberLeave.
+	Objects() []WorldObject
+
+	// Role returns the role of a peer, including the local host.
+	Role(peer_hash string) WorldRole
+	// Moderate signs a moderation action and sends it to every member.
+	// It fails if the local host lacks the role for the action, or the world has no access list.
+	Moderate(action ModerationAction, target_hash string, reason string) error
+
+	// SetInviteOnly makes joins through the local host require an invite; see IAbyssHost.CreateInvite.
+	// Joiners receive the flag, so joins through them require their own invites.
+	// Members that joined before the flag was set are not updated.
+	SetInviteOnly(invite_only bool)
+	// SetCapacity limits the members, including local; 0 is unlimited.
+	// Joiners through the local host beyond the limit wait in a queue of queue_length,
+	// and are declined when it is full, or after and.QueueTimeout. Joiners receive the limit.
+	// The limit is approximate: each member counts the members it is connected to,
+	// so joins through different members at once may exceed it.
+	SetCapacity(max_members int, queue_length int) error
+
+	// SetInterest switches a world without members to the partial mesh, where the local host
+	// only has the members within the radius, its nearest members and a few others.
+	// Joiners take the radius of the member who accepts them; radius 0 keeps the current one.
+	// The local position is that of the anchor, a local object; the first three elements of its transform.
+	SetInterest(radius float32, anchor uuid.UUID) error
+	// Roster returns every member of a world in the partial mesh, excluding local;
+	// nil in the full mesh, where every member is ready.
+	Roster() []WorldRosterMember
 }
 
 type IAbyssHost interface {
@@ -64,6 +160,12 @@ type IAbyssHost interface {
 	LeaveWorld(world IAbyssWorld) //this does not wait for world-related resource cleanup.
 	// Each world should wait for its world termination event.
 
+	// CreateInvite returns an AURL with a signed invite, which joins the world through the local host
+	// without EWorldMemberRequest until it expires. A single-use invite admits one join;
+	// it can be tried again if the join fails.
+	// path must be mapped to the world by the path resolver.
+	CreateInvite(world IAbyssWorld, path string, ttl time.Duration, single_use bool) (*aurl.AURL, error)
+
 	//Abyst
 	GetAbystClientConnection(peer_hash string) (*http3.ClientConn, error)
 }
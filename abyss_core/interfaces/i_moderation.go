@@ -0,0 +1,64 @@
+package interfaces
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WorldRole is the role of a member in a world.
+// The peer who opens a world is its owner, and the owner appoints moderators.
+type WorldRole int
+
+const (
+	RoleMember WorldRole = iota
+	RoleModerator
+	RoleOwner
+)
+
+type ModerationAction int
+
+const (
+	ModKick ModerationAction = iota + 1 // remove the member; it may join again
+	ModBan                              // remove the member and refuse its joins
+	ModUnban
+	ModGrantModerator  // owner only
+	ModRevokeModerator // owner only
+)
+
+// WorldModeration is a moderation action, signed with the issuer's root key.
+// It is flooded to every member, and each member checks the signature and
+// the issuer's role before applying it.
+type WorldModeration struct {
+	WorldID           uuid.UUID // the owner's session id
+	Action            ModerationAction
+	Target            string // peer hash
+	Issuer            string // peer hash
+	IssuerRootCertDer []byte
+	Reason            string
+	TimeStamp         time.Time
+	Signature         []byte
+}
+
+// WorldAccess is the access list of a world, as the signed records it is built from.
+// A member sends it to joiners with JOK. The joiner checks the owner's signature
+// and replays the moderations, so a member cannot forge entries;
+// it can only withhold moderations, as it can withhold MOD.
+type WorldAccess struct {
+	WorldID          uuid.UUID
+	Owner            string
+	OwnerRootCertDer []byte
+	Signature        []byte             // by the owner, over the world id
+	Moderations      []*WorldModeration // in the order they were applied
+}
+
+// MaxWorldAccessModerations bounds WorldAccess.Moderations.
+const MaxWorldAccessModerations = 4096
+
+// IRootSigner signs with the local root key, and verifies signatures of other peers' root keys.
+type IRootSigner interface {
+	RootCertificateDer() []byte
+	Sign(message []byte) ([]byte, error)
+	// Verify returns the id hash of the certificate if the signature is valid.
+	Verify(root_cert_der []byte, message []byte, signature []byte) (string, error)
+}
@@ -36,8 +36,20 @@ type INetworkService interface {
 	ConnectAbyst(peer_hash string) (quic.Connection, error) //should take ~2 rtt.
 }
 
+// IPeerIntroducer is implemented by network services with a trust policy,
+// which distinguish certificates introduced by world members from AppendKnownPeer.
+type IPeerIntroducer interface {
+	IntroducePeerDer(introducer_id string, root_cert []byte, handshake_key_cert []byte) error
+}
+
+// IRootSignerProvider is implemented by network services that sign with the local root key.
+type IRootSignerProvider interface {
+	RootSigner() IRootSigner
+}
+
 type IAddressSelector interface {
 	LocalPrivateIPAddr() net.IP
+	LocalIPAddrs() []net.IP // one per IP version, if available
 	FilterAddressCandidates(addresses []*net.UDPAddr) []*net.UDPAddr
 }
 
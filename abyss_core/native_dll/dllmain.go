@@ -28,13 +28,14 @@ import (
 
 	abyss_and "github.com/kadmila/Abyss-Browser/abyss_core/and"
 
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
 	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
 
 	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
 
 	"github.com/google/uuid"
 	"github.com/quic-go/quic-go/http3"
-	"golang.org/x/crypto/ssh"
 )
 
 const (
@@ -194,7 +195,8 @@ func NewHost(root_priv_key_pem_ptr *C.char, root_priv_key_pem_len C.int, h_path_
 		return 0
 	}
 
-	root_priv_key, err := ssh.ParseRawPrivateKey(root_priv_key_pem)
+	// OpenSSH or PKCS#8; see sec.ParseRootPrivateKey.
+	root_priv_key, err := sec.ParseRootPrivateKey(root_priv_key_pem, nil)
 	if err != nil {
 		watchdog.Error(err)
 		return 0
@@ -222,9 +224,12 @@ func NewHost(root_priv_key_pem_ptr *C.char, root_priv_key_pem_len C.int, h_path_
 		return 0
 	}
 
+	and := abyss_and.NewAND(net_service.LocalIdentity().IDHash())
+	and.SetRootSigner(net_service.RootSigner())
+
 	host := abyss_host.NewAbyssHost(
 		net_service,
-		abyss_and.NewAND(net_service.LocalIdentity().IDHash()),
+		and,
 		path_resolver,
 	)
 	go host.ListenAndServe(context.Background())
@@ -410,6 +415,22 @@ type ObjectDeleteData struct {
 	body_json string
 }
 
+type ObjectUpdateData struct {
+	peer_hash string
+	body_json string
+}
+
+type ObjectTransformData struct {
+	peer_hash string
+	body_json string
+}
+
+type MemberMessageData struct {
+	peer_hash string
+	topic     string
+	payload   []byte
+}
+
 //export World_GetURL
 func World_GetURL(h C.uintptr_t, buf_ptr *C.char, buf_len C.int) C.int {
 	world, ok := cgo.Handle(h).Value().(*WorldExport)
@@ -477,6 +498,52 @@ func World_WaitEvent(h C.uintptr_t, event_type_out *C.int) C.uintptr_t {
 	case abyss.EWorldTerminate:
 		*event_type_out = 6
 		return 0
+	case abyss.EWorldModeration:
+		*event_type_out = 10
+		watchdog.CountHandleExport()
+		return C.uintptr_t(cgo.NewHandle(&event))
+	case abyss.EMemberMessage:
+		*event_type_out = 7
+		watchdog.CountHandleExport()
+		return C.uintptr_t(cgo.NewHandle(&MemberMessageData{
+			peer_hash: event.PeerHash,
+			topic:     event.Topic,
+			payload:   event.Payload,
+		}))
+	case abyss.EMemberObjectUpdate:
+		*event_type_out = 9
+		data, _ := json.Marshal(functional.Filter(event.Updates, func(i abyss.ObjectUpdate) ObjectUpdateFormat {
+			return ObjectUpdateFormat{
+				ID:                hex.EncodeToString(i.ID[:]),
+				Transform:         i.Transform,
+				Properties:        i.Properties,
+				RemovedProperties: i.RemovedProperties,
+			}
+		}))
+		watchdog.CountHandleExport()
+		return C.uintptr_t(cgo.NewHandle(&ObjectUpdateData{
+			peer_hash: event.PeerHash,
+			body_json: string(data),
+		}))
+	case abyss.EMemberObjectTransform:
+		*event_type_out = 8
+		data, _ := json.Marshal(functional.Filter(event.Transforms, func(i abyss.ObjectTransform) struct {
+			ID        string
+			Transform [7]float32
+		} {
+			return struct {
+				ID        string
+				Transform [7]float32
+			}{
+				ID:        hex.EncodeToString(i.ID[:]),
+				Transform: i.Transform,
+			}
+		}))
+		watchdog.CountHandleExport()
+		return C.uintptr_t(cgo.NewHandle(&ObjectTransformData{
+			peer_hash: event.PeerHash,
+			body_json: string(data),
+		}))
 	default:
 		watchdog.Error(errors.New("internal fault"))
 		*event_type_out = -1
@@ -484,6 +551,267 @@ func World_WaitEvent(h C.uintptr_t, event_type_out *C.int) C.uintptr_t {
 	}
 }
 
+func unmarshalObjectInfos(json_data []byte) ([]abyss.ObjectInfo, error) {
+	var raw_object_infos []struct {
+		ID        string
+		Addr      string
+		Transform [7]float32
+	}
+	err := json.Unmarshal(json_data, &raw_object_infos)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := functional.Filter_until_err(raw_object_infos, func(i struct {
+		ID        string
+		Addr      string
+		Transform [7]float32
+	}) (abyss.ObjectInfo, error) {
+		bytes, err := hex.DecodeString(i.ID)
+		if err != nil {
+			return abyss.ObjectInfo{}, err
+		}
+		return abyss.ObjectInfo{
+			ID:        uuid.UUID(bytes),
+			Addr:      i.Addr,
+			Transform: i.Transform,
+		}, nil
+	})
+	return res, err
+}
+
+func unmarshalObjectIDs(json_data []byte) ([]uuid.UUID, error) {
+	var raw_object_ids []string
+	err := json.Unmarshal(json_data, &raw_object_ids)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := functional.Filter_until_err(raw_object_ids, func(i string) (uuid.UUID, error) {
+		bytes, err := hex.DecodeString(i)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		return uuid.UUID(bytes), nil
+	})
+	return res, err
+}
+
+func unmarshalObjectUpdates(json_data []byte) ([]abyss.ObjectUpdate, error) {
+	var raw_updates []ObjectUpdateFormat
+	err := json.Unmarshal(json_data, &raw_updates)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := functional.Filter_until_err(raw_updates, func(i ObjectUpdateFormat) (abyss.ObjectUpdate, error) {
+		bytes, err := hex.DecodeString(i.ID)
+		if err != nil {
+			return abyss.ObjectUpdate{}, err
+		}
+		oid, err := uuid.FromBytes(bytes)
+		return abyss.ObjectUpdate{
+			ID:                oid,
+			Transform:         i.Transform,
+			Properties:        i.Properties,
+			RemovedProperties: i.RemovedProperties,
+		}, err
+	})
+	return res, err
+}
+
+//export World_AppendObjects
+func World_AppendObjects(h C.uintptr_t, json_ptr *C.char, json_len C.int) C.int {
+	world, ok := cgo.Handle(h).Value().(*WorldExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	json_data, ok := TryUnmarshalBytes(json_ptr, json_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	res, err := unmarshalObjectInfos(json_data)
+	if err != nil {
+		watchdog.Error(err)
+		return INVALID_ARGUMENTS
+	}
+
+	world.inner.AppendObjects(res)
+	return 0
+}
+
+//export World_DeleteObjects
+func World_DeleteObjects(h C.uintptr_t, json_ptr *C.char, json_len C.int) C.int {
+	world, ok := cgo.Handle(h).Value().(*WorldExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	json_data, ok := TryUnmarshalBytes(json_ptr, json_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	res, err := unmarshalObjectIDs(json_data)
+	if err != nil {
+		watchdog.Error(err)
+		return INVALID_ARGUMENTS
+	}
+
+	world.inner.DeleteObjects(res)
+	return 0
+}
+
+//export World_UpdateObjects
+func World_UpdateObjects(h C.uintptr_t, json_ptr *C.char, json_len C.int) C.int {
+	world, ok := cgo.Handle(h).Value().(*WorldExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	json_data, ok := TryUnmarshalBytes(json_ptr, json_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	res, err := unmarshalObjectUpdates(json_data)
+	if err != nil {
+		watchdog.Error(err)
+		return INVALID_ARGUMENTS
+	}
+
+	world.inner.UpdateObjects(res)
+	return 0
+}
+
+//export World_GetRole
+func World_GetRole(h C.uintptr_t, peer_hash_ptr *C.char, peer_hash_len C.int) C.int {
+	world, ok := cgo.Handle(h).Value().(*WorldExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	peer_hash, ok := TryUnmarshalBytes(peer_hash_ptr, peer_hash_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	return C.int(world.inner.Role(string(peer_hash)))
+}
+
+// World_Moderate returns ERROR if the moderation is rejected. The reason may be empty.
+//
+//export World_Moderate
+func World_Moderate(h C.uintptr_t, action C.int, target_ptr *C.char, target_len C.int, reason_ptr *C.char, reason_len C.int) C.int {
+	world, ok := cgo.Handle(h).Value().(*WorldExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	target, ok := TryUnmarshalBytes(target_ptr, target_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	reason := []byte{}
+	if reason_len != 0 {
+		reason, ok = TryUnmarshalBytes(reason_ptr, reason_len)
+		if !ok {
+			return INVALID_ARGUMENTS
+		}
+	}
+	if err := world.inner.Moderate(abyss.ModerationAction(action), string(target), string(reason)); err != nil {
+		watchdog.Error(err)
+		return ERROR
+	}
+	return 0
+}
+
+//export World_SetCapacity
+func World_SetCapacity(h C.uintptr_t, max_members C.int, queue_length C.int) C.int {
+	world, ok := cgo.Handle(h).Value().(*WorldExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	if err := world.inner.SetCapacity(int(max_members), int(queue_length)); err != nil {
+		return INVALID_ARGUMENTS
+	}
+	return 0
+}
+
+//export World_SetInviteOnly
+func World_SetInviteOnly(h C.uintptr_t, invite_only C.int) C.int {
+	world, ok := cgo.Handle(h).Value().(*WorldExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	world.inner.SetInviteOnly(invite_only != 0)
+	return 0
+}
+
+// World_CreateInvite writes the invite AURL to buf. path must be mapped to the world.
+//
+//export World_CreateInvite
+func World_CreateInvite(h C.uintptr_t, path_ptr *C.char, path_len C.int, ttl_ms C.int, single_use C.int, buf *C.char, buf_len C.int) C.int {
+	world, ok := cgo.Handle(h).Value().(*WorldExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	path := []byte{}
+	if path_len != 0 {
+		path, ok = TryUnmarshalBytes(path_ptr, path_len)
+		if !ok {
+			return INVALID_ARGUMENTS
+		}
+	}
+	if ttl_ms <= 0 {
+		return INVALID_ARGUMENTS
+	}
+	invite, err := world.origin.CreateInvite(world.inner, string(path), time.Duration(ttl_ms)*time.Millisecond, single_use != 0)
+	if err != nil {
+		watchdog.Error(err)
+		return ERROR
+	}
+	return TryMarshalBytes(buf, buf_len, []byte(invite.ToString()))
+}
+
+// World_SetInterest reads the 16-byte anchor object id; radius 0 keeps the current radius.
+//
+//export World_SetInterest
+func World_SetInterest(h C.uintptr_t, radius C.float, anchor_ptr *C.char) C.int {
+	world, ok := cgo.Handle(h).Value().(*WorldExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	anchor_bytes, ok := TryUnmarshalBytes(anchor_ptr, 16)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	if err := world.inner.SetInterest(float32(radius), uuid.UUID(anchor_bytes)); err != nil {
+		return INVALID_ARGUMENTS
+	}
+	return 0
+}
+
+// World_GetRoster writes the roster as json; an empty array in the full mesh.
+//
+//export World_GetRoster
+func World_GetRoster(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
+	world, ok := cgo.Handle(h).Value().(*WorldExport)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	data, _ := json.Marshal(functional.Filter(world.inner.Roster(), func(member abyss.WorldRosterMember) struct {
+		PeerHash string
+		Position *[3]float32
+	} {
+		return struct {
+			PeerHash string
+			Position *[3]float32
+		}{member.PeerHash, member.Position}
+	}))
+	return TryMarshalBytes(buf, buf_len, data)
+}
+
 //export WorldPeerRequest_GetHash
 func WorldPeerRequest_GetHash(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
 	event, ok := cgo.Handle(h).Value().(*abyss.EWorldMemberRequest)
@@ -547,42 +875,70 @@ func WorldPeer_AppendObjects(h C.uintptr_t, json_ptr *C.char, json_len C.int) C.
 	if !ok {
 		return INVALID_ARGUMENTS
 	}
-	var raw_object_infos []struct {
-		ID        string
-		Addr      string
-		Transform [7]float32
+	res, err := unmarshalObjectInfos(json_data)
+	if err != nil {
+		watchdog.Error(err)
+		return INVALID_ARGUMENTS
 	}
-	err := json.Unmarshal(json_data, &raw_object_infos)
+
+	peer.AppendObjects(res)
+	return 0
+}
+
+//export WorldPeer_DeleteObjects
+func WorldPeer_DeleteObjects(h C.uintptr_t, json_ptr *C.char, json_len C.int) C.int {
+	peer, ok := cgo.Handle(h).Value().(abyss.IWorldMember)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	json_data, ok := TryUnmarshalBytes(json_ptr, json_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	res, err := unmarshalObjectIDs(json_data)
 	if err != nil {
 		watchdog.Error(err)
 		return INVALID_ARGUMENTS
 	}
-	res, _, err := functional.Filter_until_err(raw_object_infos, func(i struct {
-		ID        string
-		Addr      string
-		Transform [7]float32
-	}) (abyss.ObjectInfo, error) {
-		bytes, err := hex.DecodeString(i.ID)
-		if err != nil {
-			return abyss.ObjectInfo{}, err
-		}
-		return abyss.ObjectInfo{
-			ID:        uuid.UUID(bytes),
-			Addr:      i.Addr,
-			Transform: i.Transform,
-		}, nil
-	})
+
+	peer.DeleteObjects(res)
+	return 0
+}
+
+// ObjectUpdateFormat is the json of abyss.ObjectUpdate; omitted fields are unchanged.
+type ObjectUpdateFormat struct {
+	ID                string
+	Transform         *[7]float32       `json:",omitempty"`
+	Properties        map[string]string `json:",omitempty"`
+	RemovedProperties []string          `json:",omitempty"`
+}
+
+//export WorldPeer_UpdateObjects
+func WorldPeer_UpdateObjects(h C.uintptr_t, json_ptr *C.char, json_len C.int) C.int {
+	peer, ok := cgo.Handle(h).Value().(abyss.IWorldMember)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	json_data, ok := TryUnmarshalBytes(json_ptr, json_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	res, err := unmarshalObjectUpdates(json_data)
 	if err != nil {
 		watchdog.Error(err)
 		return INVALID_ARGUMENTS
 	}
 
-	peer.AppendObjects(res)
+	if !peer.UpdateObjects(res) {
+		return REMOTE_ERROR
+	}
 	return 0
 }
 
-//export WorldPeer_DeleteObjects
-func WorldPeer_DeleteObjects(h C.uintptr_t, json_ptr *C.char, json_len C.int) C.int {
+//export WorldPeer_UpdateTransforms
+func WorldPeer_UpdateTransforms(h C.uintptr_t, json_ptr *C.char, json_len C.int) C.int {
 	peer, ok := cgo.Handle(h).Value().(abyss.IWorldMember)
 	if !ok {
 		return INVALID_HANDLE
@@ -592,25 +948,66 @@ func WorldPeer_DeleteObjects(h C.uintptr_t, json_ptr *C.char, json_len C.int) C.
 	if !ok {
 		return INVALID_ARGUMENTS
 	}
-	var raw_object_ids []string
-	err := json.Unmarshal(json_data, &raw_object_ids)
+	var raw_transforms []struct {
+		ID        string
+		Transform [7]float32
+	}
+	err := json.Unmarshal(json_data, &raw_transforms)
 	if err != nil {
 		watchdog.Error(err)
 		return INVALID_ARGUMENTS
 	}
-	res, _, err := functional.Filter_until_err(raw_object_ids, func(i string) (uuid.UUID, error) {
-		bytes, err := hex.DecodeString(i)
+	res, _, err := functional.Filter_until_err(raw_transforms, func(i struct {
+		ID        string
+		Transform [7]float32
+	}) (abyss.ObjectTransform, error) {
+		bytes, err := hex.DecodeString(i.ID)
 		if err != nil {
-			return uuid.Nil, err
+			return abyss.ObjectTransform{}, err
 		}
-		return uuid.UUID(bytes), nil
+		oid, err := uuid.FromBytes(bytes)
+		return abyss.ObjectTransform{
+			ID:        oid,
+			Transform: i.Transform,
+		}, err
 	})
 	if err != nil {
 		watchdog.Error(err)
 		return INVALID_ARGUMENTS
 	}
 
-	peer.DeleteObjects(res)
+	if !peer.UpdateTransforms(res) {
+		return REMOTE_ERROR
+	}
+	return 0
+}
+
+//export WorldPeer_SendMessage
+func WorldPeer_SendMessage(h C.uintptr_t, topic_ptr *C.char, topic_len C.int, payload_ptr *C.char, payload_len C.int) C.int {
+	peer, ok := cgo.Handle(h).Value().(abyss.IWorldMember)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	topic, ok := TryUnmarshalBytes(topic_ptr, topic_len)
+	if !ok {
+		return INVALID_ARGUMENTS
+	}
+	var payload []byte
+	if payload_len != 0 {
+		payload, ok = TryUnmarshalBytes(payload_ptr, payload_len)
+		if !ok {
+			return INVALID_ARGUMENTS
+		}
+	}
+	if len(topic) > ahmp.MaxSAMTopicLength || len(payload) > ahmp.MaxSAMPayloadSize {
+		return INVALID_ARGUMENTS
+	}
+
+	// the payload is copied, as the caller owns the buffer.
+	if !peer.SendMessage(string(topic), append([]byte{}, payload...)) {
+		return REMOTE_ERROR
+	}
 	return 0
 }
 
@@ -656,6 +1053,112 @@ func WorldPeerObjectDelete_GetBody(h C.uintptr_t, buf *C.char, buf_len C.int) C.
 	return TryMarshalBytes(buf, buf_len, []byte(data.body_json))
 }
 
+//export WorldPeerObjectUpdate_GetHead
+func WorldPeerObjectUpdate_GetHead(h C.uintptr_t, peer_hash_out *C.char, body_len *C.int) C.int {
+	data, ok := cgo.Handle(h).Value().(*ObjectUpdateData)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	*body_len = C.int(len(data.body_json))
+	return TryMarshalBytes(peer_hash_out, 128, []byte(data.peer_hash))
+}
+
+//export WorldPeerObjectUpdate_GetBody
+func WorldPeerObjectUpdate_GetBody(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
+	data, ok := cgo.Handle(h).Value().(*ObjectUpdateData)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	return TryMarshalBytes(buf, buf_len, []byte(data.body_json))
+}
+
+//export WorldPeerObjectTransform_GetHead
+func WorldPeerObjectTransform_GetHead(h C.uintptr_t, peer_hash_out *C.char, body_len *C.int) C.int {
+	data, ok := cgo.Handle(h).Value().(*ObjectTransformData)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	*body_len = C.int(len(data.body_json))
+	return TryMarshalBytes(peer_hash_out, 128, []byte(data.peer_hash))
+}
+
+//export WorldPeerObjectTransform_GetBody
+func WorldPeerObjectTransform_GetBody(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
+	data, ok := cgo.Handle(h).Value().(*ObjectTransformData)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	return TryMarshalBytes(buf, buf_len, []byte(data.body_json))
+}
+
+//export WorldPeerMessage_GetHead
+func WorldPeerMessage_GetHead(h C.uintptr_t, peer_hash_out *C.char, topic_len *C.int, payload_len *C.int) C.int {
+	data, ok := cgo.Handle(h).Value().(*MemberMessageData)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	*topic_len = C.int(len(data.topic))
+	*payload_len = C.int(len(data.payload))
+	return TryMarshalBytes(peer_hash_out, 128, []byte(data.peer_hash))
+}
+
+//export WorldPeerMessage_GetTopic
+func WorldPeerMessage_GetTopic(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
+	data, ok := cgo.Handle(h).Value().(*MemberMessageData)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	return TryMarshalBytes(buf, buf_len, []byte(data.topic))
+}
+
+//export WorldPeerMessage_GetPayload
+func WorldPeerMessage_GetPayload(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
+	data, ok := cgo.Handle(h).Value().(*MemberMessageData)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	return TryMarshalBytes(buf, buf_len, data.payload)
+}
+
+//export WorldModeration_GetHead
+func WorldModeration_GetHead(h C.uintptr_t, action_out *C.int, issuer_out *C.char, reason_len *C.int) C.int {
+	event, ok := cgo.Handle(h).Value().(*abyss.EWorldModeration)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	*action_out = C.int(event.Action)
+	*reason_len = C.int(len(event.Reason))
+	return TryMarshalBytes(issuer_out, 128, []byte(event.Issuer))
+}
+
+//export WorldModeration_GetTarget
+func WorldModeration_GetTarget(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
+	event, ok := cgo.Handle(h).Value().(*abyss.EWorldModeration)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	return TryMarshalBytes(buf, buf_len, []byte(event.Target))
+}
+
+//export WorldModeration_GetReason
+func WorldModeration_GetReason(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
+	event, ok := cgo.Handle(h).Value().(*abyss.EWorldModeration)
+	if !ok {
+		return INVALID_HANDLE
+	}
+
+	return TryMarshalBytes(buf, buf_len, []byte(event.Reason))
+}
+
 //export WorldPeerLeave_GetHash
 func WorldPeerLeave_GetHash(h C.uintptr_t, buf *C.char, buf_len C.int) C.int {
 	event, ok := cgo.Handle(h).Value().(*abyss.EWorldMemberLeave)
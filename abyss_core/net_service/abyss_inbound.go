@@ -16,6 +16,7 @@ func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connect
 	//watchdog.Info("inbound detected")
 	var target *ContextedPeer
 	var ahmp_decoder *cbor.Decoder
+	var hello ahmp.Hello
 	var err error
 
 	defer func() {
@@ -37,12 +38,16 @@ func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connect
 				target.state = PNCS_INBOUND
 				target.inbound_conn = connection
 				target.ahmp_decoder = ahmp_decoder
+				target.hello = hello
 				go target.listenAhmp()
+				go target.listenDatagram()
 			case PNCS_OUTBOUND:
 				target.state = PNCS_CONNECTED
 				target.inbound_conn = connection
 				target.ahmp_decoder = ahmp_decoder
+				target.hello = hello
 				go target.listenAhmp()
+				go target.listenDatagram()
 				h.abyssPeerCH <- target
 			case PNCS_INBOUND, PNCS_CONNECTED:
 				connection.CloseWithError(ABYSS_ALREADY_CONNECTED, ABYSS_ALREADY_CONNECTED_M)
@@ -62,7 +67,7 @@ func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connect
 		return
 	}
 	ahmp_encoder := cbor.NewEncoder(ahmp_stream)
-	ahmp_decoder = cbor.NewDecoder(ahmp_stream)
+	ahmp_decoder = ahmp.NewDecoder(ahmp_stream)
 
 	//receive connecter-side handshake1 self-authentication payload
 	var handshake_1_raw []byte
@@ -75,8 +80,8 @@ func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connect
 		err = aerr.NewConnErr(connection, nil, err)
 		return
 	}
-	var abyss_bind_cert []byte
-	if err = cbor.Unmarshal(handshake_1, &abyss_bind_cert); err != nil {
+	abyss_bind_cert, hello, err := ahmp.ParseHS2(handshake_1)
+	if err != nil {
 		err = aerr.NewConnErr(connection, nil, err)
 		return
 	}
@@ -95,13 +100,14 @@ func (h *BetaNetService) PrepareAbyssInbound(listen_ctx context.Context, connect
 		err = aerr.NewConnErrM(connection, nil, "unknown peer")
 		return
 	}
-	if err = target.identity.VerifyTLSBinding(abyss_bind_cert_x509, client_tls_cert); err != nil {
+	target_identity := target.Identity()
+	if err = target_identity.VerifyTLSBinding(abyss_bind_cert_x509, client_tls_cert); err != nil {
 		err = aerr.NewConnErr(connection, nil, err)
 		return
 	}
 
 	//send local tls-abyss binding cert
-	if err = ahmp_encoder.Encode(h.tlsIdentity.abyss_bind_cert); err != nil {
+	if err = ahmp_encoder.Encode(h.bindingMessage(connection)); err != nil {
 		err = aerr.NewConnErr(connection, nil, err)
 		return
 	}
@@ -119,157 +125,39 @@ func (p *AbyssPeer) listenAhmp() {
 	}()
 
 	for {
-		var ahmp_type int
-		err := p.ahmp_decoder.Decode(&ahmp_type)
-		if err != nil {
+		var parsed_msg any
+		_, parsed_msg, err = ahmp.DefaultRegistry.Decode(p.ahmp_decoder)
+		switch {
+		case err == nil:
+			p.ahmp_decoded_ch <- parsed_msg
+		case errors.Is(err, ahmp.ErrUnknownMessageType):
+			// sent by a newer peer; skip it, rather than breaking the connection.
+			err = nil
+		case errors.Is(err, ahmp.ErrMalformedMessage), errors.Is(err, ahmp.ErrInvalidMessage):
+			p.ahmp_decoded_ch <- &ahmp.INVAL{Err: err}
+			return
+		default:
 			return
 		}
+	}
+}
 
-		//fmt.Println(p.inbound_conn.LocalAddr().String() + " < " + p.inbound_conn.RemoteAddr().String() + " " + strconv.Itoa(ahmp_type))
-		switch ahmp_type {
-		case ahmp.JN_T:
-			//fmt.Println("receiving JN")
-			var raw_msg ahmp.RawJN
-			err = p.ahmp_decoder.Decode(&raw_msg)
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing JN"), err)}
-				return
-			}
-			parsed_msg, err := raw_msg.TryParse()
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing JN"), err)}
-				return
-			}
-			p.ahmp_decoded_ch <- parsed_msg
-		case ahmp.JOK_T:
-			//fmt.Println("receiving JOK")
-			var raw_msg ahmp.RawJOK
-			err = p.ahmp_decoder.Decode(&raw_msg)
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing JOK"), err)}
-				return
-			}
-			parsed_msg, err := raw_msg.TryParse()
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing JOK"), err)}
-				return
-			}
-			p.ahmp_decoded_ch <- parsed_msg
-		case ahmp.JDN_T:
-			//fmt.Println("receiving JDN")
-			var raw_msg ahmp.RawJDN
-			err = p.ahmp_decoder.Decode(&raw_msg)
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing JDN"), err)}
-				return
-			}
-			parsed_msg, err := raw_msg.TryParse()
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing JDN"), err)}
-				return
-			}
-			p.ahmp_decoded_ch <- parsed_msg
-		case ahmp.JNI_T:
-			//fmt.Println("receiving JNI")
-			var raw_msg ahmp.RawJNI
-			err = p.ahmp_decoder.Decode(&raw_msg)
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing JNI"), err)}
-				return
-			}
-			parsed_msg, err := raw_msg.TryParse()
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing JNI"), err)}
-				return
-			}
-			p.ahmp_decoded_ch <- parsed_msg
-		case ahmp.MEM_T:
-			//fmt.Println("receiving MEM")
-			var raw_msg ahmp.RawMEM
-			err = p.ahmp_decoder.Decode(&raw_msg)
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing MEM"), err)}
-				return
-			}
-			parsed_msg, err := raw_msg.TryParse()
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing MEM"), err)}
-				return
-			}
-			p.ahmp_decoded_ch <- parsed_msg
-		case ahmp.SJN_T:
-			//fmt.Println("receiving SJN")
-			var raw_msg ahmp.RawSJN
-			err = p.ahmp_decoder.Decode(&raw_msg)
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing SJN"), err)}
-				return
-			}
-			parsed_msg, err := raw_msg.TryParse()
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing SJN"), err)}
-				return
-			}
-			p.ahmp_decoded_ch <- parsed_msg
-		case ahmp.CRR_T:
-			//fmt.Println("receiving CRR")
-			var raw_msg ahmp.RawCRR
-			err = p.ahmp_decoder.Decode(&raw_msg)
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing CRR"), err)}
-				return
-			}
-			parsed_msg, err := raw_msg.TryParse()
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing CRR"), err)}
-				return
-			}
-			p.ahmp_decoded_ch <- parsed_msg
-		case ahmp.RST_T:
-			//fmt.Println("receiving RST")
-			var raw_msg ahmp.RawRST
-			err = p.ahmp_decoder.Decode(&raw_msg)
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing RST"), err)}
-				return
-			}
-			parsed_msg, err := raw_msg.TryParse()
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing RST"), err)}
-				return
-			}
-			p.ahmp_decoded_ch <- parsed_msg
-		case ahmp.SOA_T:
-			//fmt.Println("receiving SOA")
-			var raw_msg ahmp.RawSOA
-			err = p.ahmp_decoder.Decode(&raw_msg)
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing SOA"), err)}
-				return
-			}
-			parsed_msg, err := raw_msg.TryParse()
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing SOA"), err)}
-				return
-			}
-			p.ahmp_decoded_ch <- parsed_msg
-		case ahmp.SOD_T:
-			//fmt.Println("receiving SOD")
-			var raw_msg ahmp.RawSOD
-			err = p.ahmp_decoder.Decode(&raw_msg)
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing SOD"), err)}
-				return
-			}
-			parsed_msg, err := raw_msg.TryParse()
-			if err != nil {
-				p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.Join(errors.New("parsing SOD"), err)}
-				return
-			}
-			p.ahmp_decoded_ch <- parsed_msg
-		default:
-			p.ahmp_decoded_ch <- &ahmp.INVAL{Err: errors.New("unknown AHMP message type")}
+// listenDatagram parses datagrams from the inbound connection until it closes.
+// Invalid datagrams are dropped, as are datagrams that the consumer cannot keep up with.
+func (p *AbyssPeer) listenDatagram() {
+	connection := p.inbound_conn
+	for {
+		datagram, err := connection.ReceiveDatagram(connection.Context())
+		if err != nil {
 			return
 		}
+		_, parsed_msg, err := ahmp.DatagramRegistry.DecodeDatagram(datagram)
+		if err != nil {
+			continue
+		}
+		select {
+		case p.datagram_ch <- parsed_msg:
+		default:
+		}
 	}
 }
@@ -7,12 +7,15 @@ import (
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/quic-go/quic-go"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
 )
 
 func (h *BetaNetService) PrepareAbyssOutbound(target *ContextedPeer, addresses []*net.UDPAddr) {
 	//watchdog.Info("outbound detected")
 	var connection quic.Connection
 	var ahmp_encoder *cbor.Encoder
+	var hello ahmp.Hello
 	var err error
 
 	defer func() {
@@ -31,11 +34,13 @@ func (h *BetaNetService) PrepareAbyssOutbound(target *ContextedPeer, addresses [
 				target.outbound_conn = connection
 				target.addresses = append(target.addresses, addresses...)
 				target.ahmp_encoder = ahmp_encoder
+				target.hello = hello
 			case PNCS_INBOUND:
 				target.state = PNCS_CONNECTED
 				target.outbound_conn = connection
 				target.addresses = append(target.addresses, addresses...)
 				target.ahmp_encoder = ahmp_encoder
+				target.hello = hello
 				h.abyssPeerCH <- target
 			case PNCS_OUTBOUND, PNCS_CONNECTED:
 				connection.CloseWithError(ABYSS_ALREADY_CONNECTED, ABYSS_ALREADY_CONNECTED_M)
@@ -60,15 +65,16 @@ func (h *BetaNetService) PrepareAbyssOutbound(target *ContextedPeer, addresses [
 		return
 	}
 	ahmp_encoder = cbor.NewEncoder(ahmp_stream)
-	ahmp_decoder := cbor.NewDecoder(ahmp_stream)
+	ahmp_decoder := ahmp.NewDecoder(ahmp_stream)
 
 	//send {local peer_hash, local tls-abyss binding cert} encrypted with remote handshake key.
 	var handshake_1_buf bytes.Buffer
-	err = cbor.MarshalToBuffer(h.tlsIdentity.abyss_bind_cert, &handshake_1_buf)
+	err = cbor.MarshalToBuffer(h.bindingMessage(connection), &handshake_1_buf)
 	if err != nil {
 		return
 	}
-	handshake_1_payload, err := target.identity.EncryptHandshake(handshake_1_buf.Bytes())
+	target_identity := target.Identity()
+	handshake_1_payload, err := target_identity.EncryptHandshake(handshake_1_buf.Bytes())
 	if err != nil {
 		return
 	}
@@ -78,12 +84,16 @@ func (h *BetaNetService) PrepareAbyssOutbound(target *ContextedPeer, addresses [
 	}
 
 	//receive accepter-side self-authentication
-	var handshake_2_payload []byte
-	if err := ahmp_decoder.Decode(&handshake_2_payload); err != nil {
+	var handshake_2_raw cbor.RawMessage
+	if err = ahmp_decoder.Decode(&handshake_2_raw); err != nil {
+		return
+	}
+	handshake_2_payload, hello, err := ahmp.ParseHS2(handshake_2_raw)
+	if err != nil {
 		return
 	}
 	handshake_2_payload_x509, err := x509.ParseCertificate(handshake_2_payload)
-	if err := target.identity.VerifyTLSBinding(handshake_2_payload_x509, client_tls_cert); err != nil {
+	if err := target_identity.VerifyTLSBinding(handshake_2_payload_x509, client_tls_cert); err != nil {
 		return
 	}
 
@@ -29,13 +29,15 @@ const (
 
 type AbyssPeer struct {
 	state           PNCState     //can be checked without entering mtx only after once its state becomes PNCS_CONNECTED
-	identity        PeerIdentity //must be set at creation
+	identity        PeerIdentity //set at creation, and updated in place by the trust policy
+	hello           ahmp.Hello   //from the binding certificate, set in handshake
 	addresses       []*net.UDPAddr
 	inbound_conn    quic.Connection
 	outbound_conn   quic.Connection
 	ahmp_encoder    *cbor.Encoder
 	ahmp_decoder    *cbor.Decoder //only listenAhmp() reads from this
 	ahmp_decoded_ch chan any
+	datagram_ch     chan any
 	err             error
 
 	mtx sync.Mutex //for peer component changes.
@@ -47,6 +49,7 @@ func NewAbyssPeer(identity PeerIdentity) *AbyssPeer {
 		identity:        identity,
 		addresses:       make([]*net.UDPAddr, 0),
 		ahmp_decoded_ch: make(chan any, 32),
+		datagram_ch:     make(chan any, 64),
 	}
 }
 
@@ -54,13 +57,38 @@ func (p *AbyssPeer) IDHash() string {
 	return p.identity.root_id_hash
 }
 
+// Identity returns the current identity of the peer.
+// It changes when the peer rotates its handshake key.
+func (p *AbyssPeer) Identity() PeerIdentity {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.identity
+}
+
+// setIdentity keeps the connection; it is authenticated by the root certificate,
+// which is not changed without confirmation.
+func (p *AbyssPeer) setIdentity(identity PeerIdentity) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.identity = identity
+}
+
 func (p *AbyssPeer) RootCertificateDer() []byte {
-	return p.identity.root_self_cert_der
+	return p.Identity().root_self_cert_der
 }
 
 func (p *AbyssPeer) HandshakeKeyCertificateDer() []byte {
-	return p.identity.handshake_key_cert_der
+	return p.Identity().handshake_key_cert_der
 }
+
+// ProtocolVersion and HasCapability tell what the peer announced in the handshake.
+// They are valid once the peer is connected.
+func (p *AbyssPeer) ProtocolVersion() int { return p.hello.Version }
+
+func (p *AbyssPeer) HasCapability(capability uint64) bool { return p.hello.Has(capability) }
+
 func (p *AbyssPeer) AURL() *aurl.AURL {
 	return &aurl.AURL{
 		Scheme:    "abyss",
@@ -79,6 +107,9 @@ func (p *AbyssPeer) IsConnected() bool {
 func (p *AbyssPeer) AhmpCh() chan any {
 	return p.ahmp_decoded_ch
 }
+func (p *AbyssPeer) DatagramCh() chan any {
+	return p.datagram_ch
+}
 
 func (p *ContextedPeer) _trySend(v any) bool {
 	if p.state != PNCS_CONNECTED {
@@ -112,7 +143,7 @@ func (p *ContextedPeer) TrySendJN(local_session_id uuid.UUID, path string, times
 		TimeStamp:       timestamp.UnixMilli(),
 	})
 }
-func (p *ContextedPeer) TrySendJOK(local_session_id uuid.UUID, peer_session_id uuid.UUID, timestamp time.Time, world_url string, member_sessions []abyss.ANDPeerSessionWithTimeStamp) bool {
+func (p *ContextedPeer) TrySendJOK(local_session_id uuid.UUID, peer_session_id uuid.UUID, timestamp time.Time, world_url string, member_sessions []abyss.ANDPeerSessionWithTimeStamp, settings abyss.WorldSettings) bool {
 	return p._trySend2(ahmp.JOK_T, ahmp.RawJOK{
 		SenderSessionID: local_session_id.String(),
 		RecverSessionID: peer_session_id.String(),
@@ -127,6 +158,10 @@ func (p *ContextedPeer) TrySendJOK(local_session_id uuid.UUID, peer_session_id u
 				HandshakeKeyCertificateDer: session.Peer.HandshakeKeyCertificateDer(),
 			}
 		}),
+		Access:         ahmp.MakeRawWorldAccess(settings.Access),
+		MaxMembers:     settings.MaxMembers,
+		InterestRadius: settings.InterestRadius,
+		InviteOnly:     settings.InviteOnly,
 	})
 }
 func (p *ContextedPeer) TrySendJDN(peer_session_id uuid.UUID, code int, message string) bool {
@@ -208,3 +243,59 @@ func (p *ContextedPeer) TrySendSOD(local_session_id uuid.UUID, peer_session_id u
 		ObjectIDs:       functional.Filter(objectIDs, func(u uuid.UUID) string { return u.String() }),
 	})
 }
+
+// TrySendSOU, TrySendSAM, TrySendROS, TrySendMOD and TrySendDTU fail without sending
+// if the peer lacks the capability, as a legacy peer would reject the message.
+func (p *ContextedPeer) TrySendSOU(local_session_id uuid.UUID, peer_session_id uuid.UUID, updates []abyss.ObjectUpdate) bool {
+	if !p.HasCapability(ahmp.CapObjectUpdate) {
+		return false
+	}
+	return p._trySend2(ahmp.SOU_T, ahmp.RawSOU{
+		SenderSessionID: local_session_id.String(),
+		RecverSessionID: peer_session_id.String(),
+		Objects: functional.Filter(updates, func(u abyss.ObjectUpdate) ahmp.RawObjectUpdate {
+			return ahmp.RawObjectUpdate{
+				ID:                u.ID.String(),
+				Transform:         u.Transform,
+				Properties:        u.Properties,
+				RemovedProperties: u.RemovedProperties,
+			}
+		}),
+	})
+}
+
+func (p *ContextedPeer) TrySendSAM(local_session_id uuid.UUID, peer_session_id uuid.UUID, topic string, payload []byte) bool {
+	if !p.HasCapability(ahmp.CapMemberMessage) {
+		return false
+	}
+	return p._trySend2(ahmp.SAM_T, ahmp.RawSAM{
+		SenderSessionID: local_session_id.String(),
+		RecverSessionID: peer_session_id.String(),
+		Topic:           topic,
+		Payload:         payload,
+	})
+}
+func (p *ContextedPeer) TrySendROS(local_session_id uuid.UUID, peer_session_id uuid.UUID, keep bool, local abyss.ANDRosterEntry, roster []abyss.ANDRosterEntry) bool {
+	if !p.HasCapability(ahmp.CapPartialMesh) {
+		return false
+	}
+	return p._trySend2(ahmp.ROS_T, ahmp.MakeRawROS(local_session_id, peer_session_id, keep, local, roster))
+}
+func (p *ContextedPeer) TrySendMOD(local_session_id uuid.UUID, peer_session_id uuid.UUID, moderation *abyss.WorldModeration) bool {
+	if !p.HasCapability(ahmp.CapModeration) {
+		return false
+	}
+	return p._trySend2(ahmp.MOD_T, ahmp.MakeRawMOD(local_session_id, peer_session_id, moderation))
+}
+
+// TrySendDTU does not close the peer on failure, as datagrams are unreliable anyway.
+func (p *ContextedPeer) TrySendDTU(local_session_id uuid.UUID, peer_session_id uuid.UUID, sequence uint64, transforms []abyss.ObjectTransform) bool {
+	if p.state != PNCS_CONNECTED || !p.HasCapability(ahmp.CapObjectDatagram) {
+		return false
+	}
+	datagram, err := ahmp.DatagramRegistry.EncodeDatagram(ahmp.MakeRawDTU(local_session_id, peer_session_id, sequence, transforms))
+	if err != nil {
+		return false
+	}
+	return p.outbound_conn.SendDatagram(datagram) == nil
+}
@@ -1 +1,112 @@
 package net_service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+	"github.com/kadmila/Abyss-Browser/abyss_core/net_service"
+)
+
+func newNetService(t *testing.T, ctx context.Context) *net_service.BetaNetService {
+	selector, err := net_service.NewBetaAddressSelector()
+	if err != nil {
+		t.Skip("no network interface: ", err)
+	}
+	root_key, err := net_service.NewRootPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := net_service.NewBetaNetService(ctx, root_key, selector, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func waitPeer(t *testing.T, service *net_service.BetaNetService) *net_service.ContextedPeer {
+	select {
+	case peer := <-service.GetAbyssPeerChannel():
+		return peer.(*net_service.ContextedPeer)
+	case <-time.After(3 * time.Second):
+		t.Fatal("peer not connected")
+		return nil
+	}
+}
+
+// TestLegacyPeerCapabilities connects a peer without hello, which is sent
+// no message of an optional capability, as it would reject the message and disconnect.
+func TestLegacyPeerCapabilities(t *testing.T) {
+	ctx, ctx_cancel := context.WithCancel(context.Background())
+	defer ctx_cancel()
+
+	legacy := newNetService(t, ctx)
+	if err := net_service.UseLegacyHello(legacy); err != nil {
+		t.Fatal(err)
+	}
+	current := newNetService(t, ctx)
+	for _, pair := range [][2]*net_service.BetaNetService{{legacy, current}, {current, legacy}} {
+		identity := pair[1].LocalIdentity()
+		if err := pair[0].AppendKnownPeer(identity.RootCertificate(), identity.HandshakeKeyCertificate()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go legacy.ListenAndServe()
+	go current.ListenAndServe()
+	legacy.ConnectAbyssAsync(current.LocalAURL())
+	current.ConnectAbyssAsync(legacy.LocalAURL())
+
+	legacy_peer := waitPeer(t, current)
+	current_peer := waitPeer(t, legacy)
+	if legacy_peer.ProtocolVersion() != 0 || legacy_peer.HasCapability(ahmp.CapMemberMessage) {
+		t.Fatal("legacy peer should have no hello")
+	}
+	if current_peer.ProtocolVersion() != 0 {
+		t.Fatal("legacy peer should not receive a hello")
+	}
+
+	local_session_id, peer_session_id := uuid.New(), uuid.New()
+	if legacy_peer.TrySendSAM(local_session_id, peer_session_id, "topic", nil) ||
+		legacy_peer.TrySendSOU(local_session_id, peer_session_id, nil) ||
+		legacy_peer.TrySendMOD(local_session_id, peer_session_id, &abyss.WorldModeration{}) ||
+		legacy_peer.TrySendROS(local_session_id, peer_session_id, true, abyss.ANDRosterEntry{}, nil) ||
+		legacy_peer.TrySendDTU(local_session_id, peer_session_id, 1, nil) {
+		t.Fatal("optional message sent to a legacy peer")
+	}
+	if !legacy_peer.TrySendSOA(local_session_id, peer_session_id, nil) {
+		t.Fatal("failed to send SOA to a legacy peer")
+	}
+}
+
+// TestPeerHello checks that peers offering the hello in ALPN exchange it,
+// and send messages of the capabilities they share.
+func TestPeerHello(t *testing.T) {
+	ctx, ctx_cancel := context.WithCancel(context.Background())
+	defer ctx_cancel()
+
+	service_A := newNetService(t, ctx)
+	service_B := newNetService(t, ctx)
+	for _, pair := range [][2]*net_service.BetaNetService{{service_A, service_B}, {service_B, service_A}} {
+		identity := pair[1].LocalIdentity()
+		if err := pair[0].AppendKnownPeer(identity.RootCertificate(), identity.HandshakeKeyCertificate()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go service_A.ListenAndServe()
+	go service_B.ListenAndServe()
+	service_A.ConnectAbyssAsync(service_B.LocalAURL())
+	service_B.ConnectAbyssAsync(service_A.LocalAURL())
+
+	for _, peer := range []*net_service.ContextedPeer{waitPeer(t, service_A), waitPeer(t, service_B)} {
+		if peer.ProtocolVersion() != ahmp.ProtocolVersion || !peer.HasCapability(ahmp.LocalCapabilities) {
+			t.Fatal("hello not received: ", peer.ProtocolVersion())
+		}
+		if !peer.TrySendSAM(uuid.New(), uuid.New(), "topic", nil) {
+			t.Fatal("failed to send SAM")
+		}
+	}
+}
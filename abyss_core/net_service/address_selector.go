@@ -6,9 +6,13 @@ import (
 	"sync"
 )
 
+// BetaAddressSelector picks one local address per IP version,
+// and filters remote candidates by reachability.
+// Either of the local addresses may be nil, but not both.
 type BetaAddressSelector struct {
-	localPrivateAddr net.IP
-	localPublicAddr  net.IP //can be added later
+	localPrivateAddr  net.IP // IPv4
+	localPrivateAddr6 net.IP // IPv6, not link-local
+	localPublicAddr   net.IP //can be added later
 
 	mtx *sync.Mutex
 }
@@ -19,6 +23,10 @@ func NewBetaAddressSelector() (*BetaAddressSelector, error) {
 		return nil, err
 	}
 
+	result := &BetaAddressSelector{
+		localPublicAddr: net.IPv4zero,
+		mtx:             new(sync.Mutex),
+	}
 	for _, i := range interfaces {
 		addrs, err := i.Addrs()
 		if err != nil {
@@ -35,22 +43,25 @@ func NewBetaAddressSelector() (*BetaAddressSelector, error) {
 				ip = v.IP
 			}
 
-			// Skip loopback and non-IPv4 addresses
-			if ip == nil || ip.IsLoopback() || ip.To4() == nil || ip.IsLinkLocalUnicast() {
+			// Skip loopback and link-local addresses
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
 				continue
 			}
 
-			//fmt.Println("ffff: " + ip.String())
-
-			return &BetaAddressSelector{
-				ip,
-				net.IPv4zero,
-				new(sync.Mutex),
-			}, nil
+			if ip4 := ip.To4(); ip4 != nil {
+				if result.localPrivateAddr == nil {
+					result.localPrivateAddr = ip4
+				}
+			} else if result.localPrivateAddr6 == nil {
+				result.localPrivateAddr6 = ip
+			}
 		}
 	}
 
-	return nil, errors.New("no network interface available")
+	if result.localPrivateAddr == nil && result.localPrivateAddr6 == nil {
+		return nil, errors.New("no network interface available")
+	}
+	return result, nil
 }
 
 func (s *BetaAddressSelector) SetPublicIP(ip net.IP) {
@@ -59,28 +70,57 @@ func (s *BetaAddressSelector) SetPublicIP(ip net.IP) {
 	s.mtx.Unlock()
 }
 
+// LocalPrivateIPAddr returns the IPv4 address, or the IPv6 address on IPv6-only hosts.
 func (s *BetaAddressSelector) LocalPrivateIPAddr() net.IP {
-	return s.localPrivateAddr
+	if s.localPrivateAddr != nil {
+		return s.localPrivateAddr
+	}
+	return s.localPrivateAddr6
+}
+
+func (s *BetaAddressSelector) LocalIPAddrs() []net.IP {
+	result := make([]net.IP, 0, 2)
+	if s.localPrivateAddr != nil {
+		result = append(result, s.localPrivateAddr)
+	}
+	if s.localPrivateAddr6 != nil {
+		result = append(result, s.localPrivateAddr6)
+	}
+	return result
+}
+
+func (s *BetaAddressSelector) isLocalAddr(ip net.IP) bool {
+	return ip.Equal(s.localPrivateAddr) || ip.Equal(s.localPrivateAddr6)
 }
 
+// FilterAddressCandidates returns public addresses if any, or else a private address of another host,
+// or else a loopback address.
+// When the local host has no IPv4 address, IPv6 candidates are preferred.
 func (s *BetaAddressSelector) FilterAddressCandidates(addresses []*net.UDPAddr) []*net.UDPAddr {
 	public_addresses := make([]*net.UDPAddr, 0)
+	public_addresses6 := make([]*net.UDPAddr, 0)
 
 	var loopbackaddr *net.UDPAddr
 	var privateaddr *net.UDPAddr
 
 	for _, address := range addresses {
-		if address.IP.Equal(net.IPv4zero) || address.IP.Equal(net.IPv4bcast) {
+		if address.IP.IsUnspecified() || address.IP.Equal(net.IPv4bcast) ||
+			address.IP.IsMulticast() || address.IP.IsLinkLocalUnicast() {
 			continue
 		}
 
-		if address.IP.Equal([]byte{127, 0, 0, 1}) {
-			loopbackaddr = address
+		if address.IP.IsLoopback() {
+			if loopbackaddr == nil || address.IP.To4() != nil {
+				loopbackaddr = address
+			}
 			continue
 		}
 
-		if address.IP[0] == 192 && address.IP[1] == 168 {
-			privateaddr = address
+		if address.IP.IsPrivate() {
+			// a private address of another host is preferred.
+			if privateaddr == nil || s.isLocalAddr(privateaddr.IP) {
+				privateaddr = address
+			}
 			continue
 		}
 
@@ -91,11 +131,21 @@ func (s *BetaAddressSelector) FilterAddressCandidates(addresses []*net.UDPAddr)
 			continue //ignore same public address
 		}
 
-		public_addresses = append(public_addresses, address)
+		if address.IP.To4() != nil {
+			public_addresses = append(public_addresses, address)
+		} else {
+			public_addresses6 = append(public_addresses6, address)
+		}
+	}
+
+	if s.localPrivateAddr == nil {
+		public_addresses = append(public_addresses6, public_addresses...)
+	} else {
+		public_addresses = append(public_addresses, public_addresses6...)
 	}
 
 	if len(public_addresses) == 0 { //no public address found
-		if privateaddr != nil && !privateaddr.IP.Equal(s.localPrivateAddr) {
+		if privateaddr != nil && !s.isLocalAddr(privateaddr.IP) {
 			return []*net.UDPAddr{privateaddr}
 		}
 
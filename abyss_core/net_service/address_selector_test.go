@@ -0,0 +1,60 @@
+package net_service_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/net_service"
+)
+
+func udpAddr(s string) *net.UDPAddr {
+	addr, err := net.ResolveUDPAddr("udp", s)
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+func TestFilterAddressCandidatesIPv6(t *testing.T) {
+	selector, err := net_service.NewBetaAddressSelector()
+	if err != nil {
+		t.Skip("no network interface: ", err)
+	}
+
+	// public IPv6 only (IPv6-only mobile network peer)
+	result := selector.FilterAddressCandidates([]*net.UDPAddr{
+		udpAddr("[fe80::1]:1605"),
+		udpAddr("[2001:db8::1]:1605"),
+		udpAddr("[::1]:1605"),
+	})
+	if len(result) != 1 || !result[0].IP.Equal(net.ParseIP("2001:db8::1")) {
+		t.Fatal("public IPv6 candidate should be selected: ", result)
+	}
+
+	// both versions; all public candidates are kept.
+	result = selector.FilterAddressCandidates([]*net.UDPAddr{
+		udpAddr("[2001:db8::1]:1605"),
+		udpAddr("8.8.8.8:1605"),
+	})
+	if len(result) != 2 {
+		t.Fatal("both public candidates should be kept: ", result)
+	}
+
+	// private IPv6 (ULA) of another host
+	result = selector.FilterAddressCandidates([]*net.UDPAddr{
+		udpAddr("[fd12:3456::1]:1605"),
+		udpAddr("[::1]:1605"),
+	})
+	if len(result) != 1 || !result[0].IP.Equal(net.ParseIP("fd12:3456::1")) {
+		t.Fatal("private IPv6 candidate should be selected: ", result)
+	}
+
+	// loopback only
+	result = selector.FilterAddressCandidates([]*net.UDPAddr{
+		udpAddr("[::1]:1605"),
+		udpAddr("[::]:1605"),
+	})
+	if len(result) != 1 || !result[0].IP.Equal(net.IPv6loopback) {
+		t.Fatal("IPv6 loopback should be selected: ", result)
+	}
+}
@@ -235,6 +235,7 @@ type PeerIdentity struct {
 
 	root_self_cert_der     []byte
 	handshake_key_cert_der []byte
+	issue_time             time.Time // of the handshake key
 }
 
 func NewPeerIdentity(root_self_cert []byte, handshake_key_cert []byte) (*PeerIdentity, error) {
@@ -278,12 +279,26 @@ func NewPeerIdentity(root_self_cert []byte, handshake_key_cert []byte) (*PeerIde
 
 		root_self_cert_der:     root_self_cert,
 		handshake_key_cert_der: handshake_key_cert,
+		issue_time:             handshake_key_cert_x509.NotBefore,
 	}, nil
 }
 
 func (p *PeerIdentity) IDHash() string {
 	return p.root_id_hash
 }
+
+// PeerIdentity is an ani.IAbyssPeerIdentity, for trust events.
+
+func (p *PeerIdentity) ID() string                 { return p.root_id_hash }
+func (p *PeerIdentity) RootCertificateDer() []byte { return p.root_self_cert_der }
+func (p *PeerIdentity) RootCertificate() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.root_self_cert_der}))
+}
+func (p *PeerIdentity) HandshakeKeyCertificateDer() []byte { return p.handshake_key_cert_der }
+func (p *PeerIdentity) HandshakeKeyCertificate() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.handshake_key_cert_der}))
+}
+func (p *PeerIdentity) IssueTime() time.Time { return p.issue_time }
 func (p *PeerIdentity) EncryptHandshake(payload []byte) ([]byte, error) {
 	aesKey := make([]byte, 32) //AES-256 key
 	_, err := rand.Read(aesKey)
@@ -325,3 +340,42 @@ func (p *PeerIdentity) VerifyTLSBinding(abyss_bind_cert *x509.Certificate, tls_c
 	}
 	return nil
 }
+
+///// root key signatures (abyss.IRootSigner)
+
+func (r *RootSecrets) RootCertificateDer() []byte {
+	return r.root_self_cert_x509.Raw
+}
+func (r *RootSecrets) Sign(message []byte) ([]byte, error) {
+	signer, ok := r.root_priv_key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("root key cannot sign")
+	}
+	return signer.Sign(rand.Reader, message, crypto.Hash(0))
+}
+
+// Verify checks the message against a self-signed root certificate, as in NewPeerIdentity.
+func (r *RootSecrets) Verify(root_cert_der []byte, message []byte, signature []byte) (string, error) {
+	root_self_cert_x509, err := x509.ParseCertificate(root_cert_der)
+	if err != nil {
+		return "", err
+	}
+	if root_self_cert_x509.Issuer.CommonName != root_self_cert_x509.Subject.CommonName {
+		return "", errors.New("invalid root certificate")
+	}
+	peer_hash, err := AbyssIdFromKey(root_self_cert_x509.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	if peer_hash != root_self_cert_x509.Issuer.CommonName {
+		return "", errors.New("invalid root certificate")
+	}
+	pkey, ok := root_self_cert_x509.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return "", errors.New("unsupported public key")
+	}
+	if !ed25519.Verify(pkey, message, signature) {
+		return "", errors.New("invalid signature")
+	}
+	return peer_hash, nil
+}
@@ -1,5 +1,11 @@
 package net_service_test
 
+import (
+	"testing"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/net_service"
+)
+
 // func TestNewRootIdentity(t *testing.T) {
 // 	priv_key, err := abyss_net.NewRootPrivateKey()
 // 	if err != nil {
@@ -18,3 +24,37 @@ package net_service_test
 
 // 	root_secret, err := abyss_net.NewRootIdentity(priv_key)
 // }
+
+func TestRootSigner(t *testing.T) {
+	new_identity := func() *net_service.RootSecrets {
+		priv_key, err := net_service.NewRootPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		root_secret, err := net_service.NewRootIdentity(priv_key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return root_secret
+	}
+	signer, verifier := new_identity(), new_identity()
+
+	message := []byte("kick")
+	signature, err := signer.Sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer_hash, err := verifier.Verify(signer.RootCertificateDer(), message, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer_hash != signer.IDHash() {
+		t.Fatal("id hash mismatch: ", peer_hash)
+	}
+	if _, err := verifier.Verify(signer.RootCertificateDer(), []byte("ban"), signature); err == nil {
+		t.Fatal("signature of another message accepted")
+	}
+	if _, err := verifier.Verify(verifier.RootCertificateDer(), message, signature); err == nil {
+		t.Fatal("signature of another key accepted")
+	}
+}
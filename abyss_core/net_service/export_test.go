@@ -0,0 +1,51 @@
+package net_service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+)
+
+// UseLegacyHello makes the service exchange bare binding certificates,
+// as peers before the protocol hello do. It must be called before ListenAndServe.
+func UseLegacyHello(h *BetaNetService) error {
+	h.abyssTlsConf.NextProtos = []string{abyss.NextProtoAbyss, http3.NextProtoH3}
+	return nil
+}
+
+func FindPeer(h *BetaNetService, id string) (*ContextedPeer, bool) {
+	return h.peers.Find(id)
+}
+
+// IssueHandshakeKey issues a new handshake key certificate (der) under the root certificate,
+// as a peer rotating its handshake key would.
+func IssueHandshakeKey(r *RootSecrets) ([]byte, error) {
+	handshake_private_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	h_template := x509.Certificate{
+		Issuer: pkix.Name{
+			CommonName: r.root_id_hash,
+		},
+		Subject: pkix.Name{
+			CommonName: "H-" + r.root_id_hash + "-OAEP-SHA3-256-AES-256-GCM",
+		},
+		NotBefore:             time.Now(),
+		SerialNumber:          serialNumber,
+		KeyUsage:              x509.KeyUsageEncipherOnly,
+		BasicConstraintsValid: true,
+	}
+	return x509.CreateCertificate(rand.Reader, &h_template, r.root_self_cert_x509, &handshake_private_key.PublicKey, r.root_priv_key)
+}
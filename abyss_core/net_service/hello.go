@@ -0,0 +1,29 @@
+package net_service
+
+import (
+	"github.com/quic-go/quic-go"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ahmp"
+)
+
+///// protocol hello
+// The hello rides on the binding certificate exchange (handshake 1 and 2): an ahmp.RawHS2
+// is sent in place of the bare binding certificate. Legacy peers expect the bare certificate,
+// so the hello is sent only if both sides offered nextProtoAbyssHello in TLS ALPN; a legacy
+// peer negotiates abyss.NextProtoAbyss, and has ahmp.LegacyHello.
+// Handshake 1 is encrypted to the accepter, and handshake 2 is sent on the TLS connection
+// that the binding certificate authenticates, so the hello needs no signature of its own.
+
+const nextProtoAbyssHello = "abyss-hello"
+
+// bindingMessage is the local half of the binding certificate exchange.
+// ahmp.ParseHS2 parses both forms.
+func (h *BetaNetService) bindingMessage(connection quic.Connection) any {
+	if connection.ConnectionState().TLS.NegotiatedProtocol != nextProtoAbyssHello {
+		return h.tlsIdentity.abyss_bind_cert
+	}
+	return &ahmp.RawHS2{
+		BindingCertificate: h.tlsIdentity.abyss_bind_cert,
+		Hello:              *ahmp.MakeRawHello(ahmp.LocalHello()),
+	}
+}
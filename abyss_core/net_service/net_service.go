@@ -7,6 +7,7 @@ import (
 	"errors"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/quic-go/quic-go"
@@ -32,6 +33,7 @@ type BetaNetService struct {
 	preAccepter abyss.IPreAccepter
 
 	peers *ContextedPeerMap
+	trust *peerTrust
 
 	abyssPeerCH chan abyss.IANDPeer //before actually using the peer, each thread must check IsConnected()
 
@@ -57,25 +59,31 @@ func NewBetaNetService(ctx context.Context, local_private_key PrivateKey, addres
 	result.tlsIdentity = tls_identity
 	result.abyssTlsConf = NewDefaultTlsConf(tls_identity)
 
-	udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	// dual-stack where IPv6 is available; with IP unset, Go falls back to IPv4 on hosts without it.
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: 0})
 	if err != nil {
 		return nil, err
 	}
 	result.quicTransport = &quic.Transport{Conn: udpConn}
 	result.quicConf = NewDefaultQuicConf()
 
+	// loopback must come last; see ConnectAbyst.
 	local_port := strconv.Itoa(udpConn.LocalAddr().(*net.UDPAddr).Port)
-	local_ip := address_selector.LocalPrivateIPAddr().String()
+	local_candidates := make([]string, 0, 3)
+	for _, local_ip := range address_selector.LocalIPAddrs() {
+		local_candidates = append(local_candidates, net.JoinHostPort(local_ip.String(), local_port))
+	}
+	local_candidates = append(local_candidates, "127.0.0.1:"+local_port)
 	local_aurl, err := aurl.TryParse("abyss:" +
 		root_secret.IDHash() +
-		":" + local_ip + ":" + local_port +
-		"|127.0.0.1:" + local_port)
+		":" + strings.Join(local_candidates, "|"))
 	if err != nil {
 		return nil, err
 	}
 	result.local_aurl = local_aurl
 
 	result.peers = NewContextedPeerMap()
+	result.trust = newPeerTrust()
 
 	result.abyssPeerCH = make(chan abyss.IANDPeer, 8)
 
@@ -104,7 +112,7 @@ func NewDefaultTlsConf(tls_identity *TLSIdentity) *tls.Config {
 			}
 			return nil
 		},
-		NextProtos:         []string{abyss.NextProtoAbyss, http3.NextProtoH3},
+		NextProtos:         []string{nextProtoAbyssHello, abyss.NextProtoAbyss, http3.NextProtoH3},
 		ServerName:         "abyss",
 		ClientAuth:         tls.RequireAnyClientCert,
 		InsecureSkipVerify: true,
@@ -124,6 +132,11 @@ func NewDefaultQuicConf() *quic.Config {
 func (h *BetaNetService) LocalIdentity() abyss.IHostIdentity {
 	return h.localIdentity
 }
+
+// RootSigner signs with the local root key, e.g. for world moderation.
+func (h *BetaNetService) RootSigner() abyss.IRootSigner {
+	return h.localIdentity
+}
 func (h *BetaNetService) LocalAURL() *aurl.AURL {
 	return h.local_aurl
 }
@@ -145,7 +158,7 @@ func (h *BetaNetService) ListenAndServe() error {
 			return err
 		}
 		switch connection.ConnectionState().TLS.NegotiatedProtocol {
-		case abyss.NextProtoAbyss:
+		case nextProtoAbyssHello, abyss.NextProtoAbyss:
 			go h.PrepareAbyssInbound(h.ctx, connection)
 		case http3.NextProtoH3:
 			go h.abystServer.ServeQUICConn(connection)
@@ -168,14 +181,11 @@ func (h *BetaNetService) AppendKnownPeer(root_cert string, handshake_key_cert st
 	return h.AppendKnownPeerDer(root_cert_block.Bytes, handshake_key_cert_block.Bytes)
 }
 func (h *BetaNetService) AppendKnownPeerDer(root_cert []byte, handshake_key_cert []byte) error {
-	//Future: allow updating handshake key? may not? unsure.
 	peer_identity, err := NewPeerIdentity(root_cert, handshake_key_cert)
 	if err != nil {
 		return err
 	}
-
-	h.peers.Append(h.ctx, peer_identity.root_id_hash, NewAbyssPeer(*peer_identity))
-	return nil
+	return h.updatePeerIdentity(peer_identity, IdentityOrigin{Source: IdentityDirect})
 }
 
 func (h *BetaNetService) GetAbyssPeerChannel() chan abyss.IANDPeer {
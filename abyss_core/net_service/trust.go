@@ -0,0 +1,213 @@
+package net_service
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/ani"
+)
+
+// The trust model is shared with ann; see ani.TrustPolicy.
+// The service keeps no identity store, so identities are direct or introduced.
+// A known peer is updated in place, so that its connection and sessions survive
+// a handshake key rotation.
+
+type (
+	IdentitySource = ani.IdentitySource
+	IdentityOrigin = ani.IdentityOrigin
+	TrustPolicy    = ani.TrustPolicy
+	TrustEventType = ani.TrustEventType
+	TrustOutcome   = ani.TrustOutcome
+	TrustEvent     = ani.TrustEvent
+)
+
+const (
+	IdentityDirect     = ani.IdentityDirect
+	IdentityIntroduced = ani.IdentityIntroduced
+
+	TrustNewPeer                = ani.TrustNewPeer
+	TrustRootCertificateChanged = ani.TrustRootCertificateChanged
+	TrustHandshakeKeyChanged    = ani.TrustHandshakeKeyChanged
+
+	TrustAccepted = ani.TrustAccepted
+	TrustHeld     = ani.TrustHeld
+	TrustRejected = ani.TrustRejected
+)
+
+var (
+	ErrIdentityHeld   = ani.ErrIdentityHeld
+	ErrIdentityPinned = ani.ErrIdentityPinned
+	ErrNoHeldIdentity = ani.ErrNoHeldIdentity
+)
+
+type heldIdentity struct {
+	identity *PeerIdentity
+	origin   IdentityOrigin
+}
+
+type peerTrust struct {
+	policy  TrustPolicy
+	held    map[string]heldIdentity
+	pinned  map[string][]byte // id -> root certificate
+	origins map[string]IdentityOrigin
+
+	mtx sync.Mutex
+}
+
+func newPeerTrust() *peerTrust {
+	return &peerTrust{
+		held:    make(map[string]heldIdentity),
+		pinned:  make(map[string][]byte),
+		origins: make(map[string]IdentityOrigin),
+	}
+}
+
+func (h *BetaNetService) SetTrustPolicy(policy TrustPolicy) {
+	h.trust.mtx.Lock()
+	defer h.trust.mtx.Unlock()
+
+	h.trust.policy = policy
+}
+
+// IntroducePeerDer is AppendKnownPeerDer for certificates relayed by introducer_id,
+// which is subject to the trust policy. A held identity returns ErrIdentityHeld.
+func (h *BetaNetService) IntroducePeerDer(introducer_id string, root_cert []byte, handshake_key_cert []byte) error {
+	peer_identity, err := NewPeerIdentity(root_cert, handshake_key_cert)
+	if err != nil {
+		return err
+	}
+	return h.updatePeerIdentity(peer_identity, IdentityOrigin{Source: IdentityIntroduced, Introducer: introducer_id})
+}
+
+func (h *BetaNetService) updatePeerIdentity(identity *PeerIdentity, origin IdentityOrigin) error {
+	event, on_event, err := h.tryUpdatePeerIdentity(identity, origin)
+	if event != nil && on_event != nil {
+		on_event(*event)
+	}
+	return err
+}
+
+func (h *BetaNetService) tryUpdatePeerIdentity(identity *PeerIdentity, origin IdentityOrigin) (*TrustEvent, func(TrustEvent), error) {
+	h.trust.mtx.Lock()
+	defer h.trust.mtx.Unlock()
+
+	id := identity.root_id_hash
+	on_event := h.trust.policy.OnEvent
+	event := &TrustEvent{ID: id, Origin: origin, New: identity}
+	known_peer, known := h.peers.Find(id)
+	var old_identity PeerIdentity
+	if known {
+		old_identity = known_peer.Identity()
+		event.Old = &old_identity
+	}
+	switch {
+	case !known:
+		event.Type = TrustNewPeer
+	case !bytes.Equal(old_identity.root_self_cert_der, identity.root_self_cert_der):
+		event.Type = TrustRootCertificateChanged
+	case bytes.Equal(old_identity.handshake_key_cert_der, identity.handshake_key_cert_der):
+		return nil, nil, nil // nothing new; introductions are repeated often.
+	default:
+		event.Type = TrustHandshakeKeyChanged
+	}
+
+	// an older handshake key is ignored.
+	if known && old_identity.issue_time.After(identity.issue_time) {
+		return nil, nil, nil
+	}
+
+	pinned_root, pinned := h.trust.pinned[id]
+	if pinned && !bytes.Equal(pinned_root, identity.root_self_cert_der) {
+		event.Outcome = TrustRejected
+		return event, on_event, ErrIdentityPinned
+	}
+
+	var hold bool
+	if origin.Source == IdentityIntroduced {
+		hold = event.Type == TrustRootCertificateChanged || (!known && h.trust.policy.ConfirmIntroduced)
+	} else {
+		hold = !known && !pinned && h.trust.policy.ConfirmDirect
+	}
+	if hold {
+		if held, ok := h.trust.held[id]; ok && bytes.Equal(held.identity.handshake_key_cert_der, identity.handshake_key_cert_der) {
+			return nil, nil, ErrIdentityHeld // already reported.
+		}
+		h.trust.held[id] = heldIdentity{identity: identity, origin: origin}
+		event.Outcome = TrustHeld
+		return event, on_event, ErrIdentityHeld
+	}
+
+	event.Outcome = TrustAccepted
+	h.acceptPeerIdentity(identity, origin)
+	return event, on_event, nil
+}
+
+// acceptPeerIdentity must be called with h.trust.mtx locked.
+func (h *BetaNetService) acceptPeerIdentity(identity *PeerIdentity, origin IdentityOrigin) {
+	id := identity.root_id_hash
+	h.trust.origins[id] = origin
+	delete(h.trust.held, id)
+	if _, ok := h.peers.Append(h.ctx, id, NewAbyssPeer(*identity)); ok {
+		return
+	}
+	if known_peer, ok := h.peers.Find(id); ok {
+		known_peer.setIdentity(*identity)
+	}
+}
+
+// ConfirmPeer accepts the held identity of the peer, even if it is older than the known one.
+func (h *BetaNetService) ConfirmPeer(id string) error {
+	h.trust.mtx.Lock()
+	defer h.trust.mtx.Unlock()
+
+	held, ok := h.trust.held[id]
+	if !ok {
+		return ErrNoHeldIdentity
+	}
+	delete(h.trust.held, id)
+	if pinned_root, pinned := h.trust.pinned[id]; pinned && !bytes.Equal(pinned_root, held.identity.root_self_cert_der) {
+		return ErrIdentityPinned
+	}
+	h.acceptPeerIdentity(held.identity, held.origin)
+	return nil
+}
+
+// RejectPeer discards the held identity of the peer, if any.
+func (h *BetaNetService) RejectPeer(id string) {
+	h.trust.mtx.Lock()
+	defer h.trust.mtx.Unlock()
+
+	delete(h.trust.held, id)
+}
+
+// PinPeer fixes the root certificate of a known peer.
+func (h *BetaNetService) PinPeer(id string) error {
+	h.trust.mtx.Lock()
+	defer h.trust.mtx.Unlock()
+
+	known, ok := h.peers.Find(id)
+	if !ok {
+		return errors.New("unknown peer")
+	}
+	h.trust.pinned[id] = known.Identity().root_self_cert_der
+	return nil
+}
+
+func (h *BetaNetService) UnpinPeer(id string) {
+	h.trust.mtx.Lock()
+	defer h.trust.mtx.Unlock()
+
+	delete(h.trust.pinned, id)
+}
+
+// PeerOrigin returns where the known identity of the peer came from.
+func (h *BetaNetService) PeerOrigin(id string) (IdentityOrigin, bool) {
+	h.trust.mtx.Lock()
+	defer h.trust.mtx.Unlock()
+
+	if _, ok := h.peers.Find(id); !ok {
+		return IdentityOrigin{}, false
+	}
+	return h.trust.origins[id], true
+}
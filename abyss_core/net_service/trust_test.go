@@ -0,0 +1,139 @@
+package net_service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/net_service"
+)
+
+func der(pem_cert string) []byte {
+	block, _ := pem.Decode([]byte(pem_cert))
+	return block.Bytes
+}
+
+func expectTrustEvent(t *testing.T, events *[]net_service.TrustEvent, event_type net_service.TrustEventType, outcome net_service.TrustOutcome) {
+	t.Helper()
+	if len(*events) != 1 {
+		t.Fatal("expected one trust event, got ", len(*events))
+	}
+	event := (*events)[0]
+	*events = (*events)[:0]
+	if event.Type != event_type || event.Outcome != outcome {
+		t.Fatal("unexpected trust event: ", event.Type, event.Outcome)
+	}
+}
+
+// TestTrustRotation checks that a newer handshake key under the known root certificate
+// is accepted in place, without dropping the peer, and that root certificate changes are held.
+func TestTrustRotation(t *testing.T) {
+	ctx, ctx_cancel := context.WithCancel(context.Background())
+	defer ctx_cancel()
+
+	service := newNetService(t, ctx)
+	events := &[]net_service.TrustEvent{}
+	service.SetTrustPolicy(net_service.TrustPolicy{
+		OnEvent: func(event net_service.TrustEvent) { *events = append(*events, event) },
+	})
+
+	root_key, _ := net_service.NewRootPrivateKey()
+	peer, err := net_service.NewRootIdentity(root_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.AppendKnownPeer(peer.RootCertificate(), peer.HandshakeKeyCertificate()); err != nil {
+		t.Fatal(err)
+	}
+	expectTrustEvent(t, events, net_service.TrustNewPeer, net_service.TrustAccepted)
+	known, _ := net_service.FindPeer(service, peer.IDHash())
+
+	time.Sleep(time.Millisecond * 1100) // certificate time has second precision.
+	rotated, err := net_service.IssueHandshakeKey(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.IntroducePeerDer("introducer", der(peer.RootCertificate()), rotated); err != nil {
+		t.Fatal("rotated handshake key should be accepted: ", err)
+	}
+	expectTrustEvent(t, events, net_service.TrustHandshakeKeyChanged, net_service.TrustAccepted)
+	updated, _ := net_service.FindPeer(service, peer.IDHash())
+	if updated != known || known.Context().Err() != nil {
+		t.Fatal("peer replaced on handshake key rotation")
+	}
+	if !bytes.Equal(known.HandshakeKeyCertificateDer(), rotated) {
+		t.Fatal("handshake key not updated")
+	}
+	if origin, ok := service.PeerOrigin(peer.IDHash()); !ok || origin.Source != net_service.IdentityIntroduced {
+		t.Fatal("unexpected origin: ", origin)
+	}
+
+	// the older key is ignored.
+	if err := service.AppendKnownPeer(peer.RootCertificate(), peer.HandshakeKeyCertificate()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*events) != 0 || !bytes.Equal(known.HandshakeKeyCertificateDer(), rotated) {
+		t.Fatal("older handshake key accepted")
+	}
+
+	// a different root certificate is held, and can be confirmed.
+	time.Sleep(time.Millisecond * 1100)
+	reissued, _ := net_service.NewRootIdentity(root_key)
+	if err := service.IntroducePeerDer("introducer", der(reissued.RootCertificate()), der(reissued.HandshakeKeyCertificate())); !errors.Is(err, net_service.ErrIdentityHeld) {
+		t.Fatal("changed root certificate should be held: ", err)
+	}
+	expectTrustEvent(t, events, net_service.TrustRootCertificateChanged, net_service.TrustHeld)
+	if err := service.ConfirmPeer(peer.IDHash()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(known.RootCertificateDer(), der(reissued.RootCertificate())) || known.Context().Err() != nil {
+		t.Fatal("confirmed identity not updated in place")
+	}
+}
+
+func TestTrustPinning(t *testing.T) {
+	ctx, ctx_cancel := context.WithCancel(context.Background())
+	defer ctx_cancel()
+
+	service := newNetService(t, ctx)
+	events := &[]net_service.TrustEvent{}
+	service.SetTrustPolicy(net_service.TrustPolicy{
+		ConfirmIntroduced: true,
+		OnEvent:           func(event net_service.TrustEvent) { *events = append(*events, event) },
+	})
+
+	root_key, _ := net_service.NewRootPrivateKey()
+	peer, _ := net_service.NewRootIdentity(root_key)
+	if err := service.IntroducePeerDer("introducer", der(peer.RootCertificate()), der(peer.HandshakeKeyCertificate())); !errors.Is(err, net_service.ErrIdentityHeld) {
+		t.Fatal("introduced peer should be held: ", err)
+	}
+	expectTrustEvent(t, events, net_service.TrustNewPeer, net_service.TrustHeld)
+	if _, ok := net_service.FindPeer(service, peer.IDHash()); ok {
+		t.Fatal("held peer is known")
+	}
+	if err := service.ConfirmPeer(peer.IDHash()); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.PinPeer(peer.IDHash()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a newer handshake key signed by the pinned root is accepted.
+	time.Sleep(time.Millisecond * 1100)
+	rotated, _ := net_service.IssueHandshakeKey(peer)
+	if err := service.IntroducePeerDer("introducer", der(peer.RootCertificate()), rotated); err != nil {
+		t.Fatal("rotated handshake key of a pinned peer should be accepted: ", err)
+	}
+	expectTrustEvent(t, events, net_service.TrustHandshakeKeyChanged, net_service.TrustAccepted)
+
+	// a different root certificate is rejected, even from a direct source.
+	time.Sleep(time.Millisecond * 1100)
+	reissued, _ := net_service.NewRootIdentity(root_key)
+	if err := service.AppendKnownPeer(reissued.RootCertificate(), reissued.HandshakeKeyCertificate()); !errors.Is(err, net_service.ErrIdentityPinned) {
+		t.Fatal("pinned root certificate replaced: ", err)
+	}
+	expectTrustEvent(t, events, net_service.TrustRootCertificateChanged, net_service.TrustRejected)
+}
@@ -6,6 +6,8 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"sync"
+	"time"
 
 	"github.com/kadmila/Abyss-Browser/abyss_core/ani"
 	"github.com/quic-go/quic-go/http3"
@@ -16,24 +18,28 @@ const (
 )
 
 // TLSIdentity is constructed from AbyssRootSecretes.NewTLSIdentity().
+// Its certificates are renewed with AbyssRootSecret.RenewTLSIdentity, keeping the key.
+// As the key does not change, connections and bindings issued before renewal stay valid.
 type TLSIdentity struct {
-	priv_key        crypto.PrivateKey
+	priv_key crypto.PrivateKey
+
+	mtx             sync.RWMutex
 	tls_self_cert   []byte //der
 	abyss_bind_cert []byte //der
+	not_after       time.Time
 }
 
+// HashTlsCertificate digests the public key only, so that
+// a renewed certificate of the same TLSIdentity has the same hash.
 func HashTlsCertificate(cert *x509.Certificate) [32]byte {
-	return sha3.Sum256(cert.Raw)
+	return sha3.Sum256(cert.RawSubjectPublicKeyInfo)
 }
 
 // NewServerTlsConf provides *tls.Config for server-side
 func (t *TLSIdentity) NewServerTlsConf(abyst_cert_checker ani.IAbystTlsCertChecker) *tls.Config {
 	return &tls.Config{
-		Certificates: []tls.Certificate{
-			{
-				Certificate: [][]byte{t.tls_self_cert},
-				PrivateKey:  t.priv_key,
-			},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return t.certificate(), nil
 		},
 		VerifyConnection: func(cs tls.ConnectionState) error {
 			if len(cs.PeerCertificates) != 1 {
@@ -65,11 +71,8 @@ func (t *TLSIdentity) NewServerTlsConf(abyst_cert_checker ani.IAbystTlsCertCheck
 // NewAbyssClientTlsConf provides *tls.Config for client-side (abyss)
 func (t *TLSIdentity) NewAbyssClientTlsConf() *tls.Config {
 	return &tls.Config{
-		Certificates: []tls.Certificate{
-			{
-				Certificate: [][]byte{t.tls_self_cert},
-				PrivateKey:  t.priv_key,
-			},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return t.certificate(), nil
 		},
 		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
 			if len(rawCerts) != 1 {
@@ -92,11 +95,8 @@ func (t *TLSIdentity) NewAbyssClientTlsConf() *tls.Config {
 // NewAbystClientTlsConf provides *tls.Config for client-side (abyst)
 func (t *TLSIdentity) NewAbystClientTlsConf(abyst_cert_checker ani.IAbystTlsCertChecker) *tls.Config {
 	return &tls.Config{
-		Certificates: []tls.Certificate{
-			{
-				Certificate: [][]byte{t.tls_self_cert},
-				PrivateKey:  t.priv_key,
-			},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return t.certificate(), nil
 		},
 		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
 			if len(rawCerts) != 1 {
@@ -119,4 +119,44 @@ func (t *TLSIdentity) NewAbystClientTlsConf(abyst_cert_checker ani.IAbystTlsCert
 	}
 }
 
-func (t *TLSIdentity) AbyssBindingCertificate() []byte { return t.abyss_bind_cert }
+// NewCollocatedClientTlsConf provides *tls.Config for ordinary https (http/3) client.
+// Server certificates are verified normally, against root_cas (nil for system roots).
+// The TLS certificate is presented when the server requests client auth.
+func (t *TLSIdentity) NewCollocatedClientTlsConf(root_cas *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return t.certificate(), nil
+		},
+		RootCAs:    root_cas,
+		NextProtos: []string{http3.NextProtoH3},
+	}
+}
+
+func (t *TLSIdentity) certificate() *tls.Certificate {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	return &tls.Certificate{
+		Certificate: [][]byte{t.tls_self_cert},
+		PrivateKey:  t.priv_key,
+	}
+}
+
+func (t *TLSIdentity) TLSCertificate() []byte {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return t.tls_self_cert
+}
+
+func (t *TLSIdentity) AbyssBindingCertificate() []byte {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return t.abyss_bind_cert
+}
+
+// TLSNotAfter is the expiry of the current certificates.
+func (t *TLSIdentity) TLSNotAfter() time.Time {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return t.not_after
+}
@@ -0,0 +1,277 @@
+package sec
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+// HandshakeSuite identifies how the handshake payload is encrypted.
+// It follows the type of the handshake key certificate.
+type HandshakeSuite int
+
+const (
+	// HandshakeSuiteRSAOAEP wraps a random AES-256-GCM key with RSA-OAEP (SHA3-256).
+	// This is the legacy suite, for RSA handshake keys.
+	HandshakeSuiteRSAOAEP HandshakeSuite = iota
+	// HandshakeSuiteX25519AES256GCM is HPKE (RFC 9180) base mode with
+	// DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and AES-256-GCM.
+	HandshakeSuiteX25519AES256GCM
+	// HandshakeSuiteX25519ChaCha20Poly1305 is same as above, with ChaCha20-Poly1305.
+	HandshakeSuiteX25519ChaCha20Poly1305
+)
+
+// HandshakeKeyType is the type of handshake key AbyssRootSecret issues.
+type HandshakeKeyType int
+
+const (
+	// HandshakeKeyRSA is the default, as peers without HPKE support can only encrypt to RSA keys.
+	HandshakeKeyRSA HandshakeKeyType = iota
+	// HandshakeKeyX25519 is much faster, but only peers with HPKE support can dial with it.
+	HandshakeKeyX25519
+)
+
+var ErrHandshakeSuiteMismatch = errors.New("handshake suite does not match the handshake key")
+
+// hpkeInfo binds the HPKE context to the abyss handshake.
+var hpkeInfo = []byte("abyss handshake")
+
+// x25519Suite prefers AES-GCM only with hardware support, as quic-go does.
+func x25519Suite() HandshakeSuite {
+	if cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ || cpu.ARM64.HasAES && cpu.ARM64.HasPMULL || cpu.S390X.HasAESGCM {
+		return HandshakeSuiteX25519AES256GCM
+	}
+	return HandshakeSuiteX25519ChaCha20Poly1305
+}
+
+// hpke identifiers (RFC 9180, section 7)
+const (
+	hpkeKemX25519HkdfSha256 uint16 = 0x0020
+	hpkeKdfHkdfSha256       uint16 = 0x0001
+	hpkeAeadAes256Gcm       uint16 = 0x0002
+	hpkeAeadChaCha20        uint16 = 0x0003
+)
+
+func hpkeAeadID(suite HandshakeSuite) (uint16, bool) {
+	switch suite {
+	case HandshakeSuiteX25519AES256GCM:
+		return hpkeAeadAes256Gcm, true
+	case HandshakeSuiteX25519ChaCha20Poly1305:
+		return hpkeAeadChaCha20, true
+	default:
+		return 0, false
+	}
+}
+
+func hpkeLabeledExtract(suite_id []byte, salt []byte, label string, ikm []byte) []byte {
+	labeled_ikm := make([]byte, 0, 7+len(suite_id)+len(label)+len(ikm))
+	labeled_ikm = append(labeled_ikm, "HPKE-v1"...)
+	labeled_ikm = append(labeled_ikm, suite_id...)
+	labeled_ikm = append(labeled_ikm, label...)
+	labeled_ikm = append(labeled_ikm, ikm...)
+	prk, _ := hkdf.Extract(sha256.New, labeled_ikm, salt) // never fails for sha256.
+	return prk
+}
+
+func hpkeLabeledExpand(suite_id []byte, prk []byte, label string, info []byte, length int) []byte {
+	labeled_info := binary.BigEndian.AppendUint16(nil, uint16(length))
+	labeled_info = append(labeled_info, "HPKE-v1"...)
+	labeled_info = append(labeled_info, suite_id...)
+	labeled_info = append(labeled_info, label...)
+	labeled_info = append(labeled_info, info...)
+	okm, _ := hkdf.Expand(sha256.New, prk, string(labeled_info), length) // length is always small.
+	return okm
+}
+
+// hpkeSharedSecret is DHKEM ExtractAndExpand.
+func hpkeSharedSecret(dh []byte, enc []byte, recipient_pub []byte) []byte {
+	kem_suite_id := binary.BigEndian.AppendUint16([]byte("KEM"), hpkeKemX25519HkdfSha256)
+	kem_context := append(append([]byte{}, enc...), recipient_pub...)
+	eae_prk := hpkeLabeledExtract(kem_suite_id, nil, "eae_prk", dh)
+	return hpkeLabeledExpand(kem_suite_id, eae_prk, "shared_secret", kem_context, 32)
+}
+
+// hpkeAEAD is the base mode key schedule. As each context seals only one message,
+// the base nonce is used as is (sequence number 0).
+func hpkeAEAD(suite HandshakeSuite, shared_secret []byte) (cipher.AEAD, []byte, error) {
+	aead_id, ok := hpkeAeadID(suite)
+	if !ok {
+		return nil, nil, ErrHandshakeSuiteMismatch
+	}
+	suite_id := []byte("HPKE")
+	suite_id = binary.BigEndian.AppendUint16(suite_id, hpkeKemX25519HkdfSha256)
+	suite_id = binary.BigEndian.AppendUint16(suite_id, hpkeKdfHkdfSha256)
+	suite_id = binary.BigEndian.AppendUint16(suite_id, aead_id)
+
+	psk_id_hash := hpkeLabeledExtract(suite_id, nil, "psk_id_hash", nil)
+	info_hash := hpkeLabeledExtract(suite_id, nil, "info_hash", hpkeInfo)
+	key_schedule_context := append([]byte{0}, psk_id_hash...) // mode_base
+	key_schedule_context = append(key_schedule_context, info_hash...)
+	secret := hpkeLabeledExtract(suite_id, shared_secret, "secret", nil)
+
+	key := hpkeLabeledExpand(suite_id, secret, "key", key_schedule_context, 32)
+	base_nonce := hpkeLabeledExpand(suite_id, secret, "base_nonce", key_schedule_context, 12)
+
+	var aead cipher.AEAD
+	var err error
+	if aead_id == hpkeAeadAes256Gcm {
+		var block cipher.Block
+		if block, err = aes.NewCipher(key); err != nil {
+			return nil, nil, err
+		}
+		aead, err = cipher.NewGCM(block)
+	} else {
+		aead, err = chacha20poly1305.New(key)
+	}
+	return aead, base_nonce, err
+}
+
+// hpkeSeal returns the ciphertext and the encapsulated key.
+func hpkeSeal(suite HandshakeSuite, recipient *ecdh.PublicKey, payload []byte) ([]byte, []byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	dh, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc := ephemeral.PublicKey().Bytes()
+	aead, nonce, err := hpkeAEAD(suite, hpkeSharedSecret(dh, enc, recipient.Bytes()))
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead.Seal(nil, nonce, payload, nil), enc, nil
+}
+
+func hpkeOpen(suite HandshakeSuite, recipient *ecdh.PrivateKey, ciphertext []byte, enc []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	dh, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, nonce, err := hpkeAEAD(suite, hpkeSharedSecret(dh, enc, recipient.PublicKey().Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// handshakePublicKey returns *rsa.PublicKey or X25519 *ecdh.PublicKey.
+// x509.ParseCertificate leaves X25519 keys unparsed.
+func handshakePublicKey(handshake_key_cert *x509.Certificate) (crypto.PublicKey, error) {
+	switch key := handshake_key_cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return key, nil
+	case nil:
+		key_any, err := x509.ParsePKIXPublicKey(handshake_key_cert.RawSubjectPublicKeyInfo)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := key_any.(*ecdh.PublicKey); ok && key.Curve() == ecdh.X25519() {
+			return key, nil
+		}
+	}
+	return nil, errors.New("unsupported public key")
+}
+
+// createX25519Certificate works around x509.CreateCertificate, which does not accept
+// X25519 subject keys. The certificate is created for a placeholder key, and signed again
+// after its SubjectPublicKeyInfo is replaced.
+func createX25519Certificate(template *x509.Certificate, parent *x509.Certificate, pub *ecdh.PublicKey, priv PrivateKey) ([]byte, error) {
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	placeholder_pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	placeholder_der, err := x509.CreateCertificate(rand.Reader, template, parent, placeholder_pub, priv)
+	if err != nil {
+		return nil, err
+	}
+	placeholder, err := x509.ParseCertificate(placeholder_der)
+	if err != nil {
+		return nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	// TBSCertificate: [0] version, serial, signature, issuer, validity, subject, subjectPublicKeyInfo, ...
+	var tbs asn1.RawValue
+	if _, err := asn1.Unmarshal(placeholder.RawTBSCertificate, &tbs); err != nil {
+		return nil, err
+	}
+	var tbs_body []byte
+	rest := tbs.Bytes
+	for i := 0; len(rest) > 0; i++ {
+		var field asn1.RawValue
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, err
+		}
+		if i == 6 {
+			tbs_body = append(tbs_body, spki...)
+		} else {
+			tbs_body = append(tbs_body, field.FullBytes...)
+		}
+	}
+	raw_tbs, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: tbs_body})
+	if err != nil {
+		return nil, err
+	}
+
+	var hash crypto.Hash
+	switch placeholder.SignatureAlgorithm {
+	case x509.PureEd25519:
+	case x509.SHA256WithRSA, x509.ECDSAWithSHA256:
+		hash = crypto.SHA256
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384:
+		hash = crypto.SHA384
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512:
+		hash = crypto.SHA512
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	signed := raw_tbs
+	if hash != 0 {
+		h := hash.New()
+		h.Write(raw_tbs)
+		signed = h.Sum(nil)
+	}
+	signature, err := signer.Sign(rand.Reader, signed, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificate: tbsCertificate, signatureAlgorithm, signatureValue
+	var certificate struct {
+		TBSCertificate     asn1.RawValue
+		SignatureAlgorithm asn1.RawValue
+		SignatureValue     asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(placeholder.Raw, &certificate); err != nil {
+		return nil, err
+	}
+	certificate.TBSCertificate = asn1.RawValue{FullBytes: raw_tbs}
+	certificate.SignatureValue = asn1.BitString{Bytes: signature, BitLength: len(signature) * 8}
+	return asn1.Marshal(certificate)
+}
@@ -0,0 +1,91 @@
+package sec_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+)
+
+func TestHandshakeKeyTypes(t *testing.T) {
+	root_key, _ := sec.NewRootPrivateKey()
+	x25519_secret, err := sec.NewAbyssRootSecretsWithKeyType(root_key, sec.HandshakeKeyX25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa_secret, err := sec.NewAbyssRootSecrets(root_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x25519_secret.HandshakeKeyType() != sec.HandshakeKeyX25519 || rsa_secret.HandshakeKeyType() != sec.HandshakeKeyRSA {
+		t.Fatal("handshake key type mismatch")
+	}
+
+	for _, secret := range []*sec.AbyssRootSecret{x25519_secret, rsa_secret} {
+		view, err := sec.NewAbyssPeerIdentityFromDER(secret.RootCertificateDer(), secret.HandshakeKeyCertificateDer())
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, encrypted_secret, err := view.EncryptHandshake([]byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain, err := secret.DecryptHandshake(view.HandshakeSuite(), payload, encrypted_secret); err != nil || string(plain) != "hello" {
+			t.Fatal("decrypt failed: ", err)
+		}
+		payload[0] ^= 1
+		if _, err := secret.DecryptHandshake(view.HandshakeSuite(), payload, encrypted_secret); err == nil {
+			t.Fatal("tampered payload should fail")
+		}
+	}
+
+	x25519_view, _ := sec.NewAbyssPeerIdentityFromDER(x25519_secret.RootCertificateDer(), x25519_secret.HandshakeKeyCertificateDer())
+	if x25519_view.HandshakeSuite() == sec.HandshakeSuiteRSAOAEP {
+		t.Fatal("X25519 key should use HPKE")
+	}
+	payload, encrypted_secret, _ := x25519_view.EncryptHandshake([]byte("hello"))
+	if _, err := rsa_secret.DecryptHandshake(x25519_view.HandshakeSuite(), payload, encrypted_secret); !errors.Is(err, sec.ErrHandshakeSuiteMismatch) {
+		t.Fatal("RSA key should not accept HPKE: ", err)
+	}
+}
+
+func TestHandshakeKeyMigration(t *testing.T) {
+	root_key, _ := sec.NewRootPrivateKey()
+	secret, err := sec.NewAbyssRootSecretsWithKeyType(root_key, sec.HandshakeKeyRSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy_view, _ := sec.NewAbyssPeerIdentityFromDER(secret.RootCertificateDer(), secret.HandshakeKeyCertificateDer())
+
+	if _, err := secret.RotateHandshakeKeyTo(sec.HandshakeKeyX25519); err != nil {
+		t.Fatal(err)
+	}
+	view, err := sec.NewAbyssPeerIdentityFromDER(secret.RootCertificateDer(), secret.HandshakeKeyCertificateDer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.HandshakeKeyType() != sec.HandshakeKeyX25519 {
+		t.Fatal("handshake key type not migrated")
+	}
+
+	// peers with the legacy certificate keep dialing during the migration.
+	for _, v := range []*sec.AbyssPeerIdentity{legacy_view, view} {
+		payload, encrypted_secret, _ := v.EncryptHandshake([]byte("hello"))
+		if plain, err := secret.DecryptHandshake(v.HandshakeSuite(), payload, encrypted_secret); err != nil || string(plain) != "hello" {
+			t.Fatal("decrypt failed: ", err)
+		}
+	}
+
+	// the migrated secret survives a round trip through the keystore.
+	data, err := secret.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := sec.ParseAbyssRootSecret(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.HandshakeKeyType() != sec.HandshakeKeyX25519 || !restored.IssueTime().Equal(secret.IssueTime()) {
+		t.Fatal("restored handshake key mismatch")
+	}
+}
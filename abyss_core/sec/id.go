@@ -2,8 +2,10 @@ package sec
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
@@ -18,8 +20,9 @@ import (
 type AbyssPeerIdentity struct {
 	id                  string
 	root_self_cert_x509 *x509.Certificate
-	handshake_pub_key   *rsa.PublicKey
+	handshake_pub_key   crypto.PublicKey // *rsa.PublicKey or X25519 *ecdh.PublicKey
 	issue_time          time.Time
+	expire_time         time.Time // zero if the handshake key certificate does not expire.
 
 	root_self_cert         string
 	root_self_cert_der     []byte
@@ -77,9 +80,15 @@ func NewAbyssPeerIdentity(root_self_cert *x509.Certificate, handshake_key_cert *
 	if handshake_key_cert.Subject.CommonName != "h."+id {
 		return nil, errors.New("invalid handshake key certificate name: " + handshake_key_cert.Subject.CommonName)
 	}
-	pkey, ok := handshake_key_cert.PublicKey.(*rsa.PublicKey)
-	if !ok {
-		return nil, errors.New("unsupported public key")
+	pkey, err := handshakePublicKey(handshake_key_cert)
+	if err != nil {
+		return nil, err
+	}
+
+	// certificates without NotAfter (encoded as year 1) never expire.
+	var expire_time time.Time
+	if handshake_key_cert.NotAfter.After(handshake_key_cert.NotBefore) {
+		expire_time = handshake_key_cert.NotAfter
 	}
 
 	// re-encode der and pem. We don't re-use input values, for the sake of sanity.
@@ -114,6 +123,7 @@ func NewAbyssPeerIdentity(root_self_cert *x509.Certificate, handshake_key_cert *
 		id:                  id,
 		handshake_pub_key:   pkey,
 		issue_time:          handshake_key_cert.NotBefore,
+		expire_time:         expire_time,
 
 		root_self_cert:         root_self_cert_pem,
 		root_self_cert_der:     root_self_cert_der,
@@ -122,11 +132,25 @@ func NewAbyssPeerIdentity(root_self_cert *x509.Certificate, handshake_key_cert *
 	}, nil
 }
 
-// EncryptHandshake encrypts the payload with the handshake encryption key.
-// First, the payload is encrypted with a random AES-128 key and GCM nonce.
+// HandshakeSuite is the suite EncryptHandshake uses, which follows the handshake key type.
+func (p *AbyssPeerIdentity) HandshakeSuite() HandshakeSuite {
+	if _, ok := p.handshake_pub_key.(*ecdh.PublicKey); ok {
+		return x25519Suite()
+	}
+	return HandshakeSuiteRSAOAEP
+}
+
+// EncryptHandshake encrypts the payload with the handshake encryption key, in HandshakeSuite.
+// For X25519 keys, the payload is sealed with HPKE, and the encapsulated key is returned as the secret.
+// For RSA keys, the payload is encrypted with a random AES-256 key and GCM nonce.
 // Then, the key and nonce are encrypted with RSA OAEP.
-// The return values are encrypted payload, encrypted aes secret, and error.
+// The return values are encrypted payload, encrypted secret, and error.
 func (p *AbyssPeerIdentity) EncryptHandshake(payload []byte) ([]byte, []byte, error) {
+	rsa_pub_key, ok := p.handshake_pub_key.(*rsa.PublicKey)
+	if !ok {
+		return hpkeSeal(p.HandshakeSuite(), p.handshake_pub_key.(*ecdh.PublicKey), payload)
+	}
+
 	// Generate a random 32-byte AES-256 key
 	aesKey := make([]byte, 32)
 	_, err := rand.Read(aesKey)
@@ -154,14 +178,15 @@ func (p *AbyssPeerIdentity) EncryptHandshake(payload []byte) ([]byte, []byte, er
 
 	// Encrypt the aes secret (key and nonce) in RSA OAEP
 	aes_secret := append(aesKey, nonce...)
-	encrypted_aes_secret, err := rsa.EncryptOAEP(sha3.New256(), rand.Reader, p.handshake_pub_key, aes_secret, nil)
+	encrypted_aes_secret, err := rsa.EncryptOAEP(sha3.New256(), rand.Reader, rsa_pub_key, aes_secret, nil)
 	return encrypted_payload, encrypted_aes_secret, err
 }
 func (p *AbyssPeerIdentity) VerifyTLSBinding(tls_binding_cert *x509.Certificate, tls_cert *x509.Certificate) error {
 	if err := tls_binding_cert.CheckSignatureFrom(p.root_self_cert_x509); err != nil {
 		return err
 	}
-	if !tls_binding_cert.PublicKey.(ed25519.PublicKey).Equal(tls_cert.PublicKey) {
+	tls_public_key, ok := tls_binding_cert.PublicKey.(ed25519.PublicKey)
+	if !ok || !tls_public_key.Equal(tls_cert.PublicKey) {
 		return errors.New("invalid TLS binding key certificate; TLS public key mismatch")
 	}
 	if tls_binding_cert.Issuer.CommonName != p.id {
@@ -170,6 +195,11 @@ func (p *AbyssPeerIdentity) VerifyTLSBinding(tls_binding_cert *x509.Certificate,
 	if tls_binding_cert.Subject.CommonName != "tls."+p.id {
 		return errors.New("invalid root certificate; unrecognized name")
 	}
+	// binding certificates are renewed before expiry; allow some clock skew.
+	now := time.Now()
+	if now.Add(time.Minute).Before(tls_binding_cert.NotBefore) || now.Add(-time.Minute).After(tls_binding_cert.NotAfter) {
+		return errors.New("invalid TLS binding key certificate; expired or not yet valid")
+	}
 	return nil
 }
 
@@ -179,3 +209,4 @@ func (p *AbyssPeerIdentity) RootCertificateDer() []byte         { return p.root_
 func (p *AbyssPeerIdentity) HandshakeKeyCertificate() string    { return p.handshake_key_cert }
 func (p *AbyssPeerIdentity) HandshakeKeyCertificateDer() []byte { return p.handshake_key_cert_der }
 func (p *AbyssPeerIdentity) IssueTime() time.Time               { return p.issue_time }
+func (p *AbyssPeerIdentity) ExpireTime() time.Time              { return p.expire_time }
@@ -0,0 +1,415 @@
+package sec
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// Keystore.
+// The root private key is the identity of a user; losing it loses the identity,
+// and leaking it leaks the identity. It is stored as a PEM file.
+// Without passphrase, it is a plain PKCS#8 "PRIVATE KEY" block.
+// With passphrase, the PKCS#8 DER is sealed with AES-256-GCM, keyed by scrypt.
+// KDF parameters, salt and nonce are stored in the PEM headers,
+// and the block type is authenticated as additional data.
+//
+// A root secret bundle additionally holds the root certificate, the handshake key,
+// and its certificate, so that the peers who know the handshake key certificate
+// can keep dialing after restart.
+
+const (
+	pemTypePrivateKey          = "PRIVATE KEY"
+	pemTypeOpenSSHPrivateKey   = "OPENSSH PRIVATE KEY"
+	pemTypeEncryptedPrivateKey = "ABYSS ENCRYPTED PRIVATE KEY"
+	pemTypeHandshakePrivateKey = "ABYSS HANDSHAKE PRIVATE KEY"
+	pemTypeCertificate         = "CERTIFICATE"
+	pemTypeEncryptedRootSecret = "ABYSS ENCRYPTED ROOT SECRET"
+)
+
+var (
+	ErrPassphraseRequired  = errors.New("passphrase required")
+	ErrIncorrectPassphrase = errors.New("incorrect passphrase or corrupted key")
+	ErrUnsupportedKey      = errors.New("unsupported private key")
+)
+
+// ScryptParams is the cost of passphrase key derivation.
+// See golang.org/x/crypto/scrypt.
+type ScryptParams struct {
+	N int
+	R int
+	P int
+}
+
+// DefaultScryptParams is the recommended interactive login cost (2017).
+var DefaultScryptParams = ScryptParams{N: 1 << 15, R: 8, P: 1}
+
+// MaxScryptParams bounds the parameters read from a key file, as scrypt takes
+// 128*N*r bytes of memory and p times the time; a crafted file could exhaust both.
+// Parameters above it are neither written nor read.
+var MaxScryptParams = ScryptParams{N: 1 << 20, R: 16, P: 4}
+
+func (p ScryptParams) check() error {
+	if p.N > MaxScryptParams.N || p.R > MaxScryptParams.R || p.P > MaxScryptParams.P {
+		return errors.New("scrypt parameters too large")
+	}
+	return nil
+}
+
+// MarshalRootPrivateKey encodes the root private key as PEM.
+// If passphrase is empty, the key is not encrypted (export).
+func MarshalRootPrivateKey(root_private_key PrivateKey, passphrase []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(root_private_key)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der}), nil
+	}
+	block, err := sealPemBlock(pemTypeEncryptedPrivateKey, der, passphrase, DefaultScryptParams)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// ParseRootPrivateKey decodes the root private key (import).
+// It accepts PKCS#8, OpenSSH, and encrypted keys from MarshalRootPrivateKey.
+// passphrase is ignored for unencrypted keys.
+func ParseRootPrivateKey(pem_bytes []byte, passphrase []byte) (PrivateKey, error) {
+	block, _ := pem.Decode(pem_bytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case pemTypePrivateKey:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case pemTypeOpenSSHPrivateKey:
+		if len(passphrase) == 0 {
+			key, err = ssh.ParseRawPrivateKey(pem_bytes)
+		} else {
+			key, err = ssh.ParseRawPrivateKeyWithPassphrase(pem_bytes, passphrase)
+		}
+	case pemTypeEncryptedPrivateKey:
+		var der []byte
+		der, err = openPemBlock(block, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, errors.New("unsupported PEM block: " + block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return asRootPrivateKey(key)
+}
+
+// asRootPrivateKey rejects keys that cannot sign certificates.
+func asRootPrivateKey(key any) (PrivateKey, error) {
+	if k, ok := key.(*ed25519.PrivateKey); ok { // ssh returns a pointer.
+		key = *k
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return signer, nil
+}
+
+// SaveRootPrivateKey writes the key file with owner-only permission.
+// The file is replaced atomically.
+func SaveRootPrivateKey(path string, root_private_key PrivateKey, passphrase []byte) error {
+	data, err := MarshalRootPrivateKey(root_private_key, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func LoadRootPrivateKey(path string, passphrase []byte) (PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRootPrivateKey(data, passphrase)
+}
+
+// Marshal encodes the root secret bundle as PEM.
+// If passphrase is empty, the bundle is not encrypted.
+func (r *AbyssRootSecret) Marshal(passphrase []byte) ([]byte, error) {
+	root_key_der, err := x509.MarshalPKCS8PrivateKey(r.root_priv_key)
+	if err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	handshake_priv_key := r.handshake_priv_key
+	handshake_key_cert_der := r.handshake_key_cert_der
+	r.mtx.RUnlock()
+
+	handshake_key_der, err := x509.MarshalPKCS8PrivateKey(handshake_priv_key)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, block := range []*pem.Block{
+		{Type: pemTypePrivateKey, Bytes: root_key_der},
+		{Type: pemTypeCertificate, Bytes: r.root_self_cert_der},
+		{Type: pemTypeHandshakePrivateKey, Bytes: handshake_key_der},
+		{Type: pemTypeCertificate, Bytes: handshake_key_cert_der},
+	} {
+		if err := pem.Encode(&buf, block); err != nil {
+			return nil, err
+		}
+	}
+	if len(passphrase) == 0 {
+		return buf.Bytes(), nil
+	}
+	block, err := sealPemBlock(pemTypeEncryptedRootSecret, buf.Bytes(), passphrase, DefaultScryptParams)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// ParseAbyssRootSecret decodes a root secret bundle from Marshal.
+// A bare root private key (any format of ParseRootPrivateKey) is also accepted.
+// The handshake key is preserved if present and valid for the root key;
+// otherwise, a new handshake key is generated, as NewAbyssRootSecrets does.
+func ParseAbyssRootSecret(data []byte, passphrase []byte) (*AbyssRootSecret, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if block.Type == pemTypeEncryptedRootSecret {
+		var err error
+		if data, err = openPemBlock(block, passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	root_private_key, err := ParseRootPrivateKey(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	// the remaining blocks, in order: root certificate, handshake key, handshake key certificate.
+	var blocks []*pem.Block
+	for rest := data; ; {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 4 &&
+		blocks[1].Type == pemTypeCertificate &&
+		blocks[2].Type == pemTypeHandshakePrivateKey &&
+		blocks[3].Type == pemTypeCertificate {
+		secret, err := restoreAbyssRootSecret(root_private_key, blocks[1].Bytes, blocks[2].Bytes, blocks[3].Bytes)
+		if err == nil {
+			return secret, nil
+		}
+	}
+	return NewAbyssRootSecrets(root_private_key)
+}
+
+// restoreAbyssRootSecret verifies that every part belongs to the root key.
+func restoreAbyssRootSecret(root_private_key PrivateKey, root_cert_der []byte, handshake_key_der []byte, handshake_cert_der []byte) (*AbyssRootSecret, error) {
+	id, err := abyssIDFromKey(root_private_key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	root_cert, err := x509.ParseCertificate(root_cert_der)
+	if err != nil {
+		return nil, err
+	}
+	if cert_id, err := abyssIDFromKey(root_cert.PublicKey); err != nil || cert_id != id {
+		return nil, errors.New("root certificate does not match the root key")
+	}
+
+	handshake_key_any, err := x509.ParsePKCS8PrivateKey(handshake_key_der)
+	if err != nil {
+		return nil, err
+	}
+	var handshake_public_key interface{ Equal(crypto.PublicKey) bool }
+	switch key := handshake_key_any.(type) {
+	case *rsa.PrivateKey:
+		handshake_public_key = &key.PublicKey
+	case *ecdh.PrivateKey:
+		handshake_public_key = key.PublicKey()
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	handshake_cert, err := x509.ParseCertificate(handshake_cert_der)
+	if err != nil {
+		return nil, err
+	}
+	handshake_cert_public_key, err := handshakePublicKey(handshake_cert)
+	if err != nil {
+		return nil, err
+	}
+	if !handshake_public_key.Equal(handshake_cert_public_key) {
+		return nil, errors.New("handshake key certificate does not match the handshake key")
+	}
+
+	// reuse identity validation of peers.
+	if _, err := NewAbyssPeerIdentity(root_cert, handshake_cert); err != nil {
+		return nil, err
+	}
+
+	return &AbyssRootSecret{
+		root_priv_key: root_private_key,
+
+		id:                  id,
+		root_self_cert:      string(pem.EncodeToMemory(&pem.Block{Type: pemTypeCertificate, Bytes: root_cert_der})),
+		root_self_cert_der:  root_cert_der,
+		root_self_cert_x509: root_cert,
+
+		handshake_priv_key: handshake_key_any,
+		issue_time:         handshake_cert.NotBefore,
+
+		handshake_key_cert:     string(pem.EncodeToMemory(&pem.Block{Type: pemTypeCertificate, Bytes: handshake_cert_der})),
+		handshake_key_cert_der: handshake_cert_der,
+	}, nil
+}
+
+// Save writes the root secret bundle with owner-only permission.
+// The file is replaced atomically.
+func (r *AbyssRootSecret) Save(path string, passphrase []byte) error {
+	data, err := r.Marshal(passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func LoadAbyssRootSecret(path string, passphrase []byte) (*AbyssRootSecret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAbyssRootSecret(data, passphrase)
+}
+
+func sealPemBlock(block_type string, plaintext []byte, passphrase []byte, params ScryptParams) (*pem.Block, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newPassphraseAEAD(passphrase, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &pem.Block{
+		Type: block_type,
+		Headers: map[string]string{
+			"KDF":        "scrypt",
+			"KDF-Params": fmt.Sprintf("N=%d,r=%d,p=%d", params.N, params.R, params.P),
+			"Salt":       hex.EncodeToString(salt),
+			"Cipher":     "AES-256-GCM",
+			"Nonce":      hex.EncodeToString(nonce),
+		},
+		Bytes: aead.Seal(nil, nonce, plaintext, []byte(block_type)),
+	}, nil
+}
+
+func openPemBlock(block *pem.Block, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	if block.Headers["KDF"] != "scrypt" || block.Headers["Cipher"] != "AES-256-GCM" {
+		return nil, errors.New("unsupported key encryption")
+	}
+	var params ScryptParams
+	if _, err := fmt.Sscanf(block.Headers["KDF-Params"], "N=%d,r=%d,p=%d", &params.N, &params.R, &params.P); err != nil {
+		return nil, err
+	}
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(block.Headers["Nonce"])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newPassphraseAEAD(passphrase, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, nonce, block.Bytes, []byte(block.Type))
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+	return plaintext, nil
+}
+
+func newPassphraseAEAD(passphrase []byte, salt []byte, params ScryptParams) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if err = file.Chmod(0o600); err == nil {
+		if _, err = file.Write(data); err == nil {
+			err = file.Sync()
+		}
+	}
+	if c_err := file.Close(); err == nil {
+		err = c_err
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package sec_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRootPrivateKeyStore(t *testing.T) {
+	root_key, err := sec.NewRootPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "root.pem")
+	passphrase := []byte("correct horse battery staple")
+
+	if err := sec.SaveRootPrivateKey(path, root_key, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+			t.Fatal("key file should be owner-only: ", info.Mode())
+		}
+	}
+	if _, err := sec.LoadRootPrivateKey(path, nil); !errors.Is(err, sec.ErrPassphraseRequired) {
+		t.Fatal("encrypted key should require passphrase: ", err)
+	}
+	if _, err := sec.LoadRootPrivateKey(path, []byte("wrong")); !errors.Is(err, sec.ErrIncorrectPassphrase) {
+		t.Fatal("wrong passphrase should fail: ", err)
+	}
+	loaded, err := sec.LoadRootPrivateKey(path, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !root_key.(ed25519.PrivateKey).Equal(loaded) {
+		t.Fatal("loaded key mismatch")
+	}
+
+	// export and import
+	exported, err := sec.MarshalRootPrivateKey(root_key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := sec.ParseRootPrivateKey(exported, nil)
+	if err != nil || !root_key.(ed25519.PrivateKey).Equal(imported) {
+		t.Fatal("PKCS#8 import failed: ", err)
+	}
+
+	// OpenSSH keys, as native_dll used to take.
+	ssh_block, err := ssh.MarshalPrivateKey(root_key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err = sec.ParseRootPrivateKey(pem.EncodeToMemory(ssh_block), nil)
+	if err != nil || !root_key.(ed25519.PrivateKey).Equal(imported) {
+		t.Fatal("OpenSSH import failed: ", err)
+	}
+}
+
+func TestRootSecretStore(t *testing.T) {
+	_, root_key, _ := ed25519.GenerateKey(rand.Reader)
+	secret, err := sec.NewAbyssRootSecrets(root_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "secret.pem")
+	passphrase := []byte("passphrase")
+	if err := secret.Save(path, passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := sec.LoadAbyssRootSecret(path, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID() != secret.ID() ||
+		restored.RootCertificate() != secret.RootCertificate() ||
+		restored.HandshakeKeyCertificate() != secret.HandshakeKeyCertificate() {
+		t.Fatal("restored root secret mismatch")
+	}
+
+	// the restored handshake key decrypts for peers that know the old certificate.
+	peer_view, err := sec.NewAbyssPeerIdentityFromPEM(secret.RootCertificate(), secret.HandshakeKeyCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.IssueTime().Equal(peer_view.IssueTime()) {
+		t.Fatal("restored issue time mismatch")
+	}
+	encrypted, encrypted_secret, err := peer_view.EncryptHandshake([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := restored.DecryptHandshake(peer_view.HandshakeSuite(), encrypted, encrypted_secret); err != nil || string(plain) != "hello" {
+		t.Fatal("restored handshake key cannot decrypt: ", err)
+	}
+
+	// a bare root key yields a new handshake key with the same id.
+	key_only, _ := sec.MarshalRootPrivateKey(root_key, nil)
+	renewed, err := sec.ParseAbyssRootSecret(key_only, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.ID() != secret.ID() || renewed.HandshakeKeyCertificate() == secret.HandshakeKeyCertificate() {
+		t.Fatal("bare root key should keep the id and renew the handshake key")
+	}
+}
+
+func TestScryptParamsLimit(t *testing.T) {
+	root_key, err := sec.NewRootPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase := []byte("correct horse battery staple")
+	sealed, err := sec.MarshalRootPrivateKey(root_key, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crafted file must fail before scrypt allocates 128 GiB.
+	block, _ := pem.Decode(sealed)
+	block.Headers["KDF-Params"] = "N=1073741824,r=8,p=1"
+	if _, err := sec.ParseRootPrivateKey(pem.EncodeToMemory(block), passphrase); err == nil {
+		t.Fatal("oversized scrypt parameters should be rejected")
+	}
+	block.Headers["KDF-Params"] = "N=32768,r=8,p=64"
+	if _, err := sec.ParseRootPrivateKey(pem.EncodeToMemory(block), passphrase); err == nil {
+		t.Fatal("oversized scrypt parameters should be rejected")
+	}
+}
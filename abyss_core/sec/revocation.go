@@ -0,0 +1,135 @@
+package sec
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var ErrRevokeCurrentHandshakeKey = errors.New("cannot revoke the current handshake key; rotate first")
+
+// HandshakeKeyRevocation is a root-signed statement that a handshake key certificate
+// must not be used anymore. The certificate is identified by HashHandshakeKeyCertificate.
+type HandshakeKeyRevocation struct {
+	ID              string
+	CertificateHash [32]byte
+	RevokeTime      time.Time
+	Signature       []byte
+}
+
+// HashHandshakeKeyCertificate is SHA3-256 digest of the certificate DER.
+func HashHandshakeKeyCertificate(handshake_key_cert_der []byte) [32]byte {
+	return sha3.Sum256(handshake_key_cert_der)
+}
+
+// signedBytes is the message the root key signs.
+func (v *HandshakeKeyRevocation) signedBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("abyss handshake key revocation\x00")
+	buf.WriteString(v.ID)
+	buf.WriteByte(0)
+	buf.Write(v.CertificateHash[:])
+	binary.Write(&buf, binary.BigEndian, v.RevokeTime.Unix())
+	return buf.Bytes()
+}
+
+// revocationSignatureAlgorithm follows the root key type.
+func revocationSignatureAlgorithm(algorithm x509.PublicKeyAlgorithm) (x509.SignatureAlgorithm, crypto.Hash, error) {
+	switch algorithm {
+	case x509.Ed25519:
+		return x509.PureEd25519, crypto.Hash(0), nil
+	case x509.ECDSA:
+		return x509.ECDSAWithSHA256, crypto.SHA256, nil
+	case x509.RSA:
+		return x509.SHA256WithRSA, crypto.SHA256, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, 0, ErrUnsupportedKey
+	}
+}
+
+// RotateHandshakeKey issues a new handshake key of the current type, which is newer than the current one.
+// The current key is kept as the previous key, so that handshakes from peers
+// that have not received the new certificate still succeed.
+// It returns the new handshake key certificate (der).
+func (r *AbyssRootSecret) RotateHandshakeKey() ([]byte, error) {
+	return r.RotateHandshakeKeyTo(r.HandshakeKeyType())
+}
+
+// RotateHandshakeKeyTo is RotateHandshakeKey, with the key type of choice.
+// Migrating from RSA to X25519, peers without HPKE support keep dialing with the previous RSA key.
+func (r *AbyssRootSecret) RotateHandshakeKeyTo(key_type HandshakeKeyType) ([]byte, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	handshake_priv_key, handshake_key_cert_der, issue_time, err := r.issueHandshakeKey(key_type, r.issue_time)
+	if err != nil {
+		return nil, err
+	}
+	r.prev_handshake_priv_key = r.handshake_priv_key
+	r.prev_handshake_key_cert_der = r.handshake_key_cert_der
+
+	r.handshake_priv_key = handshake_priv_key
+	r.issue_time = issue_time
+	r.handshake_key_cert = encodeCertificatePem(handshake_key_cert_der)
+	r.handshake_key_cert_der = handshake_key_cert_der
+	return handshake_key_cert_der, nil
+}
+
+// RevokeHandshakeKey signs a revocation for a handshake key certificate issued by this root.
+// If it is the previous key, the key is dropped and no longer decrypts handshakes.
+// The current key cannot be revoked; call RotateHandshakeKey first.
+func (r *AbyssRootSecret) RevokeHandshakeKey(handshake_key_cert_der []byte) (*HandshakeKeyRevocation, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if bytes.Equal(handshake_key_cert_der, r.handshake_key_cert_der) {
+		return nil, ErrRevokeCurrentHandshakeKey
+	}
+	_, hash, err := revocationSignatureAlgorithm(r.root_self_cert_x509.PublicKeyAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := r.root_priv_key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	result := &HandshakeKeyRevocation{
+		ID:              r.id,
+		CertificateHash: HashHandshakeKeyCertificate(handshake_key_cert_der),
+		RevokeTime:      time.Now().Truncate(time.Second),
+	}
+	message := result.signedBytes()
+	if hash != 0 {
+		digest := sha256.Sum256(message)
+		message = digest[:]
+	}
+	result.Signature, err = signer.Sign(rand.Reader, message, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(handshake_key_cert_der, r.prev_handshake_key_cert_der) {
+		r.prev_handshake_priv_key = nil
+		r.prev_handshake_key_cert_der = nil
+	}
+	return result, nil
+}
+
+// VerifyHandshakeKeyRevocation checks that the revocation is signed by the peer's root key.
+func (p *AbyssPeerIdentity) VerifyHandshakeKeyRevocation(revocation *HandshakeKeyRevocation) error {
+	if revocation.ID != p.id {
+		return errors.New("invalid handshake key revocation; id mismatch")
+	}
+	signature_algorithm, _, err := revocationSignatureAlgorithm(p.root_self_cert_x509.PublicKeyAlgorithm)
+	if err != nil {
+		return err
+	}
+	return p.root_self_cert_x509.CheckSignature(signature_algorithm, revocation.signedBytes(), revocation.Signature)
+}
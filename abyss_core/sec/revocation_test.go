@@ -0,0 +1,123 @@
+package sec_test
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/kadmila/Abyss-Browser/abyss_core/sec"
+)
+
+func newRootSecret(t *testing.T) *sec.AbyssRootSecret {
+	root_key, err := sec.NewRootPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := sec.NewAbyssRootSecrets(root_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestHandshakeKeyRotation(t *testing.T) {
+	secret := newRootSecret(t)
+	old_view, err := sec.NewAbyssPeerIdentityFromDER(secret.RootCertificateDer(), secret.HandshakeKeyCertificateDer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	new_cert, err := secret.RotateHandshakeKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(new_cert, secret.HandshakeKeyCertificateDer()) {
+		t.Fatal("rotated certificate is not current")
+	}
+	new_view, err := sec.NewAbyssPeerIdentityFromDER(secret.RootCertificateDer(), new_cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !new_view.IssueTime().After(old_view.IssueTime()) {
+		t.Fatal("rotated key must be newer")
+	}
+
+	// both keys decrypt, until the old one is revoked.
+	for _, view := range []*sec.AbyssPeerIdentity{old_view, new_view} {
+		payload, aes_secret, err := view.EncryptHandshake([]byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain, err := secret.DecryptHandshake(view.HandshakeSuite(), payload, aes_secret); err != nil || string(plain) != "hello" {
+			t.Fatal("decrypt failed: ", err)
+		}
+	}
+
+	if _, err := secret.RevokeHandshakeKey(new_cert); !errors.Is(err, sec.ErrRevokeCurrentHandshakeKey) {
+		t.Fatal("current key should not be revocable: ", err)
+	}
+	revocation, err := secret.RevokeHandshakeKey(old_view.HandshakeKeyCertificateDer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, aes_secret, _ := old_view.EncryptHandshake([]byte("hello"))
+	if _, err := secret.DecryptHandshake(old_view.HandshakeSuite(), payload, aes_secret); err == nil {
+		t.Fatal("revoked key should not decrypt")
+	}
+
+	// revocation verification
+	if revocation.CertificateHash != sec.HashHandshakeKeyCertificate(old_view.HandshakeKeyCertificateDer()) {
+		t.Fatal("revocation hash mismatch")
+	}
+	if err := new_view.VerifyHandshakeKeyRevocation(revocation); err != nil {
+		t.Fatal(err)
+	}
+	tampered := *revocation
+	tampered.CertificateHash = sec.HashHandshakeKeyCertificate(new_cert)
+	if err := new_view.VerifyHandshakeKeyRevocation(&tampered); err == nil {
+		t.Fatal("tampered revocation should fail")
+	}
+	other := newRootSecret(t)
+	other_view, _ := sec.NewAbyssPeerIdentityFromDER(other.RootCertificateDer(), other.HandshakeKeyCertificateDer())
+	forged, err := other.RevokeHandshakeKey(old_view.HandshakeKeyCertificateDer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.ID = secret.ID()
+	if err := new_view.VerifyHandshakeKeyRevocation(forged); err == nil {
+		t.Fatal("revocation signed by another root should fail")
+	}
+	if err := other_view.VerifyHandshakeKeyRevocation(revocation); err == nil {
+		t.Fatal("revocation for another id should fail")
+	}
+}
+
+func TestTLSIdentityRenewal(t *testing.T) {
+	secret := newRootSecret(t)
+	view, _ := sec.NewAbyssPeerIdentityFromDER(secret.RootCertificateDer(), secret.HandshakeKeyCertificateDer())
+	tls_identity, err := secret.NewTLSIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old_tls_cert, _ := x509.ParseCertificate(tls_identity.TLSCertificate())
+	old_not_after := tls_identity.TLSNotAfter()
+
+	if err := secret.RenewTLSIdentity(tls_identity); err != nil {
+		t.Fatal(err)
+	}
+	tls_cert, _ := x509.ParseCertificate(tls_identity.TLSCertificate())
+	binding_cert, _ := x509.ParseCertificate(tls_identity.AbyssBindingCertificate())
+	if bytes.Equal(old_tls_cert.Raw, tls_cert.Raw) || tls_identity.TLSNotAfter().Before(old_not_after) {
+		t.Fatal("certificate not renewed")
+	}
+	if sec.HashTlsCertificate(old_tls_cert) != sec.HashTlsCertificate(tls_cert) {
+		t.Fatal("renewed certificate should keep the key")
+	}
+	if err := view.VerifyTLSBinding(binding_cert, tls_cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.VerifyTLSBinding(binding_cert, old_tls_cert); err != nil {
+		t.Fatal("binding should hold for the old certificate of the same key: ", err)
+	}
+}
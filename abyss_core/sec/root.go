@@ -5,6 +5,7 @@ import (
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
@@ -12,7 +13,9 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"math/big"
+	"sync"
 	"time"
 )
 
@@ -38,14 +41,27 @@ type AbyssRootSecret struct {
 	root_self_cert_der  []byte
 	root_self_cert_x509 *x509.Certificate
 
-	handshake_priv_key *rsa.PrivateKey //may support others in future
-	issue_time         time.Time       //handshake encryption key issue time
+	// mtx protects the handshake key, which is rotated with RotateHandshakeKey.
+	mtx sync.RWMutex
+
+	handshake_priv_key crypto.PrivateKey // *rsa.PrivateKey or X25519 *ecdh.PrivateKey
+	issue_time         time.Time         //handshake encryption key issue time
 
 	handshake_key_cert     string //pem
 	handshake_key_cert_der []byte
+
+	// the previous handshake key is kept for peers that have not heard of the rotation.
+	// nil if there was no rotation, or the previous key is revoked.
+	prev_handshake_priv_key     crypto.PrivateKey
+	prev_handshake_key_cert_der []byte
 }
 
+// NewAbyssRootSecrets issues an RSA handshake key, which every peer can dial.
 func NewAbyssRootSecrets(root_private_key PrivateKey) (*AbyssRootSecret, error) {
+	return NewAbyssRootSecretsWithKeyType(root_private_key, HandshakeKeyRSA)
+}
+
+func NewAbyssRootSecretsWithKeyType(root_private_key PrivateKey, key_type HandshakeKeyType) (*AbyssRootSecret, error) {
 	root_public_key := root_private_key.Public()
 
 	//root certificate
@@ -88,78 +104,133 @@ func NewAbyssRootSecrets(root_private_key PrivateKey) (*AbyssRootSecret, error)
 		return nil, err
 	}
 
+	result := &AbyssRootSecret{
+		root_priv_key: root_private_key,
+
+		id:                  id,
+		root_self_cert:      r_pem_buf.String(),
+		root_self_cert_der:  r_derBytes,
+		root_self_cert_x509: r_x509,
+	}
+
 	//handshake key
-	handshake_private_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	result.handshake_priv_key, result.handshake_key_cert_der, result.issue_time, err = result.issueHandshakeKey(key_type, time.Time{})
 	if err != nil {
 		return nil, err
 	}
-	serialNumber, err = rand.Int(rand.Reader, serialNumberLimit)
+	result.handshake_key_cert = encodeCertificatePem(result.handshake_key_cert_der)
+	return result, nil
+}
+
+// issueHandshakeKey creates a handshake key and its certificate.
+// The issue time is strictly after not_after_than, as
+// certificate time has second precision and peers keep the latest one.
+func (r *AbyssRootSecret) issueHandshakeKey(key_type HandshakeKeyType, not_after_than time.Time) (crypto.PrivateKey, []byte, time.Time, error) {
+	var handshake_private_key crypto.PrivateKey
+	var handshake_public_key crypto.PublicKey
+	var key_usage x509.KeyUsage
+	switch key_type {
+	case HandshakeKeyX25519:
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, time.Time{}, err
+		}
+		handshake_private_key, handshake_public_key, key_usage = key, key.PublicKey(), x509.KeyUsageKeyAgreement
+	case HandshakeKeyRSA:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, time.Time{}, err
+		}
+		handshake_private_key, handshake_public_key, key_usage = key, &key.PublicKey, x509.KeyUsageEncipherOnly
+	default:
+		return nil, nil, time.Time{}, errors.New("unsupported handshake key type")
+	}
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128) // 2^128
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
 	if err != nil {
-		return nil, err
+		return nil, nil, time.Time{}, err
+	}
+	issue_time := time.Now().Add(time.Duration(-1) * time.Second).Truncate(time.Second) //1-sec backdate, for badly synced peers.
+	if !issue_time.After(not_after_than) {
+		issue_time = not_after_than.Truncate(time.Second).Add(time.Second)
 	}
-	issue_time := time.Now().Add(time.Duration(-1) * time.Second) //1-sec backdate, for badly synced peers.
 	h_template := x509.Certificate{
 		Issuer: pkix.Name{
-			CommonName: id,
+			CommonName: r.id,
 		},
 		Subject: pkix.Name{
-			CommonName: "h." + id,
+			CommonName: "h." + r.id,
 		},
 		NotBefore:             issue_time,
 		SerialNumber:          serialNumber,
-		KeyUsage:              x509.KeyUsageEncipherOnly,
+		KeyUsage:              key_usage,
 		BasicConstraintsValid: true,
 	}
-	h_derBytes, err := x509.CreateCertificate(rand.Reader, &h_template, &r_template, &handshake_private_key.PublicKey, root_private_key)
-	if err != nil {
-		return nil, err
+	var h_derBytes []byte
+	if x25519_public_key, ok := handshake_public_key.(*ecdh.PublicKey); ok {
+		h_derBytes, err = createX25519Certificate(&h_template, r.root_self_cert_x509, x25519_public_key, r.root_priv_key)
+	} else {
+		h_derBytes, err = x509.CreateCertificate(rand.Reader, &h_template, r.root_self_cert_x509, handshake_public_key, r.root_priv_key)
 	}
-	var h_pem_buf bytes.Buffer
-	err = pem.Encode(&h_pem_buf, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: h_derBytes,
-	})
 	if err != nil {
-		return nil, err
+		return nil, nil, time.Time{}, err
 	}
+	return handshake_private_key, h_derBytes, issue_time, nil
+}
 
-	// { // debug
-	// 	err = r_x509.CheckSignatureFrom(r_x509)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	h_cert, err := x509.ParseCertificate(h_derBytes)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	err = h_cert.CheckSignatureFrom(r_x509)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// }
-
-	return &AbyssRootSecret{
-		root_priv_key: root_private_key,
+func encodeCertificatePem(der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	}))
+}
 
-		id:                  id,
-		root_self_cert:      r_pem_buf.String(),
-		root_self_cert_der:  r_derBytes,
-		root_self_cert_x509: r_x509,
+// DecryptHandshake tries the current handshake key, and then the previous one.
+// Only keys of the suite's type are tried, so that a node keeps accepting
+// the legacy suite while migrating to X25519 with RotateHandshakeKeyTo.
+func (r *AbyssRootSecret) DecryptHandshake(suite HandshakeSuite, encrypted_payload, encrypted_secret []byte) ([]byte, error) {
+	r.mtx.RLock()
+	handshake_priv_key := r.handshake_priv_key
+	prev_handshake_priv_key := r.prev_handshake_priv_key
+	r.mtx.RUnlock()
 
-		handshake_priv_key: handshake_private_key,
-		issue_time:         issue_time,
+	payload, err := decryptHandshake(handshake_priv_key, suite, encrypted_payload, encrypted_secret)
+	if err == nil || prev_handshake_priv_key == nil {
+		return payload, err
+	}
+	payload, prev_err := decryptHandshake(prev_handshake_priv_key, suite, encrypted_payload, encrypted_secret)
+	if prev_err != nil && !errors.Is(err, ErrHandshakeSuiteMismatch) {
+		return nil, err // the suite matched the current key; report its error.
+	}
+	return payload, prev_err
+}
 
-		handshake_key_cert:     h_pem_buf.String(),
-		handshake_key_cert_der: h_derBytes,
-	}, nil
+func decryptHandshake(handshake_priv_key crypto.PrivateKey, suite HandshakeSuite, encrypted_payload, encrypted_secret []byte) ([]byte, error) {
+	switch key := handshake_priv_key.(type) {
+	case *ecdh.PrivateKey:
+		if suite == HandshakeSuiteRSAOAEP {
+			return nil, ErrHandshakeSuiteMismatch
+		}
+		return hpkeOpen(suite, key, encrypted_payload, encrypted_secret)
+	case *rsa.PrivateKey:
+		if suite != HandshakeSuiteRSAOAEP {
+			return nil, ErrHandshakeSuiteMismatch
+		}
+		return decryptHandshakeRSA(key, encrypted_payload, encrypted_secret)
+	default:
+		return nil, ErrHandshakeSuiteMismatch
+	}
 }
 
-func (r *AbyssRootSecret) DecryptHandshake(encrypted_payload, encrypted_aes_secret []byte) ([]byte, error) {
+func decryptHandshakeRSA(handshake_priv_key *rsa.PrivateKey, encrypted_payload, encrypted_aes_secret []byte) ([]byte, error) {
 	// decrypt AES-GCM secret
-	aes_secret, err := rsa.DecryptOAEP(sha3.New256(), nil, r.handshake_priv_key, encrypted_aes_secret, nil)
+	aes_secret, err := rsa.DecryptOAEP(sha3.New256(), nil, handshake_priv_key, encrypted_aes_secret, nil)
 	if err != nil {
 		return nil, err
 	}
+	if len(aes_secret) != 32+12 {
+		return nil, errors.New("invalid handshake secret")
+	}
 	aes_key := aes_secret[:32]
 	aes_nonce := aes_secret[32:]
 	// construct AES-GCM decryptor
@@ -176,15 +247,28 @@ func (r *AbyssRootSecret) DecryptHandshake(encrypted_payload, encrypted_aes_secr
 }
 
 func (r *AbyssRootSecret) NewTLSIdentity() (*TLSIdentity, error) {
-	tls_public_key, tls_private_key, err := ed25519.GenerateKey(rand.Reader)
+	_, tls_private_key, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return nil, err
 	}
+	result := &TLSIdentity{priv_key: tls_private_key}
+	if err := r.RenewTLSIdentity(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RenewTLSIdentity re-issues the TLS certificate and the abyss binding certificate
+// of the TLS identity, for another 7 days, with the same key.
+func (r *AbyssRootSecret) RenewTLSIdentity(t *TLSIdentity) error {
+	tls_private_key := t.priv_key.(ed25519.PrivateKey)
+	tls_public_key := tls_private_key.Public()
+	not_after := time.Now().Add(7 * 24 * time.Hour) // Valid for 7 days
 
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128) // 2^128
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// memo: this is a problem with golang' crypto library.
 	// We can't use CheckSignatureFrom() function to verify non-CA self-signed certficate.
@@ -193,7 +277,7 @@ func (r *AbyssRootSecret) NewTLSIdentity() (*TLSIdentity, error) {
 	tls_self_template := x509.Certificate{
 		// no name
 		NotBefore:             time.Now().Add(time.Duration(-1) * time.Second), //1-sec backdate, for badly synced peers.
-		NotAfter:              time.Now().Add(7 * 24 * time.Hour),              // Valid for 7 days
+		NotAfter:              not_after,
 		SerialNumber:          serialNumber,
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
@@ -202,12 +286,12 @@ func (r *AbyssRootSecret) NewTLSIdentity() (*TLSIdentity, error) {
 	}
 	tls_self_derBytes, err := x509.CreateCertificate(rand.Reader, &tls_self_template, &tls_self_template, tls_public_key, tls_private_key)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	serialNumber, err = rand.Int(rand.Reader, serialNumberLimit)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	bind_template := x509.Certificate{
 		Issuer: pkix.Name{
@@ -217,7 +301,7 @@ func (r *AbyssRootSecret) NewTLSIdentity() (*TLSIdentity, error) {
 			CommonName: "tls." + r.id,
 		},
 		NotBefore:             time.Now().Add(time.Duration(-1) * time.Second), //1-sec backdate, for badly synced peers.
-		NotAfter:              time.Now().Add(7 * 24 * time.Hour),              // Valid for 7 days
+		NotAfter:              not_after,
 		SerialNumber:          serialNumber,
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
@@ -226,38 +310,42 @@ func (r *AbyssRootSecret) NewTLSIdentity() (*TLSIdentity, error) {
 	}
 	bind_derBytes, err := x509.CreateCertificate(rand.Reader, &bind_template, r.root_self_cert_x509, tls_public_key, r.root_priv_key)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// { // debug
-	// 	tls_cert, err := x509.ParseCertificate(tls_self_derBytes)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	err = tls_cert.CheckSignatureFrom(tls_cert)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	bind_cert, err := x509.ParseCertificate(bind_derBytes)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	err = bind_cert.CheckSignatureFrom(r.root_self_cert_x509)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// }
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
 
-	return &TLSIdentity{
-		priv_key:        tls_private_key,
-		tls_self_cert:   tls_self_derBytes,
-		abyss_bind_cert: bind_derBytes,
-	}, nil
+	t.tls_self_cert = tls_self_derBytes
+	t.abyss_bind_cert = bind_derBytes
+	t.not_after = not_after
+	return nil
 }
 
-func (r *AbyssRootSecret) ID() string                         { return r.id }
-func (r *AbyssRootSecret) RootCertificate() string            { return r.root_self_cert }
-func (r *AbyssRootSecret) RootCertificateDer() []byte         { return r.root_self_cert_der }
-func (r *AbyssRootSecret) HandshakeKeyCertificate() string    { return r.handshake_key_cert }
-func (r *AbyssRootSecret) HandshakeKeyCertificateDer() []byte { return r.handshake_key_cert_der }
-func (r *AbyssRootSecret) IssueTime() time.Time               { return r.issue_time }
+func (r *AbyssRootSecret) ID() string                 { return r.id }
+func (r *AbyssRootSecret) RootCertificate() string    { return r.root_self_cert }
+func (r *AbyssRootSecret) RootCertificateDer() []byte { return r.root_self_cert_der }
+
+func (r *AbyssRootSecret) HandshakeKeyCertificate() string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.handshake_key_cert
+}
+func (r *AbyssRootSecret) HandshakeKeyCertificateDer() []byte {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.handshake_key_cert_der
+}
+func (r *AbyssRootSecret) IssueTime() time.Time {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.issue_time
+}
+func (r *AbyssRootSecret) HandshakeKeyType() HandshakeKeyType {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	if _, ok := r.handshake_priv_key.(*rsa.PrivateKey); ok {
+		return HandshakeKeyRSA
+	}
+	return HandshakeKeyX25519
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/ed25519"
 	crypto_rand "crypto/rand"
+	"errors"
 	"fmt"
 	"math/rand"
 	"strconv"
@@ -14,6 +15,7 @@ import (
 	"github.com/kadmila/Abyss-Browser/abyss_core/aurl"
 	abyss_host "github.com/kadmila/Abyss-Browser/abyss_core/host"
 	abyss "github.com/kadmila/Abyss-Browser/abyss_core/interfaces"
+	abyss_net "github.com/kadmila/Abyss-Browser/abyss_core/net_service"
 	"github.com/kadmila/Abyss-Browser/abyss_core/tools/functional"
 
 	"github.com/google/uuid"
@@ -456,3 +458,114 @@ func TestKnownPeerUpdate(t *testing.T) {
 
 	<-time.After(time.Second * 5)
 }
+
+func TestUntrustedIntroduction(t *testing.T) {
+	_, A_privkey, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	_, B_privkey, _ := ed25519.GenerateKey(crypto_rand.Reader)
+	_, C_privkey, _ := ed25519.GenerateKey(crypto_rand.Reader)
+
+	A_host, _, _ := abyss_host.NewBetaAbyssHost(context.Background(), &A_privkey, nil)
+	B_host, B_pathmap, _ := abyss_host.NewBetaAbyssHost(context.Background(), &B_privkey, nil)
+	C_host, _, _ := abyss_host.NewBetaAbyssHost(context.Background(), &C_privkey, nil)
+
+	// A holds peers introduced by world members.
+	type heldEvent struct{ id, introducer string }
+	held_ch := make(chan heldEvent, 4)
+	A_net := A_host.NetworkService.(*abyss_net.BetaNetService)
+	A_net.SetTrustPolicy(abyss_net.TrustPolicy{
+		ConfirmIntroduced: true,
+		OnEvent: func(event abyss_net.TrustEvent) {
+			if event.Outcome == abyss_net.TrustHeld {
+				held_ch <- heldEvent{event.ID, event.Origin.Introducer}
+			}
+		},
+	})
+
+	go A_host.ListenAndServe(context.Background())
+	go B_host.ListenAndServe(context.Background())
+	go C_host.ListenAndServe(context.Background())
+
+	A_host.NetworkService.AppendKnownPeer(
+		B_host.NetworkService.LocalIdentity().RootCertificate(),
+		B_host.NetworkService.LocalIdentity().HandshakeKeyCertificate(),
+	)
+	B_host.NetworkService.AppendKnownPeer(
+		A_host.NetworkService.LocalIdentity().RootCertificate(),
+		A_host.NetworkService.LocalIdentity().HandshakeKeyCertificate(),
+	)
+	B_host.NetworkService.AppendKnownPeer(
+		C_host.NetworkService.LocalIdentity().RootCertificate(),
+		C_host.NetworkService.LocalIdentity().HandshakeKeyCertificate(),
+	)
+	C_host.NetworkService.AppendKnownPeer(
+		B_host.NetworkService.LocalIdentity().RootCertificate(),
+		B_host.NetworkService.LocalIdentity().HandshakeKeyCertificate(),
+	)
+
+	B_world, _ := B_host.OpenWorld("http://b.world.com")
+	B_pathmap.TrySetMapping("/home", B_world.SessionID())
+	world_aurl := B_host.GetLocalAbyssURL()
+	world_aurl.Path = "/home"
+
+	B_host.OpenOutboundConnection(A_host.GetLocalAbyssURL())
+	B_host.OpenOutboundConnection(C_host.GetLocalAbyssURL())
+
+	A_joined := make(chan abyss.IAbyssWorld, 1)
+	go func() {
+		world, _ := A_host.JoinWorld(context.Background(), world_aurl)
+		ev_ch := world.GetEventChannel()
+		(<-ev_ch).(abyss.EWorldMemberRequest).Accept()
+		assert((<-ev_ch).(abyss.EWorldMemberReady).Member.Hash() == B_host.GetLocalAbyssURL().Hash)
+		A_joined <- world
+	}()
+	go func() {
+		ev_ch := B_world.GetEventChannel()
+		for range 2 {
+			(<-ev_ch).(abyss.EWorldMemberRequest).Accept()
+			_ = (<-ev_ch).(abyss.EWorldMemberReady)
+		}
+	}()
+
+	var A_world abyss.IAbyssWorld
+	select {
+	case A_world = <-A_joined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("A failed to join")
+	}
+
+	go func() {
+		world, _ := C_host.JoinWorld(context.Background(), world_aurl)
+		ev_ch := world.GetEventChannel()
+		(<-ev_ch).(abyss.EWorldMemberRequest).Accept()
+	}()
+
+	C_hash := C_host.GetLocalAbyssURL().Hash
+	select {
+	case e := <-held_ch:
+		if e.id != C_hash || e.introducer != B_host.GetLocalAbyssURL().Hash {
+			t.Fatalf("held %s introduced by %s", e.id, e.introducer)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("introduction of C was not held")
+	}
+
+	// C never becomes a member of A's world, and A cannot dial C.
+	select {
+	case e := <-A_world.GetEventChannel():
+		t.Fatalf("unexpected world event at A: %#v", e)
+	case <-time.After(time.Second):
+	}
+	if A_host.NetworkService.ConnectAbyssAsync(C_host.GetLocalAbyssURL()) == nil {
+		t.Fatal("held peer is dialable")
+	}
+
+	if err := A_net.ConfirmPeer(C_hash); err != nil {
+		t.Fatal(err)
+	}
+	if err := A_net.ConfirmPeer(C_hash); !errors.Is(err, abyss_net.ErrNoHeldIdentity) {
+		t.Fatalf("second ConfirmPeer: %v", err)
+	}
+	if err := A_host.NetworkService.ConnectAbyssAsync(C_host.GetLocalAbyssURL()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -28,6 +28,20 @@ public static class AbyssLib
         REMOTE_ERROR = -4,
         INVALID_HANDLE = -99,
     }
+    public enum WorldRole : int
+    {
+        Member = 0,
+        Moderator = 1,
+        Owner = 2,
+    }
+    public enum ModerationAction : int
+    {
+        Kick = 1,
+        Ban = 2,
+        Unban = 3,
+        GrantModerator = 4,
+        RevokeModerator = 5,
+    }
     public static string GetVersion()
     {
         unsafe
@@ -453,10 +467,206 @@ public static class AbyssLib
                     3 => new MemberObjectAppend(ret_handle),
                     4 => new MemberObjectDelete(ret_handle),
                     5 => new WorldMemberLeave(ret_handle),
+                    7 => new MemberMessage(ret_handle),
+                    8 => new MemberObjectTransform(ret_handle),
+                    9 => new MemberObjectUpdate(ret_handle),
+                    10 => new WorldModeration(ret_handle),
                     _ => 0,
                 };
             }
         }
+        public ErrorCode AppendObjects(Tuple<Guid, string, float[]>[] objects_info)
+        {
+            ObjectInfoFormat[] objinfo_marshalled = [.. objects_info.Select(x => new ObjectInfoFormat { ID = BytesToHex(x.Item1.ToByteArray()), Addr = x.Item2, Transform = x.Item3 })];
+            string data = System.Text.Json.JsonSerializer.Serialize(objinfo_marshalled);
+            byte[] data_bytes;
+            try
+            {
+                data_bytes = Encoding.UTF8.GetBytes(data);
+            }
+            catch
+            {
+                return ErrorCode.INVALID_ARGUMENTS;
+            }
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int World_AppendObjects(IntPtr h, byte* json_ptr, int json_len);
+
+                fixed (byte* data_ptr = data_bytes)
+                {
+                    return (ErrorCode)World_AppendObjects(handle, data_ptr, data_bytes.Length);
+                }
+            }
+        }
+        public ErrorCode DeleteObjects(Guid[] object_ids)
+        {
+            IEnumerable<string> objid_marshalled = object_ids.Select(x => BytesToHex(x.ToByteArray()));
+            string data = System.Text.Json.JsonSerializer.Serialize(objid_marshalled);
+            byte[] data_bytes;
+            try
+            {
+                data_bytes = Encoding.UTF8.GetBytes(data);
+            }
+            catch
+            {
+                return ErrorCode.INVALID_ARGUMENTS;
+            }
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int World_DeleteObjects(IntPtr h, byte* json_ptr, int json_len);
+
+                fixed (byte* data_ptr = data_bytes)
+                {
+                    return (ErrorCode)World_DeleteObjects(handle, data_ptr, data_bytes.Length);
+                }
+            }
+        }
+        public ErrorCode UpdateObjects(ObjectUpdateFormat[] updates)
+        {
+            string data = System.Text.Json.JsonSerializer.Serialize(updates);
+            byte[] data_bytes;
+            try
+            {
+                data_bytes = Encoding.UTF8.GetBytes(data);
+            }
+            catch
+            {
+                return ErrorCode.INVALID_ARGUMENTS;
+            }
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int World_UpdateObjects(IntPtr h, byte* json_ptr, int json_len);
+
+                fixed (byte* data_ptr = data_bytes)
+                {
+                    return (ErrorCode)World_UpdateObjects(handle, data_ptr, data_bytes.Length);
+                }
+            }
+        }
+        public WorldRole GetRole(string peer_hash)
+        {
+            byte[] hash_bytes = Encoding.ASCII.GetBytes(peer_hash);
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int World_GetRole(IntPtr h, byte* peer_hash_ptr, int peer_hash_len);
+
+                fixed (byte* hash_ptr = hash_bytes)
+                {
+                    int res = World_GetRole(handle, hash_ptr, hash_bytes.Length);
+                    return res < 0 ? WorldRole.Member : (WorldRole)res;
+                }
+            }
+        }
+        public ErrorCode Moderate(ModerationAction action, string target_hash, string reason)
+        {
+            byte[] target_bytes;
+            byte[] reason_bytes;
+            try
+            {
+                target_bytes = Encoding.ASCII.GetBytes(target_hash);
+                reason_bytes = Encoding.UTF8.GetBytes(reason);
+            }
+            catch
+            {
+                return ErrorCode.INVALID_ARGUMENTS;
+            }
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int World_Moderate(IntPtr h, int action, byte* target_ptr, int target_len, byte* reason_ptr, int reason_len);
+
+                fixed (byte* target_ptr = target_bytes)
+                fixed (byte* reason_ptr = reason_bytes)
+                {
+                    return (ErrorCode)World_Moderate(handle, (int)action, target_ptr, target_bytes.Length, reason_ptr, reason_bytes.Length);
+                }
+            }
+        }
+        public ErrorCode SetCapacity(int max_members, int queue_length)
+        {
+            [DllImport("abyssnet.dll")]
+            static extern int World_SetCapacity(IntPtr h, int max_members, int queue_length);
+
+            return (ErrorCode)World_SetCapacity(handle, max_members, queue_length);
+        }
+        public ErrorCode SetInviteOnly(bool invite_only)
+        {
+            [DllImport("abyssnet.dll")]
+            static extern int World_SetInviteOnly(IntPtr h, int invite_only);
+
+            return (ErrorCode)World_SetInviteOnly(handle, invite_only ? 1 : 0);
+        }
+        public string CreateInvite(string path, int ttl_ms, bool single_use)
+        {
+            byte[] path_bytes;
+            try
+            {
+                path_bytes = Encoding.UTF8.GetBytes(path);
+            }
+            catch
+            {
+                return "";
+            }
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int World_CreateInvite(IntPtr h, byte* path_ptr, int path_len, int ttl_ms, int single_use, byte* buf, int buflen);
+
+                fixed (byte* path_ptr = path_bytes)
+                fixed (byte* buf = new byte[4096])
+                {
+                    int len = World_CreateInvite(handle, path_ptr, path_bytes.Length, ttl_ms, single_use ? 1 : 0, buf, 4096);
+                    return len <= 0 ? "" : Encoding.ASCII.GetString(buf, len);
+                }
+            }
+        }
+        public ErrorCode SetInterest(float radius, Guid anchor)
+        {
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int World_SetInterest(IntPtr h, float radius, byte* anchor_ptr);
+
+                fixed (byte* anchor_ptr = anchor.ToByteArray())
+                {
+                    return (ErrorCode)World_SetInterest(handle, radius, anchor_ptr);
+                }
+            }
+        }
+        public Tuple<string, float[]?>[] GetRoster()
+        {
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int World_GetRoster(IntPtr h, byte* buf, int buflen);
+
+                int buf_len = 65536;
+                while (true)
+                {
+                    var buf = new byte[buf_len];
+                    int len;
+                    fixed (byte* buf_ptr = buf)
+                    {
+                        len = World_GetRoster(handle, buf_ptr, buf_len);
+                    }
+                    if (len == (int)ErrorCode.BUFFER_OVERFLOW)
+                    {
+                        buf_len *= 4;
+                        continue;
+                    }
+                    if (len <= 0)
+                    {
+                        return [];
+                    }
+                    var members = System.Text.Json.JsonSerializer.Deserialize<RosterMemberFormat[]>(Encoding.ASCII.GetString(buf, 0, len));
+                    return members == null ? [] : [.. members.Select(x => Tuple.Create(x.PeerHash, x.Position))];
+                }
+            }
+        }
         public int Leave()
         {
             [DllImport("abyssnet.dll")]
@@ -534,6 +744,55 @@ public static class AbyssLib
             get; set;
         }
     }
+    public class ObjectUpdateFormat
+    {
+        public required string ID
+        {
+            get; set;
+        }
+
+        [System.Text.Json.Serialization.JsonIgnore(Condition = System.Text.Json.Serialization.JsonIgnoreCondition.WhenWritingNull)]
+        public float[]? Transform
+        {
+            get; set;
+        }
+
+        [System.Text.Json.Serialization.JsonIgnore(Condition = System.Text.Json.Serialization.JsonIgnoreCondition.WhenWritingNull)]
+        public Dictionary<string, string>? Properties
+        {
+            get; set;
+        }
+
+        [System.Text.Json.Serialization.JsonIgnore(Condition = System.Text.Json.Serialization.JsonIgnoreCondition.WhenWritingNull)]
+        public string[]? RemovedProperties
+        {
+            get; set;
+        }
+    }
+    public class RosterMemberFormat
+    {
+        public required string PeerHash
+        {
+            get; set;
+        }
+
+        public float[]? Position
+        {
+            get; set;
+        }
+    }
+    public class ObjectTransformFormat
+    {
+        public required string ID
+        {
+            get; set;
+        }
+
+        public required float[] Transform
+        {
+            get; set;
+        }
+    }
     private static string BytesToHex(byte[] input)
     {
         char[] result = new char[input.Length * 2];
@@ -636,6 +895,76 @@ public static class AbyssLib
                 }
             }
         }
+        public ErrorCode UpdateObjects(ObjectUpdateFormat[] updates)
+        {
+            string data = System.Text.Json.JsonSerializer.Serialize(updates);
+            byte[] data_bytes;
+            try
+            {
+                data_bytes = Encoding.UTF8.GetBytes(data);
+            }
+            catch
+            {
+                return ErrorCode.INVALID_ARGUMENTS;
+            }
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int WorldPeer_UpdateObjects(IntPtr h, byte* json_ptr, int json_len);
+
+                fixed (byte* data_ptr = data_bytes)
+                {
+                    return (ErrorCode)WorldPeer_UpdateObjects(handle, data_ptr, data_bytes.Length);
+                }
+            }
+        }
+        public ErrorCode UpdateTransforms(Tuple<Guid, float[]>[] transforms)
+        {
+            ObjectTransformFormat[] transforms_marshalled = [.. transforms.Select(x => new ObjectTransformFormat { ID = BytesToHex(x.Item1.ToByteArray()), Transform = x.Item2 })];
+            string data = System.Text.Json.JsonSerializer.Serialize(transforms_marshalled);
+            byte[] data_bytes;
+            try
+            {
+                data_bytes = Encoding.ASCII.GetBytes(data);
+            }
+            catch
+            {
+                return ErrorCode.INVALID_ARGUMENTS;
+            }
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int WorldPeer_UpdateTransforms(IntPtr h, byte* json_ptr, int json_len);
+
+                fixed (byte* data_ptr = data_bytes)
+                {
+                    return (ErrorCode)WorldPeer_UpdateTransforms(handle, data_ptr, data_bytes.Length);
+                }
+            }
+        }
+        public ErrorCode SendMessage(string topic, byte[] payload)
+        {
+            byte[] topic_bytes;
+            try
+            {
+                topic_bytes = Encoding.UTF8.GetBytes(topic);
+            }
+            catch
+            {
+                return ErrorCode.INVALID_ARGUMENTS;
+            }
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int WorldPeer_SendMessage(IntPtr h, byte* topic_ptr, int topic_len, byte* payload_ptr, int payload_len);
+
+                fixed (byte* topic_ptr = topic_bytes)
+                fixed (byte* payload_ptr = payload)
+                {
+                    return (ErrorCode)WorldPeer_SendMessage(handle, topic_ptr, topic_bytes.Length, payload_ptr, payload.Length);
+                }
+            }
+        }
         private readonly IntPtr handle;
         public readonly string hash;
         ~WorldMember() => CloseAbyssHandle(handle);
@@ -732,6 +1061,198 @@ public static class AbyssLib
         public readonly Guid[] object_ids;
         ~MemberObjectDelete() => CloseAbyssHandle(handle);
     }
+    public class MemberObjectUpdate
+    {
+        public MemberObjectUpdate(IntPtr _handle)
+        {
+            handle = _handle;
+
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int WorldPeerObjectUpdate_GetHead(IntPtr h, byte* peer_hash_out, int* body_len);
+
+                [DllImport("abyssnet.dll")]
+                static extern int WorldPeerObjectUpdate_GetBody(IntPtr h, byte* buf, int buflen);
+
+                int body_len = 0;
+                fixed (byte* buf = new byte[128])
+                {
+                    int hash_len = WorldPeerObjectUpdate_GetHead(handle, buf, &body_len);
+                    peer_hash = hash_len < 0 ? "" : System.Text.Encoding.ASCII.GetString(buf, hash_len);
+                }
+                if (body_len <= 0)
+                {
+                    updates = [];
+                    return;
+                }
+
+                fixed (byte* buf = new byte[body_len])
+                {
+                    int res_len = WorldPeerObjectUpdate_GetBody(handle, buf, body_len);
+                    if (res_len != body_len)
+                    {
+                        updates = [];
+                        return;
+                    }
+                    updates = System.Text.Json.JsonSerializer.Deserialize<ObjectUpdateFormat[]>(System.Text.Encoding.UTF8.GetString(buf, res_len)) ?? [];
+                }
+            }
+        }
+        private readonly IntPtr handle;
+        public readonly string peer_hash;
+        public readonly ObjectUpdateFormat[] updates;
+        ~MemberObjectUpdate() => CloseAbyssHandle(handle);
+    }
+    public class MemberObjectTransform
+    {
+        public MemberObjectTransform(IntPtr _handle)
+        {
+            handle = _handle;
+
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int WorldPeerObjectTransform_GetHead(IntPtr h, byte* peer_hash_out, int* body_len);
+
+                [DllImport("abyssnet.dll")]
+                static extern int WorldPeerObjectTransform_GetBody(IntPtr h, byte* buf, int buflen);
+
+                int body_len = 0;
+                fixed (byte* buf = new byte[128])
+                {
+                    int hash_len = WorldPeerObjectTransform_GetHead(handle, buf, &body_len);
+                    peer_hash = hash_len < 0 ? "" : System.Text.Encoding.ASCII.GetString(buf, hash_len);
+                }
+                if (body_len <= 0)
+                {
+                    transforms = [];
+                    return;
+                }
+
+                ObjectTransformFormat[]? infos;
+                fixed (byte* buf = new byte[body_len])
+                {
+                    int res_len = WorldPeerObjectTransform_GetBody(handle, buf, body_len);
+                    if (res_len != body_len)
+                    {
+                        transforms = [];
+                        return;
+                    }
+                    infos = System.Text.Json.JsonSerializer.Deserialize<ObjectTransformFormat[]>(System.Text.Encoding.ASCII.GetString(buf, res_len));
+                }
+
+                transforms = infos == null ? [] : [.. infos.Select(x => Tuple.Create(new Guid(HexToBytes(x.ID)), x.Transform))];
+            }
+        }
+        private readonly IntPtr handle;
+        public readonly string peer_hash;
+        public readonly Tuple<Guid, float[]>[] transforms;
+        ~MemberObjectTransform() => CloseAbyssHandle(handle);
+    }
+    public class MemberMessage
+    {
+        public MemberMessage(IntPtr _handle)
+        {
+            handle = _handle;
+
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int WorldPeerMessage_GetHead(IntPtr h, byte* peer_hash_out, int* topic_len, int* payload_len);
+
+                [DllImport("abyssnet.dll")]
+                static extern int WorldPeerMessage_GetTopic(IntPtr h, byte* buf, int buflen);
+
+                [DllImport("abyssnet.dll")]
+                static extern int WorldPeerMessage_GetPayload(IntPtr h, byte* buf, int buflen);
+
+                int topic_len = 0;
+                int payload_len = 0;
+                fixed (byte* buf = new byte[128])
+                {
+                    int hash_len = WorldPeerMessage_GetHead(handle, buf, &topic_len, &payload_len);
+                    peer_hash = hash_len < 0 ? "" : System.Text.Encoding.ASCII.GetString(buf, hash_len);
+                }
+
+                topic = "";
+                if (topic_len > 0)
+                {
+                    fixed (byte* buf = new byte[topic_len])
+                    {
+                        int res_len = WorldPeerMessage_GetTopic(handle, buf, topic_len);
+                        topic = res_len == topic_len ? System.Text.Encoding.UTF8.GetString(buf, res_len) : "";
+                    }
+                }
+
+                payload = new byte[payload_len];
+                if (payload_len > 0)
+                {
+                    fixed (byte* buf = payload)
+                    {
+                        if (WorldPeerMessage_GetPayload(handle, buf, payload_len) != payload_len)
+                        {
+                            payload = [];
+                        }
+                    }
+                }
+            }
+        }
+        private readonly IntPtr handle;
+        public readonly string peer_hash;
+        public readonly string topic;
+        public readonly byte[] payload;
+        ~MemberMessage() => CloseAbyssHandle(handle);
+    }
+    public class WorldModeration
+    {
+        public WorldModeration(IntPtr _handle)
+        {
+            handle = _handle;
+
+            unsafe
+            {
+                [DllImport("abyssnet.dll")]
+                static extern int WorldModeration_GetHead(IntPtr h, int* action_out, byte* issuer_out, int* reason_len);
+
+                [DllImport("abyssnet.dll")]
+                static extern int WorldModeration_GetTarget(IntPtr h, byte* buf, int buflen);
+
+                [DllImport("abyssnet.dll")]
+                static extern int WorldModeration_GetReason(IntPtr h, byte* buf, int buflen);
+
+                int action_int = 0;
+                int reason_len = 0;
+                fixed (byte* buf = new byte[128])
+                {
+                    int hash_len = WorldModeration_GetHead(handle, &action_int, buf, &reason_len);
+                    issuer_hash = hash_len < 0 ? "" : System.Text.Encoding.ASCII.GetString(buf, hash_len);
+                }
+                fixed (byte* buf = new byte[128])
+                {
+                    int hash_len = WorldModeration_GetTarget(handle, buf, 128);
+                    target_hash = hash_len < 0 ? "" : System.Text.Encoding.ASCII.GetString(buf, hash_len);
+                }
+                action = (ModerationAction)action_int;
+
+                reason = "";
+                if (reason_len > 0)
+                {
+                    fixed (byte* buf = new byte[reason_len])
+                    {
+                        int res_len = WorldModeration_GetReason(handle, buf, reason_len);
+                        reason = res_len == reason_len ? System.Text.Encoding.UTF8.GetString(buf, res_len) : "";
+                    }
+                }
+            }
+        }
+        private readonly IntPtr handle;
+        public readonly ModerationAction action;
+        public readonly string issuer_hash;
+        public readonly string target_hash;
+        public readonly string reason;
+        ~WorldModeration() => CloseAbyssHandle(handle);
+    }
     public class WorldMemberLeave
     {
         public WorldMemberLeave(IntPtr _handle)